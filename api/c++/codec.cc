@@ -179,8 +179,12 @@ int KeyValue::encode(char* pkg, int pkgLen) {
 }
 
 int PkgOneOp::length() {
-	// PKG = HEAD+cPkgFlag+KeyValue
-	return HeadSize + 1 + KeyValue::length();
+	// PKG = HEAD+cPkgFlag+KeyValue+[ddwLogSeq]
+	int n = HeadSize + 1 + KeyValue::length();
+	if(pkgFlag&FlagLogSeq) {
+		n += 8;
+	}
+	return n;
 }
 
 int PkgOneOp::decode(const char* pkg, int pkgLen) {
@@ -201,6 +205,15 @@ int PkgOneOp::decode(const char* pkg, int pkgLen) {
 	}
 	n += m;
 
+	logSeq = 0;
+	if(pkgFlag&FlagLogSeq) {
+		if(n+8 > pkgLen) {
+			return -5;
+		}
+		logSeq = getUint64(pkg+n);
+		n += 8;
+	}
+
 	return n;
 }
 
@@ -222,16 +235,27 @@ int PkgOneOp::encode(char* pkg, int pkgLen) {
 	}
 	n += m;
 
+	if(pkgFlag&FlagLogSeq) {
+		if(n+8 > pkgLen) {
+			return -5;
+		}
+		putUint64(pkg+n, logSeq);
+		n += 8;
+	}
+
 	overWriteLen(pkg, n);
 	return n;
 }
 
 int PkgMultiOp::length() {
-	// PKG = HEAD+cPkgFlag+cErrCode+wNum+KeyValue[wNum]
+	// PKG = HEAD+cPkgFlag+cErrCode+wNum+KeyValue[wNum]+[ddwLogSeq]
 	int n = HeadSize + 4;
 	for(int i = 0; i < kvs.size(); i++) {
 		n += kvs[i].length();
 	}
+	if(pkgFlag&FlagLogSeq) {
+		n += 8;
+	}
 	return n;
 }
 
@@ -260,6 +284,15 @@ int PkgMultiOp::decode(const char* pkg, int pkgLen) {
 		n += m;
 	}
 
+	logSeq = 0;
+	if(pkgFlag&FlagLogSeq) {
+		if(n+8 > pkgLen) {
+			return -5;
+		}
+		logSeq = getUint64(pkg+n);
+		n += 8;
+	}
+
 	return n;
 }
 
@@ -292,6 +325,14 @@ int PkgMultiOp::encode(char* pkg, int pkgLen) {
 		n += m;
 	}
 
+	if(pkgFlag&FlagLogSeq) {
+		if(n+8 > pkgLen) {
+			return -7;
+		}
+		putUint64(pkg+n, logSeq);
+		n += 8;
+	}
+
 	overWriteLen(pkg, n);
 	return n;
 }
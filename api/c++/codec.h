@@ -113,7 +113,9 @@ struct KeyValue {
 // PkgFlag
 enum {
 	// Common flags
-	FlagZop = 0x1, // if set, it is a "Z" op
+	FlagZop    = 0x1,  // if set, it is a "Z" op
+	FlagLogSeq = 0x2,  // if set, pkg ends with ddwLogSeq (binlog seq token)
+	FlagAsking = 0x40, // if set, request is redirected by EcAsk
 
 	// (Z)Scan flags
 	FlagScanAsc      = 0x4,  // if set, Scan in ASC order, else DESC order
@@ -127,11 +129,12 @@ enum {
 };
 
 // Get, Set, Del, GetSet, GetDel, ZGet, ZSet, Sync
-// PKG=HEAD+cPkgFlag+KeyValue
+// PKG=HEAD+cPkgFlag+KeyValue+[ddwLogSeq]
 struct PkgOneOp : public PkgHead, KeyValue {
 	uint8_t  pkgFlag;
+	uint64_t logSeq; // Write reply: binlog seq; Read request: min binlog seq
 
-	PkgOneOp() : pkgFlag(0) {}
+	PkgOneOp() : pkgFlag(0), logSeq(0) {}
 
 	int length();
 	int decode(const char* pkg, int len);
@@ -139,13 +142,14 @@ struct PkgOneOp : public PkgHead, KeyValue {
 };
 
 // MGet, MSet, MDel, MZGet, MZSet, MZDel
-// PKG=HEAD+cPkgFlag+cErrCode+wNum+KeyValue[wNum]
+// PKG=HEAD+cPkgFlag+cErrCode+wNum+KeyValue[wNum]+[ddwLogSeq]
 struct PkgMultiOp : public PkgHead {
 	uint8_t          pkgFlag;
 	int8_t           errCode;
 	vector<KeyValue> kvs;
+	uint64_t         logSeq; // Write reply: binlog seq; Read request: min binlog seq
 
-	PkgMultiOp() : pkgFlag(0), errCode(0) {}
+	PkgMultiOp() : pkgFlag(0), errCode(0), logSeq(0) {}
 
 	int length();
 	int decode(const char* pkg, int len);
@@ -21,6 +21,7 @@
 
 #include "codec.h"
 #include "gotable.h"
+#include "scram.h"
 
 namespace gotable {
 
@@ -28,7 +29,10 @@ using std::string;
 
 static const string EMPTYSTR;
 
-Client::Client(int fd) : closed(false), fd(fd), dbId(0), seq(0), authAdmin(false) {
+static const int MaxRedirects = 5; // Max times a request follows EcMoved/EcAsk
+
+Client::Client(int fd) : closed(false), fd(fd), dbId(0), seq(0), authAdmin(false),
+		plainAuth(false) {
 
 }
 
@@ -41,6 +45,12 @@ void Client::close() {
 		closed = true;
 		::close(fd);
 	}
+
+	std::map<string, Client*>::iterator it;
+	for(it = redirects.begin(); it != redirects.end(); ++it) {
+		delete it->second;
+	}
+	redirects.clear();
 }
 
 void Client::select(uint8_t dbId) {
@@ -88,6 +98,133 @@ Client* Client::Dial(const char* ip, int port) {
 	return NULL;
 }
 
+// Send the request pkg, and receive the reply pkg into pkg.
+int Client::exchange(string& pkg) {
+	// send pkg
+	int pkgLen = pkg.size();
+	int n = 0;
+	while(n < pkgLen) {
+		int m = write(fd, pkg.data()+n, pkgLen-n);
+		if(m < 0) {
+			return -3;
+		}
+		n += m;
+	}
+
+	// recv pkg
+	PkgHead head;
+	n = readPkg(fd, buf, sizeof(buf), &head, pkg);
+	if(n < 0) {
+		return -4;
+	}
+	if(n == 0) {
+		this->close();
+		return -5;
+	}
+	if(head.seq != seq) {
+		this->close();
+		return -6;
+	}
+
+	return 0;
+}
+
+// Get the connection to the new owner, it's authorized as this connection.
+Client* Client::getRedirect(const string& addr) {
+	std::map<string, Client*>::iterator it = redirects.find(addr);
+	if(it != redirects.end()) {
+		if(!it->second->closed) {
+			return it->second;
+		}
+		delete it->second;
+		redirects.erase(it);
+	}
+
+	size_t pos = addr.rfind(':');
+	if(pos == string::npos) {
+		return NULL;
+	}
+	string ip = addr.substr(0, pos);
+	int port = atoi(addr.c_str()+pos+1);
+
+	Client* c = Dial(ip.c_str(), port);
+	if(c == NULL) {
+		return NULL;
+	}
+
+	c->setPlainAuth(plainAuth);
+	std::map<uint8_t, string>::iterator pw;
+	for(pw = passwords.begin(); pw != passwords.end(); ++pw) {
+		c->select(pw->first);
+		if(c->auth(pw->second.c_str()) != 0) {
+			delete c;
+			return NULL;
+		}
+	}
+
+	redirects[addr] = c;
+	return c;
+}
+
+// Get the error code and new owner address of EcMoved/EcAsk reply.
+static inline int getRedirectAddr(const PkgOneOp& p, string* addr) {
+	addr->assign(p.value.data(), p.value.size());
+	return p.errCode;
+}
+
+static inline int getRedirectAddr(const PkgMultiOp& p, string* addr) {
+	if(p.kvs.size() == 0) {
+		addr->clear();
+	} else {
+		addr->assign(p.kvs[0].value.data(), p.kvs[0].value.size());
+	}
+	return p.errCode;
+}
+
+// Follow the EcMoved/EcAsk redirection by sending the request p to the new
+// owner. The reply pkg is saved in pkg and decoded into resp.
+template <typename Req, typename Resp>
+int Client::followRedirect(Req& p, Resp* resp, string& pkg) {
+	string addr;
+	for(int i = 0; i < MaxRedirects; i++) {
+		int errCode = getRedirectAddr(*resp, &addr);
+		if((errCode != EcMoved && errCode != EcAsk) || addr.empty()) {
+			return 0;
+		}
+
+		Client* c = getRedirect(addr);
+		if(c == NULL) {
+			return -8;
+		}
+
+		if(errCode == EcAsk) {
+			p.pkgFlag |= FlagAsking;
+		} else {
+			p.pkgFlag &= (~FlagAsking);
+		}
+		p.seq = ++c->seq;
+
+		int pkgLen = p.length();
+		pkg.resize(pkgLen);
+		int n = p.encode((char*)pkg.data(), pkgLen);
+		if(n < 0) {
+			return -2;
+		}
+
+		n = c->exchange(pkg);
+		if(n < 0) {
+			return n;
+		}
+
+		n = resp->decode(pkg.data(), pkg.size());
+		if(n < 0) {
+			return -7;
+		}
+	}
+
+	return 0;
+}
+
 int Client::doOneOp(bool zop, uint8_t cmd, uint8_t tableId,
 		const string& rowKey, const string& colKey,
 		const string& value, int64_t score, uint32_t cas,
@@ -126,29 +263,9 @@ int Client::doOneOp(bool zop, uint8_t cmd, uint8_t tableId,
 		return -2;
 	}
 
-	// send pkg
-	n = 0;
-	while(n < pkgLen) {
-		int m = write(fd, pkg.data()+n, pkgLen-n);
-		if(m < 0) {
-			return -3;
-		}
-		n += m;
-	}
-
-	// recv pkg
-	PkgHead head;
-	n = readPkg(fd, buf, sizeof(buf), &head, pkg);
+	n = exchange(pkg);
 	if(n < 0) {
-		return -4;
-	}
-	if(n == 0) {
-		this->close();
-		return -5;
-	}
-	if(head.seq != seq) {
-		this->close();
-		return -6;
+		return n;
 	}
 
 	// reply
@@ -157,7 +274,7 @@ int Client::doOneOp(bool zop, uint8_t cmd, uint8_t tableId,
 		return -7;
 	}
 
-	return 0;
+	return followRedirect(p, reply, pkg);
 }
 
 static inline void copyArgs(KeyValue& kv, const GetArgs& a) {
@@ -260,29 +377,9 @@ int Client::doMultiOp(bool zop, uint8_t cmd, const vector<T>& args,
 		return -2;
 	}
 
-	// send pkg
-	n = 0;
-	while(n < pkgLen) {
-		int m = write(fd, pkg.data()+n, pkgLen-n);
-		if(m < 0) {
-			return -3;
-		}
-		n += m;
-	}
-
-	// recv pkg
-	PkgHead head;
-	n = readPkg(fd, buf, sizeof(buf), &head, pkg);
+	n = exchange(pkg);
 	if(n < 0) {
-		return -4;
-	}
-	if(n == 0) {
-		this->close();
-		return -5;
-	}
-	if(head.seq != seq) {
-		this->close();
-		return -6;
+		return n;
 	}
 
 	// reply
@@ -291,7 +388,7 @@ int Client::doMultiOp(bool zop, uint8_t cmd, const vector<T>& args,
 		return -7;
 	}
 
-	return 0;
+	return followRedirect(p, reply, pkg);
 }
 
 int Client::auth(const char* password) {
@@ -299,19 +396,90 @@ int Client::auth(const char* password) {
 		return 0;
 	}
 
+	uint8_t authDB = dbId;
+	int err = 0;
+	if(plainAuth) {
+		string pkg;
+		PkgOneOp reply;
+		err = doOneOp(false, CmdAuth, 0, password, EMPTYSTR, EMPTYSTR, 0, 0,
+				&reply, pkg);
+		if(err < 0) {
+			return err;
+		}
+		err = reply.errCode;
+		authDB = reply.dbId;
+	} else {
+		err = scramAuth(dbId, password, &authDB);
+		if(err == EcAuthFailed && dbId != AdminDbId) {
+			// The DB has its own password, try the admin password
+			err = scramAuth(AdminDbId, password, &authDB);
+		}
+	}
+
+	if(err == 0) {
+		if(authDB == AdminDbId) {
+			authAdmin = true;
+		} else {
+			setAuth.insert(authDB);
+		}
+		passwords[authDB] = password;
+	}
+	return err;
+}
+
+void Client::setPlainAuth(bool plain) {
+	plainAuth = plain;
+}
+
+// Challenge-response auth of authDbId, the password is never sent to server.
+int Client::scramAuth(uint8_t authDbId, const string& password, uint8_t* authDB) {
+	string nonce;
+	if(scramNonce(&nonce) < 0) {
+		return EcAuthFailed;
+	}
+
+	uint8_t oldDbId = dbId;
+	dbId = authDbId;
+
 	string pkg;
 	PkgOneOp reply;
-	int err = doOneOp(false, CmdPing, 0, password, EMPTYSTR, EMPTYSTR, 0, 0,
+	int err = doOneOp(false, CmdScram, 0, nonce, EMPTYSTR, EMPTYSTR, 0, 0,
 			&reply, pkg);
+	if(err == 0 && reply.errCode == 0 && reply.value.size() > 0) {
+		int iter = int(reply.score);
+		if(iter <= 0 || iter > ScramMaxIter) {
+			dbId = oldDbId;
+			return EcAuthFailed;
+		}
+
+		string salt(reply.value.data(), reply.value.size());
+		nonce.assign(reply.rowKey.data(), reply.rowKey.size());
+
+		string proof, serverSig;
+		string msg = scramAuthMessage(EMPTYSTR, authDbId, nonce, salt, iter);
+		scramClientProof(password, salt, iter, msg, &proof, &serverSig);
+
+		err = doOneOp(false, CmdScram, 0, nonce, EMPTYSTR, proof, 0, 0,
+				&reply, pkg);
+		if(err == 0 && reply.errCode == 0 &&
+				string(reply.value.data(), reply.value.size()) != serverSig) {
+			err = EcAuthFailed;
+		}
+	} else if(err == 0 && reply.errCode == 0) {
+		// No challenge: auth disabled or already admin
+		string marker(reply.rowKey.data(), reply.rowKey.size());
+		if(marker != ScramAuthDisabled &&
+				(marker != ScramAlreadyAdmin || !authAdmin)) {
+			err = EcAuthFailed;
+		}
+	}
+	dbId = oldDbId;
+
 	if(err < 0) {
 		return err;
 	}
 	if(reply.errCode == 0) {
-		if(reply.dbId == AdminDbId) {
-			authAdmin = true;
-		} else {
-			setAuth.insert(reply.dbId);
-		}
+		*authDB = reply.dbId;
 	}
 	return reply.errCode;
 }
@@ -588,29 +756,9 @@ int Client::doScan(bool zop, uint8_t tableId, const string& rowKey, const string
 		return -2;
 	}
 
-	// send pkg
-	n = 0;
-	while(n < pkgLen) {
-		int m = write(fd, pkg.data()+n, pkgLen-n);
-		if(m < 0) {
-			return -3;
-		}
-		n += m;
-	}
-
-	// recv pkg
-	PkgHead head;
-	n = readPkg(fd, buf, sizeof(buf), &head, pkg);
+	n = exchange(pkg);
 	if(n < 0) {
-		return -4;
-	}
-	if(n == 0) {
-		this->close();
-		return -5;
-	}
-	if(head.seq != seq) {
-		this->close();
-		return -6;
+		return n;
 	}
 
 	// reply
@@ -619,6 +767,11 @@ int Client::doScan(bool zop, uint8_t tableId, const string& rowKey, const string
 		return -7;
 	}
 
+	n = followRedirect(p, resp, pkg);
+	if(n < 0) {
+		return n;
+	}
+
 	reply->tableId = tableId;
 	reply->rowKey = rowKey;
 
@@ -742,29 +895,9 @@ int Client::doDump(bool oneTable, uint8_t tableId, uint8_t colSpace,
 		return -2;
 	}
 
-	// send pkg
-	n = 0;
-	while(n < pkgLen) {
-		int m = write(fd, pkg.data()+n, pkgLen-n);
-		if(m < 0) {
-			return -3;
-		}
-		n += m;
-	}
-
-	// recv pkg
-	PkgHead head;
-	n = readPkg(fd, buf, sizeof(buf), &head, pkg);
+	n = exchange(pkg);
 	if(n < 0) {
-		return -4;
-	}
-	if(n == 0) {
-		this->close();
-		return -5;
-	}
-	if(head.seq != seq) {
-		this->close();
-		return -6;
+		return n;
 	}
 
 	// reply
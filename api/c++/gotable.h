@@ -18,6 +18,7 @@
 #include <stdint.h>
 #include <stdlib.h>
 #include <string>
+#include <map>
 #include <set>
 #include <vector>
 
@@ -46,6 +47,11 @@ enum {
 	EcInvPkgLen   = -21, // Pkg length should be less than 2MB
 	EcInvScanNum  = -22, // Scan request number out of range
 	EcScanEnded   = -23, // Already scan/dump to end
+	EcNotSynced   = -24, // Slave has not synced to the required binlog seq
+	EcMoved       = -25, // Slot moved to the new owner, retry there
+	EcAsk         = -26, // Slot migrating, ask the new owner for this request
+	EcCrossSlot   = -27, // Keys of the multi-key request are on different servers
+	EcPlainAuth   = -28, // Challenge-response auth not supported, use plain auth
 };
 
 struct GetArgs {
@@ -204,10 +210,16 @@ public:
 	// Get the selected database ID for the current connection.
 	uint8_t databaseId();
 
-	// Authenticate to the server.
+	// Authenticate to the server. The password is not sent to the server,
+	// it's checked by the challenge-response auth unless plain auth is set.
 	// Return value <0 means failed, 0 means succeed.
 	int auth(const char* password);
 
+	// Send plain text passwords by the old AUTH command. It's only for
+	// servers with auth.plain_auth enabled, like the servers behind
+	// gotable-proxy.
+	void setPlainAuth(bool plain);
+
 	// Ping the server.
 	// Return value <0 means failed, 0 means succeed.
 	int ping();
@@ -355,6 +367,13 @@ public:
 	int dumpMore(const DumpReply& last, DumpReply* reply);
 
 private:
+	int exchange(string& pkg);
+
+	Client* getRedirect(const string& addr);
+
+	template <typename Req, typename Resp>
+	int followRedirect(Req& p, Resp* resp, string& pkg);
+
 	int doOneOp(bool zop, uint8_t cmd, uint8_t tableId,
 			const string& rowKey, const string& colKey,
 			const string& value, int64_t score, uint32_t cas,
@@ -368,6 +387,8 @@ private:
 			int64_t score, bool start, bool asc, bool orderByScore, int num,
 			ScanReply* reply, PkgMultiOp* resp, string& pkg);
 
+	int scramAuth(uint8_t authDbId, const string& password, uint8_t* authDB);
+
 	int doDump(bool oneTable, uint8_t tableId, uint8_t colSpace,
 			const string& rowKey, const string& colKey, int64_t score,
 			uint16_t startUnitId, uint16_t endUnitId,
@@ -383,7 +404,10 @@ private:
 	uint8_t  dbId;
 	uint64_t seq;
 	bool              authAdmin;
+	bool              plainAuth;
 	std::set<uint8_t> setAuth;
+	std::map<uint8_t, string> passwords; // Replayed on redirected connections
+	std::map<string, Client*> redirects; // Connections to the new slot owners
 	char     buf[4096];
 };
 
@@ -34,7 +34,8 @@ using std::string;
 
 enum {
 	// Front CTRL
-	CmdAuth = 0x9,
+	CmdAuth  = 0x9,
+	CmdScram = 0xA, // Challenge-response auth
 
 	// Front Read
 	CmdPing = 0x10,
@@ -0,0 +1,228 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include <fcntl.h>
+#include <unistd.h>
+#include <string.h>
+
+#include "scram.h"
+
+namespace gotable {
+
+static const uint32_t K[64] = {
+	0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1,
+	0x923f82a4, 0xab1c5ed5, 0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3,
+	0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174, 0xe49b69c1, 0xefbe4786,
+	0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+	0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147,
+	0x06ca6351, 0x14292967, 0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13,
+	0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85, 0xa2bfe8a1, 0xa81a664b,
+	0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+	0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a,
+	0x5b9cca4f, 0x682e6ff3, 0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208,
+	0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+};
+
+static inline uint32_t ror(uint32_t x, int n) {
+	return (x >> n) | (x << (32 - n));
+}
+
+Sha256::Sha256() : nbuf(0), total(0) {
+	h[0] = 0x6a09e667; h[1] = 0xbb67ae85; h[2] = 0x3c6ef372; h[3] = 0xa54ff53a;
+	h[4] = 0x510e527f; h[5] = 0x9b05688c; h[6] = 0x1f83d9ab; h[7] = 0x5be0cd19;
+}
+
+void Sha256::block(const uint8_t* p) {
+	uint32_t w[64];
+	for(int i = 0; i < 16; i++) {
+		w[i] = (uint32_t(p[4*i]) << 24) | (uint32_t(p[4*i+1]) << 16) |
+				(uint32_t(p[4*i+2]) << 8) | uint32_t(p[4*i+3]);
+	}
+	for(int i = 16; i < 64; i++) {
+		uint32_t s0 = ror(w[i-15], 7) ^ ror(w[i-15], 18) ^ (w[i-15] >> 3);
+		uint32_t s1 = ror(w[i-2], 17) ^ ror(w[i-2], 19) ^ (w[i-2] >> 10);
+		w[i] = w[i-16] + s0 + w[i-7] + s1;
+	}
+
+	uint32_t a = h[0], b = h[1], c = h[2], d = h[3];
+	uint32_t e = h[4], f = h[5], g = h[6], k = h[7];
+	for(int i = 0; i < 64; i++) {
+		uint32_t t1 = k + (ror(e, 6) ^ ror(e, 11) ^ ror(e, 25)) +
+				((e & f) ^ (~e & g)) + K[i] + w[i];
+		uint32_t t2 = (ror(a, 2) ^ ror(a, 13) ^ ror(a, 22)) +
+				((a & b) ^ (a & c) ^ (b & c));
+		k = g; g = f; f = e; e = d + t1;
+		d = c; c = b; b = a; a = t1 + t2;
+	}
+
+	h[0] += a; h[1] += b; h[2] += c; h[3] += d;
+	h[4] += e; h[5] += f; h[6] += g; h[7] += k;
+}
+
+void Sha256::write(const void* data, size_t len) {
+	const uint8_t* p = (const uint8_t*)data;
+	total += len;
+	while(len > 0) {
+		size_t n = BlockSize - nbuf;
+		if(n > len) {
+			n = len;
+		}
+		memcpy(buf+nbuf, p, n);
+		nbuf += n;
+		p += n;
+		len -= n;
+		if(nbuf == BlockSize) {
+			block(buf);
+			nbuf = 0;
+		}
+	}
+}
+
+void Sha256::sum(uint8_t out[Size]) {
+	uint64_t bits = total * 8;
+	uint8_t pad = 0x80;
+	write(&pad, 1);
+	pad = 0;
+	while(nbuf != BlockSize - 8) {
+		write(&pad, 1);
+	}
+
+	uint8_t len[8];
+	for(int i = 0; i < 8; i++) {
+		len[i] = uint8_t(bits >> (56 - 8*i));
+	}
+	write(len, 8);
+
+	for(int i = 0; i < 8; i++) {
+		out[4*i]   = uint8_t(h[i] >> 24);
+		out[4*i+1] = uint8_t(h[i] >> 16);
+		out[4*i+2] = uint8_t(h[i] >> 8);
+		out[4*i+3] = uint8_t(h[i]);
+	}
+}
+
+// HMAC with the inner and outer hash states prepared for the key.
+class Hmac {
+public:
+	explicit Hmac(const string& key) {
+		uint8_t k[Sha256::BlockSize];
+		memset(k, 0, sizeof(k));
+		if(key.size() > Sha256::BlockSize) {
+			Sha256 h;
+			h.write(key.data(), key.size());
+			h.sum(k);
+		} else {
+			memcpy(k, key.data(), key.size());
+		}
+
+		uint8_t pad[Sha256::BlockSize];
+		for(int i = 0; i < Sha256::BlockSize; i++) {
+			pad[i] = k[i] ^ 0x36;
+		}
+		inner.write(pad, sizeof(pad));
+		for(int i = 0; i < Sha256::BlockSize; i++) {
+			pad[i] = k[i] ^ 0x5c;
+		}
+		outer.write(pad, sizeof(pad));
+	}
+
+	void sum(const void* data, size_t len, uint8_t out[Sha256::Size]) {
+		Sha256 in = inner;
+		in.write(data, len);
+		in.sum(out);
+
+		Sha256 o = outer;
+		o.write(out, Sha256::Size);
+		o.sum(out);
+	}
+
+private:
+	Sha256 inner;
+	Sha256 outer;
+};
+
+string hmacSha256(const string& key, const string& data) {
+	uint8_t out[Sha256::Size];
+	Hmac(key).sum(data.data(), data.size(), out);
+	return string((const char*)out, sizeof(out));
+}
+
+string pbkdf2Sha256(const string& password, const string& salt, int iter) {
+	Hmac h(password);
+	string s = salt;
+	s.append("\x00\x00\x00\x01", 4);
+
+	uint8_t u[Sha256::Size], t[Sha256::Size];
+	h.sum(s.data(), s.size(), u);
+	memcpy(t, u, sizeof(t));
+	for(int i = 1; i < iter; i++) {
+		h.sum(u, sizeof(u), u);
+		for(int j = 0; j < Sha256::Size; j++) {
+			t[j] ^= u[j];
+		}
+	}
+	return string((const char*)t, sizeof(t));
+}
+
+string scramAuthMessage(const string& name, uint8_t dbId,
+		const string& nonce, const string& salt, int iter) {
+	string msg;
+	msg.push_back(char(name.size()));
+	msg.append(name);
+	msg.push_back(char(dbId));
+	msg.push_back(char(nonce.size()));
+	msg.append(nonce);
+	for(int i = 3; i >= 0; i--) {
+		msg.push_back(char(uint32_t(iter) >> (8*i)));
+	}
+	msg.append(salt);
+	return msg;
+}
+
+void scramClientProof(const string& password, const string& salt, int iter,
+		const string& authMsg, string* proof, string* serverSig) {
+	string salted = pbkdf2Sha256(password, salt, iter);
+	string clientKey = hmacSha256(salted, "Client Key");
+
+	uint8_t storedKey[Sha256::Size];
+	Sha256 h;
+	h.write(clientKey.data(), clientKey.size());
+	h.sum(storedKey);
+
+	*proof = hmacSha256(string((const char*)storedKey, sizeof(storedKey)), authMsg);
+	for(size_t i = 0; i < proof->size(); i++) {
+		(*proof)[i] ^= clientKey[i];
+	}
+
+	*serverSig = hmacSha256(hmacSha256(salted, "Server Key"), authMsg);
+}
+
+int scramNonce(string* nonce) {
+	int fd = open("/dev/urandom", O_RDONLY);
+	if(fd < 0) {
+		return -1;
+	}
+
+	char buf[ScramNonceLen];
+	ssize_t n = read(fd, buf, sizeof(buf));
+	close(fd);
+	if(n != (ssize_t)sizeof(buf)) {
+		return -1;
+	}
+
+	nonce->assign(buf, sizeof(buf));
+	return 0;
+}
+
+}  // namespace gotable
@@ -0,0 +1,75 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#ifndef _GO_TABLE_SCRAM_H_
+#define _GO_TABLE_SCRAM_H_
+
+#include <stdint.h>
+#include <string>
+
+namespace gotable {
+
+using std::string;
+
+// Challenge-response auth, the same as util/scram.go of the server.
+enum {
+	ScramNonceLen = 16,
+	ScramMaxIter  = 1 << 20, // Refuse more iterations than this
+};
+
+// Nonce replied without challenge, fail on any other empty challenge
+static const char ScramAuthDisabled[] = "auth-disabled"; // No admin password
+static const char ScramAlreadyAdmin[] = "already-admin"; // Authorized as admin
+
+class Sha256 {
+public:
+	enum {
+		Size      = 32,
+		BlockSize = 64,
+	};
+
+	Sha256();
+	void write(const void* data, size_t len);
+	void sum(uint8_t out[Size]);
+
+private:
+	void block(const uint8_t* p);
+
+private:
+	uint32_t h[8];
+	uint8_t  buf[BlockSize];
+	size_t   nbuf;
+	uint64_t total;
+};
+
+string hmacSha256(const string& key, const string& data);
+
+// PBKDF2 with HMAC-SHA256, only the first block.
+string pbkdf2Sha256(const string& password, const string& salt, int iter);
+
+// The message both sides sign:
+// cNameLen+sName+cDbId+cNonceLen+sNonce+dwIter+sSalt
+string scramAuthMessage(const string& name, uint8_t dbId,
+		const string& nonce, const string& salt, int iter);
+
+// Get the client proof of the auth message, and the server signature
+// client expects.
+void scramClientProof(const string& password, const string& salt, int iter,
+		const string& authMsg, string* proof, string* serverSig);
+
+// Get a random nonce. Return value <0 means failed, 0 means succeed.
+int scramNonce(string* nonce);
+
+}  // namespace gotable
+#endif
@@ -0,0 +1,141 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"crypto/hmac"
+	"errors"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/util"
+)
+
+var (
+	ErrServerSig = errors.New("invalid server signature")
+)
+
+// Credential of a succeeded auth, used to authorize redirected connections.
+type authCred struct {
+	name     string // Empty for DB/admin password
+	password string
+}
+
+// Send plain text passwords by the old AUTH command instead of the
+// challenge-response auth. It's only for servers with auth.plain_auth
+// enabled, like the servers behind gotable-proxy.
+func (c *Client) SetPlainAuth(plain bool) {
+	c.mtx.Lock()
+	c.plainAuth = plain
+	c.mtx.Unlock()
+}
+
+// Authorize dbId by the password, or as the named user if name is not empty.
+// It returns the authorized DB ID (255 for admin).
+func (c *Client) auth(dbId uint8, name, password string) (uint8, error) {
+	c.mtx.Lock()
+	var plain = c.plainAuth
+	c.mtx.Unlock()
+
+	var authDB uint8
+	var err error
+	if plain {
+		authDB, err = c.plainAuthOp(dbId, name, password)
+	} else {
+		authDB, err = c.scramAuth(dbId, name, password)
+		if err == ErrAuthFailed && name == "" && dbId != proto.AdminDbId {
+			// The DB has its own password, try the admin password
+			authDB, err = c.scramAuth(proto.AdminDbId, "", password)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	c.cachAuth(authDB, name, password)
+	return authDB, nil
+}
+
+// Send one auth request, and return the decoded reply.
+func (c *Client) authOp(cmd, dbId uint8, rowKey, colKey,
+	value []byte) (*proto.PkgOneOp, error) {
+	call, err := c.NewContext(dbId).goOneOp(false, cmd, 0, rowKey, colKey,
+		value, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	var p proto.PkgOneOp
+	_, err = p.Decode(call.pkg)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (c *Client) plainAuthOp(dbId uint8, name, password string) (uint8, error) {
+	p, err := c.authOp(proto.CmdAuth, dbId, []byte(password), []byte(name), nil)
+	if err != nil {
+		return 0, err
+	}
+	return p.DbId, nil
+}
+
+// Challenge-response auth, the password is never sent to server.
+func (c *Client) scramAuth(dbId uint8, name, password string) (uint8, error) {
+	nonce, err := util.NewScramNonce()
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := c.authOp(proto.CmdScram, dbId, nonce, []byte(name), nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(p.Value) == 0 {
+		// No challenge: auth disabled or already admin
+		switch string(p.RowKey) {
+		case util.ScramAuthDisabled:
+			return p.DbId, nil
+		case util.ScramAlreadyAdmin:
+			if c.isAuthorized(proto.AdminDbId) {
+				return p.DbId, nil
+			}
+		}
+		return 0, ErrServerSig
+	}
+
+	var iter = int(p.Score)
+	if iter <= 0 || iter > util.ScramMaxIter {
+		return 0, ErrAuthFailed
+	}
+	var salt = copyBytes(p.Value)
+	nonce = copyBytes(p.RowKey)
+
+	var msg = util.ScramAuthMessage(name, dbId, nonce, salt, iter)
+	proof, serverSig := util.ScramClientProof(password, salt, iter, msg)
+
+	p, err = c.authOp(proto.CmdScram, dbId, nonce, []byte(name), proof)
+	if err != nil {
+		return 0, err
+	}
+	if !hmac.Equal(p.Value, serverSig) {
+		return 0, ErrServerSig
+	}
+	return p.DbId, nil
+}
@@ -18,6 +18,7 @@ package table
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/stevejiang/gotable/api/go/table/proto"
@@ -55,6 +56,11 @@ var (
 	ErrInvPkgLen   = initErr(EcInvPkgLen, "pkg length out of range")
 	ErrInvScanNum  = initErr(EcInvScanNum, "scan request number out of range")
 	ErrScanEnded   = initErr(EcScanEnded, "already scan/dump to end")
+	ErrNotSynced   = initErr(EcNotSynced, "slave has not synced to the seq")
+	ErrMoved       = initErr(EcMoved, "slot moved to another server")
+	ErrAsk         = initErr(EcAsk, "slot migrating to another server")
+	ErrCrossSlot   = initErr(EcCrossSlot, "keys are on different servers")
+	ErrPlainAuth   = initErr(EcPlainAuth, "use plain auth, no challenge-response")
 )
 
 // GoTable Error Code List
@@ -77,6 +83,11 @@ const (
 	EcInvPkgLen   = -21 // Pkg length should be less than 2MB
 	EcInvScanNum  = -22 // Scan request number out of range
 	EcScanEnded   = -23 // Already scan/dump to end
+	EcNotSynced   = -24 // Slave has not synced to the required binlog seq
+	EcMoved       = -25 // Slot moved to the new owner, retry there
+	EcAsk         = -26 // Slot migrating, ask the new owner for this request
+	EcCrossSlot   = -27 // Keys of the multi-key request are on different servers
+	EcPlainAuth   = -28 // Challenge-response auth not supported, use plain auth
 )
 
 var tableErrors = make([]error, 256)
@@ -101,13 +112,17 @@ type Client struct {
 	c       net.Conn
 	r       *bufio.Reader
 	sending chan *Call
-
-	mtx      sync.Mutex // protects following
-	authBM   *util.BitMap
-	seq      uint64
-	pending  map[uint64]*Call
-	closing  bool // user has called Close
-	shutdown bool // server has told us to stop
+	tlsConf *tls.Config // nil if not connected with TLS
+
+	mtx       sync.Mutex // protects following
+	authBM    *util.BitMap
+	creds     map[uint8]authCred // Succeeded auth credentials, used on redirect
+	plainAuth bool
+	redirects map[string]*Client
+	seq       uint64
+	pending   map[uint64]*Call
+	closing   bool // user has called Close
+	shutdown  bool // server has told us to stop
 }
 
 // Create a new connection Client to GoTable server.
@@ -150,10 +165,32 @@ func Dial(network, address string) (*Client, error) {
 	return NewClient(conn), nil
 }
 
+// DialTLS connects to the address of GoTable server with TLS. The config
+// has the CAs to verify the server certificate, and the client certificate
+// if the server requires one.
+func DialTLS(network, address string, config *tls.Config) (*Client, error) {
+	conn, err := tls.Dial(network, address, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var c = NewClient(conn)
+	c.tlsConf = config
+	return c, nil
+}
+
+// Connect with TLS if config is not nil.
+func dial(network, address string, config *tls.Config) (*Client, error) {
+	if config != nil {
+		return DialTLS(network, address, config)
+	}
+	return Dial(network, address)
+}
+
 // Create a new client Context with selected dbId.
 // All operations on the Context use the selected dbId.
 func (c *Client) NewContext(dbId uint8) *Context {
-	return &Context{c, dbId}
+	return &Context{c, dbId, nil}
 }
 
 // Close the connection.
@@ -183,6 +220,8 @@ func (c *Client) doClose() error {
 	close(c.sending)
 	var err = c.c.Close()
 
+	c.closeRedirects()
+
 	if c.p != nil {
 		c.p.remove(c)
 	}
@@ -207,17 +246,25 @@ func (c *Client) isAuthorized(dbId uint8) bool {
 }
 
 // Cache authorize result. When authorizing again, return directly.
-func (c *Client) cachAuth(pkg []byte) {
-	var one proto.PkgOneOp
-	_, err := one.Decode(pkg)
-	if err == nil && one.ErrCode == 0 {
-		c.mtx.Lock()
-		if c.authBM == nil {
-			c.authBM = util.NewBitMap(256 / 8)
-		}
-		c.authBM.Set(uint(one.DbId))
-		c.mtx.Unlock()
+// The credential is kept for authorizing redirected connections.
+// A named user may have limited access, so it's not cached as authorized,
+// and doesn't replace the password credential of the DB.
+func (c *Client) cachAuth(dbId uint8, name, password string) {
+	var user = len(name) > 0
+	c.mtx.Lock()
+	if c.authBM == nil {
+		c.authBM = util.NewBitMap(256 / 8)
+	}
+	if !user {
+		c.authBM.Set(uint(dbId))
+	}
+	if c.creds == nil {
+		c.creds = make(map[uint8]authCred)
 	}
+	if _, ok := c.creds[dbId]; !ok || !user {
+		c.creds[dbId] = authCred{name, password}
+	}
+	c.mtx.Unlock()
 }
 
 func (c *Client) recv() {
@@ -241,8 +288,8 @@ func (c *Client) recv() {
 		}
 		c.mtx.Unlock()
 
-		if proto.CmdAuth == head.Cmd {
-			c.cachAuth(pkg)
+		if call != nil && c.redirect(call, pkg) {
+			continue
 		}
 
 		if call != nil {
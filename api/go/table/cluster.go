@@ -0,0 +1,688 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/util"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	clusterTimeout = time.Second // Timeout of reading slot owners
+	clusterRetries = 3           // Max times a request retries after refresh
+)
+
+var ErrNoSlotOwner = errors.New("no server owns the slot")
+
+// A Cluster sends requests to the server which owns the slot of the key.
+// The slot owner map is read from the servers by CLUSTER SLOTS, and refreshed
+// when a request is redirected by EcMoved without the new owner address,
+// or the owner is unreachable.
+// It's safe to use in multiple goroutines.
+type Cluster struct {
+	network string
+	seeds   []string
+	connNum int
+	tlsConf *tls.Config // nil if not connecting with TLS
+
+	mtx    sync.RWMutex // protects following
+	owners [ctrl.TotalSlotNum]string
+	pools  map[string]*Pool
+	closed bool
+}
+
+// Create a Cluster with seed server addresses, and read the slot owner map.
+// Every server has a connection Pool with at most connNum connections.
+func NewCluster(network string, seeds []string, connNum int) (*Cluster, error) {
+	return NewTLSCluster(network, seeds, connNum, nil)
+}
+
+// NewTLSCluster creates a Cluster connecting to servers with TLS.
+func NewTLSCluster(network string, seeds []string, connNum int,
+	config *tls.Config) (*Cluster, error) {
+	var cl = new(Cluster)
+	cl.network = network
+	cl.seeds = seeds
+	cl.connNum = connNum
+	cl.tlsConf = config
+	cl.pools = make(map[string]*Pool)
+
+	err := cl.Refresh()
+	if err != nil {
+		cl.Close()
+		return nil, err
+	}
+	return cl, nil
+}
+
+// Refresh reads the slot owner map from the seeds and all known servers.
+func (cl *Cluster) Refresh() error {
+	var addrs = make([]string, 0, len(cl.seeds))
+	var visited = make(map[string]bool)
+	addrs = append(addrs, cl.seeds...)
+	cl.mtx.RLock()
+	for addr := range cl.pools {
+		addrs = append(addrs, addr)
+	}
+	cl.mtx.RUnlock()
+
+	var owners, migrating, hints [ctrl.TotalSlotNum]string
+	var lastErr error = ErrNoValidAddr
+	for i := 0; i < len(addrs); i++ {
+		var addr = addrs[i]
+		if visited[addr] {
+			continue
+		}
+		visited[addr] = true
+
+		p, err := cl.clusterSlots(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, o := range p.Owners {
+			for j := int(o.Start); j <= int(o.End); j++ {
+				switch o.State {
+				case ctrl.SlotOwned:
+					owners[j] = addr
+				case ctrl.SlotMigrating:
+					// Served by EcAsk redirection until the new owner owns it
+					migrating[j] = addr
+				case ctrl.SlotMoved:
+					hints[j] = o.Addr
+				}
+			}
+			if o.State == ctrl.SlotMoved && !visited[o.Addr] {
+				addrs = append(addrs, o.Addr)
+			}
+		}
+	}
+
+	var found bool
+	for i := 0; i < ctrl.TotalSlotNum; i++ {
+		if len(owners[i]) == 0 {
+			owners[i] = migrating[i]
+		}
+		if len(owners[i]) == 0 {
+			owners[i] = hints[i]
+		}
+		if len(owners[i]) > 0 {
+			found = true
+		}
+	}
+	if !found {
+		return lastErr
+	}
+
+	cl.mtx.Lock()
+	cl.owners = owners
+	cl.mtx.Unlock()
+	return nil
+}
+
+func (cl *Cluster) clusterSlots(addr string) (*ctrl.PkgCluster, error) {
+	conn, err := util.DialTimeout(cl.network, addr, clusterTimeout, cl.tlsConf)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(clusterTimeout))
+
+	var c = NewClient(conn)
+	defer c.Close()
+
+	var cc = CtrlContext(*c.NewContext(0))
+	return cc.ClusterSlots()
+}
+
+// GetOwner returns the owner address of the slot, empty if unknown.
+func (cl *Cluster) GetOwner(slotId uint16) string {
+	cl.mtx.RLock()
+	var addr = cl.owners[slotId]
+	cl.mtx.RUnlock()
+	return addr
+}
+
+// Close all connections of the Cluster.
+func (cl *Cluster) Close() {
+	cl.mtx.Lock()
+	if cl.closed {
+		cl.mtx.Unlock()
+		return
+	}
+	cl.closed = true
+	var pools = cl.pools
+	cl.pools = nil
+	cl.mtx.Unlock()
+
+	for _, p := range pools {
+		p.Close()
+	}
+}
+
+// Get the connection Pool of the slot owner.
+func (cl *Cluster) getPool(slotId uint16) (*Pool, error) {
+	cl.mtx.RLock()
+	if cl.closed {
+		cl.mtx.RUnlock()
+		return nil, ErrClosedPool
+	}
+	var addr = cl.owners[slotId]
+	var p = cl.pools[addr]
+	cl.mtx.RUnlock()
+
+	if p != nil {
+		return p, nil
+	}
+	if len(addr) == 0 {
+		return nil, ErrNoSlotOwner
+	}
+
+	cl.mtx.Lock()
+	defer cl.mtx.Unlock()
+	if cl.closed {
+		return nil, ErrClosedPool
+	}
+	p = cl.pools[addr]
+	if p == nil {
+		p = NewTLSPool([]Addr{{cl.network, addr}}, cl.connNum, cl.tlsConf)
+		cl.pools[addr] = p
+	}
+	return p, nil
+}
+
+// Create a new ClusterContext with selected dbId.
+func (cl *Cluster) NewContext(dbId uint8) *ClusterContext {
+	return &ClusterContext{cl: cl, dbId: dbId}
+}
+
+// A ClusterContext routes every request to the slot owner of its key.
+// Multi-key requests are split by slot owners and sent in parallel.
+type ClusterContext struct {
+	cl   *Cluster
+	dbId uint8
+
+	mtx      sync.Mutex // protects following
+	password string
+}
+
+// Authenticate to the servers. The password is kept to authenticate new
+// connections.
+func (c *ClusterContext) Auth(password string) error {
+	c.mtx.Lock()
+	c.password = password
+	c.mtx.Unlock()
+
+	var ctx, err = c.getContext(0)
+	if err != nil {
+		return err
+	}
+	err = ctx.Auth(password)
+	if err == ErrAuthFailed {
+		c.mtx.Lock()
+		c.password = ""
+		c.mtx.Unlock()
+	}
+	return err
+}
+
+// Get the Context connected to the owner of the slot.
+func (c *ClusterContext) getContext(slotId uint16) (*Context, error) {
+	p, err := c.cl.getPool(slotId)
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := p.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx = cli.NewContext(c.dbId)
+	c.mtx.Lock()
+	var password = c.password
+	c.mtx.Unlock()
+	if len(password) > 0 {
+		err = ctx.Auth(password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+// Return true if the request is not handled by the server,
+// and it can be retried after refreshing the slot owner map.
+func isClusterRetry(err error) bool {
+	switch err {
+	case ErrMoved:
+		fallthrough
+	case ErrCrossSlot:
+		fallthrough
+	case ErrNoSlotOwner:
+		fallthrough
+	case ErrNoValidAddr:
+		return true
+	}
+	return false
+}
+
+// Return true if the slot owner map should be refreshed.
+func isClusterRefresh(err error) bool {
+	if isClusterRetry(err) || err == ErrShutdown {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// Run the request on the owner of the slot.
+func (c *ClusterContext) do(tableId uint8, rowKey []byte,
+	op func(ctx *Context) error) error {
+	var slotId = ctrl.GetSlotId(c.dbId, tableId, rowKey)
+	var err error
+	for i := 0; i <= clusterRetries; i++ {
+		var ctx *Context
+		ctx, err = c.getContext(slotId)
+		if err == nil {
+			err = op(ctx)
+		}
+		if err == nil || !isClusterRefresh(err) {
+			return err
+		}
+
+		c.cl.Refresh()
+		if !isClusterRetry(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Keys of a multi-key request sent to the same server
+type multiGroup struct {
+	p      *Pool
+	slotId int // -1 if keys of all slots are in the group
+}
+
+// Run the request on the owners of the keys in parallel.
+// Keys are grouped by owner, and op is called with indexes of every group.
+// When a server fails a group by ErrCrossSlot, as some of its slots are being
+// moved, the keys are retried in groups of one slot.
+func (c *ClusterContext) doMulti(n int, key func(i int) (uint8, []byte),
+	op func(ctx *Context, idx []int) error) error {
+	var pending = make([]int, n)
+	for i := 0; i < n; i++ {
+		pending[i] = i
+	}
+
+	var err error
+	var bySlot bool
+	for i := 0; i <= clusterRetries && len(pending) > 0; i++ {
+		var groups = make(map[multiGroup][]int)
+		var retry []int
+		var lastErr error
+		for _, j := range pending {
+			tableId, rowKey := key(j)
+			var slotId = ctrl.GetSlotId(c.dbId, tableId, rowKey)
+			p, e := c.cl.getPool(slotId)
+			if e != nil {
+				retry = append(retry, j)
+				lastErr = e
+				continue
+			}
+			var g = multiGroup{p, -1}
+			if bySlot {
+				g.slotId = int(slotId)
+			}
+			groups[g] = append(groups[g], j)
+		}
+
+		var mtx sync.Mutex
+		var wg sync.WaitGroup
+		for g, idx := range groups {
+			wg.Add(1)
+			go func(p *Pool, idx []int) {
+				defer wg.Done()
+				cli, e := p.Get()
+				if e == nil {
+					var ctx = cli.NewContext(c.dbId)
+					c.mtx.Lock()
+					var password = c.password
+					c.mtx.Unlock()
+					if len(password) > 0 {
+						e = ctx.Auth(password)
+					}
+					if e == nil {
+						e = op(ctx, idx)
+					}
+				}
+				if e != nil {
+					mtx.Lock()
+					if isClusterRetry(e) {
+						retry = append(retry, idx...)
+					}
+					if e == ErrCrossSlot {
+						bySlot = true
+					}
+					if lastErr == nil || !isClusterRetry(e) {
+						lastErr = e
+					}
+					mtx.Unlock()
+				}
+			}(g.p, idx)
+		}
+		wg.Wait()
+
+		err = lastErr
+		if err == nil || !isClusterRefresh(err) {
+			return err
+		}
+
+		c.cl.Refresh()
+		if !isClusterRetry(err) {
+			return err
+		}
+		pending = retry
+	}
+	return err
+}
+
+// Get value&score of the key in default column space.
+func (c *ClusterContext) Get(tableId uint8, rowKey, colKey []byte, cas uint32) (
+	value []byte, score int64, newCas uint32, err error) {
+	err = c.do(tableId, rowKey, func(ctx *Context) error {
+		var e error
+		value, score, newCas, e = ctx.Get(tableId, rowKey, colKey, cas)
+		return e
+	})
+	return
+}
+
+// Get value&score of the key in "Z" sorted score column space.
+func (c *ClusterContext) ZGet(tableId uint8, rowKey, colKey []byte, cas uint32) (
+	value []byte, score int64, newCas uint32, err error) {
+	err = c.do(tableId, rowKey, func(ctx *Context) error {
+		var e error
+		value, score, newCas, e = ctx.ZGet(tableId, rowKey, colKey, cas)
+		return e
+	})
+	return
+}
+
+// Set key/value in default column space.
+func (c *ClusterContext) Set(tableId uint8, rowKey, colKey, value []byte,
+	score int64, cas uint32) error {
+	return c.do(tableId, rowKey, func(ctx *Context) error {
+		return ctx.Set(tableId, rowKey, colKey, value, score, cas)
+	})
+}
+
+// Set key/value in "Z" sorted score column space.
+func (c *ClusterContext) ZSet(tableId uint8, rowKey, colKey, value []byte,
+	score int64, cas uint32) error {
+	return c.do(tableId, rowKey, func(ctx *Context) error {
+		return ctx.ZSet(tableId, rowKey, colKey, value, score, cas)
+	})
+}
+
+// Delete the key in default column space.
+func (c *ClusterContext) Del(tableId uint8, rowKey, colKey []byte,
+	cas uint32) error {
+	return c.do(tableId, rowKey, func(ctx *Context) error {
+		return ctx.Del(tableId, rowKey, colKey, cas)
+	})
+}
+
+// Delete the key in "Z" sorted score column space.
+func (c *ClusterContext) ZDel(tableId uint8, rowKey, colKey []byte,
+	cas uint32) error {
+	return c.do(tableId, rowKey, func(ctx *Context) error {
+		return ctx.ZDel(tableId, rowKey, colKey, cas)
+	})
+}
+
+// Increase key/score in default column space.
+func (c *ClusterContext) Incr(tableId uint8, rowKey, colKey []byte, score int64,
+	cas uint32) (newValue []byte, newScore int64, err error) {
+	err = c.do(tableId, rowKey, func(ctx *Context) error {
+		var e error
+		newValue, newScore, e = ctx.Incr(tableId, rowKey, colKey, score, cas)
+		return e
+	})
+	return
+}
+
+// Increase key/score in "Z" sorted score column space.
+func (c *ClusterContext) ZIncr(tableId uint8, rowKey, colKey []byte, score int64,
+	cas uint32) (newValue []byte, newScore int64, err error) {
+	err = c.do(tableId, rowKey, func(ctx *Context) error {
+		var e error
+		newValue, newScore, e = ctx.ZIncr(tableId, rowKey, colKey, score, cas)
+		return e
+	})
+	return
+}
+
+func (c *ClusterContext) mGet(zop bool, args MGetArgs) ([]GetReply, error) {
+	var rs = make([]GetReply, len(args))
+	err := c.doMulti(len(args), func(i int) (uint8, []byte) {
+		return args[i].TableId, args[i].RowKey
+	}, func(ctx *Context, idx []int) error {
+		var sub = make(MGetArgs, len(idx))
+		for i, j := range idx {
+			sub[i] = args[j]
+		}
+		var r []GetReply
+		var e error
+		if zop {
+			r, e = ctx.ZmGet(sub)
+		} else {
+			r, e = ctx.MGet(sub)
+		}
+		for i := 0; e == nil && i < len(r) && i < len(idx); i++ {
+			rs[idx[i]] = r[i]
+		}
+		return e
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Get values&scores of multiple keys in default column space.
+func (c *ClusterContext) MGet(args MGetArgs) ([]GetReply, error) {
+	return c.mGet(false, args)
+}
+
+// Get values&scores of multiple keys in "Z" sorted score column space.
+func (c *ClusterContext) ZmGet(args MGetArgs) ([]GetReply, error) {
+	return c.mGet(true, args)
+}
+
+func (c *ClusterContext) mSet(zop bool, args MSetArgs) ([]SetReply, error) {
+	var rs = make([]SetReply, len(args))
+	err := c.doMulti(len(args), func(i int) (uint8, []byte) {
+		return args[i].TableId, args[i].RowKey
+	}, func(ctx *Context, idx []int) error {
+		var sub = make(MSetArgs, len(idx))
+		for i, j := range idx {
+			sub[i] = args[j]
+		}
+		var r []SetReply
+		var e error
+		if zop {
+			r, e = ctx.ZmSet(sub)
+		} else {
+			r, e = ctx.MSet(sub)
+		}
+		for i := 0; e == nil && i < len(r) && i < len(idx); i++ {
+			rs[idx[i]] = r[i]
+		}
+		return e
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Set multiple keys/values in default column space.
+func (c *ClusterContext) MSet(args MSetArgs) ([]SetReply, error) {
+	return c.mSet(false, args)
+}
+
+// Set multiple keys/values in "Z" sorted score column space.
+func (c *ClusterContext) ZmSet(args MSetArgs) ([]SetReply, error) {
+	return c.mSet(true, args)
+}
+
+func (c *ClusterContext) mDel(zop bool, args MDelArgs) ([]DelReply, error) {
+	var rs = make([]DelReply, len(args))
+	err := c.doMulti(len(args), func(i int) (uint8, []byte) {
+		return args[i].TableId, args[i].RowKey
+	}, func(ctx *Context, idx []int) error {
+		var sub = make(MDelArgs, len(idx))
+		for i, j := range idx {
+			sub[i] = args[j]
+		}
+		var r []DelReply
+		var e error
+		if zop {
+			r, e = ctx.ZmDel(sub)
+		} else {
+			r, e = ctx.MDel(sub)
+		}
+		for i := 0; e == nil && i < len(r) && i < len(idx); i++ {
+			rs[idx[i]] = r[i]
+		}
+		return e
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Delete multiple keys in default column space.
+func (c *ClusterContext) MDel(args MDelArgs) ([]DelReply, error) {
+	return c.mDel(false, args)
+}
+
+// Delete multiple keys in "Z" sorted score column space.
+func (c *ClusterContext) ZmDel(args MDelArgs) ([]DelReply, error) {
+	return c.mDel(true, args)
+}
+
+func (c *ClusterContext) mIncr(zop bool, args MIncrArgs) ([]IncrReply, error) {
+	var rs = make([]IncrReply, len(args))
+	err := c.doMulti(len(args), func(i int) (uint8, []byte) {
+		return args[i].TableId, args[i].RowKey
+	}, func(ctx *Context, idx []int) error {
+		var sub = make(MIncrArgs, len(idx))
+		for i, j := range idx {
+			sub[i] = args[j]
+		}
+		var r []IncrReply
+		var e error
+		if zop {
+			r, e = ctx.ZmIncr(sub)
+		} else {
+			r, e = ctx.MIncr(sub)
+		}
+		for i := 0; e == nil && i < len(r) && i < len(idx); i++ {
+			rs[idx[i]] = r[i]
+		}
+		return e
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Increase multiple keys/scores in default column space.
+func (c *ClusterContext) MIncr(args MIncrArgs) ([]IncrReply, error) {
+	return c.mIncr(false, args)
+}
+
+// Increase multiple keys/scores in "Z" sorted score column space.
+func (c *ClusterContext) ZmIncr(args MIncrArgs) ([]IncrReply, error) {
+	return c.mIncr(true, args)
+}
+
+// Scan columns of rowKey in default column space from MIN/MAX colKey.
+func (c *ClusterContext) Scan(tableId uint8, rowKey []byte,
+	asc bool, num int) (r ScanReply, err error) {
+	err = c.do(tableId, rowKey, func(ctx *Context) error {
+		var e error
+		r, e = ctx.Scan(tableId, rowKey, asc, num)
+		return e
+	})
+	return
+}
+
+// Scan columns of rowKey in default column space from pivot record.
+func (c *ClusterContext) ScanPivot(tableId uint8, rowKey, colKey []byte,
+	asc bool, num int) (r ScanReply, err error) {
+	err = c.do(tableId, rowKey, func(ctx *Context) error {
+		var e error
+		r, e = ctx.ScanPivot(tableId, rowKey, colKey, asc, num)
+		return e
+	})
+	return
+}
+
+// Scan columns of rowKey in "Z" sorted score space from MIN/MAX colKey and score.
+func (c *ClusterContext) ZScan(tableId uint8, rowKey []byte,
+	asc, orderByScore bool, num int) (r ScanReply, err error) {
+	err = c.do(tableId, rowKey, func(ctx *Context) error {
+		var e error
+		r, e = ctx.ZScan(tableId, rowKey, asc, orderByScore, num)
+		return e
+	})
+	return
+}
+
+// Scan columns of rowKey in "Z" sorted score space from pivot record.
+func (c *ClusterContext) ZScanPivot(tableId uint8, rowKey, colKey []byte,
+	score int64, asc, orderByScore bool, num int) (r ScanReply, err error) {
+	err = c.do(tableId, rowKey, func(ctx *Context) error {
+		var e error
+		r, e = ctx.ZScanPivot(tableId, rowKey, colKey, score,
+			asc, orderByScore, num)
+		return e
+	})
+	return
+}
+
+// Scan/ZScan more records.
+func (c *ClusterContext) ScanMore(last ScanReply) (r ScanReply, err error) {
+	if last.End || len(last.Kvs) == 0 {
+		return ScanReply{}, ErrScanEnded
+	}
+	err = c.do(last.ctx.tableId, last.ctx.rowKey, func(ctx *Context) error {
+		var e error
+		r, e = ctx.ScanMore(last)
+		return e
+	})
+	return
+}
@@ -25,16 +25,19 @@ import (
 type Context struct {
 	cli  *Client
 	dbId uint8
+	ses  *Session // Session tracks binlog seq, may be nil
 }
 
 type Call struct {
 	Done  chan *Call  // Reply channel
 	ctx   interface{} // Request context
+	ses   *Session
 	err   error
 	pkg   []byte
 	seq   uint64
 	cmd   uint8
 	ready bool // Ready to invoke Reply?
+	moved int  // Times redirected by EcMoved/EcAsk
 }
 
 // Get the underling connection Client of the Context.
@@ -47,21 +50,24 @@ func (c *Context) DatabaseId() uint8 {
 	return c.dbId
 }
 
-// Authenticate to the server.
+// Authenticate to the server. The password is not sent to the server, it's
+// checked by the challenge-response auth unless Client.SetPlainAuth is set.
 func (c *Context) Auth(password string) error {
 	if c.cli.isAuthorized(c.dbId) {
 		return nil
 	}
 
-	call, err := c.goOneOp(false, proto.CmdAuth, 0, []byte(password), nil, nil, 0, 0, nil)
-	if err != nil {
-		return err
-	}
-
-	_, err = (<-call.Done).Reply()
+	_, err := c.cli.auth(c.dbId, "", password)
 	return err
 }
 
+// Authenticate to the server as a named user, and return the authorized
+// database ID (255 for admin role). Users with read role can only read, and
+// users scoped to tables can only access the tables.
+func (c *Context) AuthUser(name, password string) (uint8, error) {
+	return c.cli.auth(c.dbId, name, password)
+}
+
 // Ping the server.
 func (c *Context) Ping() error {
 	call, err := c.GoPing(nil)
@@ -404,6 +410,13 @@ func (c *Context) goOneOp(zop bool, cmd, tableId uint8,
 		p.PkgFlag |= proto.FlagZop
 	}
 
+	if c.ses != nil {
+		call.ses = c.ses
+		if cmd == proto.CmdGet {
+			p.SetLogSeq(c.ses.LogSeq())
+		}
+	}
+
 	var pkgLen = p.Length()
 	if pkgLen > proto.MaxPkgLen {
 		c.cli.errCall(call, ErrInvPkgLen)
@@ -494,6 +507,13 @@ func (c *Context) goMultiOp(zop bool, args multiArgs, cmd uint8,
 		p.PkgFlag |= proto.FlagZop
 	}
 
+	if c.ses != nil {
+		call.ses = c.ses
+		if cmd == proto.CmdMGet {
+			p.SetLogSeq(c.ses.LogSeq())
+		}
+	}
+
 	p.Kvs = make([]proto.KeyValue, args.length())
 	args.toKV(p.Kvs)
 
@@ -582,6 +602,9 @@ func (c *Context) goScan(zop bool, tableId uint8, rowKey, colKey []byte,
 	p.TableId = tableId
 	p.RowKey = rowKey
 	p.ColKey = colKey
+	if c.ses != nil {
+		p.SetLogSeq(c.ses.LogSeq())
+	}
 
 	// ZScan
 	if zop {
@@ -691,6 +714,22 @@ type CtrlContext Context
 // Internal control command.
 // SlaveOf can change the replication settings of a slave on the fly.
 func (c *CtrlContext) SlaveOf(host string) error {
+	return c.SlaveOfFilter(host, nil)
+}
+
+// Internal control command.
+// SlaveOfFilter is like SlaveOf, but only replicates the DBs/tables
+// selected by filter. Nil filter replicates everything.
+// Filter changes only apply to new writes if the slave is already synced.
+func (c *CtrlContext) SlaveOfFilter(host string, filter *ctrl.SyncFilter) error {
+	return c.SlaveOfDelay(host, filter, 0)
+}
+
+// Internal control command.
+// SlaveOfDelay is like SlaveOfFilter, but the slave applies changes
+// delay seconds behind the master. Zero delay means no delay.
+func (c *CtrlContext) SlaveOfDelay(host string, filter *ctrl.SyncFilter,
+	delay int) error {
 	call := c.cli.newCall(proto.CmdSlaveOf, nil)
 	if call.err != nil {
 		return call.err
@@ -699,6 +738,10 @@ func (c *CtrlContext) SlaveOf(host string) error {
 	var p ctrl.PkgSlaveOf
 	p.ClientReq = true
 	p.MasterAddr = host
+	if filter != nil {
+		p.Filter = *filter
+	}
+	p.Delay = delay
 
 	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
 	if err != nil {
@@ -724,6 +767,13 @@ func (c *CtrlContext) SlaveOf(host string) error {
 // Internal control command.
 // Migrate moves one slot data to another server on the fly.
 func (c *CtrlContext) Migrate(host string, slotId uint16) error {
+	return c.MigrateSlots(host, []ctrl.SlotRange{{Start: slotId, End: slotId}})
+}
+
+// Internal control command.
+// MigrateSlots moves the data of the slot ranges to another server on the fly.
+// All slots are migrated over one connection with one scan.
+func (c *CtrlContext) MigrateSlots(host string, slots []ctrl.SlotRange) error {
 	call := c.cli.newCall(proto.CmdMigrate, nil)
 	if call.err != nil {
 		return call.err
@@ -732,7 +782,10 @@ func (c *CtrlContext) Migrate(host string, slotId uint16) error {
 	var p ctrl.PkgMigrate
 	p.ClientReq = true
 	p.MasterAddr = host
-	p.SlotId = slotId
+	p.Slots = slots
+	if len(slots) > 0 {
+		p.SlotId = slots[0].Start
+	}
 
 	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
 	if err != nil {
@@ -758,9 +811,22 @@ func (c *CtrlContext) Migrate(host string, slotId uint16) error {
 // Internal control command.
 // SlaveStatus reads migration/slave status.
 func (c *CtrlContext) SlaveStatus(migration bool, slotId uint16) (int, error) {
+	t, err := c.GetSlaveStatus(migration, slotId)
+	if err != nil {
+		return ctrl.NotSlave, err
+	}
+	return t.Status, nil
+}
+
+// Internal control command.
+// GetSlaveStatus reads migration/slave status with more details.
+// For migration, slotId can be any slot under migration, and the status of
+// each migrating slot range is returned in SlotStatus.
+func (c *CtrlContext) GetSlaveStatus(migration bool, slotId uint16) (
+	*ctrl.PkgSlaveStatus, error) {
 	call := c.cli.newCall(proto.CmdSlaveSt, nil)
 	if call.err != nil {
-		return ctrl.NotSlave, call.err
+		return nil, call.err
 	}
 
 	var p ctrl.PkgSlaveStatus
@@ -770,7 +836,7 @@ func (c *CtrlContext) SlaveStatus(migration bool, slotId uint16) (int, error) {
 	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
 	if err != nil {
 		c.cli.errCall(call, err)
-		return ctrl.NotSlave, call.err
+		return nil, call.err
 	}
 
 	call.pkg = pkg
@@ -778,19 +844,34 @@ func (c *CtrlContext) SlaveStatus(migration bool, slotId uint16) (int, error) {
 
 	r, err := (<-call.Done).Reply()
 	if err != nil {
-		return ctrl.NotSlave, call.err
+		return nil, err
 	}
 
 	t := r.(*ctrl.PkgSlaveStatus)
 	if t.ErrMsg != "" {
-		return ctrl.NotSlave, errors.New(t.ErrMsg)
+		return nil, errors.New(t.ErrMsg)
 	}
-	return t.Status, nil
+	return t, nil
 }
 
 // Internal control command.
 // DelSlot deletes one slot data.
 func (c *CtrlContext) DelSlot(slotId uint16) error {
+	return c.delSlots(slotId, nil, false)
+}
+
+// Internal control command.
+// DelSlots deletes data of the slot ranges, and compacts the slots after
+// deleting if compact is true. It's used for bulk cleanup after rebalancing.
+func (c *CtrlContext) DelSlots(slots []ctrl.SlotRange, compact bool) error {
+	if len(slots) == 0 {
+		return nil
+	}
+	return c.delSlots(0, slots, compact)
+}
+
+func (c *CtrlContext) delSlots(slotId uint16, slots []ctrl.SlotRange,
+	compact bool) error {
 	call := c.cli.newCall(proto.CmdDelSlot, nil)
 	if call.err != nil {
 		return call.err
@@ -798,6 +879,8 @@ func (c *CtrlContext) DelSlot(slotId uint16) error {
 
 	var p ctrl.PkgDelSlot
 	p.SlotId = slotId
+	p.Slots = slots
+	p.Compact = compact
 
 	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
 	if err != nil {
@@ -820,6 +903,619 @@ func (c *CtrlContext) DelSlot(slotId uint16) error {
 	return nil
 }
 
+// Internal control command.
+// Switchover promotes the slave host to be the new master, and turns the
+// current master into its slave. Timeout is the max seconds to wait for
+// the slave to sync, 0 means default.
+func (c *CtrlContext) Switchover(host string, timeout int) error {
+	call := c.cli.newCall(proto.CmdSwitch, nil)
+	if call.err != nil {
+		return call.err
+	}
+
+	var p ctrl.PkgSwitchover
+	p.SlaveAddr = host
+	p.Timeout = timeout
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return err
+	}
+
+	t := r.(*ctrl.PkgSwitchover)
+	if t.ErrMsg != "" {
+		return errors.New(t.ErrMsg)
+	}
+	return nil
+}
+
+// Internal control command.
+// DelayCtrl pauses, resumes or fast-forwards the delayed slave, and returns
+// the delayed apply status. Op is one of ctrl.DelayStatus, ctrl.DelayPause,
+// ctrl.DelayResume and ctrl.DelayForward. Until is the Unix time to
+// fast-forward to, 0 means now.
+func (c *CtrlContext) DelayCtrl(op int, until int64) (*ctrl.PkgDelay, error) {
+	call := c.cli.newCall(proto.CmdDelay, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	var p ctrl.PkgDelay
+	p.Op = op
+	p.Until = until
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgDelay)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
+// Internal control command.
+// SetSlot sets the owner state of slots on the server. Requests for slots
+// not owned are redirected to addr by EcMoved(ctrl.SlotMoved) or
+// EcAsk(ctrl.SlotMigrating). Use ctrl.SlotOwned to serve them here again.
+func (c *CtrlContext) SetSlot(slots []ctrl.SlotRange, state int, addr string) error {
+	call := c.cli.newCall(proto.CmdSetSlot, nil)
+	if call.err != nil {
+		return call.err
+	}
+
+	var p ctrl.PkgSetSlot
+	p.Slots = slots
+	p.State = state
+	p.Addr = addr
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return err
+	}
+
+	t := r.(*ctrl.PkgSetSlot)
+	if t.ErrMsg != "" {
+		return errors.New(t.ErrMsg)
+	}
+	return nil
+}
+
+// Internal control command.
+// ClusterSlots returns the slot owners of the server.
+func (c *CtrlContext) ClusterSlots() (*ctrl.PkgCluster, error) {
+	return c.cluster(ctrl.ClusterSlots, nil)
+}
+
+// Internal control command.
+// ClusterSizes returns the slot owners and the data size of every slot.
+func (c *CtrlContext) ClusterSizes() (*ctrl.PkgCluster, error) {
+	return c.cluster(ctrl.ClusterSizes, nil)
+}
+
+// Internal control command.
+// ClusterSetSlots switches the server to cluster mode, and only the slots are
+// served by it. Requests for other slots are redirected by EcMoved.
+func (c *CtrlContext) ClusterSetSlots(slots []ctrl.SlotRange) (*ctrl.PkgCluster, error) {
+	return c.cluster(ctrl.ClusterSetSlots, slots)
+}
+
+// Internal control command.
+// ClusterReset switches the server to normal mode, and all slots are served.
+func (c *CtrlContext) ClusterReset() (*ctrl.PkgCluster, error) {
+	return c.cluster(ctrl.ClusterReset, nil)
+}
+
+func (c *CtrlContext) cluster(op int, slots []ctrl.SlotRange) (*ctrl.PkgCluster, error) {
+	call := c.cli.newCall(proto.CmdCluster, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	var p ctrl.PkgCluster
+	p.Op = op
+	p.Slots = slots
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgCluster)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
+// Internal control command.
+// GetRateLimit gets the rate limits of sync data sent to slaves and
+// migration servers, and the RocksDB IO rate limit.
+func (c *CtrlContext) GetRateLimit() (*ctrl.PkgRateLimit, error) {
+	return c.rateLimit(false, 0, 0)
+}
+
+// Internal control command.
+// SetRateLimit sets max MB/s and keys/s of sync data sent to slaves and
+// migration servers at runtime, 0 means unlimited.
+func (c *CtrlContext) SetRateLimit(syncMBps, syncKeys int64) (*ctrl.PkgRateLimit, error) {
+	return c.rateLimit(true, syncMBps, syncKeys)
+}
+
+func (c *CtrlContext) rateLimit(set bool, syncMBps, syncKeys int64) (*ctrl.PkgRateLimit, error) {
+	call := c.cli.newCall(proto.CmdLimit, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	var p ctrl.PkgRateLimit
+	p.Set = set
+	p.SyncMBps = syncMBps
+	p.SyncKeys = syncKeys
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgRateLimit)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
+// Internal control command.
+// Stats gets the approximate data size and key count of every slot
+// (ctrl.StatsSlots) or every db/table (ctrl.StatsTables) in the slots, or
+// starts counting keys in the background (ctrl.StatsCount).
+// Empty slots means all slots.
+func (c *CtrlContext) Stats(op int, slots []ctrl.SlotRange) (*ctrl.PkgStats, error) {
+	call := c.cli.newCall(proto.CmdStats, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	var p ctrl.PkgStats
+	p.Op = op
+	p.Slots = slots
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgStats)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
+// Internal control command.
+// Verify checks slot checksums between the master and the slave. Op is one
+// of ctrl.VerifyStart (sent to the master with slaveAddr), ctrl.VerifyStatus
+// and ctrl.VerifyRepair. Empty slots means all slots. Seq is unused, the
+// master tells the slave the snapshot seq in the sync stream.
+func (c *CtrlContext) Verify(op int, slaveAddr string, slots []ctrl.SlotRange,
+	seq uint64) (*ctrl.PkgVerify, error) {
+	call := c.cli.newCall(proto.CmdVerify, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	var p ctrl.PkgVerify
+	p.Op = op
+	p.SlaveAddr = slaveAddr
+	p.Slots = slots
+	p.Seq = seq
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgVerify)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
+// Internal control command.
+// Info returns the server, storage and replication statistics as text.
+// Empty section means all sections.
+func (c *CtrlContext) Info(section string) (string, error) {
+	call := c.cli.newCall(proto.CmdInfo, nil)
+	if call.err != nil {
+		return "", call.err
+	}
+
+	var p ctrl.PkgInfo
+	p.Section = section
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return "", err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return "", err
+	}
+
+	t := r.(*ctrl.PkgInfo)
+	if t.ErrMsg != "" {
+		return "", errors.New(t.ErrMsg)
+	}
+	return t.Info, nil
+}
+
+// Internal control command.
+// SlowLog gets at most num latest slow requests (all if num <= 0) when op is
+// ctrl.SlowLogGet, or clears them when op is ctrl.SlowLogReset.
+func (c *CtrlContext) SlowLog(op, num int) (*ctrl.PkgSlowLog, error) {
+	call := c.cli.newCall(proto.CmdSlowLog, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	var p ctrl.PkgSlowLog
+	p.Op = op
+	p.Num = num
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgSlowLog)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
+// Internal control command.
+// ClientList returns the client connections of the server.
+func (c *CtrlContext) ClientList() ([]ctrl.ClientInfo, error) {
+	var p ctrl.PkgClient
+	p.Op = ctrl.ClientList
+	t, err := c.client(&p)
+	if err != nil {
+		return nil, err
+	}
+	return t.Clients, nil
+}
+
+// Internal control command.
+// ClientKill closes the client connection of addr (ip:port), or all other
+// client connections of cliType (normal, slave or master) if addr is empty.
+// It returns the number of connections closed.
+func (c *CtrlContext) ClientKill(addr, cliType string) (int, error) {
+	var p ctrl.PkgClient
+	p.Op = ctrl.ClientKill
+	p.Addr = addr
+	p.Type = cliType
+	t, err := c.client(&p)
+	if err != nil {
+		return 0, err
+	}
+	return t.Killed, nil
+}
+
+func (c *CtrlContext) client(p *ctrl.PkgClient) (*ctrl.PkgClient, error) {
+	call := c.cli.newCall(proto.CmdClient, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgClient)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
+// Internal control command.
+// ConfigGet returns the config items matching pattern (like "binlog.*").
+func (c *CtrlContext) ConfigGet(pattern string) ([]ctrl.ConfigItem, error) {
+	var p ctrl.PkgConfig
+	p.Op = ctrl.ConfigGet
+	p.Name = pattern
+	t, err := c.config(&p)
+	if err != nil {
+		return nil, err
+	}
+	return t.Items, nil
+}
+
+// Internal control command.
+// ConfigSet changes the config item at runtime.
+func (c *CtrlContext) ConfigSet(name, value string) error {
+	var p ctrl.PkgConfig
+	p.Op = ctrl.ConfigSet
+	p.Name = name
+	p.Value = value
+	_, err := c.config(&p)
+	return err
+}
+
+func (c *CtrlContext) config(p *ctrl.PkgConfig) (*ctrl.PkgConfig, error) {
+	call := c.cli.newCall(proto.CmdConfig, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgConfig)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
+// Internal control command.
+// Shutdown stops the server gracefully. The server waits at most timeout
+// seconds (0 means default) for the queued requests, flushes and closes the
+// binlog and the table, and then exits.
+func (c *CtrlContext) Shutdown(timeout int) error {
+	call := c.cli.newCall(proto.CmdShutdown, nil)
+	if call.err != nil {
+		return call.err
+	}
+
+	var p ctrl.PkgShutdown
+	p.Timeout = timeout
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return err
+	}
+
+	t := r.(*ctrl.PkgShutdown)
+	if t.ErrMsg != "" {
+		return errors.New(t.ErrMsg)
+	}
+	return nil
+}
+
+// Internal control command.
+// SetPassword sets or changes the password of DB dbId. The password is kept
+// hashed in the admin DB and replicated to slaves.
+func (c *CtrlContext) SetPassword(dbId uint8, password string) error {
+	var p ctrl.PkgPasswd
+	p.Op = ctrl.PasswdSet
+	p.DbId = dbId
+	p.Password = password
+	_, err := c.passwd(&p)
+	return err
+}
+
+// Internal control command.
+// DelPassword removes the password of DB dbId.
+func (c *CtrlContext) DelPassword(dbId uint8) error {
+	var p ctrl.PkgPasswd
+	p.Op = ctrl.PasswdDel
+	p.DbId = dbId
+	_, err := c.passwd(&p)
+	return err
+}
+
+// Internal control command.
+// PasswordDbs returns the DBs having passwords set by SetPassword.
+func (c *CtrlContext) PasswordDbs() ([]int, error) {
+	var p ctrl.PkgPasswd
+	p.Op = ctrl.PasswdList
+	t, err := c.passwd(&p)
+	if err != nil {
+		return nil, err
+	}
+	return t.Dbs, nil
+}
+
+func (c *CtrlContext) passwd(p *ctrl.PkgPasswd) (*ctrl.PkgPasswd, error) {
+	call := c.cli.newCall(proto.CmdPasswd, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgPasswd)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
+// Internal control command.
+// SetUser creates or changes the named user with the password. The user is
+// kept in the admin DB and replicated to slaves.
+func (c *CtrlContext) SetUser(u ctrl.UserInfo, password string) error {
+	var p ctrl.PkgUser
+	p.Op = ctrl.UserSet
+	p.User = u
+	p.Password = password
+	_, err := c.user(&p)
+	return err
+}
+
+// Internal control command.
+// DelUser removes the named user.
+func (c *CtrlContext) DelUser(name string) error {
+	var p ctrl.PkgUser
+	p.Op = ctrl.UserDel
+	p.User.Name = name
+	_, err := c.user(&p)
+	return err
+}
+
+// Internal control command.
+// Users returns all named users ordered by name.
+func (c *CtrlContext) Users() ([]ctrl.UserInfo, error) {
+	var p ctrl.PkgUser
+	p.Op = ctrl.UserList
+	t, err := c.user(&p)
+	if err != nil {
+		return nil, err
+	}
+	return t.Users, nil
+}
+
+func (c *CtrlContext) user(p *ctrl.PkgUser) (*ctrl.PkgUser, error) {
+	call := c.cli.newCall(proto.CmdUser, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgUser)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
 // Get call reply. The real reply types are:
 // Auth/Ping/(Z)Set/(Z)Del: nil;
 // (Z)Get: GetReply;
@@ -840,6 +1536,7 @@ func (call *Call) Reply() (interface{}, error) {
 	}
 
 	if proto.CmdAuth == call.cmd ||
+		proto.CmdScram == call.cmd ||
 		proto.CmdPing == call.cmd ||
 		proto.CmdIncr == call.cmd ||
 		proto.CmdDel == call.cmd ||
@@ -854,9 +1551,14 @@ func (call *Call) Reply() (interface{}, error) {
 		if p.ErrCode < 0 {
 			return nil, getErr(p.ErrCode)
 		}
+		if call.ses != nil {
+			call.ses.UpdateLogSeq(p.LogSeq)
+		}
 		switch call.cmd {
 		case proto.CmdAuth:
 			return nil, nil
+		case proto.CmdScram:
+			return nil, nil
 		case proto.CmdPing:
 			return nil, nil
 		case proto.CmdIncr:
@@ -886,6 +1588,9 @@ func (call *Call) Reply() (interface{}, error) {
 		if p.ErrCode < 0 {
 			return nil, getErr(p.ErrCode)
 		}
+		if call.ses != nil {
+			call.ses.UpdateLogSeq(p.LogSeq)
+		}
 
 		switch call.cmd {
 		case proto.CmdMIncr:
@@ -981,6 +1686,38 @@ func (call *Call) Reply() (interface{}, error) {
 		return call.replyInnerCtrl(&ctrl.PkgSlaveStatus{})
 	case proto.CmdDelSlot:
 		return call.replyInnerCtrl(&ctrl.PkgDelSlot{})
+	case proto.CmdSwitch:
+		return call.replyInnerCtrl(&ctrl.PkgSwitchover{})
+	case proto.CmdDelay:
+		return call.replyInnerCtrl(&ctrl.PkgDelay{})
+	case proto.CmdSetSlot:
+		return call.replyInnerCtrl(&ctrl.PkgSetSlot{})
+	case proto.CmdCluster:
+		return call.replyInnerCtrl(&ctrl.PkgCluster{})
+	case proto.CmdLimit:
+		return call.replyInnerCtrl(&ctrl.PkgRateLimit{})
+	case proto.CmdStats:
+		return call.replyInnerCtrl(&ctrl.PkgStats{})
+	case proto.CmdVerify:
+		return call.replyInnerCtrl(&ctrl.PkgVerify{})
+	case proto.CmdInfo:
+		return call.replyInnerCtrl(&ctrl.PkgInfo{})
+	case proto.CmdSlowLog:
+		return call.replyInnerCtrl(&ctrl.PkgSlowLog{})
+	case proto.CmdClient:
+		return call.replyInnerCtrl(&ctrl.PkgClient{})
+	case proto.CmdConfig:
+		return call.replyInnerCtrl(&ctrl.PkgConfig{})
+	case proto.CmdShutdown:
+		return call.replyInnerCtrl(&ctrl.PkgShutdown{})
+	case proto.CmdPasswd:
+		return call.replyInnerCtrl(&ctrl.PkgPasswd{})
+	case proto.CmdUser:
+		return call.replyInnerCtrl(&ctrl.PkgUser{})
+	case proto.CmdGetMaster:
+		return call.replyInnerCtrl(&ctrl.PkgGetMaster{})
+	case proto.CmdVoteDown:
+		return call.replyInnerCtrl(&ctrl.PkgVoteDown{})
 	}
 
 	return nil, ErrUnknownCmd
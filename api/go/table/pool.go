@@ -15,6 +15,7 @@
 package table
 
 import (
+	"crypto/tls"
 	"math/rand"
 	"net"
 	"sync"
@@ -34,6 +35,7 @@ type Addr struct {
 type Pool struct {
 	as      []Addr // Server address list, never change once assigned
 	connNum int
+	tlsConf *tls.Config // nil if not connecting with TLS
 
 	mtx      sync.Mutex
 	status   []int
@@ -44,8 +46,14 @@ type Pool struct {
 }
 
 func NewPool(as []Addr, connNum int) *Pool {
+	return NewTLSPool(as, connNum, nil)
+}
+
+// NewTLSPool creates a Pool connecting to servers with TLS.
+func NewTLSPool(as []Addr, connNum int, config *tls.Config) *Pool {
 	var p = new(Pool)
 	p.connNum = connNum
+	p.tlsConf = config
 	p.as = as
 	for i := 0; i < len(as); i++ {
 		p.status = append(p.status, statusOk)
@@ -78,7 +86,7 @@ func (p *Pool) Get() (*Client, error) {
 		lastAddr = (lastAddr + 1) % len(p.as)
 		if p.status[lastAddr] == statusOk {
 			var addr = p.as[lastAddr]
-			c, err := Dial(addr.Network, addr.Address)
+			c, err := dial(addr.Network, addr.Address, p.tlsConf)
 			if err != nil {
 				p.status[lastAddr] = statusErr
 				continue
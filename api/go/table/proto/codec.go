@@ -96,7 +96,12 @@ func (kv *KeyValue) SetValue(value []byte) {
 // PkgFlag
 const (
 	// Common flags
-	FlagZop = 0x1 // if set, it is a "Z" op
+	FlagZop    = 0x1  // if set, it is a "Z" op
+	FlagLogSeq = 0x2  // if set, pkg ends with ddwLogSeq (binlog seq token)
+	FlagAsking = 0x40 // if set, request is redirected by EcAsk
+
+	// Binlog flags
+	FlagLogTime = 0x20 // if set, pkg has ddwLogTime (sent to delayed slave)
 
 	// (Z)Scan flags
 	FlagScanAsc      = 0x4  // if set, Scan in ASC order, else DESC order
@@ -110,20 +115,24 @@ const (
 )
 
 // Get, Set, Del, GetSet, GetDel, ZGet, ZSet, Sync
-// PKG=HEAD+cPkgFlag+KeyValue
+// PKG=HEAD+cPkgFlag+KeyValue+[ddwLogTime]+[ddwLogSeq]
 type PkgOneOp struct {
 	PkgHead
 	PkgFlag uint8
 	KeyValue
+	LogTime int64  // Binlog write time sent to delayed slave, Unix seconds
+	LogSeq  uint64 // Write reply: binlog seq; Read request: min binlog seq
 }
 
 // MGet, MSet, MDel, MZGet, MZSet, MZDel
-// PKG=HEAD+cPkgFlag+cErrCode+wNum+KeyValue[wNum]
+// PKG=HEAD+cPkgFlag+cErrCode+wNum+KeyValue[wNum]+[ddwLogTime]+[ddwLogSeq]
 type PkgMultiOp struct {
 	PkgFlag uint8
 	ErrCode int8
 	PkgHead
-	Kvs []KeyValue
+	Kvs     []KeyValue
+	LogTime int64  // Binlog write time sent to delayed slave, Unix seconds
+	LogSeq  uint64 // Write reply: binlog seq; Read request: min binlog seq
 }
 
 // Scan, ZScan
@@ -319,9 +328,25 @@ func (kv *KeyValue) Decode(pkg []byte) (int, error) {
 	return n, nil
 }
 
+func (p *PkgOneOp) SetLogSeq(logSeq uint64) {
+	p.LogSeq = logSeq
+	if logSeq != 0 {
+		p.PkgFlag |= FlagLogSeq
+	} else {
+		p.PkgFlag &^= FlagLogSeq
+	}
+}
+
 func (p *PkgOneOp) Length() int {
-	// PKG = HEAD+cPkgFlag+KeyValue
-	return HeadSize + 1 + p.KeyValue.Length()
+	// PKG = HEAD+cPkgFlag+KeyValue+[ddwLogTime]+[ddwLogSeq]
+	var n = HeadSize + 1 + p.KeyValue.Length()
+	if p.PkgFlag&FlagLogTime != 0 {
+		n += 8
+	}
+	if p.PkgFlag&FlagLogSeq != 0 {
+		n += 8
+	}
+	return n
 }
 
 func (p *PkgOneOp) Encode(pkg []byte) (int, error) {
@@ -342,6 +367,22 @@ func (p *PkgOneOp) Encode(pkg []byte) (int, error) {
 	}
 	n += m
 
+	if p.PkgFlag&FlagLogTime != 0 {
+		if n+8 > len(pkg) {
+			return n, ErrPkgLen
+		}
+		binary.BigEndian.PutUint64(pkg[n:], uint64(p.LogTime))
+		n += 8
+	}
+
+	if p.PkgFlag&FlagLogSeq != 0 {
+		if n+8 > len(pkg) {
+			return n, ErrPkgLen
+		}
+		binary.BigEndian.PutUint64(pkg[n:], p.LogSeq)
+		n += 8
+	}
+
 	OverWriteLen(pkg, n)
 	return n, nil
 }
@@ -364,15 +405,39 @@ func (p *PkgOneOp) Decode(pkg []byte) (int, error) {
 	}
 	n += m
 
+	p.LogTime = 0
+	if p.PkgFlag&FlagLogTime != 0 {
+		if n+8 > len(pkg) {
+			return n, ErrPkgLen
+		}
+		p.LogTime = int64(binary.BigEndian.Uint64(pkg[n:]))
+		n += 8
+	}
+
+	p.LogSeq = 0
+	if p.PkgFlag&FlagLogSeq != 0 {
+		if n+8 > len(pkg) {
+			return n, ErrPkgLen
+		}
+		p.LogSeq = binary.BigEndian.Uint64(pkg[n:])
+		n += 8
+	}
+
 	return n, nil
 }
 
 func (p *PkgMultiOp) Length() int {
-	// PKG = HEAD+cPkgFlag+cErrCode+wNum+KeyValue[wNum]
+	// PKG = HEAD+cPkgFlag+cErrCode+wNum+KeyValue[wNum]+[ddwLogTime]+[ddwLogSeq]
 	var n = HeadSize + 4
 	for i := 0; i < len(p.Kvs); i++ {
 		n += p.Kvs[i].Length()
 	}
+	if p.PkgFlag&FlagLogTime != 0 {
+		n += 8
+	}
+	if p.PkgFlag&FlagLogSeq != 0 {
+		n += 8
+	}
 	return n
 }
 
@@ -380,6 +445,15 @@ func (p *PkgMultiOp) SetErrCode(errCode int8) {
 	p.ErrCode = errCode
 }
 
+func (p *PkgMultiOp) SetLogSeq(logSeq uint64) {
+	p.LogSeq = logSeq
+	if logSeq != 0 {
+		p.PkgFlag |= FlagLogSeq
+	} else {
+		p.PkgFlag &^= FlagLogSeq
+	}
+}
+
 func (p *PkgMultiOp) Encode(pkg []byte) (int, error) {
 	var numKvs = len(p.Kvs)
 	if numKvs > MaxUint16 {
@@ -409,6 +483,22 @@ func (p *PkgMultiOp) Encode(pkg []byte) (int, error) {
 		n += m
 	}
 
+	if p.PkgFlag&FlagLogTime != 0 {
+		if n+8 > len(pkg) {
+			return n, ErrPkgLen
+		}
+		binary.BigEndian.PutUint64(pkg[n:], uint64(p.LogTime))
+		n += 8
+	}
+
+	if p.PkgFlag&FlagLogSeq != 0 {
+		if n+8 > len(pkg) {
+			return n, ErrPkgLen
+		}
+		binary.BigEndian.PutUint64(pkg[n:], p.LogSeq)
+		n += 8
+	}
+
 	OverWriteLen(pkg, n)
 	return n, nil
 }
@@ -438,6 +528,24 @@ func (p *PkgMultiOp) Decode(pkg []byte) (int, error) {
 		n += m
 	}
 
+	p.LogTime = 0
+	if p.PkgFlag&FlagLogTime != 0 {
+		if n+8 > len(pkg) {
+			return n, ErrPkgLen
+		}
+		p.LogTime = int64(binary.BigEndian.Uint64(pkg[n:]))
+		n += 8
+	}
+
+	p.LogSeq = 0
+	if p.PkgFlag&FlagLogSeq != 0 {
+		if n+8 > len(pkg) {
+			return n, ErrPkgLen
+		}
+		p.LogSeq = binary.BigEndian.Uint64(pkg[n:])
+		n += 8
+	}
+
 	return n, nil
 }
 
@@ -30,7 +30,8 @@ var (
 
 const (
 	// Front CTRL
-	CmdAuth = 0x9
+	CmdAuth  = 0x9
+	CmdScram = 0xA // Challenge-response auth
 
 	// Front Read
 	CmdPing = 0x10
@@ -51,11 +52,31 @@ const (
 	CmdSync   = 0xB0 // Sync data
 	CmdSyncSt = 0xB1 // Sync status
 
+	// Inner ADMIN
+	CmdPasswd = 0xC0 // Set/Remove DB passwords
+	CmdUser   = 0xC1 // Set/Remove named users with roles
+
 	// Inner CTRL
-	CmdSlaveOf = 0xD0
-	CmdMigrate = 0xD1 // Start/Stop migration
-	CmdSlaveSt = 0xD2 // Get migration/slave status
-	CmdDelSlot = 0xD3 // Delete slot data
+	CmdSlaveOf  = 0xD0
+	CmdMigrate  = 0xD1 // Start/Stop migration
+	CmdSlaveSt  = 0xD2 // Get migration/slave status
+	CmdDelSlot  = 0xD3 // Delete slot data
+	CmdSwitch   = 0xD4 // Master/slave switchover
+	CmdDelay    = 0xD5 // Pause/Resume/Fast-forward delayed slave
+	CmdSetSlot  = 0xD6 // Set slot owner for request redirection
+	CmdCluster  = 0xD7 // Get/Set cluster mode slot owners
+	CmdLimit    = 0xD8 // Get/Set replication rate limits
+	CmdStats    = 0xD9 // Get data size and key count of slots/tables
+	CmdVerify   = 0xDA // Verify slot checksums between master and slave
+	CmdInfo     = 0xDB // Get server, storage and replication statistics
+	CmdSlowLog  = 0xDC // Get/Reset slow requests
+	CmdClient   = 0xDD // List/Kill client connections
+	CmdConfig   = 0xDE // Get/Set runtime config
+	CmdShutdown = 0xDF // Graceful shutdown
+
+	// Sentinel CTRL
+	CmdGetMaster = 0xE0 // Get master address from sentinel
+	CmdVoteDown  = 0xE1 // Vote master down between sentinels
 )
 
 const (
@@ -137,6 +158,59 @@ func OverWriteSeq(pkg []byte, seq uint64) {
 	pkg[0] = CalHeadCrc(pkg)
 }
 
+// AppendLogSeq sets binlog seq token to the encoded PkgOneOp/PkgMultiOp pkg.
+func AppendLogSeq(pkg []byte, logSeq uint64) []byte {
+	if len(pkg) <= HeadSize {
+		return pkg
+	}
+
+	if pkg[HeadSize]&FlagLogSeq == 0 {
+		pkg[HeadSize] |= FlagLogSeq
+		pkg = append(pkg, make([]byte, 8)...)
+	}
+	binary.BigEndian.PutUint64(pkg[len(pkg)-8:], logSeq)
+	OverWriteLen(pkg, len(pkg))
+	return pkg
+}
+
+// AppendLogTime sets the log time for delayed slave to the encoded
+// PkgOneOp/PkgMultiOp pkg.
+// The time is kept if already set.
+func AppendLogTime(pkg []byte, logTime int64) []byte {
+	if len(pkg) <= HeadSize || pkg[HeadSize]&FlagLogTime != 0 {
+		return pkg
+	}
+
+	// ddwLogTime is before ddwLogSeq
+	var n = len(pkg)
+	if pkg[HeadSize]&FlagLogSeq != 0 {
+		n -= 8
+	}
+	pkg[HeadSize] |= FlagLogTime
+	pkg = append(pkg, make([]byte, 8)...)
+	copy(pkg[n+8:], pkg[n:len(pkg)-8])
+	binary.BigEndian.PutUint64(pkg[n:], uint64(logTime))
+	OverWriteLen(pkg, len(pkg))
+	return pkg
+}
+
+// GetLogTime returns the log time of the encoded PkgOneOp/PkgMultiOp pkg.
+// Returns 0 if not set.
+func GetLogTime(pkg []byte) int64 {
+	if len(pkg) <= HeadSize || pkg[HeadSize]&FlagLogTime == 0 {
+		return 0
+	}
+
+	var n = len(pkg) - 8
+	if pkg[HeadSize]&FlagLogSeq != 0 {
+		n -= 8
+	}
+	if n <= HeadSize {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(pkg[n:]))
+}
+
 func ReadPkg(r *bufio.Reader, headBuf []byte, head *PkgHead,
 	pkgBuf []byte) (pkg []byte, err error) {
 	if len(headBuf) != HeadSize {
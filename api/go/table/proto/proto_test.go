@@ -0,0 +1,180 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proto
+
+import (
+	"testing"
+)
+
+func encodeOneOp(t *testing.T, p *PkgOneOp) []byte {
+	var pkg = make([]byte, p.Length())
+	if _, err := p.Encode(pkg); err != nil {
+		t.Fatalf("Encode failed: %s", err)
+	}
+	return pkg
+}
+
+func decodeOneOp(t *testing.T, pkg []byte) *PkgOneOp {
+	var p PkgOneOp
+	n, err := p.Decode(pkg)
+	if err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if n != len(pkg) || int(p.PkgLen) != len(pkg) {
+		t.Fatalf("Invalid pkg length %d/%d, expect %d", n, p.PkgLen, len(pkg))
+	}
+	return &p
+}
+
+func TestAppendLogSeq(t *testing.T) {
+	var p PkgOneOp
+	p.Cmd = CmdSet
+	p.DbId = 2
+	p.Seq = 10
+	p.TableId = 3
+	p.RowKey = []byte("row")
+	p.ColKey = []byte("col")
+	p.SetValue([]byte("value"))
+	var pkg = encodeOneOp(t, &p)
+	var size = len(pkg)
+
+	pkg = AppendLogSeq(pkg, 100)
+	if len(pkg) != size+8 {
+		t.Fatalf("Invalid pkg size %d, expect %d", len(pkg), size+8)
+	}
+	var r = decodeOneOp(t, pkg)
+	if r.PkgFlag&FlagLogSeq == 0 || r.LogSeq != 100 {
+		t.Fatalf("Invalid log seq %d, flag %x", r.LogSeq, r.PkgFlag)
+	}
+	if string(r.RowKey) != "row" || string(r.ColKey) != "col" ||
+		string(r.Value) != "value" || r.Seq != 10 || r.DbId != 2 {
+		t.Fatalf("Pkg content changed: %+v", r)
+	}
+
+	// Overwrite the existing seq in place
+	pkg = AppendLogSeq(pkg, 200)
+	if len(pkg) != size+8 {
+		t.Fatalf("Invalid pkg size %d, expect %d", len(pkg), size+8)
+	}
+	if r = decodeOneOp(t, pkg); r.LogSeq != 200 {
+		t.Fatalf("Invalid log seq %d", r.LogSeq)
+	}
+
+	// Too short pkg is kept
+	var head = make([]byte, HeadSize)
+	if len(AppendLogSeq(head, 1)) != HeadSize {
+		t.Fatalf("Pkg without body should not change")
+	}
+}
+
+func TestAppendLogSeqMultiOp(t *testing.T) {
+	var p PkgMultiOp
+	p.Cmd = CmdMSet
+	p.Kvs = []KeyValue{{TableId: 1, RowKey: []byte("r1")},
+		{TableId: 1, RowKey: []byte("r2")}}
+	var pkg = make([]byte, p.Length())
+	if _, err := p.Encode(pkg); err != nil {
+		t.Fatalf("Encode failed: %s", err)
+	}
+
+	pkg = AppendLogSeq(pkg, 300)
+	var r PkgMultiOp
+	if _, err := r.Decode(pkg); err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if r.LogSeq != 300 || len(r.Kvs) != 2 || string(r.Kvs[1].RowKey) != "r2" {
+		t.Fatalf("Invalid pkg %+v", r)
+	}
+}
+
+func TestOverWriteLen(t *testing.T) {
+	var head = PkgHead{Cmd: CmdGet, DbId: 1, Seq: 5, PkgLen: HeadSize}
+	var pkg = make([]byte, HeadSize+4)
+	head.Encode(pkg)
+
+	OverWriteLen(pkg, len(pkg))
+	var r PkgHead
+	if _, err := r.Decode(pkg); err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if r.PkgLen != uint32(len(pkg)) || r.Cmd != CmdGet || r.Seq != 5 {
+		t.Fatalf("Invalid head %+v", r)
+	}
+
+	// The CRC must be rewritten with the length
+	pkg[11]++
+	if _, err := r.Decode(pkg); err != ErrHeadCrc {
+		t.Fatalf("Expect ErrHeadCrc, got %v", err)
+	}
+}
+
+func TestAppendLogTime(t *testing.T) {
+	var p PkgOneOp
+	p.Cmd = CmdDel
+	p.RowKey = []byte("row")
+	var pkg = encodeOneOp(t, &p)
+	if GetLogTime(pkg) != 0 {
+		t.Fatalf("Log time should be 0 if not set")
+	}
+
+	pkg = AppendLogTime(pkg, 1445572801)
+	if GetLogTime(pkg) != 1445572801 {
+		t.Fatalf("Invalid log time %d", GetLogTime(pkg))
+	}
+	var r = decodeOneOp(t, pkg)
+	if r.LogTime != 1445572801 || r.LogSeq != 0 || string(r.RowKey) != "row" {
+		t.Fatalf("Invalid pkg %+v", r)
+	}
+
+	// The time is kept if already set
+	pkg = AppendLogTime(pkg, 1)
+	if GetLogTime(pkg) != 1445572801 {
+		t.Fatalf("Log time should not change")
+	}
+
+	// Log time is inserted before the log seq
+	pkg = AppendLogSeq(encodeOneOp(t, &p), 100)
+	pkg = AppendLogTime(pkg, 200)
+	r = decodeOneOp(t, pkg)
+	if r.LogTime != 200 || r.LogSeq != 100 || GetLogTime(pkg) != 200 {
+		t.Fatalf("Invalid log time %d or seq %d", r.LogTime, r.LogSeq)
+	}
+
+	// Log seq can still be updated after log time
+	pkg = AppendLogSeq(pkg, 300)
+	r = decodeOneOp(t, pkg)
+	if r.LogTime != 200 || r.LogSeq != 300 {
+		t.Fatalf("Invalid log time %d or seq %d", r.LogTime, r.LogSeq)
+	}
+}
+
+func TestAppendLogTimeMultiOp(t *testing.T) {
+	var p PkgMultiOp
+	p.Cmd = CmdMDel
+	p.Kvs = []KeyValue{{TableId: 1, RowKey: []byte("r1")}}
+	var pkg = make([]byte, p.Length())
+	if _, err := p.Encode(pkg); err != nil {
+		t.Fatalf("Encode failed: %s", err)
+	}
+
+	pkg = AppendLogTime(pkg, 400)
+	var r PkgMultiOp
+	if _, err := r.Decode(pkg); err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if r.LogTime != 400 || GetLogTime(pkg) != 400 || len(r.Kvs) != 1 {
+		t.Fatalf("Invalid pkg %+v", r)
+	}
+}
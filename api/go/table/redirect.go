@@ -0,0 +1,199 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"github.com/stevejiang/gotable/api/go/table/proto"
+)
+
+const (
+	maxRedirects = 5 // Max times a request follows EcMoved/EcAsk
+)
+
+// GetRedirect returns the error code and new owner address of a reply pkg
+// redirected by EcMoved/EcAsk. The address is empty if not redirected.
+func GetRedirect(cmd uint8, pkg []byte) (int8, string) {
+	if len(pkg) < proto.HeadSize+4 {
+		return 0, ""
+	}
+
+	switch cmd {
+	case proto.CmdGet:
+		fallthrough
+	case proto.CmdSet:
+		fallthrough
+	case proto.CmdDel:
+		fallthrough
+	case proto.CmdIncr:
+		// PKG=HEAD+cPkgFlag+cCtrlFlag+cTableId+[cErrCode]...
+		if pkg[proto.HeadSize+1]&proto.CtrlErrCode == 0 ||
+			!isRedirect(int8(pkg[proto.HeadSize+3])) {
+			return 0, ""
+		}
+		var p proto.PkgOneOp
+		_, err := p.Decode(pkg)
+		if err != nil {
+			return 0, ""
+		}
+		return p.ErrCode, string(p.Value)
+	case proto.CmdMGet:
+		fallthrough
+	case proto.CmdMSet:
+		fallthrough
+	case proto.CmdMDel:
+		fallthrough
+	case proto.CmdMIncr:
+		fallthrough
+	case proto.CmdScan:
+		// PKG=HEAD+cPkgFlag+cErrCode+wNum+KeyValue[wNum]...
+		if !isRedirect(int8(pkg[proto.HeadSize+1])) {
+			return 0, ""
+		}
+		var p proto.PkgMultiOp
+		_, err := p.Decode(pkg)
+		if err != nil || len(p.Kvs) == 0 {
+			return 0, ""
+		}
+		return p.ErrCode, string(p.Kvs[0].Value)
+	}
+
+	return 0, ""
+}
+
+func isRedirect(errCode int8) bool {
+	return errCode == EcMoved || errCode == EcAsk
+}
+
+// Follow the EcMoved/EcAsk redirection by sending the request to the new
+// owner. Return true if the call is redirected.
+func (c *Client) redirect(call *Call, pkg []byte) bool {
+	if call.moved >= maxRedirects {
+		return false
+	}
+
+	errCode, addr := GetRedirect(call.cmd, pkg)
+	if len(addr) == 0 {
+		return false
+	}
+
+	call.moved++
+	go c.doRedirect(call, addr, errCode == EcAsk)
+	return true
+}
+
+func (c *Client) doRedirect(call *Call, addr string, ask bool) {
+	rc, err := c.getRedirectClient(addr)
+	if err != nil {
+		call.err = err
+		call.ready = true
+		call.done()
+		return
+	}
+
+	var pkg = make([]byte, len(call.pkg))
+	copy(pkg, call.pkg)
+	if ask {
+		pkg[proto.HeadSize] |= proto.FlagAsking
+	} else {
+		pkg[proto.HeadSize] &^= proto.FlagAsking
+	}
+
+	rc.mtx.Lock()
+	if rc.shutdown || rc.closing {
+		rc.mtx.Unlock()
+		call.err = ErrShutdown
+		call.ready = true
+		call.done()
+		return
+	}
+	rc.seq += 1
+	call.seq = rc.seq
+	rc.pending[call.seq] = call
+	rc.mtx.Unlock()
+
+	proto.OverWriteSeq(pkg, call.seq)
+	call.pkg = pkg
+	rc.sending <- call
+}
+
+// Get the connection to the new owner, it's authorized as this connection.
+func (c *Client) getRedirectClient(addr string) (*Client, error) {
+	c.mtx.Lock()
+	if c.closing {
+		c.mtx.Unlock()
+		return nil, ErrShutdown
+	}
+	rc := c.redirects[addr]
+	if rc != nil {
+		rc.mtx.Lock()
+		var ok = !rc.shutdown && !rc.closing
+		rc.mtx.Unlock()
+		if ok {
+			c.mtx.Unlock()
+			return rc, nil
+		}
+		delete(c.redirects, addr)
+	}
+	var creds = make(map[uint8]authCred, len(c.creds))
+	for dbId, cred := range c.creds {
+		creds[dbId] = cred
+	}
+	var plain = c.plainAuth
+	c.mtx.Unlock()
+
+	rc, err := dial(c.c.RemoteAddr().Network(), addr, c.tlsConf)
+	if err != nil {
+		return nil, err
+	}
+
+	rc.SetPlainAuth(plain)
+	for dbId, cred := range creds {
+		_, err = rc.auth(dbId, cred.name, cred.password)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+
+	c.mtx.Lock()
+	if c.closing {
+		c.mtx.Unlock()
+		rc.Close()
+		return nil, ErrShutdown
+	}
+	if old := c.redirects[addr]; old != nil {
+		c.mtx.Unlock()
+		rc.Close()
+		return old, nil
+	}
+	if c.redirects == nil {
+		c.redirects = make(map[string]*Client)
+	}
+	c.redirects[addr] = rc
+	c.mtx.Unlock()
+
+	return rc, nil
+}
+
+func (c *Client) closeRedirects() {
+	c.mtx.Lock()
+	var redirects = c.redirects
+	c.redirects = nil
+	c.mtx.Unlock()
+
+	for _, rc := range redirects {
+		rc.Close()
+	}
+}
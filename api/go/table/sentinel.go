@@ -0,0 +1,123 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"errors"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"net"
+	"time"
+)
+
+var ErrNoMaster = errors.New("no master found by sentinels")
+
+// Internal control command.
+// GetMaster reads the current master address from a sentinel.
+func (c *CtrlContext) GetMaster() (*ctrl.PkgGetMaster, error) {
+	call := c.cli.newCall(proto.CmdGetMaster, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	var p ctrl.PkgGetMaster
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgGetMaster)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
+// Internal control command.
+// VoteDown asks a sentinel whether the master is down, and for its vote.
+func (c *CtrlContext) VoteDown(masterAddr, candidate string,
+	epoch uint64) (*ctrl.PkgVoteDown, error) {
+	call := c.cli.newCall(proto.CmdVoteDown, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	var p ctrl.PkgVoteDown
+	p.MasterAddr = masterAddr
+	p.Candidate = candidate
+	p.Epoch = epoch
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgVoteDown)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t, nil
+}
+
+// Get the current master address from sentinels.
+// All sentinels are asked, the master with the largest epoch wins.
+func GetSentinelMaster(network string, sentinels []string,
+	timeout time.Duration) (string, error) {
+	var masterAddr string
+	var epoch uint64
+	for _, addr := range sentinels {
+		conn, err := net.DialTimeout(network, addr, timeout)
+		if err != nil {
+			continue
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+
+		var c = NewClient(conn)
+		var cc = CtrlContext(*c.NewContext(0))
+		p, err := cc.GetMaster()
+		c.Close()
+		if err != nil {
+			continue
+		}
+
+		if len(p.MasterAddr) > 0 && (len(masterAddr) == 0 || p.Epoch > epoch) {
+			masterAddr = p.MasterAddr
+			epoch = p.Epoch
+		}
+	}
+
+	if len(masterAddr) == 0 {
+		return "", ErrNoMaster
+	}
+	return masterAddr, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"sync"
+)
+
+// A Session provides read-your-writes consistency when reading from slaves.
+// It remembers the largest binlog seq returned by writes on the session,
+// and sends it with every read, so that a slave replies only after it has
+// synced the writes. A lagging slave fails the read with ErrNotSynced at once,
+// which can be retried later or on the master.
+// It's safe to use in multiple goroutines.
+type Session struct {
+	master *Pool
+	slave  *Pool
+	dbId   uint8
+
+	mtx    sync.Mutex // protects following
+	logSeq uint64
+}
+
+// Create a new Session with selected dbId.
+// Writes are sent to Pool p (the master), reads are sent to slave Pool.
+// If slave is nil, reads are sent to Pool p too.
+func (p *Pool) NewSession(slave *Pool, dbId uint8) *Session {
+	if slave == nil {
+		slave = p
+	}
+	return &Session{master: p, slave: slave, dbId: dbId}
+}
+
+// Get a Context for writes. Seq of every write on it is tracked.
+func (s *Session) WriteContext() (*Context, error) {
+	c, err := s.master.Get()
+	if err != nil {
+		return nil, err
+	}
+	return &Context{c, s.dbId, s}, nil
+}
+
+// Get a Context for reads. Reads on it see writes of the Session.
+func (s *Session) ReadContext() (*Context, error) {
+	c, err := s.slave.Get()
+	if err != nil {
+		return nil, err
+	}
+	return &Context{c, s.dbId, s}, nil
+}
+
+// Get the binlog seq token of the Session.
+// It can be passed to another Session with UpdateLogSeq.
+func (s *Session) LogSeq() uint64 {
+	s.mtx.Lock()
+	var logSeq = s.logSeq
+	s.mtx.Unlock()
+	return logSeq
+}
+
+// Update the binlog seq token if logSeq is larger.
+func (s *Session) UpdateLogSeq(logSeq uint64) {
+	s.mtx.Lock()
+	if s.logSeq < logSeq {
+		s.logSeq = logSeq
+	}
+	s.mtx.Unlock()
+}
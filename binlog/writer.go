@@ -37,20 +37,41 @@ const (
 	MinNormalSeq = uint64(1000000000000000000)
 )
 
+// The first binlog seq written in a second
+type seqTime struct {
+	Seq  uint64
+	Time int64 // Unix seconds
+}
+
 type fileInfo struct {
 	Idx    uint64
 	MinSeq uint64
 	MaxSeq uint64
 	Done   bool
+	Times  []seqTime `json:",omitempty"` // Write time of the records
 }
 
 type readerSeq struct {
 	seq uint64
 }
 
+// Info is the BinLog status.
+type Info struct {
+	FileIdx  uint64 // Index of the binlog file writing
+	LogSeq   uint64 // Last written seq
+	MinSeq   uint64 // Min seq of the binlog files kept
+	FileNum  int    // Number of binlog files kept
+	KeepNum  int    // Max number of binlog files to keep
+	ChanLen  int    // Requests waiting to be written
+	Monitors int    // Number of masters reading binlog
+	IsSlave  bool   // Binlog comes from master
+}
+
 type Request struct {
 	MasterSeq uint64
 	Pkg       []byte
+
+	seq uint64 // binlog seq assigned by AddRequest
 }
 
 type BinLog struct {
@@ -58,9 +79,11 @@ type BinLog struct {
 	memSize int
 	keepNum int
 	reqChan chan *Request
+	seqMtx  sync.Mutex // Keeps reqChan in seq order
 
-	binFile *os.File
-	binBufW *bufio.Writer
+	binFile   *os.File
+	binBufW   *bufio.Writer
+	timeAdded bool // Write time added since the seq file was saved
 
 	mtx       sync.Mutex // The following variables are protected by mtx
 	hasMaster bool       // Has master or not, ONLY for normal master/slave
@@ -68,7 +91,8 @@ type BinLog struct {
 	monitors  []Monitor
 
 	fileIdx uint64
-	logSeq  uint64
+	logSeq  uint64 // Last written seq
+	addSeq  uint64 // Last assigned seq
 
 	memlog  []byte
 	usedLen int
@@ -123,12 +147,14 @@ func (bin *BinLog) init() error {
 		bin.fileIdx = bin.infos[len(bin.infos)-1].Idx
 		bin.logSeq = bin.infos[len(bin.infos)-1].MaxSeq
 	}
+	bin.addSeq = bin.logSeq
 
 	return nil
 }
 
 func (bin *BinLog) Close() {
 	bin.Flush()
+	bin.saveSeqFile()
 
 	if bin.binFile != nil {
 		bin.binFile.Close()
@@ -175,6 +201,9 @@ func (bin *BinLog) AsMaster() {
 	if bin.logSeq < MinNormalSeq {
 		bin.logSeq = MinNormalSeq
 	}
+	if bin.addSeq < MinNormalSeq {
+		bin.addSeq = MinNormalSeq
+	}
 	bin.mtx.Unlock()
 }
 
@@ -182,6 +211,7 @@ func (bin *BinLog) AsSlave() {
 	bin.mtx.Lock()
 	bin.hasMaster = true
 	bin.logSeq = 0
+	bin.addSeq = 0
 	bin.mtx.Unlock()
 }
 
@@ -193,6 +223,31 @@ func (bin *BinLog) GetLogSeqChanLen() (uint64, int) {
 	return seq, chanLen
 }
 
+func (bin *BinLog) GetInfo() Info {
+	var info Info
+	bin.mtx.Lock()
+	info.FileIdx = bin.fileIdx
+	info.LogSeq = bin.logSeq
+	if len(bin.infos) > 0 {
+		info.MinSeq = bin.infos[0].MinSeq
+	}
+	info.FileNum = len(bin.infos)
+	info.KeepNum = bin.keepNum
+	info.ChanLen = len(bin.reqChan)
+	info.Monitors = len(bin.monitors)
+	info.IsSlave = bin.hasMaster
+	bin.mtx.Unlock()
+	return info
+}
+
+// SetKeepNum changes the max number of binlog files to keep. Old files are
+// deleted when the next file is created.
+func (bin *BinLog) SetKeepNum(keepNum int) {
+	bin.mtx.Lock()
+	bin.keepNum = keepNum
+	bin.mtx.Unlock()
+}
+
 // Only for master/slave mode
 func (bin *BinLog) GetMasterSeq() (masterSeq uint64, valid bool) {
 	masterSeq = 0
@@ -209,8 +264,23 @@ func (bin *BinLog) GetMasterSeq() (masterSeq uint64, valid bool) {
 	return
 }
 
-func (bin *BinLog) AddRequest(req *Request) {
+// AddRequest adds req to the binlog write queue, and returns the binlog seq
+// assigned to it.
+func (bin *BinLog) AddRequest(req *Request) uint64 {
+	bin.seqMtx.Lock()
+	bin.mtx.Lock()
+	if bin.hasMaster && req.MasterSeq > 0 {
+		bin.addSeq = req.MasterSeq
+	} else {
+		bin.addSeq++
+	}
+	req.seq = bin.addSeq
+	bin.mtx.Unlock()
+
 	bin.reqChan <- req
+	bin.seqMtx.Unlock()
+
+	return req.seq
 }
 
 func (bin *BinLog) GetBinFileName(fileIdx uint64) string {
@@ -234,11 +304,7 @@ func (bin *BinLog) goWriteBinLog() {
 			}
 
 			bin.mtx.Lock()
-			if bin.hasMaster && req.MasterSeq > 0 {
-				bin.logSeq = req.MasterSeq
-			} else {
-				bin.logSeq++
-			}
+			bin.logSeq = req.seq
 			if bin.msChanged {
 				bin.msChanged = false
 				ms = make([]Monitor, len(bin.monitors))
@@ -257,6 +323,7 @@ func (bin *BinLog) goWriteBinLog() {
 
 		case <-tick:
 			bin.Flush()
+			bin.saveSeqFile()
 
 			if last1 == nil {
 				if last2 != nil {
@@ -308,21 +375,64 @@ func (bin *BinLog) doWrite(req *Request, logSeq uint64) error {
 		bin.binBufW = bufio.NewWriter(bin.binFile)
 
 		bin.mtx.Lock()
-		bin.infos = append(bin.infos, &fileInfo{bin.fileIdx, logSeq, 0, false})
+		bin.infos = append(bin.infos, &fileInfo{bin.fileIdx, logSeq, 0, false, nil})
 		bin.mtx.Unlock()
 	}
 
 	copy(bin.memlog[bin.usedLen:], req.Pkg)
 	bin.binBufW.Write(req.Pkg)
 
+	var now = time.Now().Unix()
 	bin.mtx.Lock()
 	bin.usedLen += len(req.Pkg)
-	bin.infos[len(bin.infos)-1].MaxSeq = logSeq
+	var fi = bin.infos[len(bin.infos)-1]
+	fi.MaxSeq = logSeq
+	if n := len(fi.Times); n == 0 || fi.Times[n-1].Time != now {
+		fi.Times = append(fi.Times, seqTime{logSeq, now})
+		bin.timeAdded = true
+	}
 	bin.mtx.Unlock()
 
 	return nil
 }
 
+// Save the write time of the current binlog file, so that it's kept after
+// restart. The seq file is not done, Min/MaxSeq are fixed when loaded.
+func (bin *BinLog) saveSeqFile() {
+	if !bin.timeAdded || bin.binFile == nil {
+		return
+	}
+	bin.timeAdded = false
+
+	bin.mtx.Lock()
+	var fi = *bin.infos[len(bin.infos)-1]
+	bin.mtx.Unlock()
+
+	bin.writeSeqFile(&fi)
+}
+
+// GetLogTime returns the time in Unix seconds when the binlog record of seq
+// was written, or 0 if unknown.
+func (bin *BinLog) GetLogTime(seq uint64) int64 {
+	bin.mtx.Lock()
+	defer bin.mtx.Unlock()
+
+	for i := len(bin.infos) - 1; i >= 0; i-- {
+		var fi = bin.infos[i]
+		if fi.MinSeq > seq {
+			continue
+		}
+
+		var ts = fi.Times
+		var n = sort.Search(len(ts), func(j int) bool { return ts[j].Seq > seq })
+		if n == 0 {
+			return 0
+		}
+		return ts[n-1].Time
+	}
+	return 0
+}
+
 func (bin *BinLog) selectDelBinLogFiles() []uint64 {
 	var delIdxs []uint64
 
@@ -404,7 +514,8 @@ func (bin *BinLog) writeSeqFile(fi *fileInfo) error {
 	return nil
 }
 
-func (bin *BinLog) fixSeqFile(idx uint64) (fileInfo, error) {
+// Read Min/MaxSeq from the bin file, and write the seq file with the times.
+func (bin *BinLog) fixSeqFile(idx uint64, times []seqTime) (fileInfo, error) {
 	var fi fileInfo
 	var name = bin.GetBinFileName(idx)
 	file, err := os.Open(name)
@@ -438,6 +549,7 @@ func (bin *BinLog) fixSeqFile(idx uint64) (fileInfo, error) {
 		return fi, fmt.Errorf("no record in bin file id %d", idx)
 	}
 
+	fi.Times = times
 	return fi, bin.writeSeqFile(&fi)
 }
 
@@ -455,17 +567,22 @@ func (bin *BinLog) loadAndFixSeqFiles(idxs []uint64) error {
 		}
 
 		var fi fileInfo
+		var times []seqTime
 		if !needFix {
 			de := json.NewDecoder(file)
 			err = de.Decode(&fi)
 			if err != nil {
 				needFix = true
+			} else if !fi.Done {
+				// Saved while writing, only the times are valid
+				needFix = true
+				times = fi.Times
 			}
 			file.Close()
 		}
 
 		if needFix {
-			fi, err = bin.fixSeqFile(idx)
+			fi, err = bin.fixSeqFile(idx, times)
 		}
 
 		if err == nil && fi.Idx > 0 {
@@ -0,0 +1,84 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binlog
+
+import (
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func addTestRequest(t *testing.T, bin *BinLog) uint64 {
+	var p proto.PkgOneOp
+	p.Cmd = proto.CmdSet
+	p.RowKey = []byte("row")
+	var pkg = make([]byte, p.Length())
+	if _, err := p.Encode(pkg); err != nil {
+		t.Fatalf("Encode failed: %s", err)
+	}
+	return bin.AddRequest(&Request{Pkg: pkg})
+}
+
+func waitLogSeq(t *testing.T, bin *BinLog, seq uint64) {
+	for i := 0; i < 1000; i++ {
+		if logSeq, _ := bin.GetLogSeqChanLen(); logSeq >= seq {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Binlog seq %d not written", seq)
+}
+
+func TestBinLogTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotable-binlog")
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var bin = NewBinLog(dir, 1024*1024, 2)
+	var start = time.Now().Unix()
+	var seq1 = addTestRequest(t, bin)
+	var seq2 = addTestRequest(t, bin)
+	waitLogSeq(t, bin, seq2)
+	var end = time.Now().Unix()
+
+	for _, seq := range []uint64{seq1, seq2} {
+		if lt := bin.GetLogTime(seq); lt < start || lt > end {
+			t.Fatalf("Invalid log time %d of seq %d, expect [%d, %d]",
+				lt, seq, start, end)
+		}
+	}
+	if lt := bin.GetLogTime(seq1 - 1); lt != 0 {
+		t.Fatalf("Log time of unknown seq should be 0, got %d", lt)
+	}
+	var lt1 = bin.GetLogTime(seq1)
+	bin.Close()
+
+	// Times are kept after restart
+	bin = NewBinLog(dir, 1024*1024, 2)
+	if bin == nil {
+		t.Fatalf("NewBinLog failed")
+	}
+	defer bin.Close()
+	if lt := bin.GetLogTime(seq1); lt != lt1 {
+		t.Fatalf("Log time %d changed to %d after restart", lt1, lt)
+	}
+	if logSeq, _ := bin.GetLogSeqChanLen(); logSeq != seq2 {
+		t.Fatalf("Invalid log seq %d after restart, expect %d", logSeq, seq2)
+	}
+}
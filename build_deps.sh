@@ -5,6 +5,7 @@ DEPS_DIR=/tmp/gotable-deps
 #DEPS_DIR=$HOME/localws/gotable-deps
 DEPS_ULR=https://github.com/stevejiang/gotable-deps/raw/master
 
+# store/db.cc redeclares structs of rocksdb/db/c.cc, check them on upgrade
 ROCKSDB_VER=rocksdb-3.8
 ROCKSDB_URL=$DEPS_ULR/$ROCKSDB_VER.tar.gz
 ROCKSDB=$DEPS_DIR/$ROCKSDB_VER
@@ -137,6 +138,7 @@ write_build_flags_to_file() {
 }
 
 export CGO_CFLAGS="$CGO_CFLAGS -g -O2 -DNDEBUG -I$ROCKSDB/include"
+export CGO_CXXFLAGS="$CGO_CXXFLAGS -g -O2 -DNDEBUG -std=c++11 -I$ROCKSDB/include"
 export CGO_LDFLAGS="$CGO_LDFLAGS -L$ROCKSDB -lrocksdb $PLATFORM_LDFLAGS"
 
 if [ ! -d "$DEPS_DIR" ]; then
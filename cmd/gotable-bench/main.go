@@ -18,6 +18,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/util"
 	"os"
 	"runtime"
 	"strconv"
@@ -135,9 +136,9 @@ func benchmark(cliPool *table.Pool, name string, op func(v int, p *OpParam)) {
 		close(numChan)
 	}()
 
-	var hists []Histogram
+	var hists []util.Histogram
 	if *histogram != 0 && *pipeline <= 0 {
-		hists = make([]Histogram, *cliNum)
+		hists = make([]util.Histogram, *cliNum)
 	}
 
 	var g sync.WaitGroup
@@ -164,10 +165,10 @@ func benchmark(cliPool *table.Pool, name string, op func(v int, p *OpParam)) {
 }
 
 func benchClient(id int, cliPool *table.Pool, name string, g *sync.WaitGroup,
-	numChan <-chan int, start time.Time, hists []Histogram, op func(v int, p *OpParam)) {
+	numChan <-chan int, start time.Time, hists []util.Histogram, op func(v int, p *OpParam)) {
 	defer g.Done()
 
-	var hist *Histogram
+	var hist *util.Histogram
 	if hists != nil {
 		hist = &hists[id]
 		hist.Clear()
@@ -15,10 +15,15 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/stevejiang/gotable/api/go/table"
 	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/util"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -29,11 +34,24 @@ type client struct {
 
 func newClient() *client {
 	var c = new(client)
-	cli, err := table.Dial(*network, *address)
+	var cli *table.Client
+	var err error
+	if *useTLS {
+		var tlsConf *tls.Config
+		tlsConf, err = util.NewTLSConfig(*certFile, *keyFile, *caFile)
+		if err != nil {
+			fmt.Println("Load TLS config failed: ", err)
+			return nil
+		}
+		cli, err = table.DialTLS(*network, *address, tlsConf)
+	} else {
+		cli, err = table.Dial(*network, *address)
+	}
 	if err != nil {
 		fmt.Println("Dial failed: ", err)
 		return nil
 	}
+	cli.SetPlainAuth(*plain)
 
 	c.dbId = 0
 	c.c = cli.NewContext(c.dbId)
@@ -72,6 +90,35 @@ func (c *client) auth(args []string) error {
 	return nil
 }
 
+func (c *client) login(args []string) error {
+	//login <user> <password>
+	if len(args) != 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	name, err := extractString(args[0])
+	if err != nil {
+		return err
+	}
+	password, err := extractString(args[1])
+	if err != nil {
+		return err
+	}
+
+	dbId, err := c.c.AuthUser(name, password)
+	if err != nil {
+		return err
+	}
+
+	if dbId != proto.AdminDbId && dbId != c.dbId {
+		c.c = c.c.Client().NewContext(dbId)
+		c.dbId = dbId
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
 func (c *client) use(args []string) error {
 	//select <databaseId>
 	if len(args) != 1 {
@@ -91,11 +138,13 @@ func (c *client) use(args []string) error {
 }
 
 func (c *client) slaveOf(args []string) error {
-	//slaveof [host]
+	//slaveof [host] [include <items>] [exclude <items>] [delay <seconds>]
 	//Examples:
 	//slaveof
 	//slaveof 127.0.0.1:6688
-	if len(args) > 1 {
+	//slaveof 127.0.0.1:6688 include 1,2.3 exclude 2.4
+	//slaveof 127.0.0.1:6688 delay 3600
+	if len(args) > 7 || (len(args) > 1 && len(args)%2 == 0) {
 		return fmt.Errorf("invalid number of arguments (%d)", len(args))
 	}
 
@@ -108,8 +157,31 @@ func (c *client) slaveOf(args []string) error {
 		}
 	}
 
+	var filter ctrl.SyncFilter
+	var delay int
+	for i := 1; i+1 < len(args); i += 2 {
+		var items = strings.Split(args[i+1], ",")
+		switch strings.ToLower(args[i]) {
+		case "include":
+			filter.Include = append(filter.Include, items...)
+		case "exclude":
+			filter.Exclude = append(filter.Exclude, items...)
+		case "delay":
+			delay, err = strconv.Atoi(args[i+1])
+			if err != nil || delay < 0 {
+				return fmt.Errorf("<delay> %s is not a valid number", args[i+1])
+			}
+		default:
+			return fmt.Errorf("invalid slaveof option %s", args[i])
+		}
+	}
+	_, err = filter.Matcher()
+	if err != nil {
+		return err
+	}
+
 	var cc = table.CtrlContext(*c.c)
-	err = cc.SlaveOf(host)
+	err = cc.SlaveOfDelay(host, &filter, delay)
 	if err != nil {
 		return err
 	}
@@ -118,6 +190,907 @@ func (c *client) slaveOf(args []string) error {
 	return nil
 }
 
+func (c *client) switchover(args []string) error {
+	//switchover <host> [timeout]
+	//Examples:
+	//switchover 127.0.0.1:6689
+	//switchover 127.0.0.1:6689 30
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	host, err := extractString(args[0])
+	if err != nil {
+		return err
+	}
+
+	var timeout int
+	if len(args) > 1 {
+		timeout, err = strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("<timeout> %s is not a number", args[1])
+		}
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err = cc.Switchover(host, timeout)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) delay(args []string) error {
+	//delay [status|pause|resume|ff [unixtime]]
+	//Examples:
+	//delay
+	//delay pause
+	//delay ff 1445572801
+	if len(args) > 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var op = ctrl.DelayStatus
+	var until int64
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "status":
+		case "pause":
+			op = ctrl.DelayPause
+		case "resume":
+			op = ctrl.DelayResume
+		case "ff":
+			op = ctrl.DelayForward
+		default:
+			return fmt.Errorf("invalid delay operation %s", args[0])
+		}
+	}
+	if len(args) > 1 {
+		if op != ctrl.DelayForward {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		var err error
+		until, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("<unixtime> %s is not a number", args[1])
+		}
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	p, err := cc.DelayCtrl(op, until)
+	if err != nil {
+		return err
+	}
+
+	var applyTime = "-"
+	if p.ApplyTime > 0 {
+		applyTime = time.Unix(p.ApplyTime, 0).Format(time.RFC3339)
+	}
+	fmt.Printf("delay: %ds, paused: %v, pending: %d, last applied: %s\n",
+		p.Delay, p.Paused, p.Pending, applyTime)
+	return nil
+}
+
+func (c *client) setSlot(args []string) error {
+	//setslot <slots> owned|migrating|moved|none [host]
+	//Examples:
+	//setslot 0-100,200 moved 127.0.0.1:6689
+	//setslot 0-100 owned
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	slots, err := ctrl.ParseSlotRanges(args[0])
+	if err != nil {
+		return err
+	}
+
+	var state int
+	switch strings.ToLower(args[1]) {
+	case "owned":
+		state = ctrl.SlotOwned
+	case "migrating":
+		state = ctrl.SlotMigrating
+	case "moved":
+		state = ctrl.SlotMoved
+	case "none":
+		state = ctrl.SlotNone
+	default:
+		return fmt.Errorf("invalid slot state %s", args[1])
+	}
+
+	var host string
+	if len(args) > 2 {
+		host, err = extractString(args[2])
+		if err != nil {
+			return err
+		}
+	}
+	if (state == ctrl.SlotMigrating || state == ctrl.SlotMoved) && len(host) == 0 {
+		return fmt.Errorf("missing <host> of the new owner")
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err = cc.SetSlot(slots, state, host)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) delSlots(args []string) error {
+	//delslot <slots> [compact]
+	//Examples:
+	//delslot 0-100,200
+	//delslot 0-4095 compact
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	slots, err := ctrl.ParseSlotRanges(args[0])
+	if err != nil {
+		return err
+	}
+
+	var compact bool
+	if len(args) > 1 {
+		if strings.ToLower(args[1]) != "compact" {
+			return fmt.Errorf("invalid delslot option %s", args[1])
+		}
+		compact = true
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err = cc.DelSlots(slots, compact)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) cluster(args []string) error {
+	//cluster slots|set <slots>|reset
+	//Examples:
+	//cluster slots
+	//cluster set 0-4095
+	//cluster reset
+	if len(args) < 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	var p *ctrl.PkgCluster
+	var err error
+	switch strings.ToLower(args[0]) {
+	case "slots":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		p, err = cc.ClusterSlots()
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		var slots []ctrl.SlotRange
+		slots, err = ctrl.ParseSlotRanges(args[1])
+		if err != nil {
+			return err
+		}
+		p, err = cc.ClusterSetSlots(slots)
+	case "reset":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		p, err = cc.ClusterReset()
+	default:
+		return fmt.Errorf("invalid cluster operation %s", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.Cluster {
+		fmt.Println("mode: cluster")
+	} else {
+		fmt.Println("mode: normal")
+	}
+	for _, o := range p.Owners {
+		var slots = fmt.Sprintf("%d-%d", o.Start, o.End)
+		if o.Start == o.End {
+			slots = fmt.Sprintf("%d", o.Start)
+		}
+		if len(o.Addr) > 0 {
+			fmt.Printf("%-12s %-10s %s\n", slots, slotStateName(o.State), o.Addr)
+		} else {
+			fmt.Printf("%-12s %s\n", slots, slotStateName(o.State))
+		}
+	}
+	return nil
+}
+
+func (c *client) verify(args []string) error {
+	//verify <host> [slots]|status|repair
+	//Examples:
+	//verify 127.0.0.1:6689
+	//verify 127.0.0.1:6689 0-4095
+	//verify status
+	//verify repair
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	var p *ctrl.PkgVerify
+	var err error
+	switch strings.ToLower(args[0]) {
+	case "status":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		p, err = cc.Verify(ctrl.VerifyStatus, "", nil, 0)
+	case "repair":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		p, err = cc.Verify(ctrl.VerifyRepair, "", nil, 0)
+		if err == nil && len(p.Mismatch) > 0 {
+			fmt.Printf("repairing slots %s\n", ctrl.NewSlotSet(p.Mismatch))
+			return nil
+		}
+	default:
+		var host string
+		host, err = extractString(args[0])
+		if err != nil {
+			return err
+		}
+		var slots []ctrl.SlotRange
+		if len(args) > 1 {
+			slots, err = ctrl.ParseSlotRanges(args[1])
+			if err != nil {
+				return err
+			}
+		}
+		p, err = cc.Verify(ctrl.VerifyStart, host, slots, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(p.SlaveAddr) == 0 && p.Sums == nil && !p.Running {
+		fmt.Println("no verification")
+		return nil
+	}
+	fmt.Printf("slave: %s, slots: %s, seq: %d\n", p.SlaveAddr,
+		ctrl.NewSlotSet(p.Slots), p.Seq)
+	switch {
+	case p.Running:
+		fmt.Println("status: running")
+	case len(p.Mismatch) > 0:
+		fmt.Printf("status: finished, mismatching slots %s\n",
+			ctrl.NewSlotSet(p.Mismatch))
+	default:
+		fmt.Println("status: finished, all slots match")
+	}
+	return nil
+}
+
+func (c *client) stats(args []string) error {
+	//stats top [size|keys] [num] [slots]
+	//stats tables [slots]
+	//stats count
+	//Examples:
+	//stats top
+	//stats top keys 10 0-4095
+	//stats tables 100-200
+	if len(args) < 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	switch strings.ToLower(args[0]) {
+	case "top":
+		return c.topSlots(&cc, args[1:])
+	case "tables":
+		if len(args) > 2 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		var slots []ctrl.SlotRange
+		var err error
+		if len(args) > 1 {
+			slots, err = ctrl.ParseSlotRanges(args[1])
+			if err != nil {
+				return err
+			}
+		}
+		p, err := cc.Stats(ctrl.StatsTables, slots)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-6s %-8s %12s %12s\n", "dbId", "tableId", "size", "keys")
+		for _, st := range p.TableStats {
+			fmt.Printf("%-6d %-8d %12s %12d\n",
+				st.DbId, st.TableId, formatSize(st.Size), st.Keys)
+		}
+		printCountTime(p)
+	case "count":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		_, err := cc.Stats(ctrl.StatsCount, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Println("OK, key counting started in the background")
+	default:
+		return fmt.Errorf("invalid stats operation %s", args[0])
+	}
+	return nil
+}
+
+func (c *client) topSlots(cc *table.CtrlContext, args []string) error {
+	var byKeys bool
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "keys":
+			byKeys = true
+			args = args[1:]
+		case "size":
+			args = args[1:]
+		}
+	}
+	if len(args) > 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var num = 20
+	var slots []ctrl.SlotRange
+	var err error
+	if len(args) > 0 {
+		num, err = strconv.Atoi(args[0])
+		if err != nil || num <= 0 {
+			return fmt.Errorf("<num> %s is not a valid number", args[0])
+		}
+	}
+	if len(args) > 1 {
+		slots, err = ctrl.ParseSlotRanges(args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	p, err := cc.Stats(ctrl.StatsSlots, slots)
+	if err != nil {
+		return err
+	}
+
+	var ss = slotStatSlice{p.SlotStats, byKeys}
+	sort.Sort(ss)
+	if len(ss.stats) > num {
+		ss.stats = ss.stats[:num]
+	}
+
+	fmt.Printf("%-8s %12s %12s\n", "slotId", "size", "keys")
+	for _, st := range ss.stats {
+		fmt.Printf("%-8d %12s %12d\n", st.SlotId, formatSize(st.Size), st.Keys)
+	}
+	printCountTime(p)
+	return nil
+}
+
+func printCountTime(p *ctrl.PkgStats) {
+	var countTime = "never"
+	if p.CountTime > 0 {
+		countTime = time.Unix(p.CountTime, 0).Format(time.RFC3339)
+	}
+	if p.Counting {
+		countTime += " (counting now)"
+	}
+	fmt.Printf("keys counted: %s\n", countTime)
+}
+
+func formatSize(size uint64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.2fGB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.2fMB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.2fKB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", size)
+}
+
+// Sort slot stats by size or key count in DESC order
+type slotStatSlice struct {
+	stats  []ctrl.SlotStat
+	byKeys bool
+}
+
+func (s slotStatSlice) Len() int      { return len(s.stats) }
+func (s slotStatSlice) Swap(i, j int) { s.stats[i], s.stats[j] = s.stats[j], s.stats[i] }
+func (s slotStatSlice) Less(i, j int) bool {
+	var a, b = s.stats[i], s.stats[j]
+	if s.byKeys && a.Keys != b.Keys {
+		return a.Keys > b.Keys
+	}
+	if a.Size != b.Size {
+		return a.Size > b.Size
+	}
+	return a.SlotId < b.SlotId
+}
+
+func (c *client) info(args []string) error {
+	//info [section]
+	//Examples:
+	//info
+	//info replication
+	if len(args) > 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var section string
+	if len(args) > 0 {
+		section = args[0]
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	info, err := cc.Info(section)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(info)
+	return nil
+}
+
+func (c *client) shutdown(args []string) error {
+	//shutdown [timeout]
+	//Examples:
+	//shutdown
+	//shutdown 10
+	if len(args) > 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var timeout int
+	if len(args) > 0 {
+		var err error
+		timeout, err = strconv.Atoi(args[0])
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("<timeout> %s is not a valid number", args[0])
+		}
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err := cc.Shutdown(timeout)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) passwd(args []string) error {
+	//passwd set <dbId> <password>|del <dbId>|list
+	//Examples:
+	//passwd set 1 "my password"
+	//passwd del 1
+	//passwd list
+	if len(args) < 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	switch strings.ToLower(args[0]) {
+	case "set":
+		if len(args) != 3 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		dbId, err := getDatabaseId(args[1])
+		if err != nil {
+			return err
+		}
+		password, err := extractString(args[2])
+		if err != nil {
+			return err
+		}
+		err = cc.SetPassword(dbId, password)
+		if err != nil {
+			return err
+		}
+		fmt.Println("OK")
+	case "del":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		dbId, err := getDatabaseId(args[1])
+		if err != nil {
+			return err
+		}
+		err = cc.DelPassword(dbId)
+		if err != nil {
+			return err
+		}
+		fmt.Println("OK")
+	case "list":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		dbs, err := cc.PasswordDbs()
+		if err != nil {
+			return err
+		}
+		if len(dbs) == 0 {
+			fmt.Println("No database has password")
+		}
+		for _, dbId := range dbs {
+			fmt.Printf("%d\n", dbId)
+		}
+	default:
+		return fmt.Errorf("invalid passwd operation %s", args[0])
+	}
+	return nil
+}
+
+func (c *client) user(args []string) error {
+	//user set <name> <password> admin|read <dbId> [tables]|write <dbId> [tables]
+	//     |del <name>|list
+	//Examples:
+	//user set ops "ops password" admin
+	//user set analytics pwd read 1
+	//user set app pwd write 1 2,3
+	//user del app
+	//user list
+	if len(args) < 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	switch strings.ToLower(args[0]) {
+	case "set":
+		if len(args) < 4 || len(args) > 6 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		var u ctrl.UserInfo
+		var err error
+		u.Name, err = extractString(args[1])
+		if err != nil {
+			return err
+		}
+		password, err := extractString(args[2])
+		if err != nil {
+			return err
+		}
+		u.Role = strings.ToLower(args[3])
+		if u.Role == ctrl.RoleAdmin {
+			if len(args) != 4 {
+				return fmt.Errorf("invalid number of arguments (%d)", len(args))
+			}
+		} else {
+			if len(args) < 5 {
+				return fmt.Errorf("invalid number of arguments (%d)", len(args))
+			}
+			u.DbId, err = getDatabaseId(args[4])
+			if err != nil {
+				return err
+			}
+			if len(args) > 5 {
+				for _, s := range strings.Split(args[5], ",") {
+					tableId, err := getTableId(s)
+					if err != nil {
+						return err
+					}
+					u.Tables = append(u.Tables, int(tableId))
+				}
+			}
+		}
+		err = cc.SetUser(u, password)
+		if err != nil {
+			return err
+		}
+		fmt.Println("OK")
+	case "del":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		name, err := extractString(args[1])
+		if err != nil {
+			return err
+		}
+		err = cc.DelUser(name)
+		if err != nil {
+			return err
+		}
+		fmt.Println("OK")
+	case "list":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		users, err := cc.Users()
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			fmt.Println("No user")
+			return nil
+		}
+		fmt.Printf("%-20s %-6s %-5s %s\n", "name", "role", "dbId", "tables")
+		for _, u := range users {
+			var db, tables = "-", "-"
+			if u.Role != ctrl.RoleAdmin {
+				db = strconv.Itoa(int(u.DbId))
+				tables = "all"
+				if len(u.Tables) > 0 {
+					var ids = make([]string, len(u.Tables))
+					for i, tableId := range u.Tables {
+						ids[i] = strconv.Itoa(tableId)
+					}
+					tables = strings.Join(ids, ",")
+				}
+			}
+			fmt.Printf("%-20s %-6s %-5s %s\n", u.Name, u.Role, db, tables)
+		}
+	default:
+		return fmt.Errorf("invalid user operation %s", args[0])
+	}
+	return nil
+}
+
+func (c *client) config(args []string) error {
+	//config get <pattern>|set <name> <value>
+	//Examples:
+	//config get *
+	//config get binlog.*
+	//config set binlog.keep_num 64
+	if len(args) < 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	switch strings.ToLower(args[0]) {
+	case "get":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		items, err := cc.ConfigGet(args[1])
+		if err != nil {
+			return err
+		}
+		for _, it := range items {
+			var mark = " "
+			if it.Mutable {
+				mark = "*"
+			}
+			fmt.Printf("%s %-30s %q\n", mark, it.Name, it.Value)
+		}
+	case "set":
+		if len(args) != 3 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		err := cc.ConfigSet(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		fmt.Println("OK")
+	default:
+		return fmt.Errorf("invalid config operation %s", args[0])
+	}
+	return nil
+}
+
+func (c *client) client(args []string) error {
+	//client list|kill <host>|kill type <normal|slave|master>
+	//Examples:
+	//client list
+	//client kill 127.0.0.1:51234
+	//client kill type normal
+	if len(args) < 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	switch strings.ToLower(args[0]) {
+	case "list":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		clients, err := cc.ClientList()
+		if err != nil {
+			return err
+		}
+		for _, ci := range clients {
+			fmt.Printf("id=%d addr=%s type=%s auth=%s age=%d idle=%d "+
+				"resp=%d in=%d out=%d\n", ci.Id, ci.Addr, ci.Type, ci.AuthDbs,
+				ci.Age, ci.Idle, ci.RespPending, ci.BytesIn, ci.BytesOut)
+		}
+	case "kill":
+		var killed int
+		var err error
+		if len(args) == 2 {
+			killed, err = cc.ClientKill(args[1], "")
+		} else if len(args) == 3 && strings.ToLower(args[1]) == "type" {
+			killed, err = cc.ClientKill("", strings.ToLower(args[2]))
+		} else {
+			return fmt.Errorf("invalid client kill arguments")
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d client(s) closed\n", killed)
+	default:
+		return fmt.Errorf("invalid client operation %s", args[0])
+	}
+	return nil
+}
+
+func (c *client) slowLog(args []string) error {
+	//slowlog get [num]|reset
+	//Examples:
+	//slowlog get
+	//slowlog get 100
+	//slowlog reset
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	switch strings.ToLower(args[0]) {
+	case "get":
+		var num = 10
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("<num> %s is not a valid number", args[1])
+			}
+			num = n
+		}
+		p, err := cc.SlowLog(ctrl.SlowLogGet, num)
+		if err != nil {
+			return err
+		}
+		for _, e := range p.Entries {
+			fmt.Printf("%d) %s %s %s db=%d table=%d rowKey=%q keys=%d client=%s\n",
+				e.Id, time.Unix(e.Time, 0).Format("2006-01-02 15:04:05"),
+				time.Duration(e.Duration)*time.Microsecond, e.Cmd, e.DbId,
+				e.TableId, e.RowKey, e.Keys, e.Client)
+		}
+		fmt.Printf("%d of %d slow requests\n", len(p.Entries), p.Len)
+	case "reset":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		_, err := cc.SlowLog(ctrl.SlowLogReset, 0)
+		if err != nil {
+			return err
+		}
+		fmt.Println("OK")
+	default:
+		return fmt.Errorf("invalid slowlog operation %s", args[0])
+	}
+	return nil
+}
+
+func (c *client) rateLimit(args []string) error {
+	//ratelimit [MBps keys]
+	//Examples:
+	//ratelimit
+	//ratelimit 20 100000
+	if len(args) != 0 && len(args) != 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	var p *ctrl.PkgRateLimit
+	var err error
+	if len(args) == 0 {
+		p, err = cc.GetRateLimit()
+	} else {
+		var mbps, keys int64
+		mbps, err = strconv.ParseInt(args[0], 10, 64)
+		if err != nil || mbps < 0 {
+			return fmt.Errorf("<MBps> %s is not a valid number", args[0])
+		}
+		keys, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil || keys < 0 {
+			return fmt.Errorf("<keys> %s is not a valid number", args[1])
+		}
+		p, err = cc.SetRateLimit(mbps, keys)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("sync: %s, %s; db IO: %s\n", limitName(p.SyncMBps, "MB/s"),
+		limitName(p.SyncKeys, "keys/s"), limitName(p.DbMBps, "MB/s"))
+	return nil
+}
+
+func limitName(limit int64, unit string) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d %s", limit, unit)
+}
+
+func slotStateName(state int) string {
+	switch state {
+	case ctrl.SlotOwned:
+		return "owned"
+	case ctrl.SlotMigrating:
+		return "migrating"
+	case ctrl.SlotMoved:
+		return "moved"
+	case ctrl.SlotNone:
+		return "none"
+	}
+	return fmt.Sprintf("unknown(%d)", state)
+}
+
+func (c *client) migrateStatus(args []string) error {
+	//migrate-status [slotId]
+	//Examples:
+	//migrate-status
+	//migrate-status 100
+	if len(args) > 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var migration bool
+	var slotId uint16
+	if len(args) > 0 {
+		id, err := strconv.ParseUint(args[0], 10, 16)
+		if err != nil || id >= ctrl.TotalSlotNum {
+			return fmt.Errorf("<slotId> %s is not a valid slot id", args[0])
+		}
+		migration = true
+		slotId = uint16(id)
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	p, err := cc.GetSlaveStatus(migration, slotId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("status: %s\n", ctrl.SlaveStatusName(p.Status))
+	for _, st := range p.SlotStatus {
+		fmt.Printf("  slots %d-%d: %s\n", st.Start, st.End,
+			ctrl.SlaveStatusName(st.Status))
+	}
+	if p.StartTime == 0 {
+		return nil
+	}
+
+	var start = time.Unix(p.StartTime, 0)
+	var elapsed = time.Since(start).Seconds()
+	fmt.Printf("started: %s (%.0fs ago)\n", start.Format(time.RFC3339), elapsed)
+
+	var percent = "-"
+	if p.TotalBytes > 0 {
+		var pct = float64(p.CopiedBytes) * 100 / float64(p.TotalBytes)
+		if pct > 100 {
+			pct = 100
+		}
+		percent = fmt.Sprintf("%.1f%%", pct)
+	}
+	fmt.Printf("copied: %d rows, %d bytes of ~%d bytes (%s)\n",
+		p.CopiedRows, p.CopiedBytes, p.TotalBytes, percent)
+	if elapsed > 0 {
+		fmt.Printf("throughput: %.0f rows/s, %.2f MB/s\n",
+			float64(p.CopiedRows)/elapsed,
+			float64(p.CopiedBytes)/elapsed/1024/1024)
+	}
+	fmt.Printf("seq lag: %d\n", p.SeqLag)
+	return nil
+}
+
 func (c *client) ping() error {
 	// ping
 	start := time.Now()
@@ -24,8 +24,13 @@ import (
 )
 
 var (
-	address = flag.String("h", "127.0.0.1:6688", "Server host address ip:port")
-	network = flag.String("N", "tcp", "Server network: tcp, tcp4, tcp6, unix")
+	address  = flag.String("h", "127.0.0.1:6688", "Server host address ip:port")
+	network  = flag.String("N", "tcp", "Server network: tcp, tcp4, tcp6, unix")
+	plain    = flag.Bool("plain", false, "Send plain text passwords (gotable-proxy)")
+	useTLS   = flag.Bool("tls", false, "Connect with TLS")
+	caFile   = flag.String("ca", "", "CA file to verify server certificate (TLS)")
+	certFile = flag.String("cert", "", "Client certificate file (TLS)")
+	keyFile  = flag.String("key", "", "Client key file (TLS)")
 )
 
 func main() {
@@ -90,10 +95,44 @@ func main() {
 			checkError(cli.zscan(fields[1:]))
 		case "auth":
 			checkError(cli.auth(fields[1:]))
+		case "login":
+			checkError(cli.login(fields[1:]))
 		case "select":
 			checkError(cli.use(fields[1:]))
 		case "slaveof":
 			checkError(cli.slaveOf(fields[1:]))
+		case "switchover":
+			checkError(cli.switchover(fields[1:]))
+		case "delay":
+			checkError(cli.delay(fields[1:]))
+		case "setslot":
+			checkError(cli.setSlot(fields[1:]))
+		case "delslot":
+			checkError(cli.delSlots(fields[1:]))
+		case "migrate-status":
+			checkError(cli.migrateStatus(fields[1:]))
+		case "cluster":
+			checkError(cli.cluster(fields[1:]))
+		case "verify":
+			checkError(cli.verify(fields[1:]))
+		case "stats":
+			checkError(cli.stats(fields[1:]))
+		case "shutdown":
+			checkError(cli.shutdown(fields[1:]))
+		case "passwd":
+			checkError(cli.passwd(fields[1:]))
+		case "user":
+			checkError(cli.user(fields[1:]))
+		case "config":
+			checkError(cli.config(fields[1:]))
+		case "client":
+			checkError(cli.client(fields[1:]))
+		case "slowlog":
+			checkError(cli.slowLog(fields[1:]))
+		case "info":
+			checkError(cli.info(fields[1:]))
+		case "ratelimit":
+			checkError(cli.rateLimit(fields[1:]))
 		case "dump":
 			checkError(cli.dump(fields[1:]))
 
@@ -128,6 +167,7 @@ func writeln(msg string) {
 func writeHelp() {
 	writeln("  help                      print help message")
 	writeln("  auth <dbId> <password>    authenticate to the database")
+	writeln(" login <user> <password>    authenticate as the named user")
 	writeln("select <dbId>               select database [0 ~ 254] to use")
 	writeln("   set <tableId> <rowKey> <colKey> <value> [score]")
 	writeln("                            set key/value in selected database")
@@ -151,7 +191,59 @@ func writeHelp() {
 	writeln("                            zscan columns of rowKey in ASC order by score")
 	writeln("  dump <dbId> [tableId]     dump the selected database or the table. Fields are:")
 	writeln("                            tableId, rowKey, colSpace, colKey, value, score")
-	writeln("slaveof [host]              be slave of master host(ip:port)")
+	writeln("slaveof [host] [include <items>] [exclude <items>] [delay <seconds>]")
+	writeln("                            be slave of master host(ip:port), only replicate")
+	writeln("                            the selected items (dbId or dbId.tableId, comma")
+	writeln("                            separated), apply changes seconds behind master")
+	writeln("switchover <host> [timeout] promote slave host(ip:port) to be master, and")
+	writeln("                            current master becomes its slave")
+	writeln(" delay [pause|resume|ff [unixtime]]")
+	writeln("                            show status, pause, resume or fast-forward (to")
+	writeln("                            unixtime, default now) the delayed slave")
+	writeln("setslot <slots> owned|migrating|moved|none [host]")
+	writeln("                            set owner of slots (like 0-100,200), requests")
+	writeln("                            for slots not owned are redirected to host")
+	writeln("delslot <slots> [compact]   delete data of slots (like 0-100,200), and")
+	writeln("                            compact the slots after deleting")
+	writeln("migrate-status [slotId]     show progress of the migration (with slotId)")
+	writeln("                            or of the normal slave")
+	writeln("cluster slots|set <slots>|reset")
+	writeln("                            show slot owners, serve only slots (cluster")
+	writeln("                            mode) or serve all slots (normal mode)")
+	writeln("verify <host> [slots]|status|repair")
+	writeln("                            compare slot checksums with slave host(ip:port),")
+	writeln("                            show mismatching slots, or re-sync them to slave")
+	writeln("stats top [size|keys] [num] [slots]")
+	writeln("                            show the largest slots (default all slots, top")
+	writeln("                            20 by size), key counts are of the last count")
+	writeln("stats tables [slots]        show size and key count of every db/table")
+	writeln("stats count                 count keys of every slot in the background")
+	writeln("ratelimit [MBps keys]       show or set max MB/s and keys/s of data sent")
+	writeln("                            to slaves and migration servers, 0: unlimited")
+	writeln("info [section]              show server statistics, section is one of")
+	writeln("                            server, clients, commands, rocksdb, binlog")
+	writeln("                            and replication (default all)")
+	writeln("slowlog get [num]|reset     show the latest num (default 10) slow requests,")
+	writeln("                            or clear them")
+	writeln("client list                 show client connections")
+	writeln("client kill <host>|type <normal|slave|master>")
+	writeln("                            close the client connection of host(ip:port),")
+	writeln("                            or all other client connections of the type")
+	writeln("config get <pattern>        show config items matching pattern (like")
+	writeln("                            binlog.* or *), * marks runtime changeable ones")
+	writeln("config set <name> <value>   change config item at runtime")
+	writeln("shutdown [timeout]          stop the server after processing queued requests")
+	writeln("                            (wait at most timeout seconds, default 30)")
+	writeln("passwd set <dbId> <password>")
+	writeln("                            set or change the password of database dbId")
+	writeln("passwd del <dbId>           remove the password of database dbId")
+	writeln("passwd list                 show databases having passwords")
+	writeln("user set <name> <password> admin|read|write [dbId] [tables]")
+	writeln("                            create or change the named user with role,")
+	writeln("                            read/write roles are scoped to dbId and the")
+	writeln("                            tables (like 1,2,5, default all tables)")
+	writeln("user del <name>             remove the named user")
+	writeln("user list                   show named users")
 	writeln("  ping                      ping the server")
 	writeln(" clear                      clear the screen")
 	writeln("  quit                      exit")
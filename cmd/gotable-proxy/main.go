@@ -0,0 +1,88 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"github.com/stevejiang/gotable/util"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	address  = flag.String("l", "0.0.0.0:6699", "Proxy listen address ip:port")
+	slotFile = flag.String("f", "", "Slot map file, lines of \"<slots> <ip:port>\" like \"0-4095 127.0.0.1:6688\"")
+	seeds    = flag.String("s", "", "GoTable servers ip:port list to read slot map by CLUSTER SLOTS, separated by comma")
+	interval = flag.Int("i", 10, "Seconds between slot map reloads")
+	useTLS   = flag.Bool("tls", false, "Connect to GoTable servers with TLS")
+	caFile   = flag.String("ca", "", "CA file to verify server certificates (TLS)")
+	certFile = flag.String("cert", "", "Client certificate file (TLS)")
+	keyFile  = flag.String("key", "", "Client key file (TLS)")
+)
+
+func main() {
+	log.SetFlags(log.Flags() | log.Lshortfile)
+	flag.Parse()
+
+	var as = splitAddrs(*seeds)
+	if len(*slotFile) == 0 && len(as) == 0 {
+		log.Println("No slot map file or GoTable server!")
+		flag.Usage()
+		return
+	}
+
+	var tlsConf *tls.Config
+	if *useTLS {
+		var err error
+		tlsConf, err = util.NewTLSConfig(*certFile, *keyFile, *caFile)
+		if err != nil {
+			log.Fatalln("Load TLS config failed:", err)
+		}
+	}
+
+	sm, err := newSlotMap(*slotFile, as, tlsConf)
+	if err != nil {
+		log.Fatalln("Load slot map failed:", err)
+	}
+
+	link, err := net.Listen("tcp", *address)
+	if err != nil {
+		log.Fatalln("Listen failed:", err)
+	}
+
+	log.Printf("GoTable proxy started on %s\n", *address)
+
+	go sm.goReload(time.Duration(*interval) * time.Second)
+
+	for {
+		if c, err := link.Accept(); err == nil {
+			go newSession(c, sm, tlsConf).goServe()
+		}
+	}
+}
+
+func splitAddrs(s string) []string {
+	var as []string
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if len(a) > 0 {
+			as = append(as, a)
+		}
+	}
+	return as
+}
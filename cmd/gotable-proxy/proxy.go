@@ -0,0 +1,603 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/util"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	maxRedirects = 5 // Max times a request follows EcMoved/EcAsk
+	dialTimeout  = time.Second
+)
+
+var (
+	errNoOwner      = errors.New("no server owns the slot")
+	errClosed       = errors.New("backend connection is closed")
+	errInvalidReply = errors.New("invalid reply pkg")
+	errScram        = errors.New("challenge-response auth not supported")
+)
+
+// A connection to a GoTable server. Requests of a client session are
+// pipelined on it with seq of the connection.
+type backend struct {
+	addr string
+	conn net.Conn
+
+	mtx     sync.Mutex // protects following
+	seq     uint64
+	pending map[uint64]chan []byte
+	closed  bool
+}
+
+// Connect to the server, with TLS if config is not nil.
+func dialBackend(addr string, config *tls.Config) (*backend, error) {
+	conn, err := util.DialTimeout("tcp", addr, dialTimeout, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var b = new(backend)
+	b.addr = addr
+	b.conn = conn
+	b.pending = make(map[uint64]chan []byte)
+
+	go b.goRecv()
+	return b, nil
+}
+
+// Send the request pkg. The reply is sent to the returned channel,
+// or nil if the connection is broken.
+func (b *backend) send(pkg []byte) (chan []byte, error) {
+	var req = make([]byte, len(pkg))
+	copy(req, pkg)
+	var ch = make(chan []byte, 1)
+
+	b.mtx.Lock()
+	if b.closed {
+		b.mtx.Unlock()
+		return nil, errClosed
+	}
+	b.seq += 1
+	b.pending[b.seq] = ch
+	proto.OverWriteSeq(req, b.seq)
+	_, err := b.conn.Write(req)
+	b.mtx.Unlock()
+
+	if err != nil {
+		b.close()
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (b *backend) goRecv() {
+	var r = bufio.NewReader(b.conn)
+	var headBuf = make([]byte, proto.HeadSize)
+	var head proto.PkgHead
+	for {
+		pkg, err := proto.ReadPkg(r, headBuf, &head, nil)
+		if err != nil {
+			if !b.isClosed() && err != io.EOF && err != io.ErrUnexpectedEOF {
+				log.Printf("ReadPkg from %s failed: %s\n", b.addr, err)
+			}
+			b.close()
+			return
+		}
+
+		b.mtx.Lock()
+		var ch = b.pending[head.Seq]
+		delete(b.pending, head.Seq)
+		b.mtx.Unlock()
+
+		if ch != nil {
+			ch <- pkg
+		}
+	}
+}
+
+func (b *backend) isClosed() bool {
+	b.mtx.Lock()
+	var closed = b.closed
+	b.mtx.Unlock()
+	return closed
+}
+
+func (b *backend) close() {
+	b.mtx.Lock()
+	if b.closed {
+		b.mtx.Unlock()
+		return
+	}
+	b.closed = true
+	var pending = b.pending
+	b.pending = nil
+	b.mtx.Unlock()
+
+	b.conn.Close()
+	for _, ch := range pending {
+		ch <- nil
+	}
+}
+
+// A client connection to the proxy. It has its own backend connections,
+// which are authorized as the client.
+type session struct {
+	c       net.Conn
+	sm      *slotMap
+	tlsConf *tls.Config // nil if not connecting to servers with TLS
+
+	wMtx sync.Mutex // protects writing to c
+
+	mtx      sync.Mutex // protects following
+	backends map[string]*backend
+	authPkgs map[uint8][]byte // Successful auth request pkgs by dbId
+	authGen  uint64           // Changed with authPkgs
+}
+
+func newSession(c net.Conn, sm *slotMap, tlsConf *tls.Config) *session {
+	var s = new(session)
+	s.c = c
+	s.sm = sm
+	s.tlsConf = tlsConf
+	s.backends = make(map[string]*backend)
+	s.authPkgs = make(map[uint8][]byte)
+	return s
+}
+
+func (s *session) goServe() {
+	defer s.close()
+
+	var r = bufio.NewReader(s.c)
+	var headBuf = make([]byte, proto.HeadSize)
+	var head proto.PkgHead
+	for {
+		pkg, err := proto.ReadPkg(r, headBuf, &head, nil)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				log.Printf("ReadPkg failed: %s, close client!\n", err)
+			}
+			return
+		}
+
+		switch head.Cmd {
+		case proto.CmdPing:
+			s.write(pkg)
+		case proto.CmdAuth:
+			s.auth(head, pkg)
+		case proto.CmdScram:
+			// Backends can only be authorized by the kept plain text password,
+			// as the proof of one server cannot be replayed to another
+			s.replyErr(head, false, errScram)
+		case proto.CmdGet:
+			fallthrough
+		case proto.CmdSet:
+			fallthrough
+		case proto.CmdDel:
+			fallthrough
+		case proto.CmdIncr:
+			var p proto.PkgOneOp
+			_, err = p.Decode(pkg)
+			if err == nil {
+				var slotId = ctrl.GetSlotId(p.DbId, p.TableId, p.RowKey)
+				s.forward(head, pkg, s.sm.getOwner(slotId), false)
+			}
+		case proto.CmdScan:
+			var p proto.PkgScanReq
+			_, err = p.Decode(pkg)
+			if err == nil {
+				var slotId = ctrl.GetSlotId(p.DbId, p.TableId, p.RowKey)
+				s.forward(head, pkg, s.sm.getOwner(slotId), true)
+			}
+		case proto.CmdMGet:
+			fallthrough
+		case proto.CmdMSet:
+			fallthrough
+		case proto.CmdMDel:
+			fallthrough
+		case proto.CmdMIncr:
+			var p proto.PkgMultiOp
+			_, err = p.Decode(pkg)
+			if err == nil {
+				s.multiOp(head, pkg, &p)
+			}
+		default:
+			log.Printf("Unsupported cmd 0x%X, close client!\n", head.Cmd)
+			return
+		}
+
+		if err != nil {
+			log.Printf("Decode cmd 0x%X failed: %s, close client!\n", head.Cmd, err)
+			return
+		}
+	}
+}
+
+func (s *session) close() {
+	s.c.Close()
+
+	s.mtx.Lock()
+	var backends = s.backends
+	s.backends = nil
+	s.mtx.Unlock()
+
+	for _, b := range backends {
+		b.close()
+	}
+}
+
+func (s *session) write(pkg []byte) {
+	s.wMtx.Lock()
+	_, err := s.c.Write(pkg)
+	s.wMtx.Unlock()
+	if err != nil {
+		s.c.Close()
+	}
+}
+
+// Get the backend connection to the server, it's authorized as the client.
+// The connection is dialed and authorized without holding the lock, and it's
+// authorized again if the auth requests are changed meanwhile.
+func (s *session) getBackend(addr string) (*backend, error) {
+	if len(addr) == 0 {
+		return nil, errNoOwner
+	}
+
+	for {
+		s.mtx.Lock()
+		if s.backends == nil {
+			s.mtx.Unlock()
+			return nil, errClosed
+		}
+		var b = s.backends[addr]
+		if b != nil && !b.isClosed() {
+			s.mtx.Unlock()
+			return b, nil
+		}
+		var authGen = s.authGen
+		var authPkgs = make([][]byte, 0, len(s.authPkgs))
+		for _, pkg := range s.authPkgs {
+			authPkgs = append(authPkgs, pkg)
+		}
+		s.mtx.Unlock()
+
+		b, err := s.dialAuth(addr, authPkgs)
+		if err != nil {
+			return nil, err
+		}
+
+		s.mtx.Lock()
+		if s.backends == nil {
+			s.mtx.Unlock()
+			b.close()
+			return nil, errClosed
+		}
+		if old := s.backends[addr]; old != nil && !old.isClosed() {
+			// Dialed by another request
+			s.mtx.Unlock()
+			b.close()
+			return old, nil
+		}
+		if authGen == s.authGen {
+			s.backends[addr] = b
+			s.mtx.Unlock()
+			return b, nil
+		}
+		s.mtx.Unlock()
+		b.close()
+	}
+}
+
+// Dial the server, and authorize the connection by the auth requests.
+func (s *session) dialAuth(addr string, authPkgs [][]byte) (*backend, error) {
+	b, err := dialBackend(addr, s.tlsConf)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range authPkgs {
+		err = checkReply(b.send(pkg))
+		if err != nil {
+			b.close()
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Wait for the reply, and fail if the error code is not 0.
+func checkReply(ch chan []byte, err error) error {
+	if err != nil {
+		return err
+	}
+
+	var pkg = <-ch
+	if pkg == nil {
+		return errClosed
+	}
+
+	var p proto.PkgOneOp
+	_, err = p.Decode(pkg)
+	if err != nil {
+		return err
+	}
+	if p.ErrCode != 0 {
+		return table.ErrAuthFailed
+	}
+	return nil
+}
+
+func (s *session) send(addr string, pkg []byte) (chan []byte, error) {
+	b, err := s.getBackend(addr)
+	if err != nil {
+		return nil, err
+	}
+	return b.send(pkg)
+}
+
+// Wait for the reply, and follow the EcMoved/EcAsk redirection.
+// Return nil if the connection is broken.
+func (s *session) wait(cmd uint8, pkg []byte, ch chan []byte) []byte {
+	for i := 0; ; i++ {
+		var reply = <-ch
+		if reply == nil {
+			return nil
+		}
+
+		errCode, addr := table.GetRedirect(cmd, reply)
+		if errCode == table.EcMoved {
+			s.sm.refresh()
+		}
+		if len(addr) == 0 || i >= maxRedirects {
+			return reply
+		}
+
+		var req = make([]byte, len(pkg))
+		copy(req, pkg)
+		if errCode == table.EcAsk {
+			req[proto.HeadSize] |= proto.FlagAsking
+		} else {
+			req[proto.HeadSize] &^= proto.FlagAsking
+		}
+
+		var err error
+		ch, err = s.send(addr, req)
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// Forward the request to the server, and the reply to the client.
+func (s *session) forward(head proto.PkgHead, pkg []byte, addr string,
+	multi bool) {
+	ch, err := s.send(addr, pkg)
+	if err != nil {
+		s.replyErr(head, multi, err)
+		return
+	}
+
+	go func() {
+		var reply = s.wait(head.Cmd, pkg, ch)
+		if reply == nil {
+			s.replyErr(head, multi, errClosed)
+			return
+		}
+		proto.OverWriteSeq(reply, head.Seq)
+		s.write(reply)
+	}()
+}
+
+// Split the MGet/MSet/MDel/MIncr request by servers, and merge the replies.
+func (s *session) multiOp(head proto.PkgHead, pkg []byte, p *proto.PkgMultiOp) {
+	var addrs []string
+	var groups = make(map[string][]int)
+	for i := 0; i < len(p.Kvs); i++ {
+		var slotId = ctrl.GetSlotId(p.DbId, p.Kvs[i].TableId, p.Kvs[i].RowKey)
+		var addr = s.sm.getOwner(slotId)
+		if len(addr) == 0 {
+			s.replyErr(head, true, errNoOwner)
+			return
+		}
+		if _, ok := groups[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+		groups[addr] = append(groups[addr], i)
+	}
+
+	if len(addrs) <= 1 {
+		var addr string
+		if len(addrs) == 1 {
+			addr = addrs[0]
+		} else {
+			addr = s.sm.getOwner(0)
+		}
+		s.forward(head, pkg, addr, true)
+		return
+	}
+
+	var subs = make([][]byte, len(addrs))
+	var chs = make([]chan []byte, len(addrs))
+	for i, addr := range addrs {
+		var sub = *p
+		sub.Kvs = make([]proto.KeyValue, len(groups[addr]))
+		for j, k := range groups[addr] {
+			sub.Kvs[j] = p.Kvs[k]
+		}
+
+		subs[i] = make([]byte, sub.Length())
+		_, err := sub.Encode(subs[i])
+		if err == nil {
+			chs[i], err = s.send(addr, subs[i])
+		}
+		if err != nil {
+			s.replyErr(head, true, err)
+			return
+		}
+	}
+
+	go func() {
+		var out proto.PkgMultiOp
+		out.Cmd = head.Cmd
+		out.DbId = head.DbId
+		out.Seq = head.Seq
+		out.Kvs = make([]proto.KeyValue, len(p.Kvs))
+		for i, addr := range addrs {
+			var r proto.PkgMultiOp
+			var reply = s.wait(head.Cmd, subs[i], chs[i])
+			if reply == nil {
+				s.replyErr(head, true, errClosed)
+				return
+			}
+			_, err := r.Decode(reply)
+			if err == nil && r.ErrCode == 0 && len(r.Kvs) != len(groups[addr]) {
+				err = errInvalidReply
+			}
+			if err != nil {
+				s.replyErr(head, true, err)
+				return
+			}
+			if r.ErrCode != 0 {
+				out.ErrCode = r.ErrCode
+				out.Kvs = nil
+				break
+			}
+			for j, k := range groups[addr] {
+				out.Kvs[k] = r.Kvs[j]
+			}
+		}
+
+		var reply = make([]byte, out.Length())
+		_, err := out.Encode(reply)
+		if err != nil {
+			s.replyErr(head, true, err)
+			return
+		}
+		s.write(reply)
+	}()
+}
+
+// Authorize the client on all backend connections. The auth request is kept
+// to authorize new backend connections.
+func (s *session) auth(head proto.PkgHead, pkg []byte) {
+	var req = make([]byte, len(pkg))
+	copy(req, pkg)
+
+	s.mtx.Lock()
+	var old, hasOld = s.authPkgs[head.DbId]
+	s.authPkgs[head.DbId] = req
+	s.authGen++
+	var backends []*backend
+	for _, b := range s.backends {
+		if !b.isClosed() {
+			backends = append(backends, b)
+		}
+	}
+	s.mtx.Unlock()
+
+	if len(backends) == 0 {
+		// Authorized when connected
+		for i := 0; i < ctrl.TotalSlotNum; i++ {
+			var addr = s.sm.getOwner(uint16(i))
+			if len(addr) > 0 {
+				_, err := s.getBackend(addr)
+				if err == table.ErrAuthFailed {
+					s.authFailed(head, old, hasOld)
+					return
+				}
+				break
+			}
+		}
+	} else {
+		for _, b := range backends {
+			if checkReply(b.send(pkg)) == table.ErrAuthFailed {
+				s.authFailed(head, old, hasOld)
+				return
+			}
+		}
+	}
+
+	var out proto.PkgOneOp
+	out.Cmd = head.Cmd
+	out.DbId = head.DbId
+	out.Seq = head.Seq
+	var reply = make([]byte, out.Length())
+	_, err := out.Encode(reply)
+	if err == nil {
+		s.write(reply)
+	}
+}
+
+func (s *session) authFailed(head proto.PkgHead, old []byte, hasOld bool) {
+	s.mtx.Lock()
+	if hasOld {
+		s.authPkgs[head.DbId] = old
+	} else {
+		delete(s.authPkgs, head.DbId)
+	}
+	s.authGen++
+	s.mtx.Unlock()
+
+	s.replyErr(head, false, table.ErrAuthFailed)
+}
+
+// Reply the error to the client.
+func (s *session) replyErr(head proto.PkgHead, multi bool, err error) {
+	var errCode int8 = table.EcTempFail
+	switch err {
+	case errNoOwner:
+		errCode = table.EcMoved
+	case table.ErrAuthFailed:
+		errCode = table.EcAuthFailed
+	case errScram:
+		errCode = table.EcPlainAuth
+	}
+	if err != table.ErrAuthFailed && err != errScram {
+		log.Printf("Cmd 0x%X failed: %s\n", head.Cmd, err)
+	}
+
+	var pkg []byte
+	if multi {
+		var out proto.PkgMultiOp
+		out.Cmd = head.Cmd
+		out.DbId = head.DbId
+		out.Seq = head.Seq
+		out.ErrCode = errCode
+		pkg = make([]byte, out.Length())
+		_, err = out.Encode(pkg)
+	} else {
+		var out proto.PkgOneOp
+		out.Cmd = head.Cmd
+		out.DbId = head.DbId
+		out.Seq = head.Seq
+		out.SetErrCode(errCode)
+		pkg = make([]byte, out.Length())
+		_, err = out.Encode(pkg)
+	}
+	if err == nil {
+		s.write(pkg)
+	}
+}
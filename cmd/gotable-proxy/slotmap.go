@@ -0,0 +1,201 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/ctrl"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Slot owner map, loaded from the slot map file or read from the servers.
+// It's reloaded on SIGHUP, every interval, and when a request is redirected
+// by EcMoved.
+type slotMap struct {
+	file string
+	cl   *table.Cluster // Read slot map from servers if no file
+
+	mtx     sync.RWMutex // protects following
+	owners  [ctrl.TotalSlotNum]string
+	modTime time.Time
+
+	reload chan bool
+}
+
+func newSlotMap(file string, seeds []string, tlsConf *tls.Config) (*slotMap, error) {
+	var sm = new(slotMap)
+	sm.file = file
+	sm.reload = make(chan bool, 1)
+
+	if len(file) > 0 {
+		err := sm.loadFile()
+		if err != nil {
+			return nil, err
+		}
+		return sm, nil
+	}
+
+	cl, err := table.NewTLSCluster("tcp", seeds, 1, tlsConf)
+	if err != nil {
+		return nil, err
+	}
+	sm.cl = cl
+	sm.loadCluster()
+	return sm, nil
+}
+
+// Get the owner address of the slot, empty if unknown.
+func (sm *slotMap) getOwner(slotId uint16) string {
+	sm.mtx.RLock()
+	var addr = sm.owners[slotId]
+	sm.mtx.RUnlock()
+	return addr
+}
+
+// Ask to reload the slot map as soon as possible.
+func (sm *slotMap) refresh() {
+	select {
+	case sm.reload <- true:
+	default:
+	}
+}
+
+func (sm *slotMap) goReload(interval time.Duration) {
+	var hup = make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick = time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		var force bool
+		select {
+		case <-hup:
+			force = true
+		case <-sm.reload:
+		case <-tick.C:
+		}
+
+		if len(sm.file) > 0 {
+			if !force && !sm.fileChanged() {
+				continue
+			}
+			err := sm.loadFile()
+			if err != nil {
+				log.Printf("Reload slot map file failed: %s\n", err)
+			}
+		} else {
+			err := sm.cl.Refresh()
+			if err != nil {
+				log.Printf("Read slot map from servers failed: %s\n", err)
+				continue
+			}
+			sm.loadCluster()
+		}
+	}
+}
+
+func (sm *slotMap) fileChanged() bool {
+	fi, err := os.Stat(sm.file)
+	if err != nil {
+		return false
+	}
+
+	sm.mtx.RLock()
+	var changed = !fi.ModTime().Equal(sm.modTime)
+	sm.mtx.RUnlock()
+	return changed
+}
+
+func (sm *slotMap) loadCluster() {
+	var owners [ctrl.TotalSlotNum]string
+	for i := 0; i < ctrl.TotalSlotNum; i++ {
+		owners[i] = sm.cl.GetOwner(uint16(i))
+	}
+
+	sm.mtx.Lock()
+	sm.owners = owners
+	sm.mtx.Unlock()
+}
+
+// Load the slot map file.
+func (sm *slotMap) loadFile() error {
+	file, err := os.Open(sm.file)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	owners, err := parseSlotMap(file)
+	if err != nil {
+		return err
+	}
+
+	sm.mtx.Lock()
+	sm.owners = *owners
+	sm.modTime = fi.ModTime()
+	sm.mtx.Unlock()
+
+	log.Printf("Slot map loaded from %s\n", sm.file)
+	return nil
+}
+
+// Parse the slot map. Every line is "<slots> <ip:port>", slots are like
+// "0-100,200". Empty lines and lines started with '#' are ignored.
+func parseSlotMap(r io.Reader) (*[ctrl.TotalSlotNum]string, error) {
+	var owners [ctrl.TotalSlotNum]string
+	var sc = bufio.NewScanner(r)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		var line = strings.TrimSpace(sc.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var fields = strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line %d: %s", lineNo, line)
+		}
+
+		slots, err := ctrl.ParseSlotRanges(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d: %s", lineNo, err)
+		}
+		for _, r := range slots {
+			for i := int(r.Start); i <= int(r.End); i++ {
+				owners[i] = fields[1]
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return &owners, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stevejiang/gotable/ctrl"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSlotMap(t *testing.T) {
+	owners, err := parseSlotMap(strings.NewReader(`
+# slot map
+0-4095,8000 127.0.0.1:6688
+
+  4096-7999   127.0.0.1:6689
+`))
+	if err != nil {
+		t.Fatalf("parseSlotMap failed: %s", err)
+	}
+
+	var cases = []struct {
+		slotId uint16
+		addr   string
+	}{{0, "127.0.0.1:6688"}, {4095, "127.0.0.1:6688"},
+		{4096, "127.0.0.1:6689"}, {7999, "127.0.0.1:6689"},
+		{8000, "127.0.0.1:6688"}, {8001, ""}, {ctrl.TotalSlotNum - 1, ""}}
+	for _, c := range cases {
+		if owners[c.slotId] != c.addr {
+			t.Fatalf("Slot %d owner is %q, not %q", c.slotId,
+				owners[c.slotId], c.addr)
+		}
+	}
+
+	for _, s := range []string{
+		"0-100",
+		"0-100 127.0.0.1:6688 extra",
+		"a-100 127.0.0.1:6688",
+		"100-0 127.0.0.1:6688",
+		"0-8192 127.0.0.1:6688",
+	} {
+		if _, err = parseSlotMap(strings.NewReader(s)); err == nil {
+			t.Fatalf("Invalid slot map %q should fail", s)
+		}
+	}
+}
+
+func writeSlotMap(t *testing.T, file, content string, modTime time.Time) {
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %s", err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatalf("Chtimes failed: %s", err)
+	}
+}
+
+func TestSlotMapReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotable-proxy")
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var file = filepath.Join(dir, "slots.conf")
+	var now = time.Now()
+	writeSlotMap(t, file, "0-8191 127.0.0.1:6688\n", now.Add(-time.Minute))
+
+	sm, err := newSlotMap(file, nil, nil)
+	if err != nil {
+		t.Fatalf("newSlotMap failed: %s", err)
+	}
+	if sm.getOwner(100) != "127.0.0.1:6688" || sm.fileChanged() {
+		t.Fatalf("Invalid slot map loaded")
+	}
+
+	writeSlotMap(t, file, "0-99 127.0.0.1:6688\n100-8191 127.0.0.1:6689\n", now)
+	if !sm.fileChanged() {
+		t.Fatalf("Slot map file change not found")
+	}
+	if err = sm.loadFile(); err != nil {
+		t.Fatalf("loadFile failed: %s", err)
+	}
+	if sm.getOwner(99) != "127.0.0.1:6688" || sm.getOwner(100) != "127.0.0.1:6689" {
+		t.Fatalf("Slot map not reloaded")
+	}
+	if sm.fileChanged() {
+		t.Fatalf("Slot map file should not be changed after reload")
+	}
+
+	// The old slot map is kept if the new file is invalid
+	writeSlotMap(t, file, "0-99\n", now.Add(time.Minute))
+	if err = sm.loadFile(); err == nil {
+		t.Fatalf("Invalid slot map file should fail")
+	}
+	if sm.getOwner(100) != "127.0.0.1:6689" {
+		t.Fatalf("Old slot map should be kept")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"github.com/stevejiang/gotable/util"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	servers   = flag.String("s", "", "GoTable servers ip:port list in cluster mode, separated by comma")
+	adminPwd  = flag.String("pwd", "", "Admin password of GoTable servers")
+	stateFile = flag.String("state", "rebalance.state", "File to save the plan and progress, for resuming")
+	dryRun    = flag.Bool("dry", false, "Print the plan only, do not move any slot")
+	parallel  = flag.Int("c", 1, "Max number of moves running at the same time")
+	maxSlots  = flag.Int("max", 256, "Max number of slots in one move")
+	threshold = flag.Int("t", 5, "Do not move if every server is within the percent of the average size")
+	useTLS    = flag.Bool("tls", false, "Connect to GoTable servers with TLS")
+	caFile    = flag.String("ca", "", "CA file to verify server certificates (TLS)")
+	certFile  = flag.String("cert", "", "Client certificate file (TLS)")
+	keyFile   = flag.String("key", "", "Client key file (TLS)")
+	waitTime  = flag.Int("w", 10, "Seconds to wait for clients to switch after setting slots owned and moved")
+)
+
+func main() {
+	log.SetFlags(log.Flags() | log.Lshortfile)
+	flag.Parse()
+
+	var tlsConf *tls.Config
+	if *useTLS {
+		var err error
+		tlsConf, err = util.NewTLSConfig(*certFile, *keyFile, *caFile)
+		if err != nil {
+			log.Fatalln("Load TLS config failed:", err)
+		}
+	}
+
+	var rb = newRebalancer(splitAddrs(*servers), *adminPwd, *stateFile,
+		time.Duration(*waitTime)*time.Second, tlsConf)
+
+	pl, err := rb.loadPlan()
+	if err != nil {
+		log.Fatalln("Load plan failed:", err)
+	}
+
+	if pl != nil {
+		log.Printf("Resume the plan in %s\n", *stateFile)
+	} else {
+		if len(rb.servers) == 0 {
+			log.Println("No GoTable server to rebalance!")
+			flag.Usage()
+			os.Exit(1)
+		}
+
+		nodes, err := rb.readNodes()
+		if err != nil {
+			log.Fatalln("Read slot sizes failed:", err)
+		}
+
+		pl = makePlan(nodes, *maxSlots, *threshold)
+	}
+
+	pl.print()
+	if *dryRun || pl.finished() {
+		return
+	}
+
+	err = rb.run(pl, *parallel)
+	if err != nil {
+		log.Fatalln("Rebalance failed:", err)
+	}
+
+	os.Remove(*stateFile)
+	log.Println("Rebalance finished")
+}
+
+func splitAddrs(s string) []string {
+	var as []string
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if len(a) > 0 {
+			as = append(as, a)
+		}
+	}
+	return as
+}
@@ -0,0 +1,192 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/ctrl"
+	"sort"
+	"time"
+)
+
+// Steps of a move, in the order they are executed
+const (
+	stepMigrate       = iota // Start migration on the new owner
+	stepWaitReady            // Wait until the new owner is up to date
+	stepSetMigrating         // Redirect requests from the old owner by EcAsk
+	stepStopMigration        // Stop migration, the new owner accepts writes
+	stepSetOwned             // The new owner serves the slots
+	stepSetMoved             // Redirect requests from the old owner by EcMoved
+	stepDelSlot              // Delete slot data from the old owner
+	stepDone
+)
+
+var stepNames = []string{"migrate", "wait-ready", "set-migrating",
+	"stop-migration", "set-owned", "set-moved", "delete-slot", "done"}
+
+// A move of slots from the old owner (Src) to the new owner (Dst)
+type move struct {
+	Src   string
+	Dst   string
+	Slots []ctrl.SlotRange
+	Size  uint64 // Approximate data size of the slots
+	Step  int    // The next step to execute
+}
+
+// A rebalance plan, saved in the state file with the progress
+type plan struct {
+	Moves  []*move
+	Before map[string]uint64 // Data size of each server before rebalance
+	After  map[string]uint64 // Data size of each server after rebalance
+	Time   time.Time         // Plan created time
+}
+
+// A server with the owned slots
+type node struct {
+	addr  string
+	slots []uint16 // Owned slots in ASC order
+	sizes [ctrl.TotalSlotNum]uint64
+	load  uint64
+}
+
+// Weight of a slot. Every slot weighs one more byte than its size, so that
+// slot numbers are balanced when servers have no data.
+func (nd *node) weight(slotId uint16) uint64 {
+	return nd.sizes[slotId] + 1
+}
+
+func (nd *node) dataSize() uint64 {
+	return nd.load - uint64(len(nd.slots))
+}
+
+// Make a plan moving slots from the largest server to the smallest one,
+// until every server is within threshold percent of the average.
+func makePlan(nodes []*node, maxSlots, threshold int) *plan {
+	var pl = new(plan)
+	pl.Time = time.Now()
+	pl.Before = make(map[string]uint64)
+	pl.After = make(map[string]uint64)
+	if len(nodes) == 0 {
+		return pl
+	}
+
+	var total uint64
+	for _, nd := range nodes {
+		total += nd.load
+		pl.Before[nd.addr] = nd.dataSize()
+	}
+	var avg = total / uint64(len(nodes))
+	var limit = avg * uint64(threshold) / 100
+
+	for {
+		sort.Sort(nodeSlice(nodes))
+		var dst, src = nodes[0], nodes[len(nodes)-1]
+		if src.load <= avg+limit && dst.load+limit >= avg {
+			break
+		}
+
+		// Move slots with the largest IDs, so that ranges stay continuous
+		var mv = &move{Src: src.addr, Dst: dst.addr}
+		var moved []ctrl.SlotRange
+		for i := len(src.slots) - 1; i >= 0 && len(moved) < maxSlots; i-- {
+			if src.load <= avg || dst.load >= avg {
+				break
+			}
+			var slotId = src.slots[i]
+			var w = src.weight(slotId)
+			if w >= src.load-dst.load {
+				continue // No improvement
+			}
+
+			src.slots = append(src.slots[:i], src.slots[i+1:]...)
+			dst.slots = append(dst.slots, slotId)
+			dst.sizes[slotId] = src.sizes[slotId]
+			src.load -= w
+			dst.load += w
+			mv.Size += src.sizes[slotId]
+			moved = append(moved, ctrl.SlotRange{Start: slotId, End: slotId})
+		}
+		if len(moved) == 0 {
+			break
+		}
+
+		sort.Sort(slotIdSlice(dst.slots))
+		mv.Slots = ctrl.NewSlotSet(moved).Ranges()
+		pl.Moves = append(pl.Moves, mv)
+	}
+
+	for _, nd := range nodes {
+		pl.After[nd.addr] = nd.dataSize()
+	}
+	return pl
+}
+
+func (pl *plan) finished() bool {
+	for _, mv := range pl.Moves {
+		if mv.Step < stepDone {
+			return false
+		}
+	}
+	return true
+}
+
+func (pl *plan) print() {
+	if len(pl.Before) > 0 {
+		var addrs []string
+		for addr := range pl.Before {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			fmt.Printf("%-22s %12s -> %s\n", addr,
+				formatSize(pl.Before[addr]), formatSize(pl.After[addr]))
+		}
+	}
+
+	if len(pl.Moves) == 0 {
+		fmt.Println("Already balanced, nothing to move")
+		return
+	}
+
+	for i, mv := range pl.Moves {
+		fmt.Printf("move %d: %s -> %s, slots %s, %s, next step %s\n",
+			i+1, mv.Src, mv.Dst, ctrl.NewSlotSet(mv.Slots),
+			formatSize(mv.Size), stepNames[mv.Step])
+	}
+}
+
+func formatSize(size uint64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.2fGB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.2fMB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.2fKB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", size)
+}
+
+type nodeSlice []*node
+
+func (s nodeSlice) Len() int           { return len(s) }
+func (s nodeSlice) Less(i, j int) bool { return s[i].load < s[j].load }
+func (s nodeSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type slotIdSlice []uint16
+
+func (s slotIdSlice) Len() int           { return len(s) }
+func (s slotIdSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s slotIdSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,133 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stevejiang/gotable/ctrl"
+	"testing"
+)
+
+// A node owning slots [start, end] of the same size each.
+func newNode(addr string, start, end int, size uint64) *node {
+	var nd = &node{addr: addr}
+	for i := start; i <= end; i++ {
+		nd.slots = append(nd.slots, uint16(i))
+		nd.sizes[i] = size
+		nd.load += nd.weight(uint16(i))
+	}
+	return nd
+}
+
+func TestMakePlanEmptyServer(t *testing.T) {
+	var a = newNode("a", 0, ctrl.TotalSlotNum-1, 0)
+	var b = newNode("b", 1, 0, 0)
+	var pl = makePlan([]*node{a, b}, 1000, 10)
+
+	var moved []ctrl.SlotRange
+	for _, mv := range pl.Moves {
+		if mv.Src != "a" || mv.Dst != "b" || mv.Step != stepMigrate {
+			t.Fatalf("Invalid move %+v", mv)
+		}
+		var n = ctrl.NewSlotSet(mv.Slots).Len()
+		if n == 0 || n > 1000 {
+			t.Fatalf("Invalid slot number %d", n)
+		}
+		moved = append(moved, mv.Slots...)
+	}
+
+	// The largest slots are moved, until within the threshold of half
+	var set = ctrl.NewSlotSet(moved)
+	var n = set.Len()
+	var half = ctrl.TotalSlotNum / 2
+	if n > half || n < half-half/10 {
+		t.Fatalf("Moved %d slots, expect about %d", n, half)
+	}
+	if !set.Has(uint16(ctrl.TotalSlotNum-n)) || !set.Has(ctrl.TotalSlotNum-1) ||
+		set.Has(uint16(ctrl.TotalSlotNum-n-1)) {
+		t.Fatalf("Invalid moved slots %s", set)
+	}
+	if len(a.slots) != ctrl.TotalSlotNum-n || len(b.slots) != n ||
+		b.slots[0] != uint16(ctrl.TotalSlotNum-n) {
+		t.Fatalf("Invalid owned slots %d, %d", len(a.slots), len(b.slots))
+	}
+	if pl.Before["a"] != 0 || pl.After["b"] != 0 {
+		t.Fatalf("Invalid data size %v -> %v", pl.Before, pl.After)
+	}
+}
+
+func TestMakePlanBalanced(t *testing.T) {
+	var half = ctrl.TotalSlotNum / 2
+	var nodes = []*node{newNode("a", 0, half-1, 10),
+		newNode("b", half, ctrl.TotalSlotNum-1, 10)}
+	var pl = makePlan(nodes, 1000, 10)
+	if len(pl.Moves) != 0 || !pl.finished() {
+		t.Fatalf("Balanced servers should have no moves: %+v", pl.Moves)
+	}
+
+	pl = makePlan(nil, 1000, 10)
+	if len(pl.Moves) != 0 {
+		t.Fatalf("No servers should have no moves")
+	}
+}
+
+func TestMakePlanSlotSize(t *testing.T) {
+	var a = newNode("a", 0, 1, 1000)
+	var b = newNode("b", 2, 1, 0)
+	var pl = makePlan([]*node{a, b}, 1000, 10)
+	if len(pl.Moves) != 1 || pl.Moves[0].Size != 1000 {
+		t.Fatalf("Invalid moves %+v", pl.Moves)
+	}
+	var s = pl.Moves[0].Slots
+	if len(s) != 1 || s[0].Start != 1 || s[0].End != 1 {
+		t.Fatalf("Slot 1 should be moved: %v", s)
+	}
+	if pl.Before["a"] != 2000 || pl.Before["b"] != 0 ||
+		pl.After["a"] != 1000 || pl.After["b"] != 1000 {
+		t.Fatalf("Invalid data size %v -> %v", pl.Before, pl.After)
+	}
+	if pl.finished() {
+		t.Fatalf("Plan should not be finished")
+	}
+	pl.Moves[0].Step = stepDone
+	if !pl.finished() {
+		t.Fatalf("Plan should be finished")
+	}
+
+	// Moving the only slot makes no improvement
+	a = newNode("a", 0, 0, 1000)
+	b = newNode("b", 1, 0, 0)
+	pl = makePlan([]*node{a, b}, 1000, 10)
+	if len(pl.Moves) != 0 {
+		t.Fatalf("Single slot should not be moved: %+v", pl.Moves)
+	}
+}
+
+func TestMakePlanMaxSlots(t *testing.T) {
+	var a = newNode("a", 0, 99, 0)
+	var b = newNode("b", 100, 99, 0)
+	var c = newNode("c", 100, 99, 0)
+	var pl = makePlan([]*node{a, b, c}, 10, 5)
+
+	var num = make(map[string]int)
+	for _, mv := range pl.Moves {
+		if mv.Src != "a" || ctrl.NewSlotSet(mv.Slots).Len() > 10 {
+			t.Fatalf("Invalid move %+v", mv)
+		}
+		num[mv.Dst] += ctrl.NewSlotSet(mv.Slots).Len()
+	}
+	if num["b"] < 31 || num["b"] > 35 || num["c"] < 31 || num["c"] > 35 {
+		t.Fatalf("Unbalanced moves %v", num)
+	}
+}
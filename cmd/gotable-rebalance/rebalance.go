@@ -0,0 +1,389 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	checkInterval = time.Second
+	maxStepErrors = 10 // Stop after a step failed for so many times
+)
+
+type rebalancer struct {
+	servers   []string
+	adminPwd  string
+	stateFile string
+	wait      time.Duration
+	tlsConf   *tls.Config // nil if not connecting with TLS
+
+	mtx sync.Mutex // protects saving the plan
+}
+
+func newRebalancer(servers []string, adminPwd, stateFile string,
+	wait time.Duration, tlsConf *tls.Config) *rebalancer {
+	return &rebalancer{servers: servers, adminPwd: adminPwd,
+		stateFile: stateFile, wait: wait, tlsConf: tlsConf}
+}
+
+// Connect to the server as admin, with TLS if tlsConf is set.
+func (rb *rebalancer) connect(addr string) (*table.Client, *table.CtrlContext, error) {
+	var cli *table.Client
+	var err error
+	if rb.tlsConf != nil {
+		cli, err = table.DialTLS("tcp", addr, rb.tlsConf)
+	} else {
+		cli, err = table.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ctx = cli.NewContext(proto.AdminDbId)
+	if len(rb.adminPwd) > 0 {
+		err = ctx.Auth(rb.adminPwd)
+		if err != nil {
+			cli.Close()
+			return nil, nil, err
+		}
+	}
+
+	return cli, (*table.CtrlContext)(ctx), nil
+}
+
+// Read owned slots and slot sizes of all servers.
+func (rb *rebalancer) readNodes() ([]*node, error) {
+	var nodes []*node
+	for _, addr := range rb.servers {
+		cli, cc, err := rb.connect(addr)
+		if err != nil {
+			return nil, fmt.Errorf("connect to %s failed(%s)", addr, err)
+		}
+
+		p, err := cc.ClusterSizes()
+		cli.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read slot sizes from %s failed(%s)", addr, err)
+		}
+		if !p.Cluster {
+			return nil, fmt.Errorf("server %s is not in cluster mode", addr)
+		}
+
+		var nd = &node{addr: addr}
+		for i := 0; i < len(p.Sizes) && i < ctrl.TotalSlotNum; i++ {
+			nd.sizes[i] = p.Sizes[i]
+		}
+		for _, o := range p.Owners {
+			if o.State == ctrl.SlotMigrating {
+				return nil, fmt.Errorf("slots %d-%d of %s are migrating",
+					o.Start, o.End, addr)
+			}
+			if o.State != ctrl.SlotOwned {
+				continue
+			}
+			for i := uint32(o.Start); i <= uint32(o.End); i++ {
+				nd.slots = append(nd.slots, uint16(i))
+				nd.load += nd.weight(uint16(i))
+			}
+		}
+		nodes = append(nodes, nd)
+	}
+
+	return nodes, nil
+}
+
+// Load the unfinished plan from the state file, nil if not found.
+func (rb *rebalancer) loadPlan() (*plan, error) {
+	file, err := os.Open(rb.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var pl plan
+	err = json.NewDecoder(file).Decode(&pl)
+	if err != nil {
+		return nil, err
+	}
+	for _, mv := range pl.Moves {
+		if mv.Step < 0 || mv.Step > stepDone {
+			return nil, fmt.Errorf("invalid step %d", mv.Step)
+		}
+	}
+	return &pl, nil
+}
+
+// Save the plan and progress to the state file.
+func (rb *rebalancer) savePlan(pl *plan) error {
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+
+	tmpFile := fmt.Sprintf("%s.tmp", rb.stateFile)
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = json.NewEncoder(file).Encode(pl)
+	if err != nil {
+		return err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, rb.stateFile)
+}
+
+// Run the moves of the plan, at most parallel moves at the same time.
+// A move starts after all earlier moves on the same servers finished.
+func (rb *rebalancer) run(pl *plan, parallel int) error {
+	err := rb.savePlan(pl)
+	if err != nil {
+		return err
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var running = make(map[*move]bool)
+	var done = make(chan *move)
+	var errs = make(chan error, len(pl.Moves))
+	var failed error
+	for {
+		for i, mv := range pl.Moves {
+			if failed != nil || len(running) >= parallel {
+				break
+			}
+			if mv.Step >= stepDone || running[mv] || !rb.canStart(pl, i) {
+				continue
+			}
+
+			running[mv] = true
+			go func(mv *move) {
+				err := rb.doMove(pl, mv)
+				if err != nil {
+					errs <- fmt.Errorf("move %s -> %s slots %s: %s",
+						mv.Src, mv.Dst, ctrl.NewSlotSet(mv.Slots), err)
+				}
+				done <- mv
+			}(mv)
+		}
+
+		if len(running) == 0 {
+			break
+		}
+
+		var mv = <-done
+		delete(running, mv)
+		select {
+		case err = <-errs:
+			log.Println(err)
+			failed = err
+		default:
+		}
+	}
+
+	if failed != nil {
+		return failed
+	}
+	return nil
+}
+
+// Return true if no earlier unfinished move is on the same servers.
+func (rb *rebalancer) canStart(pl *plan, idx int) bool {
+	var mv = pl.Moves[idx]
+	for i := 0; i < idx; i++ {
+		var m = pl.Moves[i]
+		if m.Step >= stepDone {
+			continue
+		}
+		if m.Src == mv.Src || m.Src == mv.Dst ||
+			m.Dst == mv.Src || m.Dst == mv.Dst {
+			return false
+		}
+	}
+	return true
+}
+
+// Run the remaining steps of the move, the progress is saved after each step.
+func (rb *rebalancer) doMove(pl *plan, mv *move) error {
+	var failures int
+	for mv.Step < stepDone {
+		err := rb.doStep(mv)
+		if err != nil {
+			failures++
+			log.Printf("Move %s -> %s step %s failed: %s\n",
+				mv.Src, mv.Dst, stepNames[mv.Step], err)
+			if failures >= maxStepErrors {
+				return err
+			}
+			time.Sleep(checkInterval)
+			continue
+		}
+
+		log.Printf("Move %s -> %s slots %s step %s done\n",
+			mv.Src, mv.Dst, ctrl.NewSlotSet(mv.Slots), stepNames[mv.Step])
+
+		failures = 0
+		rb.mtx.Lock()
+		mv.Step++
+		rb.mtx.Unlock()
+
+		err = rb.savePlan(pl)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Execute one step of the move, the steps in the Migrate command comment.
+func (rb *rebalancer) doStep(mv *move) error {
+	var addr = mv.Dst
+	switch mv.Step {
+	case stepSetMigrating:
+		fallthrough
+	case stepSetMoved:
+		fallthrough
+	case stepDelSlot:
+		addr = mv.Src
+	}
+
+	cli, cc, err := rb.connect(addr)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	switch mv.Step {
+	case stepMigrate:
+		return cc.MigrateSlots(mv.Src, mv.Slots)
+	case stepWaitReady:
+		return rb.waitReady(cc, mv)
+	case stepSetMigrating:
+		err = cc.SetSlot(mv.Slots, ctrl.SlotMigrating, mv.Dst)
+		if err != nil {
+			return err
+		}
+		return rb.waitCopied(cc, mv)
+	case stepStopMigration:
+		// The new owner refuses writes of migrating slots except EcAsk ones,
+		// so the migration stops before requests are routed to it. The old
+		// owner redirects all requests of the copied slots by EcAsk now.
+		return cc.MigrateSlots("", nil)
+	case stepSetOwned:
+		err = cc.SetSlot(mv.Slots, ctrl.SlotOwned, "")
+		if err == nil {
+			// Wait for proxies and clients to switch to the new owner
+			time.Sleep(rb.wait)
+		}
+		return err
+	case stepSetMoved:
+		err = cc.SetSlot(mv.Slots, ctrl.SlotMoved, mv.Dst)
+		if err == nil {
+			time.Sleep(rb.wait)
+		}
+		return err
+	case stepDelSlot:
+		return cc.DelSlots(mv.Slots, true)
+	}
+
+	return nil
+}
+
+// Wait until the old owner marks all slots of the move copied to the new
+// owner. It redirects all requests of the slots by EcAsk since then, so no
+// more changes are migrated.
+func (rb *rebalancer) waitCopied(cc *table.CtrlContext, mv *move) error {
+	var slots = ctrl.NewSlotSet(mv.Slots)
+	for {
+		p, err := cc.ClusterSlots()
+		if err != nil {
+			return err
+		}
+
+		var copied int
+		for _, o := range p.Owners {
+			if o.State != ctrl.SlotMigrating || !o.Copied || o.Addr != mv.Dst {
+				continue
+			}
+			for i := uint32(o.Start); i <= uint32(o.End); i++ {
+				if slots.Has(uint16(i)) {
+					copied++
+				}
+			}
+		}
+		if copied == slots.Len() {
+			return nil
+		}
+
+		time.Sleep(checkInterval)
+	}
+}
+
+// Wait until the migration on the new owner is ready.
+// The migration is started again if it stopped. Old data of the slots on the
+// new owner is deleted first, e.g. after a failed migration.
+func (rb *rebalancer) waitReady(cc *table.CtrlContext, mv *move) error {
+	for {
+		st, err := cc.GetSlaveStatus(true, mv.Slots[0].Start)
+		if err != nil {
+			return err
+		}
+
+		switch st.Status {
+		case ctrl.SlaveReady:
+			return nil
+		case ctrl.NotSlave:
+			log.Printf("Migration of slots %s on %s stopped, start again\n",
+				ctrl.NewSlotSet(mv.Slots), mv.Dst)
+			err = cc.MigrateSlots(mv.Src, mv.Slots)
+			if err != nil {
+				return err
+			}
+		case ctrl.SlaveNeedClear:
+			log.Printf("Delete old data of slots %s on %s\n",
+				ctrl.NewSlotSet(mv.Slots), mv.Dst)
+			err = cc.DelSlots(mv.Slots, false)
+			if err != nil {
+				return err
+			}
+			err = cc.MigrateSlots(mv.Src, mv.Slots)
+			if err != nil {
+				return err
+			}
+		}
+
+		time.Sleep(checkInterval)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"github.com/stevejiang/gotable/util"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	address   = flag.String("l", "0.0.0.0:6690", "Sentinel listen address ip:port")
+	servers   = flag.String("s", "", "GoTable servers ip:port list (master and slaves), separated by comma")
+	sentinels = flag.String("S", "", "Other sentinels ip:port list, separated by comma")
+	quorum    = flag.Int("quorum", 1, "Number of sentinels to agree that master is down")
+	downAfter = flag.Int("down", 5000, "Milliseconds without reply before a server is down")
+	adminPwd  = flag.String("pwd", "", "Admin password of GoTable servers")
+	useTLS    = flag.Bool("tls", false, "Connect to GoTable servers with TLS")
+	caFile    = flag.String("ca", "", "CA file to verify server certificates (TLS)")
+	certFile  = flag.String("cert", "", "Client certificate file (TLS)")
+	keyFile   = flag.String("key", "", "Client key file (TLS)")
+)
+
+func main() {
+	log.SetFlags(log.Flags() | log.Lshortfile)
+	flag.Parse()
+
+	var as = splitAddrs(*servers)
+	if len(as) == 0 {
+		log.Println("No GoTable server to monitor!")
+		flag.Usage()
+		return
+	}
+
+	var tlsConf *tls.Config
+	if *useTLS {
+		var err error
+		tlsConf, err = util.NewTLSConfig(*certFile, *keyFile, *caFile)
+		if err != nil {
+			log.Fatalln("Load TLS config failed:", err)
+		}
+	}
+
+	var s = newSentinel(*address, as, splitAddrs(*sentinels), *quorum,
+		time.Duration(*downAfter)*time.Millisecond, *adminPwd, tlsConf)
+
+	link, err := net.Listen("tcp", *address)
+	if err != nil {
+		log.Fatalln("Listen failed:", err)
+	}
+
+	log.Printf("GoTable sentinel started on %s, monitor %v, quorum %d\n",
+		*address, as, *quorum)
+
+	go s.goMonitor()
+
+	for {
+		if c, err := link.Accept(); err == nil {
+			go s.goServe(c)
+		}
+	}
+}
+
+func splitAddrs(s string) []string {
+	var as []string
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if len(a) > 0 {
+			as = append(as, a)
+		}
+	}
+	return as
+}
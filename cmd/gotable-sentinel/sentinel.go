@@ -0,0 +1,427 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/util"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	checkInterval = time.Second
+	checkTimeout  = time.Second
+)
+
+// A GoTable server or another sentinel
+type node struct {
+	addr    string
+	tlsConf *tls.Config // nil if not connecting with TLS
+	conn    net.Conn
+	cli     *table.Client
+
+	// Status of GoTable server, protected by sentinel mtx
+	lastOk  time.Time // Last time the server replied
+	isSlave bool
+	lastSeq uint64 // Last binlog seq synced from master
+}
+
+type sentinel struct {
+	id        string // Sentinel address
+	quorum    int
+	downAfter time.Duration
+	adminPwd  string
+	nodes     []*node // GoTable servers
+	peers     []*node // Other sentinels
+
+	mtx        sync.Mutex // protects following
+	master     string     // Current master address
+	epoch      uint64     // Failover epoch of current master
+	voteEpoch  uint64     // Last voted epoch
+	voteLeader string     // Leader voted in voteEpoch
+}
+
+// Sentinels connect to GoTable servers with TLS if tlsConf is not nil.
+func newSentinel(id string, servers, peers []string, quorum int,
+	downAfter time.Duration, adminPwd string, tlsConf *tls.Config) *sentinel {
+	var s = new(sentinel)
+	s.id = id
+	s.quorum = quorum
+	s.downAfter = downAfter
+	s.adminPwd = adminPwd
+
+	var now = time.Now()
+	for _, addr := range servers {
+		s.nodes = append(s.nodes, &node{addr: addr, tlsConf: tlsConf, lastOk: now})
+	}
+	for _, addr := range peers {
+		s.peers = append(s.peers, &node{addr: addr})
+	}
+
+	return s
+}
+
+// Get a Context on the connection, and set the timeout of the next calls.
+func (nd *node) context(pwd string) (*table.Context, error) {
+	if nd.cli == nil {
+		c, err := util.DialTimeout("tcp", nd.addr, checkTimeout, nd.tlsConf)
+		if err != nil {
+			return nil, err
+		}
+
+		nd.conn = c
+		nd.cli = table.NewClient(c)
+		nd.conn.SetDeadline(time.Now().Add(checkTimeout))
+
+		if len(pwd) > 0 {
+			err = nd.cli.NewContext(proto.AdminDbId).Auth(pwd)
+			if err != nil {
+				nd.close()
+				return nil, err
+			}
+		}
+	}
+
+	nd.conn.SetDeadline(time.Now().Add(checkTimeout))
+	return nd.cli.NewContext(proto.AdminDbId), nil
+}
+
+// Clear the timeout after calls finished.
+func (nd *node) done(err error) {
+	if err != nil {
+		nd.close()
+	} else if nd.conn != nil {
+		nd.conn.SetDeadline(time.Time{})
+	}
+}
+
+func (nd *node) close() {
+	if nd.cli != nil {
+		nd.cli.Close()
+		nd.cli = nil
+		nd.conn = nil
+	}
+}
+
+func (s *sentinel) goMonitor() {
+	for {
+		s.checkServers()
+		s.checkPeers()
+		s.checkMaster()
+
+		time.Sleep(checkInterval)
+	}
+}
+
+func (s *sentinel) checkServers() {
+	var wg sync.WaitGroup
+	for _, nd := range s.nodes {
+		wg.Add(1)
+		go func(nd *node) {
+			defer wg.Done()
+			s.checkServer(nd)
+		}(nd)
+	}
+	wg.Wait()
+}
+
+func (s *sentinel) checkServer(nd *node) {
+	ctx, err := nd.context(s.adminPwd)
+	if err == nil {
+		err = ctx.Ping()
+	}
+	var st *ctrl.PkgSlaveStatus
+	if err == nil {
+		st, err = (*table.CtrlContext)(ctx).GetSlaveStatus(false, 0)
+	}
+	nd.done(err)
+
+	if err != nil {
+		return
+	}
+
+	s.mtx.Lock()
+	nd.lastOk = time.Now()
+	nd.isSlave = (st.Status != ctrl.NotSlave)
+	nd.lastSeq = st.LastSeq
+	s.mtx.Unlock()
+}
+
+// Follow the master with larger epoch known by other sentinels.
+func (s *sentinel) checkPeers() {
+	for _, pr := range s.peers {
+		ctx, err := pr.context("")
+		var p *ctrl.PkgGetMaster
+		if err == nil {
+			p, err = (*table.CtrlContext)(ctx).GetMaster()
+		}
+		pr.done(err)
+		if err != nil {
+			continue
+		}
+
+		s.mtx.Lock()
+		if len(p.MasterAddr) > 0 && p.Epoch > s.epoch {
+			log.Printf("Switch master from %s to %s (epoch %d) by sentinel %s\n",
+				s.master, p.MasterAddr, p.Epoch, pr.addr)
+			s.master = p.MasterAddr
+			s.epoch = p.Epoch
+		}
+		s.mtx.Unlock()
+	}
+}
+
+func (s *sentinel) getNode(addr string) *node {
+	for _, nd := range s.nodes {
+		if nd.addr == addr {
+			return nd
+		}
+	}
+	return nil
+}
+
+// Should be protected by mtx
+func (s *sentinel) isMasterDown() bool {
+	var nd = s.getNode(s.master)
+	return nd == nil || time.Since(nd.lastOk) > s.downAfter
+}
+
+func (s *sentinel) checkMaster() {
+	s.mtx.Lock()
+	if len(s.master) == 0 {
+		// The first running server which is not a slave
+		for _, nd := range s.nodes {
+			if time.Since(nd.lastOk) < checkInterval*2 && !nd.isSlave {
+				s.master = nd.addr
+				log.Printf("Found master %s\n", s.master)
+				break
+			}
+		}
+		s.mtx.Unlock()
+		return
+	}
+
+	var down = s.isMasterDown()
+	s.mtx.Unlock()
+
+	if down {
+		s.tryFailover()
+	} else {
+		s.repointOldMasters()
+	}
+}
+
+// Ask other sentinels to agree the master is down (quorum),
+// and to vote this sentinel as the failover leader (majority).
+func (s *sentinel) tryFailover() {
+	s.mtx.Lock()
+	var master = s.master
+	var epoch = s.epoch + 1
+	if epoch <= s.voteEpoch {
+		epoch = s.voteEpoch + 1
+	}
+	s.voteEpoch = epoch
+	s.voteLeader = s.id
+	s.mtx.Unlock()
+
+	var downNum, voteNum = 1, 1
+	for _, pr := range s.peers {
+		ctx, err := pr.context("")
+		var p *ctrl.PkgVoteDown
+		if err == nil {
+			p, err = (*table.CtrlContext)(ctx).VoteDown(master, s.id, epoch)
+		}
+		pr.done(err)
+		if err != nil {
+			continue
+		}
+
+		if p.Down {
+			downNum++
+		}
+		if p.Leader == s.id {
+			voteNum++
+		}
+	}
+
+	if downNum < s.quorum {
+		log.Printf("Master %s is down, agreed by %d sentinels, quorum %d\n",
+			master, downNum, s.quorum)
+		return
+	}
+
+	var majority = (len(s.peers)+1)/2 + 1
+	if voteNum < majority || voteNum < s.quorum {
+		log.Printf("Master %s is down, but only %d votes for epoch %d\n",
+			master, voteNum, epoch)
+		return
+	}
+
+	s.failover(master, epoch)
+}
+
+// Promote the most up-to-date slave, and repoint the other slaves.
+func (s *sentinel) failover(oldMaster string, epoch uint64) {
+	var best *node
+	s.mtx.Lock()
+	for _, nd := range s.nodes {
+		if nd.addr == oldMaster || !nd.isSlave ||
+			time.Since(nd.lastOk) > checkInterval*2 {
+			continue
+		}
+		if best == nil || nd.lastSeq > best.lastSeq {
+			best = nd
+		}
+	}
+	s.mtx.Unlock()
+
+	if best == nil {
+		log.Printf("No slave available to replace master %s\n", oldMaster)
+		return
+	}
+
+	log.Printf("Failover epoch %d: promote %s (lastSeq %d) to replace master %s\n",
+		epoch, best.addr, best.lastSeq, oldMaster)
+
+	ctx, err := best.context(s.adminPwd)
+	if err == nil {
+		err = (*table.CtrlContext)(ctx).SlaveOf("")
+	}
+	best.done(err)
+	if err != nil {
+		log.Printf("Promote %s failed: %s\n", best.addr, err)
+		return
+	}
+
+	s.mtx.Lock()
+	s.master = best.addr
+	s.epoch = epoch
+	s.mtx.Unlock()
+
+	for _, nd := range s.nodes {
+		if nd != best && nd.addr != oldMaster {
+			s.slaveOf(nd, best.addr)
+		}
+	}
+}
+
+// Old master comes back after failover, turn it into a slave.
+func (s *sentinel) repointOldMasters() {
+	s.mtx.Lock()
+	var master = s.master
+	var nds []*node
+	if s.epoch > 0 {
+		for _, nd := range s.nodes {
+			if nd.addr != master && !nd.isSlave &&
+				time.Since(nd.lastOk) < checkInterval*2 {
+				nds = append(nds, nd)
+			}
+		}
+	}
+	s.mtx.Unlock()
+
+	for _, nd := range nds {
+		s.slaveOf(nd, master)
+	}
+}
+
+func (s *sentinel) slaveOf(nd *node, master string) {
+	ctx, err := nd.context(s.adminPwd)
+	if err == nil {
+		err = (*table.CtrlContext)(ctx).SlaveOf(master)
+	}
+	nd.done(err)
+	if err != nil {
+		log.Printf("Set %s as slave of %s failed: %s\n", nd.addr, master, err)
+	} else {
+		log.Printf("Set %s as slave of %s\n", nd.addr, master)
+	}
+
+	s.mtx.Lock()
+	nd.isSlave = (err == nil)
+	s.mtx.Unlock()
+}
+
+func (s *sentinel) getMaster(p *ctrl.PkgGetMaster) {
+	s.mtx.Lock()
+	p.MasterAddr = s.master
+	p.Epoch = s.epoch
+	s.mtx.Unlock()
+}
+
+func (s *sentinel) voteDown(p *ctrl.PkgVoteDown) {
+	s.mtx.Lock()
+	p.Down = (p.MasterAddr == s.master && s.isMasterDown())
+	if p.Down && p.Epoch > s.voteEpoch {
+		s.voteEpoch = p.Epoch
+		s.voteLeader = p.Candidate
+	}
+	if p.Epoch == s.voteEpoch {
+		p.Leader = s.voteLeader
+	}
+	s.mtx.Unlock()
+}
+
+func (s *sentinel) goServe(c net.Conn) {
+	defer c.Close()
+
+	var r = bufio.NewReader(c)
+	var headBuf = make([]byte, proto.HeadSize)
+	var head proto.PkgHead
+	for {
+		pkg, err := proto.ReadPkg(r, headBuf, &head, nil)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				log.Printf("ReadPkg failed: %s, close client!\n", err)
+			}
+			return
+		}
+
+		switch head.Cmd {
+		case proto.CmdGetMaster:
+			var p ctrl.PkgGetMaster
+			s.getMaster(&p)
+			pkg, err = ctrl.Encode(head.Cmd, head.DbId, head.Seq, &p)
+		case proto.CmdVoteDown:
+			var p ctrl.PkgVoteDown
+			err = ctrl.Decode(pkg, nil, &p)
+			if err == nil {
+				s.voteDown(&p)
+				pkg, err = ctrl.Encode(head.Cmd, head.DbId, head.Seq, &p)
+			}
+		default:
+			log.Printf("Invalid cmd 0x%X\n", head.Cmd)
+			return
+		}
+
+		if err != nil {
+			log.Printf("Handle cmd 0x%X failed: %s\n", head.Cmd, err)
+			return
+		}
+
+		_, err = c.Write(pkg)
+		if err != nil {
+			return
+		}
+	}
+}
@@ -46,6 +46,8 @@ func main() {
 
 	if len(conf.Profile.Host) > 0 {
 		log.Printf("Start profile on http://%s/debug/pprof\n", conf.Profile.Host)
+		log.Printf("Start metrics on http://%s/metrics\n", conf.Profile.Host)
+		http.HandleFunc("/metrics", srv.ServeMetrics)
 		go func() {
 			http.ListenAndServe(conf.Profile.Host, nil)
 		}()
@@ -53,7 +55,16 @@ func main() {
 
 	go func() {
 		var c = make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGUSR1, syscall.SIGINT)
+		signal.Notify(c, syscall.SIGHUP)
+		for s := range c {
+			log.Println("Get signal:", s)
+			srv.Reload(configFile)
+		}
+	}()
+
+	go func() {
+		var c = make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGUSR1, syscall.SIGINT, syscall.SIGTERM)
 
 		var s = <-c
 		log.Println("Get signal:", s)
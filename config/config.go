@@ -15,6 +15,7 @@
 package config
 
 import (
+	"fmt"
 	"github.com/BurntSushi/toml"
 	"log"
 )
@@ -22,7 +23,10 @@ import (
 type Config struct {
 	Db      database `toml:"database"`
 	Bin     binlog   `toml:"binlog"`
+	Repl    repl     `toml:"replication"`
+	Slow    slowlog  `toml:"slowlog"`
 	Auth    auth
+	TLS     tlsConf `toml:"tls"`
 	Profile profile
 }
 
@@ -34,6 +38,9 @@ type database struct {
 	WriteBufSize int   `toml:"write_buffer_size"`
 	CacheSize    int64 `toml:"cache_size"`
 	Compression  string
+	RateLimit    int64 `toml:"rate_limit"`
+	MaxClients   int   `toml:"max_clients"`
+	IdleTimeout  int   `toml:"idle_timeout"`
 }
 
 type binlog struct {
@@ -41,10 +48,37 @@ type binlog struct {
 	KeepNum int `toml:"keep_num"`
 }
 
+type repl struct {
+	MaxMBps int64 `toml:"max_mb_per_sec"`
+	MaxKeys int64 `toml:"max_keys_per_sec"`
+}
+
+type slowlog struct {
+	SlowerThan int64 `toml:"slower_than"` // Microseconds, 0 means disabled
+	MaxLen     int   `toml:"max_len"`
+	File       string
+}
+
 type auth struct {
-	AdminPwd string `toml:"admin_password"`
+	AdminPwd  string `toml:"admin_password"`
+	PlainAuth bool   `toml:"plain_auth"` // Accept plain text passwords (deprecated)
 }
 
+type tlsConf struct {
+	CertFile    string `toml:"cert_file"` // TLS is enabled on the listener if set
+	KeyFile     string `toml:"key_file"`
+	CaFile      string `toml:"ca_file"`
+	ClientCert  string `toml:"client_cert"` // none, verify or require
+	Replication bool   // Connect to master with TLS
+}
+
+// Client certificate modes of tls.client_cert
+const (
+	ClientCertNone    = "none"
+	ClientCertVerify  = "verify"
+	ClientCertRequire = "require"
+)
+
 type profile struct {
 	Memory string
 	Host   string
@@ -53,6 +87,9 @@ type profile struct {
 func Load(fileName string) (*Config, error) {
 	var conf Config
 	var err error
+	// Old clients and slaves only have plain text auth, so it's accepted
+	// unless disabled explicitly.
+	conf.Auth.PlainAuth = true
 	if len(fileName) == 0 {
 		log.Println("Use default configuration")
 		_, err = toml.Decode(defaultConfig, &conf)
@@ -64,9 +101,57 @@ func Load(fileName string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
 	return &conf, nil
 }
 
+// Validate checks whether the config values are valid.
+func (conf *Config) Validate() error {
+	var values = []struct {
+		name  string
+		value int64
+	}{
+		{"database.max_cpu_num", int64(conf.Db.MaxCpuNum)},
+		{"database.write_buffer_size", int64(conf.Db.WriteBufSize)},
+		{"database.cache_size", conf.Db.CacheSize},
+		{"database.rate_limit", conf.Db.RateLimit},
+		{"database.max_clients", int64(conf.Db.MaxClients)},
+		{"database.idle_timeout", int64(conf.Db.IdleTimeout)},
+		{"binlog.memory_size", int64(conf.Bin.MemSize)},
+		{"binlog.keep_num", int64(conf.Bin.KeepNum)},
+		{"replication.max_mb_per_sec", conf.Repl.MaxMBps},
+		{"replication.max_keys_per_sec", conf.Repl.MaxKeys},
+		{"slowlog.slower_than", conf.Slow.SlowerThan},
+		{"slowlog.max_len", int64(conf.Slow.MaxLen)},
+	}
+	for _, v := range values {
+		if v.value < 0 {
+			return fmt.Errorf("invalid %s %d", v.name, v.value)
+		}
+	}
+
+	if (conf.TLS.CertFile == "") != (conf.TLS.KeyFile == "") {
+		return fmt.Errorf("tls.cert_file and tls.key_file should be set together")
+	}
+	switch conf.TLS.ClientCert {
+	case "":
+		fallthrough
+	case ClientCertNone:
+	case ClientCertVerify:
+		fallthrough
+	case ClientCertRequire:
+		if conf.TLS.CaFile == "" {
+			return fmt.Errorf("tls.client_cert %s needs tls.ca_file",
+				conf.TLS.ClientCert)
+		}
+	default:
+		return fmt.Errorf("invalid tls.client_cert %s", conf.TLS.ClientCert)
+	}
+	return nil
+}
+
 var defaultConfig = `
 [database]
 network = "tcp"
@@ -80,4 +165,8 @@ compression = "snappy"
 memory_size = 8
 keep_num = 128
 
+[slowlog]
+slower_than = 10000
+max_len = 128
+
 `
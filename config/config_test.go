@@ -0,0 +1,60 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func loadConfig(t *testing.T, content string) *Config {
+	f, err := ioutil.TempFile("", "gotable-conf")
+	if err != nil {
+		t.Fatalf("TempFile failed: %s", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(content)
+	f.Close()
+	if err != nil {
+		t.Fatalf("WriteString failed: %s", err)
+	}
+
+	conf, err := Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+	return conf
+}
+
+func TestLoadPlainAuth(t *testing.T) {
+	conf, err := Load("")
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+	if !conf.Auth.PlainAuth {
+		t.Fatalf("plain_auth should be enabled by default")
+	}
+
+	conf = loadConfig(t, "[auth]\nadmin_password = \"abc\"\n")
+	if !conf.Auth.PlainAuth || conf.Auth.AdminPwd != "abc" {
+		t.Fatalf("plain_auth should be enabled if not set: %+v", conf.Auth)
+	}
+
+	conf = loadConfig(t, "[auth]\nplain_auth = false\n")
+	if conf.Auth.PlainAuth {
+		t.Fatalf("plain_auth should be disabled")
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,24 +30,50 @@ const (
 )
 
 type MasterInfo struct {
-	MasterAddr string // Master address ip:host
-	SlaveAddr  string // This server address ip:host
-	Migration  bool   // true: Migration; false: Normal master/slave
-	SlotId     uint16 // Only meaningful for migration
-	Status     int    // Status of Slave/Migration
+	MasterAddr string           // Master address ip:host
+	SlaveAddr  string           // This server address ip:host
+	Migration  bool             // true: Migration; false: Normal master/slave
+	Slots      []ctrl.SlotRange // Only meaningful for migration
+	Status     int              // Status of Slave/Migration
+	Filter     ctrl.SyncFilter  // Only meaningful for normal slave
+	Delay      int              // Delayed apply seconds, only for normal slave
 }
 
 type MasterEncoding struct {
 	HasMaster bool // true: Has master; false: No master/No migration
 	MasterInfo
 	LastTime time.Time // Last change time
+
+	// The single migration slot saved by old versions, converted to Slots
+	SlotId *uint16 `json:",omitempty"`
+}
+
+// Progress of the sync from master, not saved
+type SyncProgress struct {
+	StartTime   time.Time // Full sync start time
+	SyncSlot    uint16    // Migration: the slot under full sync
+	CopiedRows  int64     // Rows copied in full sync
+	CopiedBytes int64     // Bytes copied in full sync
+	TotalBytes  int64     // Estimated bytes of full sync, 0 means unknown
+	MasterSeq   uint64    // Last binlog seq of master
+	AppliedSeq  uint64    // Last binlog seq of master applied
+}
+
+// Master status checked by every request, see GetMasterSlot
+type masterSlot struct {
+	hasMaster bool
+	migration bool
+	slots     *ctrl.SlotSet
 }
 
 type MasterConfig struct {
-	dir string
+	dir  string
+	slot atomic.Value // *masterSlot, replaced whenever m changes
 
-	mtx sync.RWMutex // protects following
-	m   MasterEncoding
+	mtx   sync.RWMutex // protects following
+	m     MasterEncoding
+	slots *ctrl.SlotSet // Parsed m.Slots
+	prog  SyncProgress
 }
 
 func NewMasterConfig(dir string) *MasterConfig {
@@ -58,6 +85,7 @@ func NewMasterConfig(dir string) *MasterConfig {
 
 	mc := new(MasterConfig)
 	mc.dir = dir
+	mc.slot.Store(new(masterSlot))
 
 	err = mc.load(fmt.Sprintf("%s/%s", mc.dir, masterConfigFile))
 	if err != nil {
@@ -111,6 +139,13 @@ func (mc *MasterConfig) load(confFile string) error {
 	}
 	file.Close()
 
+	if m.SlotId != nil {
+		if len(m.Slots) == 0 {
+			m.Slots = []ctrl.SlotRange{{Start: *m.SlotId, End: *m.SlotId}}
+		}
+		m.SlotId = nil
+	}
+
 	// Migration shouldn't be interrupted.
 	// If server restarted, remove migration config.
 	// Administrator should send migrate command again.
@@ -121,11 +156,26 @@ func (mc *MasterConfig) load(confFile string) error {
 
 	mc.mtx.Lock()
 	mc.m = m
+	mc.slots = ctrl.NewSlotSet(m.Slots)
+	mc.updateSlot()
 	mc.mtx.Unlock()
 
 	return nil
 }
 
+// Push the change of master status to the readers of GetMasterSlot.
+// Called with mtx locked.
+func (mc *MasterConfig) updateSlot() {
+	var ms = &masterSlot{hasMaster: mc.m.HasMaster}
+	if ms.hasMaster {
+		ms.migration = mc.m.Migration
+		if ms.migration {
+			ms.slots = mc.slots
+		}
+	}
+	mc.slot.Store(ms)
+}
+
 func (mc *MasterConfig) save(m *MasterEncoding) error {
 	confFile := fmt.Sprintf("%s/%s", mc.dir, masterConfigFile)
 	tmpFile := fmt.Sprintf("%s.tmp", confFile)
@@ -149,13 +199,16 @@ func (mc *MasterConfig) save(m *MasterEncoding) error {
 
 	mc.mtx.Lock()
 	mc.m = *m
+	mc.slots = ctrl.NewSlotSet(m.Slots)
+	mc.updateSlot()
 	os.Rename(tmpFile, confFile)
 	mc.mtx.Unlock()
 
 	return nil
 }
 
-func (mc *MasterConfig) SetMaster(masterAddr, slaveAddr string) error {
+func (mc *MasterConfig) SetMaster(masterAddr, slaveAddr string,
+	filter *ctrl.SyncFilter, delay int) error {
 	mc.mtx.RLock()
 	var m = mc.m
 	mc.mtx.RUnlock()
@@ -170,8 +223,13 @@ func (mc *MasterConfig) SetMaster(masterAddr, slaveAddr string) error {
 		m.MasterAddr = masterAddr
 		m.SlaveAddr = slaveAddr
 		m.Migration = false
-		m.SlotId = ctrl.TotalSlotNum // Exceed
+		m.Slots = nil
 		m.Status = ctrl.SlaveInit
+		m.Filter = ctrl.SyncFilter{}
+		if filter != nil {
+			m.Filter = *filter
+		}
+		m.Delay = delay
 	} else {
 		m.HasMaster = false
 		m.LastTime = time.Now()
@@ -181,7 +239,8 @@ func (mc *MasterConfig) SetMaster(masterAddr, slaveAddr string) error {
 	return mc.save(&m)
 }
 
-func (mc *MasterConfig) SetMigration(masterAddr, slaveAddr string, slotId uint16) error {
+func (mc *MasterConfig) SetMigration(masterAddr, slaveAddr string,
+	slots []ctrl.SlotRange) error {
 	mc.mtx.RLock()
 	var m = mc.m
 	mc.mtx.RUnlock()
@@ -195,11 +254,12 @@ func (mc *MasterConfig) SetMigration(masterAddr, slaveAddr string, slotId uint16
 	}
 
 	if len(masterAddr) > 0 {
-		if m.HasMaster && m.Migration && m.SlotId != slotId {
-			return fmt.Errorf("cannot start more than 1 migration")
+		if err := ctrl.CheckSlotRanges(slots); err != nil {
+			return err
 		}
-		if slotId >= ctrl.TotalSlotNum {
-			return fmt.Errorf("migrate slot id out of range")
+		if m.HasMaster && m.Migration &&
+			ctrl.NewSlotSet(m.Slots).String() != ctrl.NewSlotSet(slots).String() {
+			return fmt.Errorf("cannot start more than 1 migration")
 		}
 
 		m.HasMaster = true
@@ -207,8 +267,10 @@ func (mc *MasterConfig) SetMigration(masterAddr, slaveAddr string, slotId uint16
 		m.MasterAddr = masterAddr
 		m.SlaveAddr = slaveAddr
 		m.Migration = true
-		m.SlotId = slotId
+		m.Slots = slots
 		m.Status = ctrl.SlaveInit
+		m.Filter = ctrl.SyncFilter{}
+		m.Delay = 0
 	} else {
 		m.HasMaster = false
 		m.LastTime = time.Now()
@@ -224,12 +286,16 @@ func (mc *MasterConfig) SetStatus(status int) error {
 	if mc.m.HasMaster {
 		if status == ctrl.NotSlave {
 			mc.m.HasMaster = false
+			mc.updateSlot()
 			changed = true
 		}
 		if mc.m.Status != status {
 			mc.m.Status = status
 			changed = true
 		}
+		if status == ctrl.SlaveFullSync {
+			mc.prog = SyncProgress{StartTime: time.Now()}
+		}
 	}
 	var m = mc.m
 	mc.mtx.Unlock()
@@ -267,16 +333,98 @@ func (mc *MasterConfig) GetMaster() MasterInfo {
 	return m
 }
 
-func (mc *MasterConfig) GetMasterSlot() (bool, bool, uint16) {
-	var hasMaster, migration bool
-	var slotId uint16
-	mc.mtx.RLock()
-	if mc.m.HasMaster {
-		hasMaster = true
-		migration = mc.m.Migration
-		slotId = mc.m.SlotId
+// GetMasterSlot returns whether has master, whether is migration,
+// and the slots under migration (nil for normal slave).
+// It takes no lock, as it is called by every request.
+func (mc *MasterConfig) GetMasterSlot() (bool, bool, *ctrl.SlotSet) {
+	var ms = mc.slot.Load().(*masterSlot)
+	return ms.hasMaster, ms.migration, ms.slots
+}
+
+// SetSyncSlot records the slot under full sync of migration.
+// Slots before it have been copied.
+func (mc *MasterConfig) SetSyncSlot(slotId uint16) {
+	mc.mtx.Lock()
+	mc.prog.SyncSlot = slotId
+	mc.mtx.Unlock()
+}
+
+func (mc *MasterConfig) AddCopied(rows, bytes int64) {
+	mc.mtx.Lock()
+	mc.prog.CopiedRows += rows
+	mc.prog.CopiedBytes += bytes
+	mc.mtx.Unlock()
+}
+
+func (mc *MasterConfig) SetTotalBytes(bytes int64) {
+	mc.mtx.Lock()
+	mc.prog.TotalBytes = bytes
+	mc.mtx.Unlock()
+}
+
+// SetSyncSeq records the binlog seq of master, and the last master seq
+// applied. Zero seq is ignored.
+func (mc *MasterConfig) SetSyncSeq(masterSeq, appliedSeq uint64) {
+	mc.mtx.Lock()
+	if masterSeq > 0 {
+		mc.prog.MasterSeq = masterSeq
+	}
+	if appliedSeq > 0 {
+		mc.prog.AppliedSeq = appliedSeq
 	}
+	mc.mtx.Unlock()
+}
+
+func (mc *MasterConfig) GetProgress() SyncProgress {
+	mc.mtx.RLock()
+	var prog = mc.prog
 	mc.mtx.RUnlock()
+	return prog
+}
+
+// GetSlotStatus returns the migration status of each slot range.
+func (mc *MasterConfig) GetSlotStatus() []ctrl.SlotStatus {
+	mc.mtx.RLock()
+	defer mc.mtx.RUnlock()
+
+	if !mc.m.HasMaster || !mc.m.Migration {
+		return nil
+	}
+
+	var res []ctrl.SlotStatus
+	var add = func(start, end uint16, status int) {
+		if start <= end {
+			res = append(res, ctrl.SlotStatus{
+				SlotRange: ctrl.SlotRange{Start: start, End: end}, Status: status})
+		}
+	}
+	for _, r := range mc.slots.Ranges() {
+		switch mc.m.Status {
+		case ctrl.SlaveInit:
+			fallthrough
+		case ctrl.SlaveNeedClear:
+			fallthrough
+		case ctrl.SlaveClear:
+			add(r.Start, r.End, ctrl.SlaveInit)
+		case ctrl.SlaveFullSync:
+			var cur = mc.prog.SyncSlot
+			if cur > r.End {
+				add(r.Start, r.End, ctrl.SlaveIncrSync)
+			} else if cur < r.Start {
+				add(r.Start, r.End, ctrl.SlaveInit)
+			} else {
+				if cur > r.Start {
+					add(r.Start, cur-1, ctrl.SlaveIncrSync)
+				}
+				add(cur, cur, ctrl.SlaveFullSync)
+				if cur < r.End {
+					add(cur+1, r.End, ctrl.SlaveInit)
+				}
+			}
+		default:
+			add(r.Start, r.End, mc.m.Status)
+		}
+	}
 
-	return hasMaster, migration, slotId
+	return res
 }
@@ -0,0 +1,61 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"github.com/stevejiang/gotable/ctrl"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMasterConfigLegacySlotId(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotable-master")
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Migration config saved by old versions
+	var file = filepath.Join(dir, masterConfigFile)
+	ioutil.WriteFile(file, []byte(`{"HasMaster":true,`+
+		`"MasterAddr":"127.0.0.1:6688","SlaveAddr":"127.0.0.1:6689",`+
+		`"Migration":true,"SlotId":5,"Status":3}`), 0600)
+
+	var mc = NewMasterConfig(dir)
+	if mc == nil {
+		t.Fatalf("NewMasterConfig failed")
+	}
+	mc.mtx.RLock()
+	var m = mc.m
+	mc.mtx.RUnlock()
+	if len(m.Slots) != 1 || m.Slots[0] != (ctrl.SlotRange{Start: 5, End: 5}) {
+		t.Fatalf("Legacy SlotId not converted: %v", m.Slots)
+	}
+
+	// Interrupted migration is removed, and saved in the new format
+	if hasMaster, _, _ := mc.GetMasterSlot(); hasMaster {
+		t.Fatalf("Interrupted migration should be removed")
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %s", err)
+	}
+	if strings.Contains(string(data), "SlotId") {
+		t.Fatalf("Legacy SlotId should not be saved: %s", data)
+	}
+}
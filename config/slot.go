@@ -0,0 +1,379 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/util"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	slotConfigFile = "slot.conf"
+)
+
+type SlotEncoding struct {
+	Cluster  bool             // true: Cluster mode, only owned slots are served
+	Owners   []ctrl.SlotOwner // Slot ranges not in the default state
+	LastTime time.Time        // Last change time
+}
+
+// Slot owner map of this server.
+// In normal mode slots are owned by this server unless they are set migrating
+// or moved. In cluster mode slots are not served unless they are set owned.
+type SlotConfig struct {
+	dir string
+
+	mtx       sync.RWMutex // protects following
+	cluster   bool
+	state     [ctrl.TotalSlotNum]uint8
+	addr      [ctrl.TotalSlotNum]string
+	copied    *util.BitMap // Migrating slots already copied to the new owner
+	redirects int          // Number of slots not owned
+}
+
+func NewSlotConfig(dir string) *SlotConfig {
+	err := os.MkdirAll(dir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		log.Printf("Invalid slot config directory (%s): %s\n", dir, err)
+		return nil
+	}
+
+	sc := new(SlotConfig)
+	sc.dir = dir
+	sc.copied = util.NewBitMap(ctrl.TotalSlotNum / 8)
+
+	err = sc.load(fmt.Sprintf("%s/%s", sc.dir, slotConfigFile))
+	if err != nil {
+		log.Printf("Load slot config failed: %s\n", err)
+		return nil
+	}
+
+	return sc
+}
+
+func (sc *SlotConfig) load(confFile string) error {
+	file, err := os.Open(confFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			tmpFile := fmt.Sprintf("%s.tmp", confFile)
+			file, err = os.Open(tmpFile)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+			} else {
+				os.Rename(tmpFile, confFile)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	de := json.NewDecoder(file)
+
+	var se SlotEncoding
+	err = de.Decode(&se)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	sc.reset(se.Cluster)
+	for _, o := range se.Owners {
+		if o.Start > o.End || o.End >= ctrl.TotalSlotNum || o.State > ctrl.SlotNone {
+			return fmt.Errorf("invalid slot owner %v", o)
+		}
+		sc.setOwner(o.SlotRange, o.State, o.Addr)
+	}
+
+	return nil
+}
+
+// Save the slot owners, must be called with write lock.
+func (sc *SlotConfig) save() error {
+	var se = SlotEncoding{sc.cluster, sc.owners(), time.Now()}
+
+	confFile := fmt.Sprintf("%s/%s", sc.dir, slotConfigFile)
+	tmpFile := fmt.Sprintf("%s.tmp", confFile)
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	en := json.NewEncoder(file)
+
+	err = en.Encode(&se)
+	if err != nil {
+		return err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, confFile)
+}
+
+func (sc *SlotConfig) defaultState() uint8 {
+	if sc.cluster {
+		return ctrl.SlotNone
+	}
+	return ctrl.SlotOwned
+}
+
+// Set all slots as the default state.
+func (sc *SlotConfig) reset(cluster bool) {
+	sc.cluster = cluster
+	for i := 0; i < ctrl.TotalSlotNum; i++ {
+		sc.state[i] = sc.defaultState()
+		sc.addr[i] = ""
+		sc.copied.Clear(uint(i))
+	}
+	if cluster {
+		sc.redirects = ctrl.TotalSlotNum
+	} else {
+		sc.redirects = 0
+	}
+}
+
+func (sc *SlotConfig) setOwner(r ctrl.SlotRange, state int, addr string) {
+	for i := uint(r.Start); i <= uint(r.End); i++ {
+		if sc.state[i] != ctrl.SlotOwned {
+			sc.redirects--
+		}
+		if state != ctrl.SlotOwned {
+			sc.redirects++
+		}
+		if state != ctrl.SlotMigrating || sc.addr[i] != addr {
+			sc.copied.Clear(i)
+		}
+		sc.state[i] = uint8(state)
+		sc.addr[i] = addr
+	}
+}
+
+// Slot ranges not in the default state, must be called with lock.
+func (sc *SlotConfig) owners() []ctrl.SlotOwner {
+	var def = sc.defaultState()
+	var os []ctrl.SlotOwner
+	for i := 0; i < ctrl.TotalSlotNum; i++ {
+		if sc.state[i] == def {
+			continue
+		}
+		var n = len(os)
+		if n > 0 && int(os[n-1].End)+1 == i &&
+			os[n-1].State == int(sc.state[i]) && os[n-1].Addr == sc.addr[i] {
+			os[n-1].End = uint16(i)
+			continue
+		}
+		os = append(os, ctrl.SlotOwner{
+			SlotRange: ctrl.SlotRange{Start: uint16(i), End: uint16(i)},
+			State:     int(sc.state[i]),
+			Addr:      sc.addr[i],
+		})
+	}
+	return os
+}
+
+// SetOwner sets the owner state of the slots.
+func (sc *SlotConfig) SetOwner(slots []ctrl.SlotRange, state int,
+	addr string) error {
+	err := ctrl.CheckSlotRanges(slots)
+	if err != nil {
+		return err
+	}
+
+	switch state {
+	case ctrl.SlotOwned:
+		fallthrough
+	case ctrl.SlotNone:
+		addr = ""
+	case ctrl.SlotMigrating:
+		fallthrough
+	case ctrl.SlotMoved:
+		if len(addr) == 0 {
+			return fmt.Errorf("empty new owner address")
+		}
+	default:
+		return fmt.Errorf("invalid slot state %d", state)
+	}
+
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	for _, r := range slots {
+		sc.setOwner(r, state, addr)
+	}
+
+	return sc.save()
+}
+
+// SetCluster switches to cluster mode, and only the slots are owned.
+// Other slots migrating or moved are kept.
+func (sc *SlotConfig) SetCluster(slots []ctrl.SlotRange) error {
+	err := ctrl.CheckSlotRanges(slots)
+	if err != nil {
+		return err
+	}
+
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	if !sc.cluster {
+		var os = sc.owners()
+		var copied = sc.copied // Keep migrating slots copied
+		sc.copied = util.NewBitMap(ctrl.TotalSlotNum / 8)
+		sc.reset(true)
+		for _, o := range os {
+			sc.setOwner(o.SlotRange, o.State, o.Addr)
+		}
+		sc.copied = copied
+	}
+
+	var owned = ctrl.NewSlotSet(slots)
+	for i := 0; i < ctrl.TotalSlotNum; i++ {
+		var r = ctrl.SlotRange{Start: uint16(i), End: uint16(i)}
+		if owned.Has(uint16(i)) {
+			sc.setOwner(r, ctrl.SlotOwned, "")
+		} else if sc.state[i] == ctrl.SlotOwned {
+			sc.setOwner(r, ctrl.SlotNone, "")
+		}
+	}
+
+	return sc.save()
+}
+
+// Reset switches to normal mode, and all slots are owned.
+func (sc *SlotConfig) Reset() error {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	sc.reset(false)
+	return sc.save()
+}
+
+// GetOwners returns whether in cluster mode, and owner state of all slots.
+// Migrating slots already copied to the new owner are marked Copied.
+func (sc *SlotConfig) GetOwners() (bool, []ctrl.SlotOwner) {
+	sc.mtx.RLock()
+	defer sc.mtx.RUnlock()
+
+	var os []ctrl.SlotOwner
+	for i := 0; i < ctrl.TotalSlotNum; i++ {
+		var n = len(os)
+		var copied = sc.state[i] == ctrl.SlotMigrating && sc.copied.Get(uint(i))
+		if n > 0 && os[n-1].State == int(sc.state[i]) &&
+			os[n-1].Addr == sc.addr[i] && os[n-1].Copied == copied {
+			os[n-1].End = uint16(i)
+			continue
+		}
+		os = append(os, ctrl.SlotOwner{
+			SlotRange: ctrl.SlotRange{Start: uint16(i), End: uint16(i)},
+			State:     int(sc.state[i]),
+			Addr:      sc.addr[i],
+			Copied:    copied,
+		})
+	}
+
+	return sc.cluster, os
+}
+
+// SetCopied marks the migrating slots as copied to the new owner,
+// so that requests for them are redirected by EcAsk.
+func (sc *SlotConfig) SetCopied(slots []ctrl.SlotRange) {
+	sc.mtx.Lock()
+	for _, r := range slots {
+		for i := uint(r.Start); i <= uint(r.End) && i < ctrl.TotalSlotNum; i++ {
+			if sc.state[i] == ctrl.SlotMigrating {
+				sc.copied.Set(i)
+			}
+		}
+	}
+	sc.mtx.Unlock()
+}
+
+// IsCopied returns true if the slot is migrating and already copied to the
+// new owner.
+func (sc *SlotConfig) IsCopied(slotId uint16) bool {
+	sc.mtx.RLock()
+	var copied = sc.state[slotId] == ctrl.SlotMigrating &&
+		sc.copied.Get(uint(slotId))
+	sc.mtx.RUnlock()
+	return copied
+}
+
+// Moved switches the slot from SlotMigrating to SlotMoved, as slot data has
+// been deleted from this server. Slots not copied to the new owner are kept.
+func (sc *SlotConfig) Moved(slotId uint16) error {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	if sc.state[slotId] != ctrl.SlotMigrating || !sc.copied.Get(uint(slotId)) {
+		return nil
+	}
+
+	sc.state[slotId] = ctrl.SlotMoved
+	sc.copied.Clear(uint(slotId))
+	return sc.save()
+}
+
+// HasRedirect returns true if any slot is not owned by this server.
+func (sc *SlotConfig) HasRedirect() bool {
+	sc.mtx.RLock()
+	var has = sc.redirects > 0
+	sc.mtx.RUnlock()
+	return has
+}
+
+// GetOwner returns the owner state and new owner address of the slot.
+func (sc *SlotConfig) GetOwner(slotId uint16) (int, string) {
+	sc.mtx.RLock()
+	var state, addr = int(sc.state[slotId]), sc.addr[slotId]
+	sc.mtx.RUnlock()
+	return state, addr
+}
+
+// Redirect returns how the request for the slot should be redirected:
+// SlotMoved/SlotNone for EcMoved, SlotMigrating for EcAsk and SlotOwned for
+// no redirect. Migrating slots not yet copied are still served by this server.
+func (sc *SlotConfig) Redirect(slotId uint16) (int, string) {
+	sc.mtx.RLock()
+	defer sc.mtx.RUnlock()
+
+	switch sc.state[slotId] {
+	case ctrl.SlotMoved:
+		return ctrl.SlotMoved, sc.addr[slotId]
+	case ctrl.SlotNone:
+		return ctrl.SlotNone, ""
+	case ctrl.SlotMigrating:
+		if sc.copied.Get(uint(slotId)) {
+			return ctrl.SlotMigrating, sc.addr[slotId]
+		}
+	}
+	return ctrl.SlotOwned, ""
+}
@@ -0,0 +1,182 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"github.com/stevejiang/gotable/ctrl"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func newTestSlotConfig(t *testing.T) (*SlotConfig, string) {
+	dir, err := ioutil.TempDir("", "gotable-slot")
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	var sc = NewSlotConfig(dir)
+	if sc == nil {
+		os.RemoveAll(dir)
+		t.Fatalf("NewSlotConfig failed")
+	}
+	return sc, dir
+}
+
+func checkRedirects(t *testing.T, sc *SlotConfig) {
+	sc.mtx.RLock()
+	defer sc.mtx.RUnlock()
+
+	var n int
+	for i := 0; i < ctrl.TotalSlotNum; i++ {
+		if sc.state[i] != ctrl.SlotOwned {
+			n++
+		}
+	}
+	if sc.redirects != n {
+		t.Fatalf("redirects %d, but %d slots are not owned", sc.redirects, n)
+	}
+}
+
+func checkSlot(t *testing.T, sc *SlotConfig, slotId uint16, state int,
+	addr string) {
+	s, a := sc.GetOwner(slotId)
+	if s != state || a != addr {
+		t.Fatalf("Slot %d is (%d, %q), not (%d, %q)", slotId, s, a, state, addr)
+	}
+}
+
+func TestSlotConfigSetOwner(t *testing.T) {
+	sc, dir := newTestSlotConfig(t)
+	defer os.RemoveAll(dir)
+
+	if sc.HasRedirect() {
+		t.Fatalf("All slots should be owned in normal mode")
+	}
+
+	var r = []ctrl.SlotRange{{Start: 10, End: 19}}
+	if err := sc.SetOwner(r, ctrl.SlotMigrating, ""); err == nil {
+		t.Fatalf("Migrating without new owner should fail")
+	}
+	if err := sc.SetOwner(r, ctrl.SlotMigrating, "b:1"); err != nil {
+		t.Fatalf("SetOwner failed: %s", err)
+	}
+	checkSlot(t, sc, 10, ctrl.SlotMigrating, "b:1")
+	checkRedirects(t, sc)
+
+	// Not copied yet, still served here
+	if state, _ := sc.Redirect(10); state != ctrl.SlotOwned {
+		t.Fatalf("Migrating slot not copied should not be redirected")
+	}
+	sc.SetCopied(r)
+	if state, addr := sc.Redirect(10); state != ctrl.SlotMigrating || addr != "b:1" {
+		t.Fatalf("Copied slot should be asked: %d, %q", state, addr)
+	}
+
+	// Setting the same owner again keeps the copied state
+	if err := sc.SetOwner(r, ctrl.SlotMigrating, "b:1"); err != nil {
+		t.Fatalf("SetOwner failed: %s", err)
+	}
+	if !sc.IsCopied(15) {
+		t.Fatalf("Copied state should be kept for the same owner")
+	}
+	if err := sc.SetOwner(r, ctrl.SlotMigrating, "c:1"); err != nil {
+		t.Fatalf("SetOwner failed: %s", err)
+	}
+	if sc.IsCopied(15) {
+		t.Fatalf("Copied state should be cleared for a new owner")
+	}
+
+	sc.SetCopied(r)
+	if err := sc.Moved(12); err != nil {
+		t.Fatalf("Moved failed: %s", err)
+	}
+	checkSlot(t, sc, 12, ctrl.SlotMoved, "c:1")
+	checkRedirects(t, sc)
+
+	if err := sc.SetOwner(r, ctrl.SlotOwned, "x:1"); err != nil {
+		t.Fatalf("SetOwner failed: %s", err)
+	}
+	checkSlot(t, sc, 12, ctrl.SlotOwned, "")
+	checkRedirects(t, sc)
+	if sc.HasRedirect() {
+		t.Fatalf("All slots should be owned again")
+	}
+
+	// Saved and loaded
+	if err := sc.SetOwner(r, ctrl.SlotMoved, "b:1"); err != nil {
+		t.Fatalf("SetOwner failed: %s", err)
+	}
+	var lc = NewSlotConfig(dir)
+	if lc == nil {
+		t.Fatalf("NewSlotConfig failed")
+	}
+	checkSlot(t, lc, 19, ctrl.SlotMoved, "b:1")
+	checkSlot(t, lc, 20, ctrl.SlotOwned, "")
+	checkRedirects(t, lc)
+}
+
+func TestSlotConfigSetCluster(t *testing.T) {
+	sc, dir := newTestSlotConfig(t)
+	defer os.RemoveAll(dir)
+
+	var mig = []ctrl.SlotRange{{Start: 100, End: 109}}
+	if err := sc.SetOwner(mig, ctrl.SlotMigrating, "b:1"); err != nil {
+		t.Fatalf("SetOwner failed: %s", err)
+	}
+	sc.SetCopied(mig)
+	if err := sc.SetOwner([]ctrl.SlotRange{{Start: 200, End: 200}},
+		ctrl.SlotMoved, "c:1"); err != nil {
+		t.Fatalf("SetOwner failed: %s", err)
+	}
+
+	if err := sc.SetCluster([]ctrl.SlotRange{{Start: 0, End: 99},
+		{Start: 105, End: 150}}); err != nil {
+		t.Fatalf("SetCluster failed: %s", err)
+	}
+	checkRedirects(t, sc)
+	checkSlot(t, sc, 0, ctrl.SlotOwned, "")
+	checkSlot(t, sc, 104, ctrl.SlotMigrating, "b:1")
+	checkSlot(t, sc, 105, ctrl.SlotOwned, "")
+	checkSlot(t, sc, 151, ctrl.SlotNone, "")
+	checkSlot(t, sc, 200, ctrl.SlotMoved, "c:1")
+	if !sc.IsCopied(104) || sc.IsCopied(105) {
+		t.Fatalf("Copied state of migrating slots should be kept")
+	}
+	if state, _ := sc.Redirect(151); state != ctrl.SlotNone {
+		t.Fatalf("Slot not owned in cluster mode should be redirected")
+	}
+
+	// Slots owned before are not owned unless set again
+	if err := sc.SetCluster([]ctrl.SlotRange{{Start: 0, End: 9}}); err != nil {
+		t.Fatalf("SetCluster failed: %s", err)
+	}
+	checkRedirects(t, sc)
+	checkSlot(t, sc, 10, ctrl.SlotNone, "")
+	checkSlot(t, sc, 104, ctrl.SlotMigrating, "b:1")
+
+	cluster, owners := sc.GetOwners()
+	if !cluster || len(owners) == 0 || owners[0].State != ctrl.SlotOwned ||
+		owners[0].End != 9 {
+		t.Fatalf("Invalid owners %v", owners)
+	}
+
+	if err := sc.Reset(); err != nil {
+		t.Fatalf("Reset failed: %s", err)
+	}
+	checkRedirects(t, sc)
+	if sc.HasRedirect() {
+		t.Fatalf("All slots should be owned after reset")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctrl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Replication filter of a normal slave, empty filter replicates everything.
+// Each item is a DB "dbId" or a table "dbId.tableId".
+type SyncFilter struct {
+	Include []string // Only replicate these DBs/tables if not empty
+	Exclude []string // Never replicate these DBs/tables
+}
+
+func (f *SyncFilter) IsEmpty() bool {
+	return f == nil || (len(f.Include) == 0 && len(f.Exclude) == 0)
+}
+
+// Matcher parses the filter. It returns nil matcher for empty filter.
+func (f *SyncFilter) Matcher() (*SyncMatcher, error) {
+	if f.IsEmpty() {
+		return nil, nil
+	}
+
+	var m = new(SyncMatcher)
+	m.hasInc = len(f.Include) > 0
+	for _, item := range f.Include {
+		err := m.add(item, &m.incDb, &m.incTbl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range f.Exclude {
+		err := m.add(item, &m.excDb, &m.excTbl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+type SyncMatcher struct {
+	hasInc bool
+	incDb  [256]bool
+	excDb  [256]bool
+	incTbl [256]*[256]bool
+	excTbl [256]*[256]bool
+}
+
+func (m *SyncMatcher) add(item string, db *[256]bool,
+	tbl *[256]*[256]bool) error {
+	var ids = strings.Split(strings.TrimSpace(item), ".")
+	if len(ids) > 2 {
+		return fmt.Errorf("invalid filter item %q", item)
+	}
+
+	dbId, err := strconv.ParseUint(ids[0], 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid filter dbId %q", item)
+	}
+	if len(ids) == 1 {
+		db[dbId] = true
+		return nil
+	}
+
+	tableId, err := strconv.ParseUint(ids[1], 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid filter tableId %q", item)
+	}
+	if tbl[dbId] == nil {
+		tbl[dbId] = new([256]bool)
+	}
+	tbl[dbId][tableId] = true
+	return nil
+}
+
+// Match returns true if the table should be replicated.
+func (m *SyncMatcher) Match(dbId, tableId uint8) bool {
+	if m == nil {
+		return true
+	}
+	if m.excDb[dbId] || (m.excTbl[dbId] != nil && m.excTbl[dbId][tableId]) {
+		return false
+	}
+	if !m.hasInc {
+		return true
+	}
+	return m.incDb[dbId] || (m.incTbl[dbId] != nil && m.incTbl[dbId][tableId])
+}
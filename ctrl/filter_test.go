@@ -0,0 +1,83 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctrl
+
+import (
+	"testing"
+)
+
+type filterMatch struct {
+	dbId    uint8
+	tableId uint8
+	match   bool
+}
+
+func TestSyncFilterMatch(t *testing.T) {
+	var tests = []struct {
+		name    string
+		filter  SyncFilter
+		matches []filterMatch
+	}{
+		{"empty", SyncFilter{},
+			[]filterMatch{{0, 0, true}, {255, 255, true}}},
+		{"include db", SyncFilter{Include: []string{"1", " 3 "}},
+			[]filterMatch{{1, 0, true}, {3, 200, true}, {2, 1, false}}},
+		{"exclude db", SyncFilter{Exclude: []string{"2"}},
+			[]filterMatch{{2, 1, false}, {1, 1, true}, {255, 0, true}}},
+		{"include table", SyncFilter{Include: []string{"1.5"}},
+			[]filterMatch{{1, 5, true}, {1, 6, false}, {5, 1, false}}},
+		{"exclude table", SyncFilter{Exclude: []string{"1.5"}},
+			[]filterMatch{{1, 5, false}, {1, 6, true}, {5, 1, true}}},
+		{"exclude db over include table",
+			SyncFilter{Include: []string{"1.5"}, Exclude: []string{"1"}},
+			[]filterMatch{{1, 5, false}, {1, 6, false}}},
+		{"exclude table over include db",
+			SyncFilter{Include: []string{"1"}, Exclude: []string{"1.5"}},
+			[]filterMatch{{1, 5, false}, {1, 6, true}, {2, 5, false}}},
+		{"same item in both",
+			SyncFilter{Include: []string{"1", "2"}, Exclude: []string{"2"}},
+			[]filterMatch{{1, 0, true}, {2, 0, false}}},
+	}
+
+	for _, tt := range tests {
+		m, err := tt.filter.Matcher()
+		if err != nil {
+			t.Fatalf("%s: Matcher failed: %s", tt.name, err)
+		}
+		if tt.filter.IsEmpty() != (m == nil) {
+			t.Fatalf("%s: nil matcher expected only for empty filter", tt.name)
+		}
+		for _, c := range tt.matches {
+			if m.Match(c.dbId, c.tableId) != c.match {
+				t.Fatalf("%s: Match(%d, %d) should be %v",
+					tt.name, c.dbId, c.tableId, c.match)
+			}
+		}
+	}
+}
+
+func TestSyncFilterInvalid(t *testing.T) {
+	var items = []string{"1.2.3", "x", "256", "1.256", "1.x", "", "-1", "1."}
+	for _, item := range items {
+		var f = SyncFilter{Include: []string{item}}
+		if _, err := f.Matcher(); err == nil {
+			t.Fatalf("Include item %q should be invalid", item)
+		}
+		f = SyncFilter{Exclude: []string{"1", item}}
+		if _, err := f.Matcher(); err == nil {
+			t.Fatalf("Exclude item %q should be invalid", item)
+		}
+	}
+}
@@ -14,6 +14,10 @@
 
 package ctrl
 
+import (
+	"fmt"
+)
+
 // Slave/Migration status
 const (
 	NotSlave       = iota // Not a normal slave (also not a migration slave)
@@ -25,13 +29,36 @@ const (
 	SlaveReady            // Slave is up to date with master
 )
 
+// SlaveStatusName returns the name of the slave/migration status.
+func SlaveStatusName(status int) string {
+	switch status {
+	case NotSlave:
+		return "NotSlave"
+	case SlaveInit:
+		return "SlaveInit"
+	case SlaveNeedClear:
+		return "SlaveNeedClear"
+	case SlaveClear:
+		return "SlaveClear"
+	case SlaveFullSync:
+		return "SlaveFullSync"
+	case SlaveIncrSync:
+		return "SlaveIncrSync"
+	case SlaveReady:
+		return "SlaveReady"
+	}
+	return fmt.Sprintf("Unknown(%d)", status)
+}
+
 // SlaveOf command pkg
 type PkgSlaveOf struct {
 	ClientReq  bool   // true: from client api; false: from slave to master
 	MasterAddr string // ip:host, no master if emtpy
 	SlaveAddr  string // ip:host
 	LastSeq    uint64
-	ErrMsg     string // error msg, nil means no error
+	Filter     SyncFilter // Replicate only the selected DBs/tables
+	Delay      int        // Seconds to delay applying changes, 0 means no delay
+	ErrMsg     string     // error msg, nil means no error
 }
 
 // Migrate command pkg.
@@ -44,23 +71,346 @@ type PkgSlaveOf struct {
 // 3. wait for 10 seconds, and close the master/slave connection
 // 4. delete the slot data from old servers
 type PkgMigrate struct {
-	ClientReq  bool   // true: from client api; false: from slave to master
-	MasterAddr string // ip:host, stop migration if empty
-	SlaveAddr  string // ip:host
-	SlotId     uint16 // The slot to be migrated
-	ErrMsg     string // error msg, nil means no error
+	ClientReq  bool        // true: from client api; false: from slave to master
+	MasterAddr string      // ip:host, stop migration if empty
+	SlaveAddr  string      // ip:host
+	SlotId     uint16      // The slot to be migrated (if Slots is empty)
+	Slots      []SlotRange // The slots to be migrated in one scan
+	ErrMsg     string      // error msg, nil means no error
+}
+
+// GetSlots returns the slots to be migrated.
+func (p *PkgMigrate) GetSlots() []SlotRange {
+	if len(p.Slots) > 0 {
+		return p.Slots
+	}
+	return []SlotRange{{p.SlotId, p.SlotId}}
+}
+
+// Migration status of a slot range
+type SlotStatus struct {
+	SlotRange
+	Status int // SlaveInit: waiting; SlaveFullSync: copying; others: copied
 }
 
 // Get migration/slave status
 type PkgSlaveStatus struct {
-	Migration bool   // true: Migration status; false: Normal slave status
-	SlotId    uint16 // The slot under migration
-	Status    int
-	ErrMsg    string // error msg, nil means no error
+	Migration  bool         // true: Migration status; false: Normal slave status
+	SlotId     uint16       // The slot under migration (any slot of the migration)
+	Status     int          // Status of the whole migration/slave
+	LastSeq    uint64       // Last binlog seq synced from master (normal slave)
+	SlotStatus []SlotStatus // Reply: status of each slot range (migration)
+
+	// Reply: sync progress
+	StartTime   int64  // Full sync start time (Unix seconds), 0 means unknown
+	CopiedRows  int64  // Rows copied in full sync
+	CopiedBytes int64  // Bytes copied in full sync
+	TotalBytes  int64  // Estimated bytes of full sync, 0 means unknown
+	SeqLag      uint64 // Master binlog seq minus the last applied seq
+
+	ErrMsg string // error msg, nil means no error
 }
 
 // Delete slot data
 type PkgDelSlot struct {
-	SlotId uint16 // The slot to delete
-	ErrMsg string // error msg, nil means no error
+	SlotId  uint16      // The slot to delete
+	Slots   []SlotRange // The slots to delete instead of SlotId if not empty
+	Compact bool        // Compact the slots after deleting
+	ErrMsg  string      // error msg, nil means no error
+}
+
+// Set slot owner command pkg.
+// Requests for slots not owned are redirected by EcMoved/EcAsk with the Addr.
+// SlotMigrating is set on the old server when the migration is ready, and
+// becomes SlotMoved after the slot data is deleted from the old server.
+type PkgSetSlot struct {
+	Slots  []SlotRange // The slots to set
+	State  int         // SlotOwned/SlotMigrating/SlotMoved/SlotNone
+	Addr   string      // ip:host, the new owner (empty for SlotOwned/SlotNone)
+	ErrMsg string      // error msg, nil means no error
+}
+
+// Cluster command operations
+const (
+	ClusterSlots    = iota // Get slot owners of the server
+	ClusterSetSlots        // Switch to cluster mode, and serve only the Slots
+	ClusterReset           // Switch to normal mode, and serve all slots
+	ClusterSizes           // Get slot owners and data size of every slot
+)
+
+// Cluster command pkg.
+// In cluster mode a server only serves the slots it owns, and requests for
+// other slots are redirected by EcMoved (with empty address if unknown).
+type PkgCluster struct {
+	Op      int         // ClusterSlots/ClusterSetSlots/ClusterReset
+	Slots   []SlotRange // The slots to serve for ClusterSetSlots
+	Cluster bool        // Reply: whether the server is in cluster mode
+	Owners  []SlotOwner // Reply: owner state of all slots
+	Sizes   []uint64    // Reply: approximate data size of every slot (ClusterSizes)
+	ErrMsg  string      // error msg, nil means no error
+}
+
+// Switchover command pkg, sent to the master.
+// Steps of the switchover:
+// 1. master stops write globally
+// 2. master waits until the slave synced the last binlog seq
+// 3. the slave is promoted to be the new master
+// 4. old master becomes slave of the new master from the last binlog seq
+type PkgSwitchover struct {
+	SlaveAddr string // ip:host, the slave to be promoted
+	Timeout   int    // Max seconds to wait for the slave, 0 means default
+	ErrMsg    string // error msg, nil means no error
+}
+
+// Delayed slave control operations
+const (
+	DelayStatus  = iota // Get delayed apply status
+	DelayPause          // Pause applying changes
+	DelayResume         // Resume applying changes with the delay
+	DelayForward        // Apply changes written before Until immediately
+)
+
+// Delayed slave control command pkg
+type PkgDelay struct {
+	Op        int    // DelayStatus/DelayPause/DelayResume/DelayForward
+	Until     int64  // Fast-forward to Unix time (seconds), 0 means now
+	Delay     int    // Reply: seconds to delay applying changes
+	Paused    bool   // Reply: whether applying changes is paused
+	Pending   int    // Reply: number of buffered changes
+	ApplyTime int64  // Reply: binlog write time of the last applied change
+	ErrMsg    string // error msg, nil means no error
+}
+
+// Rate limit command pkg.
+// Full sync and binlog sync data sent to all slaves and migration servers is
+// limited by SyncMBps and SyncKeys, 0 means unlimited.
+type PkgRateLimit struct {
+	Set      bool   // true: set the limits; false: get the limits
+	SyncMBps int64  // Max MB/s of sync data
+	SyncKeys int64  // Max keys/s of sync data
+	DbMBps   int64  // Reply: max MB/s of RocksDB flush and compaction IO
+	ErrMsg   string // error msg, nil means no error
+}
+
+// Stats command operations
+const (
+	StatsSlots  = iota // Get data size and key count of every slot
+	StatsTables        // Get data size and key count of every db/table
+	StatsCount         // Start counting keys in the background
+)
+
+// Data size and key count of a slot
+type SlotStat struct {
+	SlotId uint16
+	Size   uint64 // Approximate data size
+	Keys   uint64 // Key count of the last counting
+}
+
+// Data size and key count of a db/table
+type TableStat struct {
+	DbId    uint8
+	TableId uint8
+	Size    uint64 // Approximate data size
+	Keys    uint64 // Key count of the last counting
+}
+
+// Stats command pkg.
+// Data sizes are approximated by RocksDB, key counts are exact but computed
+// by iterating all keys in the background (StatsCount), so they are only
+// as fresh as CountTime.
+type PkgStats struct {
+	Op         int         // StatsSlots/StatsTables/StatsCount
+	Slots      []SlotRange // The slots to stat, empty means all slots
+	SlotStats  []SlotStat  // Reply: slots with data (StatsSlots)
+	TableStats []TableStat // Reply: tables with data in the slots (StatsTables)
+	Counting   bool        // Reply: whether key counting is running
+	CountTime  int64       // Reply: Unix time the last counting finished, 0: never
+	ErrMsg     string      // error msg, nil means no error
+}
+
+// Verify command operations
+const (
+	VerifyStart    = iota // Master: checksum slots on master and slave
+	VerifySnapshot        // Master to slave in sync stream: snapshot at the Seq
+	VerifyStatus          // Get progress and result of the last verification
+	VerifyRepair          // Master: re-sync mismatching slots to the slave
+)
+
+// Verify command pkg, sent to the master.
+// Steps of the verification:
+// 1. master pauses write shortly to take a snapshot at the last binlog seq
+// 2. master sends VerifySnapshot to the slave in the sync stream after it
+// 3. slave takes the snapshot when it has applied the binlog up to the seq
+// 4. both compute checksums of every slot, skipping tables filtered from slave
+// VerifyStatus on the master compares the checksums with the slave's, and
+// VerifyRepair deletes the mismatching slots on the slave and copies them
+// from a new master snapshot, the same way as migration.
+type PkgVerify struct {
+	Op        int         // VerifyStart/VerifySnapshot/VerifyStatus/VerifyRepair
+	SlaveAddr string      // ip:host, the slave to verify (VerifyStart)
+	Slots     []SlotRange // The slots to verify, empty means all slots
+	Seq       uint64      // Binlog seq of the snapshots
+	Timeout   int         // Max seconds for the slave to take snapshot, 0: default
+	Running   bool        // Reply: whether computing checksums is running
+	Sums      []uint64    // Reply: checksum of every slot, 0 if no data
+	Mismatch  []SlotRange // Reply: mismatching slots (VerifyStatus on master)
+	ErrMsg    string      // error msg, nil means no error
+}
+
+// Info command pkg, the reply is text of "# Section" headers followed by
+// "name:value" lines
+type PkgInfo struct {
+	Section string // Only return this section, empty means all sections
+	Info    string // Reply: info text
+	ErrMsg  string // error msg, nil means no error
+}
+
+// SlowLog operations
+const (
+	SlowLogGet   = iota // Get the latest slow requests
+	SlowLogReset        // Clear the slow requests
+)
+
+// A request slower than the threshold
+type SlowLogEntry struct {
+	Id       uint64 // Unique increasing id
+	Time     int64  // Unix seconds the request is received
+	Duration int64  // Microseconds from receiving the request to replying it
+	Cmd      string
+	DbId     uint8
+	TableId  uint8
+	RowKey   []byte // Truncated rowKey (of the first key)
+	Keys     int    // Number of keys, or max number of records to scan
+	Client   string // Client address ip:port
+}
+
+// SlowLog command pkg
+type PkgSlowLog struct {
+	Op      int            // SlowLogGet/SlowLogReset
+	Num     int            // Max number of entries to get, <= 0 means all
+	Len     int            // Reply: number of entries kept
+	Entries []SlowLogEntry // Reply: the latest entries first
+	ErrMsg  string         // error msg, nil means no error
+}
+
+// Client operations
+const (
+	ClientList = iota // List client connections
+	ClientKill        // Close client connections by address or type
+)
+
+// A client connection
+type ClientInfo struct {
+	Id          uint64
+	Addr        string // Client address ip:port
+	Type        string // normal, slave (syncing from this server) or master
+	AuthDbs     string // Authorized DBs like "0,1(user),255", "all" if auth disabled
+	Age         int64  // Seconds since connected
+	Idle        int64  // Seconds since the last request or response
+	RespPending int    // Responses waiting to be sent
+	BytesIn     uint64
+	BytesOut    uint64
+}
+
+// Client command pkg
+type PkgClient struct {
+	Op      int          // ClientList/ClientKill
+	Addr    string       // Kill the client of address ip:port
+	Type    string       // Kill all other clients of type if Addr is empty
+	Clients []ClientInfo // Reply: clients (ClientList)
+	Killed  int          // Reply: number of clients closed (ClientKill)
+	ErrMsg  string       // error msg, nil means no error
+}
+
+// Config operations
+const (
+	ConfigGet = iota // Get config items matching the name pattern
+	ConfigSet        // Change a config item at runtime
+)
+
+// A config item named as section.key of gotable.conf
+type ConfigItem struct {
+	Name    string
+	Value   string
+	Mutable bool // Whether it can be changed at runtime
+}
+
+// Config command pkg
+type PkgConfig struct {
+	Op     int          // ConfigGet/ConfigSet
+	Name   string       // Item name (ConfigSet) or pattern like "binlog.*"
+	Value  string       // New value (ConfigSet)
+	Items  []ConfigItem // Reply: matching items (ConfigGet)
+	ErrMsg string       // error msg, nil means no error
+}
+
+// Shutdown command pkg
+type PkgShutdown struct {
+	Timeout int    // Max seconds to wait for queued requests, 0 means default
+	ErrMsg  string // error msg, nil means no error
+}
+
+// Password operations
+const (
+	PasswdSet  = iota // Set or change the password of a DB
+	PasswdDel         // Remove the password of a DB
+	PasswdList        // List DBs having passwords
+)
+
+// Password command pkg
+type PkgPasswd struct {
+	Op       int    // PasswdSet/PasswdDel/PasswdList
+	DbId     uint8  // The DB to change (PasswdSet/PasswdDel)
+	Password string // New password (PasswdSet), never replied
+	Dbs      []int  // Reply: DBs having passwords (PasswdList)
+	ErrMsg   string // error msg, nil means no error
+}
+
+// User roles
+const (
+	RoleRead  = "read"  // Read-only access to tables of a DB
+	RoleWrite = "write" // Read-write access to tables of a DB
+	RoleAdmin = "admin" // Full access to all DBs and control commands
+)
+
+// A named user
+type UserInfo struct {
+	Name   string
+	Role   string // RoleRead/RoleWrite/RoleAdmin
+	DbId   uint8  // DB of read/write roles
+	Tables []int  // Tables of read/write roles, empty means all tables
+}
+
+// User operations
+const (
+	UserSet  = iota // Create or change a user
+	UserDel         // Remove a user
+	UserList        // List all users
+)
+
+// User command pkg
+type PkgUser struct {
+	Op       int        // UserSet/UserDel/UserList
+	User     UserInfo   // User to set (UserSet), or only Name (UserDel)
+	Password string     // Password of the user (UserSet), never replied
+	Users    []UserInfo // Reply: all users ordered by name (UserList)
+	ErrMsg   string     // error msg, nil means no error
+}
+
+// Get master address from sentinel
+type PkgGetMaster struct {
+	MasterAddr string // ip:host, empty if master is unknown
+	Epoch      uint64 // Failover epoch of the master
+	ErrMsg     string // error msg, nil means no error
+}
+
+// Sentinel asks another sentinel whether the master is down,
+// and asks for the vote to be failover leader of the epoch.
+type PkgVoteDown struct {
+	MasterAddr string // ip:host, the master to check
+	Epoch      uint64 // Failover epoch
+	Candidate  string // Sentinel asking for the vote
+	Down       bool   // Reply: whether the master is down
+	Leader     string // Reply: the voted leader of the epoch
+	ErrMsg     string // error msg, nil means no error
 }
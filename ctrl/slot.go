@@ -15,14 +15,179 @@
 package ctrl
 
 import (
+	"fmt"
+	"github.com/stevejiang/gotable/util"
 	"hash/crc32"
+	"strconv"
+	"strings"
 )
 
 const (
 	TotalSlotNum = 8192
 )
 
+// Slot owner state
+const (
+	SlotOwned     = iota // Slot is served by this server
+	SlotMigrating        // Slot is migrating, copied keys are asked to the new owner
+	SlotMoved            // Slot is moved to the new owner
+	SlotNone             // Slot is not served by this server (cluster mode)
+)
+
 func GetSlotId(dbId, tableId uint8, rowKey []byte) uint16 {
 	var a = crc32.Update(0, crc32.IEEETable, []byte{dbId, tableId})
 	return uint16(crc32.Update(a, crc32.IEEETable, rowKey) % TotalSlotNum)
 }
+
+// Slot ID range [Start, End]
+type SlotRange struct {
+	Start uint16
+	End   uint16
+}
+
+func CheckSlotRanges(ranges []SlotRange) error {
+	if len(ranges) == 0 {
+		return fmt.Errorf("no slot to migrate")
+	}
+	for _, r := range ranges {
+		if r.Start > r.End || r.End >= TotalSlotNum {
+			return fmt.Errorf("slot range [%d, %d] out of range", r.Start, r.End)
+		}
+	}
+	return nil
+}
+
+// Owner state of a slot range
+type SlotOwner struct {
+	SlotRange
+	State  int    // SlotOwned/SlotMigrating/SlotMoved/SlotNone
+	Addr   string // ip:host, the new owner of SlotMigrating/SlotMoved
+	Copied bool   `json:",omitempty"` // SlotMigrating already copied to Addr
+}
+
+// ParseSlotRanges parses slot ranges like "0-100,200,300-400".
+func ParseSlotRanges(str string) ([]SlotRange, error) {
+	var ranges []SlotRange
+	for _, s := range strings.Split(str, ",") {
+		var r SlotRange
+		var err error
+		var rs = strings.SplitN(strings.TrimSpace(s), "-", 2)
+		if r.Start, err = parseSlotId(rs[0]); err != nil {
+			return nil, err
+		}
+		r.End = r.Start
+		if len(rs) > 1 {
+			if r.End, err = parseSlotId(rs[1]); err != nil {
+				return nil, err
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges, CheckSlotRanges(ranges)
+}
+
+func parseSlotId(s string) (uint16, error) {
+	id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil || id >= TotalSlotNum {
+		return 0, fmt.Errorf("invalid slot id %q", s)
+	}
+	return uint16(id), nil
+}
+
+// Immutable set of slot IDs
+type SlotSet struct {
+	bm   *util.BitMap
+	num  int
+	min  uint16
+	max  uint16
+	rngs []SlotRange // Sorted and merged ranges
+}
+
+// NewSlotSet returns a new SlotSet. Slots out of range are ignored.
+func NewSlotSet(ranges []SlotRange) *SlotSet {
+	var s = new(SlotSet)
+	s.bm = util.NewBitMap(TotalSlotNum / 8)
+	for _, r := range ranges {
+		for i := uint(r.Start); i <= uint(r.End) && i < TotalSlotNum; i++ {
+			if !s.bm.Get(i) {
+				s.bm.Set(i)
+				s.num++
+			}
+		}
+	}
+
+	var inRange bool
+	for i := uint16(0); i < TotalSlotNum; i++ {
+		if s.bm.Get(uint(i)) {
+			if !inRange {
+				s.rngs = append(s.rngs, SlotRange{i, i})
+				inRange = true
+			} else {
+				s.rngs[len(s.rngs)-1].End = i
+			}
+		} else {
+			inRange = false
+		}
+	}
+	if len(s.rngs) > 0 {
+		s.min = s.rngs[0].Start
+		s.max = s.rngs[len(s.rngs)-1].End
+	}
+
+	return s
+}
+
+func (s *SlotSet) Has(slotId uint16) bool {
+	return s != nil && s.bm.Get(uint(slotId))
+}
+
+// Len returns the number of slots in the set.
+func (s *SlotSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return s.num
+}
+
+// Next returns the smallest slot in the set not less than slotId.
+func (s *SlotSet) Next(slotId uint16) (uint16, bool) {
+	if s == nil || s.num == 0 || slotId > s.max {
+		return 0, false
+	}
+	if slotId < s.min {
+		return s.min, true
+	}
+	for _, r := range s.rngs {
+		if slotId <= r.End {
+			if slotId < r.Start {
+				return r.Start, true
+			}
+			return slotId, true
+		}
+	}
+	return 0, false
+}
+
+// Ranges returns the sorted and merged slot ranges.
+func (s *SlotSet) Ranges() []SlotRange {
+	if s == nil {
+		return nil
+	}
+	return s.rngs
+}
+
+func (s *SlotSet) String() string {
+	var str string
+	for i, r := range s.Ranges() {
+		if i > 0 {
+			str += ","
+		}
+		if r.Start == r.End {
+			str += fmt.Sprintf("%d", r.Start)
+		} else {
+			str += fmt.Sprintf("%d-%d", r.Start, r.End)
+		}
+	}
+	return str
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctrl
+
+import (
+	"testing"
+)
+
+func TestParseSlotRanges(t *testing.T) {
+	var tests = []struct {
+		str  string
+		want string // SlotSet string, empty if invalid
+	}{
+		{"0", "0"},
+		{"0-100,200, 300-400", "0-100,200,300-400"},
+		{" 5 - 8 ", "5-8"},
+		{"3-3", "3"},
+		{"1,1,2", "1-2"},
+		{"10-20,15-30,5", "5,10-30"},
+		{"8191", "8191"},
+		{"", ""},
+		{",", ""},
+		{"5-3", ""},
+		{"8192", ""},
+		{"0-8192", ""},
+		{"-1", ""},
+		{"x", ""},
+		{"1-2-3", ""},
+		{"1,", ""},
+	}
+
+	for _, tt := range tests {
+		ranges, err := ParseSlotRanges(tt.str)
+		if tt.want == "" {
+			if err == nil {
+				t.Fatalf("ParseSlotRanges(%q) should fail", tt.str)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ParseSlotRanges(%q) failed: %s", tt.str, err)
+		}
+		if s := NewSlotSet(ranges).String(); s != tt.want {
+			t.Fatalf("ParseSlotRanges(%q) is %q, expect %q", tt.str, s, tt.want)
+		}
+	}
+}
+
+func TestSlotSet(t *testing.T) {
+	var s = NewSlotSet([]SlotRange{{10, 20}, {30, 30}, {15, 25}, {8000, 9000}})
+	if s.String() != "10-25,30,8000-8191" {
+		t.Fatalf("Invalid slot set %q", s.String())
+	}
+	if s.Len() != 16+1+192 {
+		t.Fatalf("Invalid slot number %d", s.Len())
+	}
+	if !s.Has(10) || !s.Has(25) || s.Has(26) || s.Has(9) {
+		t.Fatalf("Invalid Has result")
+	}
+
+	var nexts = []struct {
+		slotId uint16
+		next   uint16
+		ok     bool
+	}{
+		{0, 10, true},
+		{10, 10, true},
+		{18, 18, true},
+		{25, 25, true},
+		{26, 30, true},
+		{31, 8000, true},
+		{8191, 8191, true},
+	}
+	for _, tt := range nexts {
+		next, ok := s.Next(tt.slotId)
+		if next != tt.next || ok != tt.ok {
+			t.Fatalf("Next(%d) is (%d, %v), expect (%d, %v)",
+				tt.slotId, next, ok, tt.next, tt.ok)
+		}
+	}
+
+	s = NewSlotSet([]SlotRange{{1, 2}})
+	if _, ok := s.Next(3); ok {
+		t.Fatalf("Next after the max slot should fail")
+	}
+
+	var empty *SlotSet
+	if _, ok := empty.Next(0); ok || empty.Len() != 0 || empty.String() != "" ||
+		empty.Has(0) {
+		t.Fatalf("Invalid nil slot set")
+	}
+	if s = NewSlotSet(nil); s.String() != "" || s.Len() != 0 {
+		t.Fatalf("Invalid empty slot set")
+	}
+	if _, ok := s.Next(0); ok {
+		t.Fatalf("Next of empty slot set should fail")
+	}
+}
@@ -22,8 +22,11 @@ import (
 	"io"
 	"log"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const (
@@ -36,6 +39,7 @@ type Request struct {
 	Cli *Client
 	Slv *slave
 	store.PkgArgs
+	recvTime time.Time // Time the request is received
 }
 
 type RequestChan struct {
@@ -44,6 +48,18 @@ type RequestChan struct {
 	SyncReqChan  chan *Request
 	DumpReqChan  chan *Request
 	CtrlReqChan  chan *Request
+
+	// atomic
+	stopped uint32
+}
+
+// Stop receiving new requests from clients.
+func (ch *RequestChan) Stop() {
+	atomic.StoreUint32(&ch.stopped, 1)
+}
+
+func (ch *RequestChan) IsStopped() bool {
+	return atomic.LoadUint32(&ch.stopped) > 0
 }
 
 type Client struct {
@@ -51,14 +67,23 @@ type Client struct {
 	r           *bufio.Reader
 	respChan    chan []byte
 	authEnabled bool
+	stats       *serverStats // nil for inner connections
+	list        *clientList  // nil for inner connections
+	id          uint64       // Id in the client list
+	createTime  time.Time
 
 	// atomic
-	closed  uint32
-	cliType uint32
+	closed     uint32
+	cliType    uint32
+	lastActive int64 // Unix nanoseconds of the last request or response
+	bytesIn    uint64
+	bytesOut   uint64
 
 	// protects following
 	mtx      sync.RWMutex
 	authBM   *util.BitMap
+	perms    map[uint8]*store.Perm // Permissions of DBs authorized by users
+	scram    *store.ScramState     // Challenge-response auth in progress
 	shutdown bool
 }
 
@@ -68,7 +93,9 @@ func NewClient(conn net.Conn, authEnabled bool) *Client {
 	c.r = bufio.NewReader(conn)
 	c.respChan = make(chan []byte, 64)
 	c.authEnabled = authEnabled
+	c.createTime = time.Now()
 	atomic.StoreUint32(&c.cliType, ClientTypeNormal)
+	atomic.StoreInt64(&c.lastActive, c.createTime.UnixNano())
 	return c
 }
 
@@ -96,6 +123,12 @@ func (c *Client) Close() {
 
 		c.c.Close()
 		close(c.respChan)
+		if c.stats != nil {
+			atomic.AddInt64(&c.stats.clients, -1)
+		}
+		if c.list != nil {
+			c.list.remove(c)
+		}
 
 		//log.Printf("Close client %p\n", c)
 	}
@@ -113,6 +146,35 @@ func (c *Client) IsClosed() bool {
 	return atomic.LoadUint32(&c.closed) > 0
 }
 
+// Duration since the last request or response.
+func (c *Client) IdleTime() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.lastActive)))
+}
+
+// Authorized DBs like "0,1(user),255", "all" if auth is disabled.
+// DBs authorized by named users are marked with "(user)".
+func (c *Client) AuthDbs() string {
+	if !c.authEnabled {
+		return "all"
+	}
+
+	var ids []string
+	c.mtx.RLock()
+	if c.authBM != nil {
+		for i := 0; i <= proto.AdminDbId; i++ {
+			if c.authBM.Get(uint(i)) {
+				if c.perms[uint8(i)] != nil {
+					ids = append(ids, strconv.Itoa(i)+"(user)")
+				} else {
+					ids = append(ids, strconv.Itoa(i))
+				}
+			}
+		}
+	}
+	c.mtx.RUnlock()
+	return strings.Join(ids, ",")
+}
+
 func (c *Client) SetClientType(cliType uint32) {
 	atomic.StoreUint32(&c.cliType, cliType)
 }
@@ -157,10 +219,76 @@ func (c *Client) SetAuth(dbId uint8) {
 		}
 
 		c.authBM.Set(uint(dbId))
+		delete(c.perms, dbId) // Full access by password
+		c.mtx.Unlock()
+	}
+}
+
+// Check whether the table of dbId can be read, or written if write is true.
+func (c *Client) CanAccess(dbId, tableId uint8, write bool) bool {
+	if c == nil || !c.authEnabled {
+		return true
+	}
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if c.authBM == nil {
+		return false
+	}
+
+	if c.authBM.Get(proto.AdminDbId) {
+		return true
+	}
+
+	if dbId != proto.AdminDbId && c.authBM.Get(uint(dbId)) {
+		return c.perms[dbId].CanAccess(tableId, write)
+	}
+
+	return false
+}
+
+// Authorize dbId by a named user. The permissions of the users authorized
+// on the same connection are merged.
+func (c *Client) SetPerm(dbId uint8, perm *store.Perm) {
+	if c != nil && c.authEnabled {
+		c.mtx.Lock()
+		if c.authBM == nil {
+			c.authBM = util.NewBitMap(256 / 8)
+		}
+		if c.perms == nil {
+			c.perms = make(map[uint8]*store.Perm)
+		}
+
+		if !c.authBM.Get(uint(dbId)) {
+			c.authBM.Set(uint(dbId))
+			c.perms[dbId] = perm
+		} else if c.perms[dbId] != nil {
+			c.perms[dbId] = c.perms[dbId].Merge(perm)
+		}
 		c.mtx.Unlock()
 	}
 }
 
+// Keep the state of the challenge-response auth in progress.
+func (c *Client) SetScram(st *store.ScramState) {
+	if c != nil {
+		c.mtx.Lock()
+		c.scram = st
+		c.mtx.Unlock()
+	}
+}
+
+func (c *Client) GetScram() *store.ScramState {
+	if c == nil {
+		return nil
+	}
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.scram
+}
+
 func (c *Client) GoRecvRequest(ch *RequestChan, slv *slave) {
 	var headBuf = make([]byte, proto.HeadSize)
 	var head proto.PkgHead
@@ -177,11 +305,26 @@ func (c *Client) GoRecvRequest(ch *RequestChan, slv *slave) {
 		//log.Printf("recv(%s): [0x%X\t%d\t%d]\n",
 		//	c.c.RemoteAddr(), head.Cmd, head.DbId, head.Seq)
 
-		var req = Request{c, slv, store.PkgArgs{head.Cmd, head.DbId, head.Seq, pkg}}
+		if ch.IsStopped() {
+			// Server is shutting down, the connection is closed later
+			return
+		}
+
+		var now = time.Now()
+		atomic.AddUint64(&c.bytesIn, uint64(len(pkg)))
+		atomic.StoreInt64(&c.lastActive, now.UnixNano())
+
+		var req = Request{c, slv, store.PkgArgs{head.Cmd, head.DbId, head.Seq, pkg},
+			now}
+		if c.stats != nil {
+			atomic.AddUint64(&c.stats.cmds[head.Cmd], 1)
+		}
 
 		switch head.Cmd {
 		case proto.CmdAuth:
 			fallthrough
+		case proto.CmdScram:
+			fallthrough
 		case proto.CmdPing:
 			fallthrough
 		case proto.CmdScan:
@@ -203,6 +346,8 @@ func (c *Client) GoRecvRequest(ch *RequestChan, slv *slave) {
 		case proto.CmdSet:
 			if ClientTypeNormal == c.ClientType() {
 				ch.WriteReqChan <- &req
+			} else if slv.IsDelayed() {
+				slv.AddDelayReq(&req)
 			} else {
 				ch.SyncReqChan <- &req
 			}
@@ -210,10 +355,42 @@ func (c *Client) GoRecvRequest(ch *RequestChan, slv *slave) {
 			fallthrough
 		case proto.CmdSync:
 			if ClientTypeNormal != c.ClientType() {
-				ch.SyncReqChan <- &req
+				if slv.IsDelayed() {
+					slv.AddDelayReq(&req)
+				} else {
+					ch.SyncReqChan <- &req
+				}
 			}
 		case proto.CmdDump:
 			ch.DumpReqChan <- &req
+		case proto.CmdUser:
+			fallthrough
+		case proto.CmdPasswd:
+			fallthrough
+		case proto.CmdShutdown:
+			fallthrough
+		case proto.CmdConfig:
+			fallthrough
+		case proto.CmdClient:
+			fallthrough
+		case proto.CmdSlowLog:
+			fallthrough
+		case proto.CmdInfo:
+			fallthrough
+		case proto.CmdVerify:
+			fallthrough
+		case proto.CmdStats:
+			fallthrough
+		case proto.CmdLimit:
+			fallthrough
+		case proto.CmdCluster:
+			fallthrough
+		case proto.CmdSetSlot:
+			fallthrough
+		case proto.CmdDelay:
+			fallthrough
+		case proto.CmdSwitch:
+			fallthrough
 		case proto.CmdDelSlot:
 			fallthrough
 		case proto.CmdSlaveSt:
@@ -247,6 +424,8 @@ func (c *Client) GoSendResponse() {
 				if err != nil {
 					c.Close()
 				}
+				atomic.AddUint64(&c.bytesOut, uint64(len(pkg)))
+				atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
 			}
 		}
 	}
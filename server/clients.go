@@ -0,0 +1,197 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client connections accepted by the server
+type clientList struct {
+	mtx     sync.Mutex
+	lastId  uint64
+	clients map[uint64]*Client
+}
+
+func newClientList() *clientList {
+	var cl = new(clientList)
+	cl.clients = make(map[uint64]*Client)
+	return cl
+}
+
+func (cl *clientList) add(c *Client) {
+	cl.mtx.Lock()
+	cl.lastId++
+	c.id = cl.lastId
+	c.list = cl
+	cl.clients[c.id] = c
+	cl.mtx.Unlock()
+}
+
+func (cl *clientList) remove(c *Client) {
+	cl.mtx.Lock()
+	delete(cl.clients, c.id)
+	cl.mtx.Unlock()
+}
+
+// Get all clients ordered by id.
+func (cl *clientList) getClients() []*Client {
+	cl.mtx.Lock()
+	var res = make([]*Client, 0, len(cl.clients))
+	for _, c := range cl.clients {
+		res = append(res, c)
+	}
+	cl.mtx.Unlock()
+
+	sort.Sort(clientSlice(res))
+	return res
+}
+
+type clientSlice []*Client
+
+func (s clientSlice) Len() int           { return len(s) }
+func (s clientSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s clientSlice) Less(i, j int) bool { return s[i].id < s[j].id }
+
+// Client type name used by CLIENT LIST/KILL. The peer of a master type
+// connection is a slave syncing from this server.
+func clientTypeName(cliType uint32) string {
+	switch cliType {
+	case ClientTypeNormal:
+		return "normal"
+	case ClientTypeMaster:
+		return "slave"
+	case ClientTypeSlave:
+		return "master"
+	}
+	return fmt.Sprintf("unknown(%d)", cliType)
+}
+
+func getClientInfo(c *Client) ctrl.ClientInfo {
+	var now = time.Now()
+	return ctrl.ClientInfo{
+		Id:          c.id,
+		Addr:        c.RemoteAddr().String(),
+		Type:        clientTypeName(c.ClientType()),
+		AuthDbs:     c.AuthDbs(),
+		Age:         int64(now.Sub(c.createTime).Seconds()),
+		Idle:        int64(c.IdleTime().Seconds()),
+		RespPending: len(c.respChan),
+		BytesIn:     atomic.LoadUint64(&c.bytesIn),
+		BytesOut:    atomic.LoadUint64(&c.bytesOut),
+	}
+}
+
+// Close normal clients idle longer than the idle timeout.
+func (srv *Server) goCloseIdleClients(timeout time.Duration) {
+	var tick = time.Tick(time.Second)
+	for {
+		select {
+		case <-tick:
+			if srv.IsClosed() {
+				return
+			}
+
+			for _, c := range srv.cl.getClients() {
+				if c.ClientType() == ClientTypeNormal && c.IdleTime() > timeout {
+					log.Printf("Client %s idle for %s, close now!\n",
+						c.RemoteAddr(), c.IdleTime())
+					c.Close()
+				}
+			}
+		}
+	}
+}
+
+func (srv *Server) client(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgClient
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = fmt.Sprintf("no priviledge")
+		} else {
+			switch p.Op {
+			case ctrl.ClientList:
+				p.Clients = nil
+				for _, c := range srv.cl.getClients() {
+					p.Clients = append(p.Clients, getClientInfo(c))
+				}
+			case ctrl.ClientKill:
+				p.Killed, p.ErrMsg = srv.killClients(req.Cli, p.Addr, p.Type)
+			default:
+				p.ErrMsg = fmt.Sprintf("invalid client op %d", p.Op)
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Client command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+// Close the client of addr, or all clients of typeName except self.
+func (srv *Server) killClients(self *Client, addr, typeName string) (int, string) {
+	if addr == "" && typeName == "" {
+		return 0, "address or type is required"
+	}
+	if addr == "" && typeName != clientTypeName(ClientTypeNormal) &&
+		typeName != clientTypeName(ClientTypeMaster) &&
+		typeName != clientTypeName(ClientTypeSlave) {
+		return 0, fmt.Sprintf("invalid client type %s", typeName)
+	}
+
+	var killed int
+	for _, c := range srv.cl.getClients() {
+		if addr != "" {
+			if c.RemoteAddr().String() != addr {
+				continue
+			}
+		} else if c == self || clientTypeName(c.ClientType()) != typeName {
+			continue
+		}
+
+		log.Printf("Kill client %s\n", c.RemoteAddr())
+		c.Close()
+		killed++
+	}
+
+	if addr != "" && killed == 0 {
+		return 0, fmt.Sprintf("no client %s", addr)
+	}
+	return killed, ""
+}
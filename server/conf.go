@@ -0,0 +1,369 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/config"
+	"github.com/stevejiang/gotable/ctrl"
+	"log"
+	"path"
+	"strconv"
+)
+
+// A config item named as section.key of gotable.conf
+type confItem struct {
+	name   string
+	secret bool // Value is not shown
+	get    func(c *config.Config) string
+	// Parse value into c, nil means read only (restart required)
+	parse func(c *config.Config, value string) error
+	// Apply the new value in c to the running server
+	apply func(srv *Server, c *config.Config)
+}
+
+var confItems = []confItem{
+	{name: "database.network",
+		get: func(c *config.Config) string { return c.Db.Network }},
+	{name: "database.address",
+		get: func(c *config.Config) string { return c.Db.Address }},
+	{name: "database.data",
+		get: func(c *config.Config) string { return c.Db.Data }},
+	{name: "database.max_cpu_num",
+		get: func(c *config.Config) string { return strconv.Itoa(c.Db.MaxCpuNum) }},
+	{name: "database.write_buffer_size",
+		get: func(c *config.Config) string { return strconv.Itoa(c.Db.WriteBufSize) }},
+	// RocksDB 3.8 cannot change the block cache capacity of an open DB
+	{name: "database.cache_size",
+		get: func(c *config.Config) string { return formatInt(c.Db.CacheSize) }},
+	{name: "database.compression",
+		get: func(c *config.Config) string { return c.Db.Compression }},
+	{name: "database.rate_limit",
+		get: func(c *config.Config) string { return formatInt(c.Db.RateLimit) }},
+	{name: "database.max_clients",
+		get: func(c *config.Config) string { return strconv.Itoa(c.Db.MaxClients) }},
+	{name: "database.idle_timeout",
+		get: func(c *config.Config) string { return strconv.Itoa(c.Db.IdleTimeout) }},
+	{name: "binlog.memory_size",
+		get: func(c *config.Config) string { return strconv.Itoa(c.Bin.MemSize) }},
+	{name: "binlog.keep_num",
+		get: func(c *config.Config) string { return strconv.Itoa(c.Bin.KeepNum) },
+		parse: func(c *config.Config, v string) error {
+			return parseInt(v, &c.Bin.KeepNum)
+		},
+		apply: func(srv *Server, c *config.Config) {
+			srv.bin.SetKeepNum(c.Bin.KeepNum)
+		}},
+	{name: "replication.max_mb_per_sec",
+		get: func(c *config.Config) string { return formatInt(c.Repl.MaxMBps) },
+		parse: func(c *config.Config, v string) error {
+			return parseInt64(v, &c.Repl.MaxMBps)
+		},
+		apply: func(srv *Server, c *config.Config) {
+			srv.syncBytes.SetRate(c.Repl.MaxMBps * 1024 * 1024)
+		}},
+	{name: "replication.max_keys_per_sec",
+		get: func(c *config.Config) string { return formatInt(c.Repl.MaxKeys) },
+		parse: func(c *config.Config, v string) error {
+			return parseInt64(v, &c.Repl.MaxKeys)
+		},
+		apply: func(srv *Server, c *config.Config) {
+			srv.syncKeys.SetRate(c.Repl.MaxKeys)
+		}},
+	{name: "slowlog.slower_than",
+		get: func(c *config.Config) string { return formatInt(c.Slow.SlowerThan) },
+		parse: func(c *config.Config, v string) error {
+			return parseInt64(v, &c.Slow.SlowerThan)
+		},
+		apply: func(srv *Server, c *config.Config) {
+			srv.slow.setSlowerThan(c.Slow.SlowerThan)
+		}},
+	{name: "slowlog.max_len",
+		get: func(c *config.Config) string { return strconv.Itoa(c.Slow.MaxLen) },
+		parse: func(c *config.Config, v string) error {
+			return parseInt(v, &c.Slow.MaxLen)
+		},
+		apply: func(srv *Server, c *config.Config) {
+			srv.slow.setMaxLen(c.Slow.MaxLen)
+		}},
+	{name: "slowlog.file",
+		get: func(c *config.Config) string { return c.Slow.File }},
+	{name: "auth.admin_password", secret: true,
+		get: func(c *config.Config) string { return c.Auth.AdminPwd },
+		parse: func(c *config.Config, v string) error {
+			// Clients are created with auth enabled or not
+			if (c.Auth.AdminPwd == "") != (v == "") {
+				return errors.New("enabling or disabling auth needs restart")
+			}
+			c.Auth.AdminPwd = v
+			return nil
+		},
+		apply: func(srv *Server, c *config.Config) {
+			srv.tbl.SetPassword(proto.AdminDbId, c.Auth.AdminPwd)
+			// The slave authenticates to master with the admin password
+			srv.rwMtx.RLock()
+			var slv = srv.slv
+			srv.rwMtx.RUnlock()
+			if slv != nil {
+				slv.SetAdminPwd(c.Auth.AdminPwd)
+			}
+		}},
+	{name: "auth.plain_auth",
+		get: func(c *config.Config) string {
+			return strconv.FormatBool(c.Auth.PlainAuth)
+		},
+		parse: func(c *config.Config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s is not a valid bool", v)
+			}
+			c.Auth.PlainAuth = b
+			return nil
+		},
+		apply: func(srv *Server, c *config.Config) {
+			warnPlainAuth(c)
+		}},
+	{name: "tls.cert_file",
+		get: func(c *config.Config) string { return c.TLS.CertFile }},
+	{name: "tls.key_file",
+		get: func(c *config.Config) string { return c.TLS.KeyFile }},
+	{name: "tls.ca_file",
+		get: func(c *config.Config) string { return c.TLS.CaFile }},
+	{name: "tls.client_cert",
+		get: func(c *config.Config) string { return c.TLS.ClientCert }},
+	{name: "tls.replication",
+		get: func(c *config.Config) string {
+			return strconv.FormatBool(c.TLS.Replication)
+		}},
+	{name: "profile.memory",
+		get: func(c *config.Config) string { return c.Profile.Memory }},
+	{name: "profile.host",
+		get: func(c *config.Config) string { return c.Profile.Host }},
+}
+
+func formatInt(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+func parseInt(v string, n *int) error {
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid number", v)
+	}
+	*n = i
+	return nil
+}
+
+func parseInt64(v string, n *int64) error {
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid number", v)
+	}
+	*n = i
+	return nil
+}
+
+func (it *confItem) show(c *config.Config) string {
+	var v = it.get(c)
+	if it.secret && v != "" {
+		return "******"
+	}
+	return v
+}
+
+func findConfItem(name string) *confItem {
+	for i := 0; i < len(confItems); i++ {
+		if confItems[i].name == name {
+			return &confItems[i]
+		}
+	}
+	return nil
+}
+
+// Log a warning when the deprecated plain text auth is accepted.
+func warnPlainAuth(c *config.Config) {
+	if c.Auth.AdminPwd != "" && c.Auth.PlainAuth {
+		log.Println("Warning: plain text auth is deprecated, " +
+			"set auth.plain_auth to false when all clients are upgraded")
+	}
+}
+
+// Get the running config. The returned config must not be changed.
+func (srv *Server) getConf() *config.Config {
+	srv.confMtx.Lock()
+	var conf = srv.conf
+	srv.confMtx.Unlock()
+	return conf
+}
+
+// Change the running config by a copy of it.
+func (srv *Server) updateConf(update func(c *config.Config)) {
+	srv.setMtx.Lock()
+	defer srv.setMtx.Unlock()
+
+	var conf = *srv.getConf()
+	update(&conf)
+	srv.swapConf(&conf)
+}
+
+// Replace the running config. setMtx must be held.
+func (srv *Server) swapConf(conf *config.Config) {
+	srv.confMtx.Lock()
+	srv.conf = conf
+	srv.confMtx.Unlock()
+}
+
+// Get config items matching the pattern (like "binlog.*").
+func (srv *Server) getConfItems(pattern string) ([]ctrl.ConfigItem, error) {
+	var conf = srv.getConf()
+	var items []ctrl.ConfigItem
+	for i := 0; i < len(confItems); i++ {
+		var it = &confItems[i]
+		ok, err := path.Match(pattern, it.name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s", pattern)
+		}
+		if ok {
+			items = append(items, ctrl.ConfigItem{Name: it.name,
+				Value: it.show(conf), Mutable: it.parse != nil})
+		}
+	}
+	return items, nil
+}
+
+// Change a config item at runtime.
+func (srv *Server) setConfItem(name, value string) error {
+	var it = findConfItem(name)
+	if it == nil {
+		return fmt.Errorf("unknown config %s", name)
+	}
+	if it.parse == nil {
+		return fmt.Errorf("config %s can only be changed by restart", name)
+	}
+
+	// Applying may take long (password hashing), so only setMtx is held
+	// and getConf is never blocked by it.
+	srv.setMtx.Lock()
+	defer srv.setMtx.Unlock()
+
+	var conf = *srv.getConf()
+	var old = it.show(&conf)
+	if err := it.parse(&conf, value); err != nil {
+		return err
+	}
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	it.apply(srv, &conf)
+	srv.swapConf(&conf)
+	log.Printf("Config %s changed from %s to %s\n", name, old, it.show(&conf))
+	return nil
+}
+
+// Reload reads the config file again, and applies the changed items which
+// can be changed at runtime. Other changed items need restart.
+func (srv *Server) Reload(fileName string) {
+	if fileName == "" {
+		log.Println("Using default configuration, nothing to reload")
+		return
+	}
+
+	newConf, err := config.Load(fileName)
+	if err != nil {
+		log.Printf("Reload config failed: %s\n", err)
+		return
+	}
+
+	srv.setMtx.Lock()
+	defer srv.setMtx.Unlock()
+
+	var conf = *srv.getConf()
+	var changed []*confItem
+	for i := 0; i < len(confItems); i++ {
+		var it = &confItems[i]
+		var value = it.get(newConf)
+		if it.get(&conf) == value {
+			continue
+		}
+
+		var old = it.show(&conf)
+		if it.parse == nil {
+			log.Printf("Config %s changed from %s to %s, restart required\n",
+				it.name, old, it.show(newConf))
+			continue
+		}
+		if err = it.parse(&conf, value); err != nil {
+			log.Printf("Config %s not changed: %s\n", it.name, err)
+			continue
+		}
+
+		changed = append(changed, it)
+		log.Printf("Config %s changed from %s to %s\n",
+			it.name, old, it.show(&conf))
+	}
+
+	for _, it := range changed {
+		it.apply(srv, &conf)
+	}
+	srv.swapConf(&conf)
+	log.Printf("Config reloaded from %s, %d items changed\n",
+		fileName, len(changed))
+}
+
+func (srv *Server) config(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgConfig
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = fmt.Sprintf("no priviledge")
+		} else {
+			switch p.Op {
+			case ctrl.ConfigGet:
+				p.Items, err = srv.getConfItems(p.Name)
+			case ctrl.ConfigSet:
+				p.Items = nil
+				err = srv.setConfItem(p.Name, p.Value)
+			default:
+				err = fmt.Errorf("invalid config op %d", p.Op)
+			}
+			if err != nil {
+				p.ErrMsg = err.Error()
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Config command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
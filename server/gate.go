@@ -0,0 +1,84 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A write is committed to the table before it is added to binlog, so the
+// last binlog seq may miss committed writes. writeGate counts the writes in
+// progress until they are added to binlog, and pause waits for them, so that
+// the binlog seq taken while write is paused covers all committed writes.
+type writeGate struct {
+	writing int64 // atomic, writes in progress
+	paused  int32 // atomic, number of callers pausing write
+
+	mtx  sync.Mutex // protects paused changes
+	cond *sync.Cond // signaled when write is resumed
+}
+
+func newWriteGate() *writeGate {
+	var g = new(writeGate)
+	g.cond = sync.NewCond(&g.mtx)
+	return g
+}
+
+// Start a write, waiting while write is paused.
+func (g *writeGate) begin() {
+	for !g.tryBegin() {
+		g.mtx.Lock()
+		for atomic.LoadInt32(&g.paused) != 0 {
+			g.cond.Wait()
+		}
+		g.mtx.Unlock()
+	}
+}
+
+// Start a write. Returns false if write is paused.
+func (g *writeGate) tryBegin() bool {
+	atomic.AddInt64(&g.writing, 1)
+	if atomic.LoadInt32(&g.paused) != 0 {
+		g.end()
+		return false
+	}
+	return true
+}
+
+// Finish the write after it is added to binlog.
+func (g *writeGate) end() {
+	atomic.AddInt64(&g.writing, -1)
+}
+
+// Pause write, and wait for the writes in progress to finish.
+func (g *writeGate) pause() {
+	g.mtx.Lock()
+	atomic.AddInt32(&g.paused, 1)
+	g.mtx.Unlock()
+
+	for atomic.LoadInt64(&g.writing) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (g *writeGate) resume() {
+	g.mtx.Lock()
+	if atomic.AddInt32(&g.paused, -1) == 0 {
+		g.cond.Broadcast()
+	}
+	g.mtx.Unlock()
+}
@@ -0,0 +1,331 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/util"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Info sections in output order
+var infoSections = []string{"server", "clients", "commands", "rocksdb",
+	"binlog", "replication"}
+
+var cmdNames = map[uint8]string{
+	proto.CmdAuth:     "auth",
+	proto.CmdScram:    "scram",
+	proto.CmdPing:     "ping",
+	proto.CmdGet:      "get",
+	proto.CmdMGet:     "mget",
+	proto.CmdScan:     "scan",
+	proto.CmdDump:     "dump",
+	proto.CmdSet:      "set",
+	proto.CmdMSet:     "mset",
+	proto.CmdDel:      "del",
+	proto.CmdMDel:     "mdel",
+	proto.CmdIncr:     "incr",
+	proto.CmdMIncr:    "mincr",
+	proto.CmdSync:     "sync",
+	proto.CmdSyncSt:   "syncst",
+	proto.CmdSlaveOf:  "slaveof",
+	proto.CmdMigrate:  "migrate",
+	proto.CmdSlaveSt:  "slavest",
+	proto.CmdDelSlot:  "delslot",
+	proto.CmdSwitch:   "switch",
+	proto.CmdDelay:    "delay",
+	proto.CmdSetSlot:  "setslot",
+	proto.CmdCluster:  "cluster",
+	proto.CmdLimit:    "ratelimit",
+	proto.CmdStats:    "stats",
+	proto.CmdVerify:   "verify",
+	proto.CmdInfo:     "info",
+	proto.CmdSlowLog:  "slowlog",
+	proto.CmdClient:   "client",
+	proto.CmdConfig:   "config",
+	proto.CmdShutdown: "shutdown",
+	proto.CmdPasswd:   "passwd",
+	proto.CmdUser:     "user",
+}
+
+func cmdName(cmd uint8) string {
+	if name, ok := cmdNames[cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%X", cmd)
+}
+
+// Server statistics of client connections and requests
+type serverStats struct {
+	// atomic
+	cmds       [256]uint64 // Requests received of every cmd
+	totalConns uint64      // Connections accepted since started
+	clients    int64       // Connected clients
+
+	startTime time.Time
+	latency   map[uint8]*cmdLatency // Read only after created
+}
+
+// Latency of one cmd in microseconds
+type cmdLatency struct {
+	mtx  sync.Mutex
+	hist util.Histogram
+}
+
+func newServerStats() *serverStats {
+	var st = new(serverStats)
+	st.startTime = time.Now()
+	st.latency = make(map[uint8]*cmdLatency)
+	for cmd := range cmdNames {
+		var lat = new(cmdLatency)
+		lat.hist.Clear()
+		st.latency[cmd] = lat
+	}
+	return st
+}
+
+// Record the time from receiving the request to replying it.
+func (st *serverStats) observe(cmd uint8, d time.Duration) {
+	var lat = st.latency[cmd]
+	if lat != nil {
+		lat.mtx.Lock()
+		lat.hist.Add(float64(d / time.Microsecond))
+		lat.mtx.Unlock()
+	}
+}
+
+// Get a copy of the latency histogram of cmd, nil if cmd is unknown.
+func (st *serverStats) getLatency(cmd uint8) *util.Histogram {
+	var lat = st.latency[cmd]
+	if lat == nil {
+		return nil
+	}
+	lat.mtx.Lock()
+	var hist = lat.hist
+	lat.mtx.Unlock()
+	return &hist
+}
+
+// Replication progress of a normal slave
+type slaveLag struct {
+	addr    string
+	sentSeq uint64 // Last binlog seq sent to the slave
+	lag     uint64 // Binlog seqs not sent yet
+}
+
+// Get the progress of every normal slave, ordered by slave address.
+func (srv *Server) getSlaveLags() []slaveLag {
+	var logSeq, _ = srv.bin.GetLogSeqChanLen()
+	var lags []slaveLag
+	srv.rwMtx.RLock()
+	for addr, ms := range srv.masters {
+		if ms.isClosed() {
+			continue
+		}
+		var sl = slaveLag{addr: addr, sentSeq: atomic.LoadUint64(&ms.sentSeq)}
+		if logSeq > sl.sentSeq {
+			sl.lag = logSeq - sl.sentSeq
+		}
+		lags = append(lags, sl)
+	}
+	srv.rwMtx.RUnlock()
+
+	sort.Sort(slaveLagSlice(lags))
+	return lags
+}
+
+type slaveLagSlice []slaveLag
+
+func (s slaveLagSlice) Len() int           { return len(s) }
+func (s slaveLagSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s slaveLagSlice) Less(i, j int) bool { return s[i].addr < s[j].addr }
+
+func (srv *Server) info(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgInfo
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = fmt.Sprintf("no priviledge")
+		} else {
+			p.Info, err = srv.getInfo(strings.ToLower(p.Section))
+			if err != nil {
+				p.ErrMsg = err.Error()
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Info command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) getInfo(section string) (string, error) {
+	var buf bytes.Buffer
+	var found bool
+	for _, name := range infoSections {
+		if section != "" && section != name {
+			continue
+		}
+		found = true
+
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "# %s%s\n", strings.ToUpper(name[:1]), name[1:])
+		switch name {
+		case "server":
+			srv.serverInfo(&buf)
+		case "clients":
+			srv.clientsInfo(&buf)
+		case "commands":
+			srv.commandsInfo(&buf)
+		case "rocksdb":
+			srv.rocksdbInfo(&buf)
+		case "binlog":
+			srv.binlogInfo(&buf)
+		case "replication":
+			srv.replicationInfo(&buf)
+		}
+	}
+
+	if !found {
+		return "", fmt.Errorf("invalid section %s", section)
+	}
+	return buf.String(), nil
+}
+
+func (srv *Server) serverInfo(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "version:%s\n", table.Version)
+	fmt.Fprintf(buf, "go_version:%s\n", runtime.Version())
+	fmt.Fprintf(buf, "process_id:%d\n", os.Getpid())
+	var conf = srv.getConf()
+	fmt.Fprintf(buf, "address:%s://%s\n", conf.Db.Network, conf.Db.Address)
+	fmt.Fprintf(buf, "uptime_in_seconds:%d\n",
+		int64(time.Since(srv.st.startTime).Seconds()))
+	fmt.Fprintf(buf, "num_cpu:%d\n", runtime.NumCPU())
+	fmt.Fprintf(buf, "gomaxprocs:%d\n", runtime.GOMAXPROCS(0))
+	fmt.Fprintf(buf, "goroutines:%d\n", runtime.NumGoroutine())
+	fmt.Fprintf(buf, "read_goroutines:%d\n", srv.readProcNum)
+	fmt.Fprintf(buf, "write_goroutines:%d\n", srv.writeProcNum)
+}
+
+func (srv *Server) clientsInfo(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "connected_clients:%d\n",
+		atomic.LoadInt64(&srv.st.clients))
+	fmt.Fprintf(buf, "total_connections:%d\n",
+		atomic.LoadUint64(&srv.st.totalConns))
+	fmt.Fprintf(buf, "read_queue:%d\n", len(srv.reqChan.ReadReqChan))
+	fmt.Fprintf(buf, "write_queue:%d\n", len(srv.reqChan.WriteReqChan))
+	fmt.Fprintf(buf, "sync_queue:%d\n", len(srv.reqChan.SyncReqChan))
+}
+
+func (srv *Server) commandsInfo(buf *bytes.Buffer) {
+	var total uint64
+	for i := 0; i < len(srv.st.cmds); i++ {
+		var n = atomic.LoadUint64(&srv.st.cmds[i])
+		if n > 0 {
+			fmt.Fprintf(buf, "cmd_%s:%d\n", cmdName(uint8(i)), n)
+			total += n
+		}
+	}
+	fmt.Fprintf(buf, "total_commands:%d\n", total)
+}
+
+func (srv *Server) rocksdbInfo(buf *bytes.Buffer) {
+	var st = srv.tbl.GetDBStats()
+	fmt.Fprintf(buf, "memtable_size:%d\n", st.MemTableSize)
+	fmt.Fprintf(buf, "immutable_memtables:%d\n", st.ImmMemTables)
+	fmt.Fprintf(buf, "flush_pending:%d\n", st.FlushPending)
+	fmt.Fprintf(buf, "compaction_pending:%d\n", st.CompactionPending)
+	fmt.Fprintf(buf, "estimate_keys:%d\n", st.EstimateKeys)
+	fmt.Fprintf(buf, "background_errors:%d\n", st.BackgroundErrors)
+	fmt.Fprintf(buf, "sst_size:%d\n", st.SstSize)
+	fmt.Fprintf(buf, "block_cache_usage:%d\n", st.BlockCacheUsage)
+}
+
+func (srv *Server) binlogInfo(buf *bytes.Buffer) {
+	var bi = srv.bin.GetInfo()
+	fmt.Fprintf(buf, "file_idx:%d\n", bi.FileIdx)
+	fmt.Fprintf(buf, "log_seq:%d\n", bi.LogSeq)
+	fmt.Fprintf(buf, "min_seq:%d\n", bi.MinSeq)
+	fmt.Fprintf(buf, "files_kept:%d\n", bi.FileNum)
+	fmt.Fprintf(buf, "max_files:%d\n", bi.KeepNum)
+	fmt.Fprintf(buf, "pending_writes:%d\n", bi.ChanLen)
+	fmt.Fprintf(buf, "readers:%d\n", bi.Monitors)
+}
+
+func (srv *Server) replicationInfo(buf *bytes.Buffer) {
+	var m = srv.mc.GetMaster()
+	var role = "master"
+	if len(m.MasterAddr) > 0 {
+		if m.Migration {
+			role = "migration"
+		} else {
+			role = "slave"
+		}
+	}
+	fmt.Fprintf(buf, "role:%s\n", role)
+
+	if len(m.MasterAddr) > 0 {
+		fmt.Fprintf(buf, "master_addr:%s\n", m.MasterAddr)
+		fmt.Fprintf(buf, "master_status:%s\n", ctrl.SlaveStatusName(m.Status))
+		if m.Migration {
+			fmt.Fprintf(buf, "migration_slots:%s\n", ctrl.NewSlotSet(m.Slots))
+		} else {
+			lastSeq, _ := srv.bin.GetMasterSeq()
+			fmt.Fprintf(buf, "master_last_seq:%d\n", lastSeq)
+		}
+		var prog = srv.mc.GetProgress()
+		var lag uint64
+		if prog.MasterSeq > prog.AppliedSeq {
+			lag = prog.MasterSeq - prog.AppliedSeq
+		}
+		fmt.Fprintf(buf, "master_seq_lag:%d\n", lag)
+	}
+
+	var lags = srv.getSlaveLags()
+	fmt.Fprintf(buf, "connected_slaves:%d\n", len(lags))
+	for i, sl := range lags {
+		fmt.Fprintf(buf, "slave%d:addr=%s,sent_seq=%d,lag=%d\n",
+			i, sl.addr, sl.sentSeq, sl.lag)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of latency histogram buckets in microseconds, every bound is
+// a bucket limit of util.Histogram
+var latencyBuckets = []float64{100, 250, 500, 1000, 2500, 5000, 10000,
+	25000, 50000, 100000, 250000, 500000, 1000000, 2500000, 5000000}
+
+// ServeMetrics writes the server metrics in Prometheus text format.
+func (srv *Server) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	srv.writeMetrics(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+func (srv *Server) writeMetrics(buf *bytes.Buffer) {
+	metricHeader(buf, "gotable_uptime_seconds", "gauge",
+		"Seconds since the server started.")
+	metricValue(buf, "gotable_uptime_seconds", "",
+		time.Since(srv.st.startTime).Seconds())
+	metricHeader(buf, "gotable_goroutines", "gauge",
+		"Number of goroutines.")
+	metricValue(buf, "gotable_goroutines", "", float64(runtime.NumGoroutine()))
+
+	srv.writeClientMetrics(buf)
+	srv.writeCommandMetrics(buf)
+	srv.writeBinlogMetrics(buf)
+	srv.writeReplicationMetrics(buf)
+	srv.writeRocksdbMetrics(buf)
+}
+
+func (srv *Server) writeClientMetrics(buf *bytes.Buffer) {
+	metricHeader(buf, "gotable_connected_clients", "gauge",
+		"Number of client connections.")
+	metricValue(buf, "gotable_connected_clients", "",
+		float64(atomic.LoadInt64(&srv.st.clients)))
+	metricHeader(buf, "gotable_connections_total", "counter",
+		"Client connections accepted.")
+	metricValue(buf, "gotable_connections_total", "",
+		float64(atomic.LoadUint64(&srv.st.totalConns)))
+
+	metricHeader(buf, "gotable_queue_length", "gauge",
+		"Requests waiting in the queue.")
+	var queues = []struct {
+		name string
+		n    int
+	}{
+		{"read", len(srv.reqChan.ReadReqChan)},
+		{"write", len(srv.reqChan.WriteReqChan)},
+		{"sync", len(srv.reqChan.SyncReqChan)},
+		{"dump", len(srv.reqChan.DumpReqChan)},
+		{"ctrl", len(srv.reqChan.CtrlReqChan)},
+	}
+	for _, q := range queues {
+		metricValue(buf, "gotable_queue_length",
+			fmt.Sprintf("queue=%q", q.name), float64(q.n))
+	}
+}
+
+func (srv *Server) writeCommandMetrics(buf *bytes.Buffer) {
+	metricHeader(buf, "gotable_commands_total", "counter",
+		"Requests received of every command.")
+	for i := 0; i < len(srv.st.cmds); i++ {
+		var n = atomic.LoadUint64(&srv.st.cmds[i])
+		if n > 0 {
+			metricValue(buf, "gotable_commands_total",
+				fmt.Sprintf("cmd=%q", cmdName(uint8(i))), float64(n))
+		}
+	}
+
+	metricHeader(buf, "gotable_command_duration_seconds", "histogram",
+		"Time from receiving a request to replying it.")
+	for i := 0; i < len(srv.st.cmds); i++ {
+		var hist = srv.st.getLatency(uint8(i))
+		if hist == nil || hist.Count() == 0 {
+			continue
+		}
+
+		var cmd = cmdName(uint8(i))
+		for _, limit := range latencyBuckets {
+			metricValue(buf, "gotable_command_duration_seconds_bucket",
+				fmt.Sprintf("cmd=%q,le=%q", cmd, formatFloat(limit/1e6)),
+				hist.CumulativeCount(limit))
+		}
+		metricValue(buf, "gotable_command_duration_seconds_bucket",
+			fmt.Sprintf("cmd=%q,le=\"+Inf\"", cmd), hist.Count())
+		metricValue(buf, "gotable_command_duration_seconds_sum",
+			fmt.Sprintf("cmd=%q", cmd), hist.Sum()/1e6)
+		metricValue(buf, "gotable_command_duration_seconds_count",
+			fmt.Sprintf("cmd=%q", cmd), hist.Count())
+	}
+}
+
+func (srv *Server) writeBinlogMetrics(buf *bytes.Buffer) {
+	var bi = srv.bin.GetInfo()
+	var gauges = []struct {
+		name  string
+		help  string
+		value uint64
+	}{
+		{"gotable_binlog_seq", "Last binlog seq written.", bi.LogSeq},
+		{"gotable_binlog_min_seq", "Min seq of the binlog files kept.", bi.MinSeq},
+		{"gotable_binlog_file_index", "Index of the binlog file writing.", bi.FileIdx},
+		{"gotable_binlog_files", "Number of binlog files kept.", uint64(bi.FileNum)},
+		{"gotable_binlog_pending_writes", "Requests waiting to be written to binlog.",
+			uint64(bi.ChanLen)},
+	}
+	for _, g := range gauges {
+		metricHeader(buf, g.name, "gauge", g.help)
+		metricValue(buf, g.name, "", float64(g.value))
+	}
+}
+
+func (srv *Server) writeReplicationMetrics(buf *bytes.Buffer) {
+	var m = srv.mc.GetMaster()
+	if len(m.MasterAddr) > 0 {
+		var prog = srv.mc.GetProgress()
+		var lag uint64
+		if prog.MasterSeq > prog.AppliedSeq {
+			lag = prog.MasterSeq - prog.AppliedSeq
+		}
+		metricHeader(buf, "gotable_master_seq_lag", "gauge",
+			"Binlog seqs of the master not applied yet.")
+		metricValue(buf, "gotable_master_seq_lag",
+			fmt.Sprintf("master=%q", m.MasterAddr), float64(lag))
+		metricHeader(buf, "gotable_slave_status", "gauge",
+			"Slave/migration status, 6 means ready.")
+		metricValue(buf, "gotable_slave_status",
+			fmt.Sprintf("master=%q", m.MasterAddr), float64(m.Status))
+	}
+
+	var lags = srv.getSlaveLags()
+	metricHeader(buf, "gotable_connected_slaves", "gauge",
+		"Number of normal slaves syncing from this server.")
+	metricValue(buf, "gotable_connected_slaves", "", float64(len(lags)))
+	if len(lags) > 0 {
+		metricHeader(buf, "gotable_slave_seq_lag", "gauge",
+			"Binlog seqs not sent to the slave yet.")
+		for _, sl := range lags {
+			metricValue(buf, "gotable_slave_seq_lag",
+				fmt.Sprintf("slave=%q", sl.addr), float64(sl.lag))
+		}
+	}
+}
+
+func (srv *Server) writeRocksdbMetrics(buf *bytes.Buffer) {
+	var st = srv.tbl.GetDBStats()
+	var gauges = []struct {
+		name  string
+		help  string
+		value uint64
+	}{
+		{"gotable_rocksdb_memtable_bytes", "Memory size of all memtables.",
+			st.MemTableSize},
+		{"gotable_rocksdb_immutable_memtables", "Immutable memtables not flushed yet.",
+			st.ImmMemTables},
+		{"gotable_rocksdb_flush_pending", "1 if a memtable flush is pending.",
+			st.FlushPending},
+		{"gotable_rocksdb_compaction_pending", "1 if a compaction is pending.",
+			st.CompactionPending},
+		{"gotable_rocksdb_estimate_keys", "Estimated number of keys.",
+			st.EstimateKeys},
+		{"gotable_rocksdb_background_errors", "Accumulated background errors.",
+			st.BackgroundErrors},
+		{"gotable_rocksdb_sst_bytes", "Approximate size of all SST files.",
+			st.SstSize},
+		{"gotable_rocksdb_block_cache_bytes", "Memory size of the block cache entries.",
+			st.BlockCacheUsage},
+	}
+	for _, g := range gauges {
+		metricHeader(buf, g.name, "gauge", g.help)
+		metricValue(buf, g.name, "", float64(g.value))
+	}
+}
+
+func metricHeader(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func metricValue(buf *bytes.Buffer, name, labels string, value float64) {
+	if labels != "" {
+		fmt.Fprintf(buf, "%s{%s} %s\n", name, labels, formatFloat(value))
+	} else {
+		fmt.Fprintf(buf, "%s %s\n", name, formatFloat(value))
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/binlog"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/store"
+	"log"
+)
+
+// Write the SYNC pkg of the admin table, and add it to binlog, so that
+// slaves apply it the same way.
+func (srv *Server) writeAdminPkg(pkg []byte) error {
+	if !srv.wg.tryBegin() {
+		return errors.New("write is paused")
+	}
+	defer srv.wg.end()
+
+	_, ok := srv.tbl.Sync(&store.PkgArgs{Cmd: proto.CmdSync,
+		DbId: proto.AdminDbId, Pkg: pkg})
+	if !ok {
+		return errors.New("write admin DB failed")
+	}
+	srv.bin.AddRequest(&binlog.Request{Pkg: pkg})
+	return nil
+}
+
+// Passwords and users are only changed on master, and replicated to slaves.
+func (srv *Server) checkAdminWrite() error {
+	hasMaster, migration, _ := srv.mc.GetMasterSlot()
+	if hasMaster && !migration {
+		return errors.New("cannot change on slave")
+	}
+	return nil
+}
+
+// Store the hashed password of dbId in the admin table, or remove it if
+// password is empty.
+func (srv *Server) setPassword(dbId uint8, password string) error {
+	if dbId == proto.AdminDbId {
+		return errors.New("admin password is set by auth.admin_password")
+	}
+	if err := srv.checkAdminWrite(); err != nil {
+		return err
+	}
+	if password == "" && !srv.tbl.HasPassword(dbId) {
+		return fmt.Errorf("DB %d has no password", dbId)
+	}
+
+	pkg, err := store.NewPasswordPkg(dbId, password)
+	if err != nil {
+		return err
+	}
+	if err = srv.writeAdminPkg(pkg); err != nil {
+		return err
+	}
+
+	if password == "" {
+		log.Printf("Password of DB %d removed\n", dbId)
+	} else {
+		log.Printf("Password of DB %d changed\n", dbId)
+	}
+	return nil
+}
+
+func (srv *Server) passwd(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgPasswd
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = fmt.Sprintf("no priviledge")
+		} else {
+			switch p.Op {
+			case ctrl.PasswdSet:
+				if p.Password == "" {
+					err = errors.New("empty password")
+				} else {
+					err = srv.setPassword(p.DbId, p.Password)
+				}
+			case ctrl.PasswdDel:
+				err = srv.setPassword(p.DbId, "")
+			case ctrl.PasswdList:
+				p.Dbs = srv.tbl.GetPasswordDbs()
+			default:
+				err = fmt.Errorf("invalid passwd op %d", p.Op)
+			}
+			if err != nil {
+				p.ErrMsg = err.Error()
+			}
+		}
+		p.Password = ""
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Passwd command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
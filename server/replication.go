@@ -15,7 +15,12 @@
 package server
 
 import (
+	"crypto/hmac"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/stevejiang/gotable/api/go/table/proto"
 	"github.com/stevejiang/gotable/binlog"
 	"github.com/stevejiang/gotable/config"
@@ -29,26 +34,47 @@ import (
 	"time"
 )
 
-type slave struct {
-	reqChan  *RequestChan
-	bin      *binlog.BinLog
-	mc       *config.MasterConfig
-	adminPwd string
+const (
+	maxDelayPkgs       = 1024 * 1024 // Max buffered changes of delayed slave
+	delayCheckInterval = time.Millisecond * 100
+)
 
-	mtx    sync.Mutex // protects following
-	mi     config.MasterInfo
-	cli    *Client
-	closed bool
+type slave struct {
+	reqChan *RequestChan
+	bin     *binlog.BinLog
+	mc      *config.MasterConfig
+	tlsConf *tls.Config // nil if not connecting to master with TLS
+
+	delay     time.Duration // Delayed apply, 0 means no delay
+	delayChan chan *Request // Buffered changes of delayed slave
+	quit      chan struct{} // Closed when slave is closed (delayed slave only)
+
+	mtx       sync.Mutex // protects following
+	mi        config.MasterInfo
+	adminPwd  string // Password for master auth
+	cli       *Client
+	serverSig []byte // Expected server signature of master auth
+	closed    bool
+	paused    bool  // Pause applying changes (delayed slave only)
+	ffTime    int64 // Apply changes written before it immediately
+	applyTime int64 // Binlog write time of the last applied change
 }
 
 func NewSlave(reqChan *RequestChan, bin *binlog.BinLog,
-	mc *config.MasterConfig, adminPwd string) *slave {
+	mc *config.MasterConfig, adminPwd string, tlsConf *tls.Config) *slave {
 	var slv = new(slave)
 	slv.reqChan = reqChan
 	slv.bin = bin
 	slv.mc = mc
 	slv.mi = mc.GetMaster()
 	slv.adminPwd = adminPwd
+	slv.tlsConf = tlsConf
+
+	if slv.mi.Delay > 0 && !slv.mi.Migration {
+		slv.delay = time.Duration(slv.mi.Delay) * time.Second
+		slv.delayChan = make(chan *Request, maxDelayPkgs)
+		slv.quit = make(chan struct{})
+	}
 
 	return slv
 }
@@ -59,6 +85,9 @@ func (slv *slave) Close() {
 	if !slv.closed {
 		slv.closed = true
 		cli = slv.cli
+		if slv.quit != nil {
+			close(slv.quit)
+		}
 	}
 	slv.mtx.Unlock()
 
@@ -82,6 +111,10 @@ func (slv *slave) IsClosed() bool {
 }
 
 func (slv *slave) GoConnectToMaster() {
+	if slv.IsDelayed() {
+		go slv.goDelayApply(slv.reqChan.SyncReqChan)
+	}
+
 	slv.doConnectToMaster()
 
 	slv.cli = nil
@@ -100,10 +133,16 @@ func (slv *slave) doConnectToMaster() {
 			return
 		}
 
-		c, err := net.Dial("tcp", mi.MasterAddr)
+		var c net.Conn
+		var err error
+		if slv.tlsConf != nil {
+			c, err = tls.Dial("tcp", mi.MasterAddr, slv.tlsConf)
+		} else {
+			c, err = net.Dial("tcp", mi.MasterAddr)
+		}
 		if err != nil {
-			log.Printf("Connect to master %s failed, sleep 1 second and try again.\n",
-				mi.MasterAddr)
+			log.Printf("Connect to master %s failed(%s), sleep 1 second and "+
+				"try again.\n", mi.MasterAddr, err)
 			time.Sleep(time.Second)
 			continue
 		}
@@ -132,7 +171,7 @@ func (slv *slave) doConnectToMaster() {
 		go cli.GoRecvRequest(slv.reqChan, slv)
 		go cli.GoSendResponse()
 
-		if len(slv.adminPwd) == 0 {
+		if len(slv.getAdminPwd()) == 0 {
 			err = slv.SendSlaveOfToMaster()
 			if err != nil {
 				log.Printf("SendSlaveOfToMaster failed(%s), close slave!", err)
@@ -154,6 +193,105 @@ func (slv *slave) doConnectToMaster() {
 	}
 }
 
+// Change the password for master auth, used when reconnecting to master.
+func (slv *slave) SetAdminPwd(pwd string) {
+	slv.mtx.Lock()
+	slv.adminPwd = pwd
+	slv.mtx.Unlock()
+}
+
+func (slv *slave) getAdminPwd() string {
+	slv.mtx.Lock()
+	defer slv.mtx.Unlock()
+	return slv.adminPwd
+}
+
+func (slv *slave) IsDelayed() bool {
+	return slv != nil && slv.delay > 0
+}
+
+// Buffer the change until it is older than the delay.
+// Buffered changes are dropped when the slave is closed or reconnected,
+// and master sends them again from the last applied seq.
+func (slv *slave) AddDelayReq(req *Request) {
+	select {
+	case slv.delayChan <- req:
+	case <-slv.quit:
+	}
+}
+
+func (slv *slave) isDelayReady(logTime int64) bool {
+	slv.mtx.Lock()
+	defer slv.mtx.Unlock()
+
+	if logTime <= slv.ffTime {
+		return true
+	}
+	if slv.paused {
+		return false
+	}
+	return time.Now().Unix() >= logTime+int64(slv.delay/time.Second)
+}
+
+func (slv *slave) goDelayApply(ch chan *Request) {
+	log.Printf("Delayed slave applies changes %s behind master\n", slv.delay)
+	for {
+		select {
+		case req := <-slv.delayChan:
+			var logTime = proto.GetLogTime(req.Pkg)
+			for !slv.isDelayReady(logTime) && !req.Cli.IsClosed() {
+				if slv.IsClosed() {
+					return
+				}
+				time.Sleep(delayCheckInterval)
+			}
+
+			if !req.Cli.IsClosed() {
+				ch <- req
+
+				if logTime > 0 {
+					slv.mtx.Lock()
+					slv.applyTime = logTime
+					slv.mtx.Unlock()
+				}
+			}
+		case <-slv.quit:
+			return
+		}
+	}
+}
+
+// Pause, resume or fast-forward the delayed apply, and get the status.
+func (slv *slave) DelayCtrl(p *ctrl.PkgDelay) error {
+	slv.mtx.Lock()
+	defer slv.mtx.Unlock()
+
+	switch p.Op {
+	case ctrl.DelayStatus:
+	case ctrl.DelayPause:
+		slv.paused = true
+		log.Printf("Pause delayed apply\n")
+	case ctrl.DelayResume:
+		slv.paused = false
+		log.Printf("Resume delayed apply\n")
+	case ctrl.DelayForward:
+		slv.ffTime = p.Until
+		if slv.ffTime == 0 {
+			slv.ffTime = time.Now().Unix()
+		}
+		log.Printf("Fast-forward delayed apply to %s\n",
+			time.Unix(slv.ffTime, 0).Format(time.RFC3339))
+	default:
+		return fmt.Errorf("invalid delay operation %d", p.Op)
+	}
+
+	p.Delay = int(slv.delay / time.Second)
+	p.Paused = slv.paused
+	p.Pending = len(slv.delayChan)
+	p.ApplyTime = slv.applyTime
+	return nil
+}
+
 func (slv *slave) SendSlaveOfToMaster() error {
 	slv.mtx.Lock()
 	var mi = slv.mi
@@ -171,7 +309,10 @@ func (slv *slave) SendSlaveOfToMaster() error {
 		p.ClientReq = false
 		p.MasterAddr = mi.MasterAddr
 		p.SlaveAddr = mi.SlaveAddr
-		p.SlotId = mi.SlotId
+		p.Slots = mi.Slots
+		if len(mi.Slots) > 0 {
+			p.SlotId = mi.Slots[0].Start
+		}
 
 		pkg, err = ctrl.Encode(proto.CmdMigrate, 0, 0, &p)
 		if err != nil {
@@ -191,6 +332,8 @@ func (slv *slave) SendSlaveOfToMaster() error {
 		p.MasterAddr = mi.MasterAddr
 		p.SlaveAddr = mi.SlaveAddr
 		p.LastSeq = lastSeq
+		p.Filter = mi.Filter
+		p.Delay = mi.Delay
 		log.Printf("Connect to master %s with lastSeq %d\n",
 			mi.MasterAddr, p.LastSeq)
 
@@ -204,24 +347,35 @@ func (slv *slave) SendSlaveOfToMaster() error {
 	return slv.mc.SetStatus(ctrl.SlaveFullSync)
 }
 
+// Start the challenge-response auth to master with the admin password.
 func (slv *slave) SendAuthToMaster() error {
 	slv.mtx.Lock()
 	var cli = slv.cli
+	var adminPwd = slv.adminPwd
 	slv.mtx.Unlock()
 	if cli == nil {
 		return nil
 	}
-	if len(slv.adminPwd) == 0 {
+	if len(adminPwd) == 0 {
 		return nil
 	}
 
+	nonce, err := util.NewScramNonce()
+	if err != nil {
+		return err
+	}
+
+	slv.mtx.Lock()
+	slv.serverSig = nil
+	slv.mtx.Unlock()
+
 	var p proto.PkgOneOp
 	p.DbId = proto.AdminDbId
-	p.Cmd = proto.CmdAuth
-	p.RowKey = []byte(slv.adminPwd)
+	p.Cmd = proto.CmdScram
+	p.RowKey = nonce
 
 	var pkg = make([]byte, p.Length())
-	_, err := p.Encode(pkg)
+	_, err = p.Encode(pkg)
 	if err != nil {
 		return err
 	}
@@ -230,6 +384,63 @@ func (slv *slave) SendAuthToMaster() error {
 	return nil
 }
 
+// Handle the challenge-response auth reply of master. It returns true when
+// slave is authorized and master is verified by the server signature.
+func (slv *slave) OnScramReply(in *proto.PkgOneOp) (bool, error) {
+	slv.mtx.Lock()
+	var cli = slv.cli
+	var adminPwd = slv.adminPwd
+	var serverSig = slv.serverSig
+	slv.serverSig = nil
+	slv.mtx.Unlock()
+
+	if serverSig != nil {
+		if !hmac.Equal(serverSig, in.Value) {
+			return false, errors.New("invalid server signature")
+		}
+		return true, nil
+	}
+
+	// No challenge: auth disabled on master
+	if len(in.Value) == 0 {
+		if string(in.RowKey) != util.ScramAuthDisabled {
+			return false, errors.New("no challenge from master")
+		}
+		return true, nil
+	}
+
+	var iter = int(in.Score)
+	if iter <= 0 || iter > util.ScramMaxIter {
+		return false, fmt.Errorf("invalid iter %d", iter)
+	}
+	if cli == nil {
+		return false, nil
+	}
+
+	var msg = util.ScramAuthMessage("", proto.AdminDbId, in.RowKey, in.Value,
+		iter)
+	proof, serverSig := util.ScramClientProof(adminPwd, in.Value, iter, msg)
+
+	slv.mtx.Lock()
+	slv.serverSig = serverSig
+	slv.mtx.Unlock()
+
+	var p proto.PkgOneOp
+	p.DbId = proto.AdminDbId
+	p.Cmd = proto.CmdScram
+	p.RowKey = in.RowKey
+	p.SetValue(proof)
+
+	var pkg = make([]byte, p.Length())
+	_, err := p.Encode(pkg)
+	if err != nil {
+		return false, err
+	}
+
+	cli.AddResp(pkg)
+	return false, nil
+}
+
 type master struct {
 	syncChan  chan struct{}
 	cli       *Client
@@ -237,17 +448,30 @@ type master struct {
 	reader    *binlog.Reader
 	slaveAddr string
 	lastSeq   uint64
-	migration bool   // true: Migration; false: Normal master/slave
-	slotId    uint16 // Only meaningful for migration
+	migration bool               // true: Migration; false: Normal master/slave
+	slots     *ctrl.SlotSet      // Only meaningful for migration
+	filter    *ctrl.SyncMatcher  // Only meaningful for normal slave, nil: no filter
+	sc        *config.SlotConfig // Migration: slots copied are asked to slave
+	syncBytes *util.RateLimiter  // Shared by all masters, nil: unlimited
+	syncKeys  *util.RateLimiter  // Shared by all masters, nil: unlimited
+	logTime   bool               // Delayed slave: send pkgs with log time
+	wg        *writeGate         // Paused to take the repair/verify snapshot
+
+	repairs  chan []ctrl.SlotRange // Slots to re-sync to normal slave
+	verifies chan verifyTask       // Slots to verify with normal slave
 
 	// atomic
-	closed uint32
+	closed  uint32
+	sentSeq uint64 // Last binlog seq sent to slave
 }
 
-func NewMaster(slaveAddr string, lastSeq uint64, migration bool, slotId uint16,
+func NewMaster(slaveAddr string, lastSeq uint64, migration bool,
+	slots *ctrl.SlotSet, filter *ctrl.SyncMatcher,
 	cli *Client, bin *binlog.BinLog) *master {
 	var ms = new(master)
 	ms.syncChan = make(chan struct{}, 20)
+	ms.repairs = make(chan []ctrl.SlotRange, 1)
+	ms.verifies = make(chan verifyTask, 1)
 	ms.cli = cli
 	ms.bin = bin
 	ms.reader = nil
@@ -255,9 +479,9 @@ func NewMaster(slaveAddr string, lastSeq uint64, migration bool, slotId uint16,
 	ms.lastSeq = lastSeq
 	ms.migration = migration
 	if migration {
-		ms.slotId = slotId
+		ms.slots = slots
 	} else {
-		ms.slotId = ctrl.TotalSlotNum
+		ms.filter = filter
 	}
 	ms.bin.RegisterMonitor(ms)
 
@@ -310,6 +534,46 @@ func (ms *master) syncStatus(key string, lastSeq uint64) {
 	ms.cli.AddResp(pkg)
 }
 
+func (ms *master) syncStatusValue(key string, value []byte) {
+	var p proto.PkgOneOp
+	p.Cmd = proto.CmdSyncSt
+	p.DbId = proto.AdminDbId
+	p.RowKey = []byte(key)
+	p.SetValue(value)
+	var pkg = make([]byte, p.Length())
+	p.Encode(pkg)
+	ms.cli.AddResp(pkg)
+}
+
+// Tell migration slave the slot under full sync, slots before it are copied.
+func (ms *master) syncSlot(slotId uint16) {
+	var v = make([]byte, 2)
+	binary.BigEndian.PutUint16(v, slotId)
+	ms.syncStatusValue(store.KeyFullSyncSlot, v)
+}
+
+// Tell slave the estimated bytes of full sync.
+func (ms *master) syncSize(tbl *store.Table) {
+	var slots = []ctrl.SlotRange{{Start: 0, End: ctrl.TotalSlotNum - 1}}
+	if ms.migration {
+		slots = ms.slots.Ranges()
+	}
+
+	var v = make([]byte, 8)
+	binary.BigEndian.PutUint64(v, tbl.GetSlotsSize(slots))
+	ms.syncStatusValue(store.KeyFullSyncSize, v)
+}
+
+// Tell slave the master binlog seq, and the last seq sent to slave.
+func (ms *master) syncSeq(sentSeq uint64) {
+	masterSeq, _ := ms.bin.GetLogSeqChanLen()
+
+	var v = make([]byte, 16)
+	binary.BigEndian.PutUint64(v, masterSeq)
+	binary.BigEndian.PutUint64(v[8:], sentSeq)
+	ms.syncStatusValue(store.KeySyncSeq, v)
+}
+
 func (ms *master) openReader(lastSeq uint64) error {
 	ms.reader = binlog.NewReader(ms.bin)
 	var err = ms.reader.Init(lastSeq)
@@ -357,20 +621,36 @@ func (ms *master) fullSync(tbl *store.Table) (uint64, error) {
 		return lastSeq, err
 	}
 
+	ms.syncSize(tbl)
+
 	// Full sync
 	var p proto.PkgMultiOp
+	var syncSlotId uint16
 	p.Cmd = proto.CmdSync
 	for it.SeekToFirst(); it.Valid(); {
-		ok := store.SeekAndCopySyncPkg(it, &p, ms.migration, ms.slotId)
+		ok := store.SeekAndCopySyncPkg(it, &p, ms.migration, ms.slots)
 
 		if ms.cli.IsClosed() {
 			return lastSeq, nil
 		}
 
+		if (ms.filter != nil || ms.migration) && len(p.Kvs) > 0 {
+			p.Kvs = ms.filterKvs(p.DbId, p.Kvs)
+		}
+
+		if ms.migration && len(p.Kvs) > 0 {
+			slotId := ctrl.GetSlotId(p.DbId, p.Kvs[0].TableId, p.Kvs[0].RowKey)
+			if slotId != syncSlotId {
+				syncSlotId = slotId
+				ms.syncSlot(slotId)
+			}
+		}
+
 		if len(p.Kvs) > 0 {
 			p.Seq = 0
 			var pkg = make([]byte, p.Length())
 			p.Encode(pkg)
+			ms.throttle(len(pkg), len(p.Kvs))
 			ms.cli.AddResp(pkg)
 		}
 
@@ -382,8 +662,8 @@ func (ms *master) fullSync(tbl *store.Table) (uint64, error) {
 	// Tell slave full sync finished
 	if ms.migration {
 		ms.syncStatus(store.KeyFullSyncEnd, 0)
-		log.Printf("Full migration to %s slotId %d finished\n",
-			ms.slaveAddr, ms.slotId)
+		log.Printf("Full migration to %s slots %s finished\n",
+			ms.slaveAddr, ms.slots)
 	} else {
 		ms.syncStatus(store.KeyFullSyncEnd, lastSeq)
 		log.Printf("Full sync to %s finished\n", ms.slaveAddr)
@@ -401,8 +681,8 @@ func (ms *master) GoAsync(tbl *store.Table) {
 	}
 
 	if ms.migration {
-		log.Printf("Start incremental migration to %s slotId %d, lastSeq=%d\n",
-			ms.slaveAddr, ms.slotId, lastSeq)
+		log.Printf("Start incremental migration to %s slots %s, lastSeq=%d\n",
+			ms.slaveAddr, ms.slots, lastSeq)
 	} else {
 		log.Printf("Start incremental sync to %s, lastSeq=%d",
 			ms.slaveAddr, lastSeq)
@@ -416,7 +696,23 @@ func (ms *master) GoAsync(tbl *store.Table) {
 	ms.NewLogComming()
 
 	var readyCount int64
+	var skipSeq uint64 // Seq of the last filtered pkg not told to slave
+	var sentSeq = lastSeq
+	atomic.StoreUint64(&ms.sentSeq, sentSeq)
+	var caughtUp bool // All binlog pkgs have been sent to slave
 	var head proto.PkgHead
+	var rp *repairTask
+	var vp *ctrl.PkgVerify // Verify snapshot not told to slave
+	defer func() {
+		if rp != nil {
+			rp.it.Destroy()
+		}
+		select {
+		case vt := <-ms.verifies:
+			vt.vf.fail(errors.New("slave connection is closed"))
+		default:
+		}
+	}()
 	var tick = time.Tick(time.Second)
 	for {
 		select {
@@ -426,8 +722,25 @@ func (ms *master) GoAsync(tbl *store.Table) {
 			}
 
 			for !ms.isClosed() && !ms.cli.IsClosed() {
+				if rp != nil && sentSeq >= rp.seq {
+					// All binlog before the repair snapshot has been sent
+					ms.repairSlots(rp, sentSeq)
+					rp = nil
+				}
+				if vp != nil && sentSeq >= vp.Seq {
+					// All binlog before the verify snapshot has been sent
+					ms.verifySnapshot(vp)
+					vp = nil
+				}
+
 				var pkg = ms.reader.Next()
 				if pkg == nil {
+					caughtUp = true
+					if skipSeq > 0 {
+						// Let slave catch up with the filtered seq
+						ms.syncStatus(store.KeyIncrSyncEnd, skipSeq)
+						skipSeq = 0
+					}
 					if readyCount%61 == 0 {
 						ms.syncStatus(store.KeyIncrSyncEnd, 0)
 						readyCount++
@@ -435,17 +748,45 @@ func (ms *master) GoAsync(tbl *store.Table) {
 					break
 				}
 
-				pkg, err := ms.convertMigPkg(pkg, &head)
+				pkg, err := ms.convertSyncPkg(pkg, &head)
 				if err != nil {
 					break
 				}
+				sentSeq = head.Seq
+				atomic.StoreUint64(&ms.sentSeq, sentSeq)
 				if pkg == nil {
+					if !ms.migration {
+						skipSeq = head.Seq
+					}
 					continue
 				}
 
+				skipSeq = 0
+				caughtUp = false
+				if ms.logTime {
+					// Binlog pkg is shared, append log time to a copy
+					var cp = make([]byte, len(pkg), len(pkg)+8)
+					copy(cp, pkg)
+					pkg = proto.AppendLogTime(cp, ms.getLogTime(head.Seq))
+				}
+				ms.throttle(len(pkg), syncKeyNum(pkg, head.Cmd))
 				ms.cli.AddResp(pkg)
 			}
 
+		case slots := <-ms.repairs:
+			if rp != nil {
+				log.Printf("Repair to %s is already running\n", ms.slaveAddr)
+				continue
+			}
+			rp = ms.newRepairTask(tbl, slots)
+			ms.NewLogComming()
+
+		case vt := <-ms.verifies:
+			it, seq := ms.snapshot(tbl)
+			vt.vf.start(it, seq, ms.filter)
+			vp = &ctrl.PkgVerify{Op: ctrl.VerifySnapshot, Slots: vt.slots, Seq: seq}
+			ms.NewLogComming()
+
 		case <-tick:
 			if ms.isClosed() || ms.cli.IsClosed() {
 				return
@@ -455,18 +796,215 @@ func (ms *master) GoAsync(tbl *store.Table) {
 				readyCount++
 			}
 
+			ms.syncSeq(sentSeq)
+
+			if ms.migration && ms.sc != nil && caughtUp {
+				// All keys of the migrating slots are copied
+				ms.sc.SetCopied(ms.slots.Ranges())
+			}
+
 			ms.NewLogComming()
 		}
 	}
 }
 
-func (ms *master) convertMigPkg(pkg []byte, head *proto.PkgHead) ([]byte, error) {
+// Get the write time of the binlog seq for delayed slave. Records without
+// write time (written by old versions) are delayed from now.
+func (ms *master) getLogTime(seq uint64) int64 {
+	var t = ms.bin.GetLogTime(seq)
+	if t == 0 {
+		t = time.Now().Unix()
+	}
+	return t
+}
+
+// Whether the key should be synced to slave
+func (ms *master) isSyncKey(dbId, tableId uint8, rowKey []byte) bool {
+	if dbId == proto.AdminDbId && tableId == 0 {
+		// Reserved admin table (DB passwords) is always replicated,
+		// but never migrated
+		return !ms.migration
+	}
+
+	if ms.migration {
+		return ms.slots.Has(ctrl.GetSlotId(dbId, tableId, rowKey))
+	}
+
+	return ms.filter.Match(dbId, tableId)
+}
+
+func (ms *master) filterKvs(dbId uint8, kvs []proto.KeyValue) []proto.KeyValue {
+	var res []proto.KeyValue
+	for i := 0; i < len(kvs); i++ {
+		if ms.isSyncKey(dbId, kvs[i].TableId, kvs[i].RowKey) {
+			res = append(res, kvs[i])
+		}
+	}
+	return res
+}
+
+// Slots to re-sync to the slave from a snapshot
+type repairTask struct {
+	slots *ctrl.SlotSet
+	it    *store.Iterator
+	seq   uint64 // Binlog seq of the snapshot
+}
+
+// Ask to re-sync the slots to the normal slave.
+func (ms *master) repair(slots []ctrl.SlotRange) error {
+	if ms.migration {
+		return errors.New("cannot repair migration slave")
+	}
+	if ms.isClosed() {
+		return errors.New("slave connection is closed")
+	}
+
+	select {
+	case ms.repairs <- slots:
+		return nil
+	default:
+		return errors.New("repair is already running")
+	}
+}
+
+// Take a snapshot and return it with its binlog seq.
+func (ms *master) snapshot(tbl *store.Table) (*store.Iterator, uint64) {
+	// Pause write, so that all writes committed before the snapshot have
+	// been added to binlog, and the snapshot is exactly at lastSeq
+	ms.wg.pause()
+	lastSeq, chanLen := ms.bin.GetLogSeqChanLen()
+	for chanLen != 0 {
+		time.Sleep(time.Millisecond)
+		lastSeq, chanLen = ms.bin.GetLogSeqChanLen()
+	}
+	var it = tbl.NewIterator(false)
+	ms.wg.resume()
+
+	return it, lastSeq
+}
+
+func (ms *master) newRepairTask(tbl *store.Table, slots []ctrl.SlotRange) *repairTask {
+	it, lastSeq := ms.snapshot(tbl)
+	return &repairTask{ctrl.NewSlotSet(slots), it, lastSeq}
+}
+
+// Tell the slave to delete the slots, and copy the slots from the snapshot,
+// the same way as migration full sync. The pkgs carry sentSeq, the seq of the
+// last binlog pkg sent, so the slave keeps its binlog seq in step with master
+// instead of assigning new seqs to the copied keys.
+func (ms *master) repairSlots(rp *repairTask, sentSeq uint64) {
+	defer rp.it.Destroy()
+
+	v, err := json.Marshal(rp.slots.Ranges())
+	if err != nil {
+		return
+	}
+	ms.syncStatusValue(store.KeyRepairSlots, v)
+
+	var rows int
+	var p proto.PkgMultiOp
+	p.Cmd = proto.CmdSync
+	for rp.it.SeekToFirst(); rp.it.Valid(); {
+		ok := store.SeekAndCopySyncPkg(rp.it, &p, true, rp.slots)
+
+		if ms.isClosed() || ms.cli.IsClosed() {
+			return
+		}
+
+		if ms.filter != nil && len(p.Kvs) > 0 {
+			p.Kvs = ms.filterKvs(p.DbId, p.Kvs)
+		}
+
+		if len(p.Kvs) > 0 {
+			p.Seq = sentSeq
+			var pkg = make([]byte, p.Length())
+			p.Encode(pkg)
+			ms.throttle(len(pkg), len(p.Kvs))
+			ms.cli.AddResp(pkg)
+			rows += len(p.Kvs)
+		}
+
+		if !ok {
+			break
+		}
+	}
+
+	log.Printf("Repair slots %s to %s finished, %d rows copied, seq=%d\n",
+		rp.slots, ms.slaveAddr, rows, rp.seq)
+}
+
+// Slots to verify with the normal slave
+type verifyTask struct {
+	vf    *verifier
+	slots []ctrl.SlotRange
+}
+
+// Ask to take the verify snapshot. Master starts computing checksums with
+// vf once the snapshot is taken.
+func (ms *master) verify(vf *verifier, slots []ctrl.SlotRange) error {
+	if ms.migration {
+		return errors.New("cannot verify migration slave")
+	}
+	if ms.isClosed() {
+		return errors.New("slave connection is closed")
+	}
+
+	select {
+	case ms.verifies <- verifyTask{vf, slots}:
+		return nil
+	default:
+		return errors.New("verification is already running")
+	}
+}
+
+// Tell the slave to take the verify snapshot. All binlog before the snapshot
+// seq has been sent, so the slave has applied them when it gets this.
+func (ms *master) verifySnapshot(p *ctrl.PkgVerify) {
+	v, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	ms.syncStatusValue(store.KeyVerifySlots, v)
+	log.Printf("Ask slave %s to take verify snapshot, seq=%d\n",
+		ms.slaveAddr, p.Seq)
+}
+
+// Wait until sending bytes and keys are within the rate limits.
+func (ms *master) throttle(bytes, keys int) {
+	if ms.syncBytes != nil {
+		ms.syncBytes.Wait(int64(bytes))
+	}
+	if ms.syncKeys != nil {
+		ms.syncKeys.Wait(int64(keys))
+	}
+}
+
+// Number of keys in the binlog pkg.
+func syncKeyNum(pkg []byte, cmd uint8) int {
+	switch cmd {
+	case proto.CmdSync:
+		fallthrough
+	case proto.CmdMIncr:
+		fallthrough
+	case proto.CmdMDel:
+		fallthrough
+	case proto.CmdMSet:
+		if len(pkg) >= proto.HeadSize+4 {
+			return int(binary.BigEndian.Uint16(pkg[proto.HeadSize+2:]))
+		}
+	}
+	return 1
+}
+
+// Filter binlog pkg by migration slot or replication filter.
+// Returns nil pkg if nothing to sync.
+func (ms *master) convertSyncPkg(pkg []byte, head *proto.PkgHead) ([]byte, error) {
 	_, err := head.Decode(pkg)
 	if err != nil {
 		return nil, err
 	}
 
-	if !ms.migration {
+	if !ms.migration && ms.filter == nil {
 		return pkg, nil
 	}
 
@@ -481,7 +1019,7 @@ func (ms *master) convertMigPkg(pkg []byte, head *proto.PkgHead) ([]byte, error)
 		if err != nil {
 			return nil, err
 		}
-		if ms.slotId == ctrl.GetSlotId(p.DbId, p.TableId, p.RowKey) {
+		if ms.isSyncKey(p.DbId, p.TableId, p.RowKey) {
 			return pkg, nil
 		} else {
 			return nil, nil
@@ -498,12 +1036,7 @@ func (ms *master) convertMigPkg(pkg []byte, head *proto.PkgHead) ([]byte, error)
 		if err != nil {
 			return nil, err
 		}
-		var kvs []proto.KeyValue
-		for i := 0; i < len(p.Kvs); i++ {
-			if ms.slotId == ctrl.GetSlotId(p.DbId, p.Kvs[i].TableId, p.Kvs[i].RowKey) {
-				kvs = append(kvs, p.Kvs[i])
-			}
-		}
+		var kvs = ms.filterKvs(p.DbId, p.Kvs)
 		if len(kvs) == 0 {
 			return nil, nil
 		} else {
@@ -517,5 +1050,8 @@ func (ms *master) convertMigPkg(pkg []byte, head *proto.PkgHead) ([]byte, error)
 		}
 	}
 
+	if !ms.migration {
+		return pkg, nil
+	}
 	return nil, nil
 }
@@ -1,1127 +1,2158 @@
-// Copyright 2015 stevejiang. All Rights Reserved.
-//
-// Licensed under the Apache License, Version 2.0 (the "License");
-// you may not use this file except in compliance with the License.
-// You may obtain a copy of the License at
-//
-//     http://www.apache.org/licenses/LICENSE-2.0
-//
-// Unless required by applicable law or agreed to in writing, software
-// distributed under the License is distributed on an "AS IS" BASIS,
-// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-// See the License for the specific language governing permissions and
-// limitations under the License.
-
-package server
-
-import (
-	"fmt"
-	"github.com/stevejiang/gotable/api/go/table"
-	"github.com/stevejiang/gotable/api/go/table/proto"
-	"github.com/stevejiang/gotable/binlog"
-	"github.com/stevejiang/gotable/config"
-	"github.com/stevejiang/gotable/ctrl"
-	"github.com/stevejiang/gotable/store"
-	"github.com/stevejiang/gotable/util"
-	"log"
-	"net"
-	"os"
-	"runtime"
-	"sync"
-	"sync/atomic"
-	"syscall"
-	"time"
-)
-
-type Server struct {
-	tbl     *store.Table
-	bin     *binlog.BinLog
-	conf    *config.Config
-	mc      *config.MasterConfig
-	reqChan *RequestChan
-
-	// Atomic
-	closed uint32
-
-	rwMtx     sync.RWMutex // protects following
-	slv       *slave
-	readyTime time.Time
-}
-
-func NewServer(conf *config.Config) *Server {
-	var tableDir = TableDirName(conf)
-	var binlogDir = BinLogDirName(conf)
-	var configDir = ConfigDirName(conf)
-
-	mc := config.NewMasterConfig(configDir)
-	if mc == nil {
-		return nil
-	}
-
-	err := clearSlaveOldData(conf, mc)
-	if err != nil {
-		return nil
-	}
-
-	srv := new(Server)
-	srv.conf = conf
-	srv.mc = mc
-	srv.tbl = store.NewTable(tableDir, getMaxOpenFiles(),
-		conf.Db.WriteBufSize, conf.Db.CacheSize, conf.Db.Compression)
-	if srv.tbl == nil {
-		return nil
-	}
-
-	srv.bin = binlog.NewBinLog(binlogDir,
-		conf.Bin.MemSize*1024*1024, conf.Bin.KeepNum)
-	if srv.bin == nil {
-		return nil
-	}
-
-	srv.reqChan = new(RequestChan)
-	srv.reqChan.WriteReqChan = make(chan *Request, 1024)
-	srv.reqChan.ReadReqChan = make(chan *Request, 1024)
-	srv.reqChan.SyncReqChan = make(chan *Request, 64)
-	srv.reqChan.DumpReqChan = make(chan *Request, 16)
-	srv.reqChan.CtrlReqChan = make(chan *Request, 16)
-
-	var maxProcs = conf.Db.MaxCpuNum
-	if maxProcs <= 0 {
-		maxProcs = runtime.NumCPU()
-	}
-	runtime.GOMAXPROCS(maxProcs)
-
-	log.Printf("NumCPU %d, GOMAXPROCS %d\n", runtime.NumCPU(), maxProcs)
-
-	var totalProcNum = maxProcs * 2
-	var writeProcNum = totalProcNum / 4
-	var readProcNum = totalProcNum - writeProcNum
-	if writeProcNum < 2 {
-		writeProcNum = 2
-	}
-	for i := 0; i < readProcNum; i++ {
-		go srv.processRead()
-	}
-	for i := 0; i < writeProcNum; i++ {
-		go srv.processWrite()
-	}
-	go srv.processSync() // Use 1 goroutine to make sure data consistency
-	go srv.processDump()
-	go srv.processCtrl()
-
-	log.Printf("Goroutine distribution: read %d, write %d, %s\n",
-		readProcNum, writeProcNum, "sync 1, dump 1, ctrl 1")
-
-	return srv
-}
-
-func (srv *Server) Start() {
-	var authEnabled bool
-	if srv.conf.Auth.AdminPwd != "" {
-		authEnabled = true
-		srv.tbl.SetPassword(proto.AdminDbId, srv.conf.Auth.AdminPwd)
-	}
-
-	// Normal slave, reconnect to master
-	hasMaster, migration, _ := srv.mc.GetMasterSlot()
-	if hasMaster && !migration {
-		lastSeq, valid := srv.bin.GetMasterSeq()
-		if !valid {
-			srv.mc.SetStatus(ctrl.SlaveNeedClear)
-			// Any better solution?
-			log.Fatalf("Slave lastSeq %d is out of sync, please clear old data! "+
-				"(Restart may fix this issue)", lastSeq)
-		}
-
-		srv.bin.AsSlave()
-		srv.connectToMaster(srv.mc)
-	}
-
-	link, err := net.Listen(srv.conf.Db.Network, srv.conf.Db.Address)
-	if err != nil {
-		log.Fatalln("Listen failed:", err)
-	}
-
-	log.Printf("GoTable %s started on %s://%s\n",
-		table.Version, srv.conf.Db.Network, srv.conf.Db.Address)
-
-	for {
-		if c, err := link.Accept(); err == nil {
-			//log.Printf("New connection %s\t%s\n", c.RemoteAddr(), c.LocalAddr())
-
-			cli := NewClient(c, authEnabled)
-			go cli.GoRecvRequest(srv.reqChan, nil)
-			go cli.GoSendResponse()
-		}
-	}
-}
-
-// Stop server and exit
-func (srv *Server) Close() {
-	if !srv.IsClosed() {
-		atomic.AddUint32(&srv.closed, 1)
-
-		// Flush data to file system
-		_, chanLen := srv.bin.GetLogSeqChanLen()
-		for i := 0; chanLen != 0 && i < 5000; i++ {
-			time.Sleep(time.Millisecond)
-			_, chanLen = srv.bin.GetLogSeqChanLen()
-		}
-
-		time.Sleep(time.Millisecond * 50)
-
-		srv.bin.Close()
-		//srv.tbl.Close()
-		os.Exit(0)
-	}
-}
-
-func (srv *Server) IsClosed() bool {
-	return atomic.LoadUint32(&srv.closed) > 0
-}
-
-func TableDirName(conf *config.Config) string {
-	return fmt.Sprintf("%s/table", conf.Db.Data)
-}
-
-func BinLogDirName(conf *config.Config) string {
-	return fmt.Sprintf("%s/binlog", conf.Db.Data)
-}
-
-func ConfigDirName(conf *config.Config) string {
-	return fmt.Sprintf("%s/config", conf.Db.Data)
-}
-
-func BackupDirName(conf *config.Config) string {
-	return fmt.Sprintf("%s/backup", conf.Db.Data)
-}
-
-func clearSlaveOldData(conf *config.Config, mc *config.MasterConfig) error {
-	var err error
-	m := mc.GetMaster()
-	// Normal slave
-	if len(m.MasterAddr) > 0 && !m.Migration {
-		// Check whether need to clear old data
-		if m.Status != ctrl.SlaveNeedClear && m.Status != ctrl.SlaveClear {
-			return nil
-		}
-
-		err = mc.SetStatus(ctrl.SlaveClear)
-		if err != nil {
-			return err
-		}
-
-		// Move binlog & table to backup in case you need the old data
-		var backupDir = BackupDirName(conf)
-		err = os.RemoveAll(backupDir)
-		if err != nil {
-			return err
-		}
-
-		err = os.MkdirAll(backupDir, os.ModeDir|os.ModePerm)
-		if err != nil {
-			return err
-		}
-
-		var binlogDir = BinLogDirName(conf)
-		log.Println("Move directory binlog to backup")
-		err = os.Rename(binlogDir, backupDir+"/binlog")
-		if err != nil {
-			return err
-		}
-
-		var tableDir = TableDirName(conf)
-		log.Println("Move directory table to backup")
-		err = os.Rename(tableDir, backupDir+"/table")
-		if err != nil {
-			return err
-		}
-
-		err = mc.SetStatus(ctrl.SlaveInit)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func getMaxOpenFiles() int {
-	var rlim syscall.Rlimit
-	var err = syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim)
-	if err == nil && rlim.Cur < rlim.Max {
-		rlim.Cur = rlim.Max
-		syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlim)
-	}
-	var maxOpenFiles = 1024
-	if maxOpenFiles < int(rlim.Cur) {
-		maxOpenFiles = int(rlim.Cur)
-	}
-	return maxOpenFiles
-}
-
-func (srv *Server) sendResp(write bool, req *Request, pkg []byte) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-
-		if pkg != nil {
-			req.Cli.AddResp(pkg)
-		}
-	}
-
-	switch cliType {
-	case ClientTypeNormal:
-		if write {
-			srv.bin.AddRequest(&binlog.Request{0, req.Pkg})
-		}
-	case ClientTypeSlave:
-		if write {
-			srv.bin.AddRequest(&binlog.Request{req.Seq, req.Pkg})
-		}
-	}
-}
-
-func (srv *Server) replyOneOp(req *Request, errCode int8) {
-	var out proto.PkgOneOp
-	out.Cmd = req.Cmd
-	out.DbId = req.DbId
-	out.Seq = req.Seq
-	out.ErrCode = errCode
-	if out.ErrCode != 0 {
-		out.CtrlFlag |= proto.CtrlErrCode
-	}
-
-	var pkg = make([]byte, out.Length())
-	_, err := out.Encode(pkg)
-	if err != nil {
-		log.Fatalf("Encode failed: %s\n", err)
-	}
-
-	srv.sendResp(false, req, pkg)
-}
-
-func (srv *Server) replyMultiOp(req *Request, errCode int8) {
-	var out proto.PkgMultiOp
-	out.Cmd = req.Cmd
-	out.DbId = req.DbId
-	out.Seq = req.Seq
-	out.ErrCode = errCode
-
-	var pkg = make([]byte, out.Length())
-	_, err := out.Encode(pkg)
-	if err != nil {
-		log.Fatalf("Encode failed: %s\n", err)
-	}
-
-	srv.sendResp(false, req, pkg)
-}
-
-func (srv *Server) auth(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-
-	switch cliType {
-	case ClientTypeNormal:
-		var pkg = srv.tbl.Auth(&req.PkgArgs, req.Cli)
-		srv.sendResp(false, req, pkg)
-	case ClientTypeSlave:
-		var in proto.PkgOneOp
-		_, err := in.Decode(req.Pkg)
-		if err != nil {
-			log.Printf("Decode failed for auth reply(%s), close slave!\n", err)
-		} else if in.ErrCode != 0 {
-			log.Printf("Auth failed (%d), close slave!\n", in.ErrCode)
-		}
-		if err != nil || in.ErrCode != 0 {
-			if req.Slv != nil {
-				req.Slv.Close()
-			} else {
-				req.Cli.Close()
-			}
-			return
-		}
-		// Slave auth succeed
-		if req.Slv != nil {
-			err = req.Slv.SendSlaveOfToMaster()
-			if err != nil {
-				log.Printf("SendSlaveOfToMaster failed(%s), close slave!\n", err)
-				req.Slv.Close()
-			}
-		}
-	case ClientTypeMaster:
-		log.Printf("Invalid client type %d for AUTH cmd, close now!\n", cliType)
-		req.Cli.Close()
-	}
-}
-
-func (srv *Server) ping(req *Request) {
-	srv.sendResp(false, req, req.Pkg)
-}
-
-func (srv *Server) get(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
-
-	var pkg = srv.tbl.Get(&req.PkgArgs, req.Cli, wa)
-	srv.sendResp(false, req, pkg)
-}
-
-func (srv *Server) set(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
-	switch cliType {
-	case ClientTypeNormal:
-		if !wa.Check() {
-			srv.replyOneOp(req, table.EcWriteSlave)
-			return
-		}
-		pkg, ok := srv.tbl.Set(&req.PkgArgs, req.Cli, wa)
-		srv.sendResp(ok, req, pkg)
-	case ClientTypeSlave:
-		pkg, ok := srv.tbl.Set(&req.PkgArgs, req.Cli, wa)
-		if ok {
-			srv.sendResp(ok, req, nil)
-		} else {
-			srv.sendResp(ok, req, pkg)
-		}
-	case ClientTypeMaster:
-		log.Printf("Slave SET failed: [%d, %d]\n", req.DbId, req.Seq)
-	}
-}
-
-func (srv *Server) del(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
-	switch cliType {
-	case ClientTypeNormal:
-		if !wa.Check() {
-			srv.replyOneOp(req, table.EcWriteSlave)
-			return
-		}
-		pkg, ok := srv.tbl.Del(&req.PkgArgs, req.Cli, wa)
-		srv.sendResp(ok, req, pkg)
-	case ClientTypeSlave:
-		pkg, ok := srv.tbl.Del(&req.PkgArgs, req.Cli, wa)
-		if ok {
-			srv.sendResp(ok, req, nil)
-		} else {
-			srv.sendResp(ok, req, pkg)
-		}
-	case ClientTypeMaster:
-		log.Printf("Slave DEL failed: [%d, %d]\n", req.DbId, req.Seq)
-	}
-}
-
-func (srv *Server) incr(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
-	switch cliType {
-	case ClientTypeNormal:
-		if !wa.Check() {
-			srv.replyOneOp(req, table.EcWriteSlave)
-			return
-		}
-		pkg, ok := srv.tbl.Incr(&req.PkgArgs, req.Cli, wa)
-		srv.sendResp(ok, req, pkg)
-	case ClientTypeSlave:
-		pkg, ok := srv.tbl.Incr(&req.PkgArgs, req.Cli, wa)
-		if ok {
-			srv.sendResp(ok, req, nil)
-		} else {
-			srv.sendResp(ok, req, pkg)
-		}
-	case ClientTypeMaster:
-		log.Printf("Slave INCR failed: [%d, %d]\n", req.DbId, req.Seq)
-	}
-}
-
-func (srv *Server) mGet(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
-
-	var pkg = srv.tbl.MGet(&req.PkgArgs, req.Cli, wa)
-	srv.sendResp(false, req, pkg)
-}
-
-func (srv *Server) mSet(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
-	switch cliType {
-	case ClientTypeNormal:
-		if !wa.Check() {
-			srv.replyMultiOp(req, table.EcWriteSlave)
-			return
-		}
-		pkg, ok := srv.tbl.MSet(&req.PkgArgs, req.Cli, wa)
-		srv.sendResp(ok, req, pkg)
-	case ClientTypeSlave:
-		pkg, ok := srv.tbl.MSet(&req.PkgArgs, req.Cli, wa)
-		if ok {
-			srv.sendResp(ok, req, nil)
-		} else {
-			srv.sendResp(ok, req, pkg)
-		}
-	case ClientTypeMaster:
-		log.Printf("Slave MSET failed: [%d, %d]\n", req.DbId, req.Seq)
-	}
-}
-
-func (srv *Server) mDel(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
-	switch cliType {
-	case ClientTypeNormal:
-		if !wa.Check() {
-			srv.replyMultiOp(req, table.EcWriteSlave)
-			return
-		}
-		pkg, ok := srv.tbl.MDel(&req.PkgArgs, req.Cli, wa)
-		srv.sendResp(ok, req, pkg)
-	case ClientTypeSlave:
-		pkg, ok := srv.tbl.MDel(&req.PkgArgs, req.Cli, wa)
-		if ok {
-			srv.sendResp(ok, req, nil)
-		} else {
-			srv.sendResp(ok, req, pkg)
-		}
-	case ClientTypeMaster:
-		log.Printf("Slave MDEL failed: [%d, %d]\n", req.DbId, req.Seq)
-	}
-}
-
-func (srv *Server) mIncr(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
-	switch cliType {
-	case ClientTypeNormal:
-		if !wa.Check() {
-			srv.replyMultiOp(req, table.EcWriteSlave)
-			return
-		}
-		pkg, ok := srv.tbl.MIncr(&req.PkgArgs, req.Cli, wa)
-		srv.sendResp(ok, req, pkg)
-	case ClientTypeSlave:
-		pkg, ok := srv.tbl.MIncr(&req.PkgArgs, req.Cli, wa)
-		if ok {
-			srv.sendResp(ok, req, nil)
-		} else {
-			srv.sendResp(ok, req, pkg)
-		}
-	case ClientTypeMaster:
-		log.Printf("Slave MINCR failed: [%d, %d]\n", req.DbId, req.Seq)
-	}
-}
-
-func (srv *Server) scan(req *Request) {
-	var pkg = srv.tbl.Scan(&req.PkgArgs, req.Cli)
-	srv.sendResp(false, req, pkg)
-}
-
-func (srv *Server) sync(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	switch cliType {
-	case ClientTypeSlave:
-		pkg, ok := srv.tbl.Sync(&req.PkgArgs)
-		if ok {
-			srv.sendResp(ok, req, nil)
-		} else {
-			srv.sendResp(ok, req, pkg)
-		}
-	case ClientTypeNormal:
-		log.Printf("User cannot send SYNC command, close now!\n")
-		req.Cli.Close()
-	case ClientTypeMaster:
-		log.Printf("Slave SYNC failed: [%d, %d], close now!\n", req.DbId, req.Seq)
-		req.Cli.Close()
-	}
-}
-
-func (srv *Server) syncStatus(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	switch cliType {
-	case ClientTypeSlave:
-		var in proto.PkgOneOp
-		_, err := in.Decode(req.Pkg)
-		if err != nil || len(in.RowKey) == 0 {
-			return
-		}
-
-		rowKey := string(in.RowKey)
-		switch rowKey {
-		case store.KeyFullSyncEnd:
-			srv.mc.SetStatus(ctrl.SlaveIncrSync)
-			log.Printf("Switch sync status to SlaveIncrSync\n")
-		case store.KeyIncrSyncEnd:
-			var st = srv.mc.Status()
-			srv.mc.SetStatus(ctrl.SlaveReady)
-
-			var now = time.Now()
-			srv.rwMtx.Lock()
-			var lastTime = srv.readyTime
-			srv.readyTime = now
-			srv.rwMtx.Unlock()
-
-			if st != ctrl.SlaveReady || now.Sub(lastTime).Seconds() > 120 {
-				log.Printf("Switch sync status to SlaveReady")
-			}
-		case store.KeySyncLogMissing:
-			srv.mc.SetStatus(ctrl.SlaveNeedClear)
-			lastSeq, _ := srv.bin.GetMasterSeq()
-			// Any better solution?
-			log.Fatalf("Slave lastSeq %d is out of sync, please clear old data! "+
-				"(Restart may fix this issue)", lastSeq)
-		}
-
-		if req.Seq > 0 {
-			in.RowKey = nil // Set it as an empty OP
-			req.Pkg = make([]byte, in.Length())
-			in.Encode(req.Pkg)
-			srv.sendResp(true, req, nil)
-		}
-	case ClientTypeNormal:
-		log.Printf("User cannot send SYNCST command\n")
-	case ClientTypeMaster:
-		log.Printf("Slave SYNCST failed: [%d, %d]\n", req.DbId, req.Seq)
-		req.Cli.Close()
-	}
-}
-
-func (srv *Server) dump(req *Request) {
-	var pkg = srv.tbl.Dump(&req.PkgArgs, req.Cli)
-	srv.sendResp(false, req, pkg)
-}
-
-// Normal master
-func (srv *Server) newNormalMaster(req *Request, p *ctrl.PkgSlaveOf) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	switch cliType {
-	case ClientTypeNormal:
-		if !req.Cli.IsAuth(proto.AdminDbId) {
-			log.Printf("Not authorized!\n")
-			srv.replySlaveOf(req, "no priviledge")
-			return
-		}
-
-		req.Cli.SetClientType(ClientTypeMaster) // switch client type
-
-		log.Printf("Receive a slave connection from %s (%s), lastSeq=%d\n",
-			p.SlaveAddr, req.Cli.c.RemoteAddr(), p.LastSeq)
-
-		ms := NewMaster(p.SlaveAddr, p.LastSeq, false, 0, req.Cli, srv.bin)
-		go ms.GoAsync(srv.tbl)
-	case ClientTypeSlave:
-		// Get response from master
-		log.Printf("Master failed(%s), close slave!\n", p.ErrMsg)
-		if req.Slv != nil {
-			req.Slv.Close()
-		} else {
-			req.Cli.Close()
-		}
-	case ClientTypeMaster:
-		log.Printf("Already master, cannot create master again, close now!\n")
-		req.Cli.Close()
-	}
-}
-
-// Migration master
-func (srv *Server) newMigMaster(req *Request, p *ctrl.PkgMigrate) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-	switch cliType {
-	case ClientTypeNormal:
-		if !req.Cli.IsAuth(proto.AdminDbId) {
-			log.Printf("Not authorized!\n")
-			srv.replyMigrate(req, "no priviledge")
-			return
-		}
-
-		req.Cli.SetClientType(ClientTypeMaster) // switch client type
-
-		log.Printf("Receive a migration slave connection from %s(%s)\n",
-			req.Cli.c.RemoteAddr(), p.SlaveAddr)
-
-		ms := NewMaster(p.SlaveAddr, 0, true, p.SlotId, req.Cli, srv.bin)
-		go ms.GoAsync(srv.tbl)
-	case ClientTypeSlave:
-		// Get response from master
-		log.Printf("Master failed(%s), close slave!\n", p.ErrMsg)
-		if req.Slv != nil {
-			req.Slv.Close()
-		} else {
-			req.Cli.Close()
-		}
-	case ClientTypeMaster:
-		log.Printf("Already master, cannot create master again, close now!\n")
-		req.Cli.Close()
-	}
-}
-
-func (srv *Server) replySlaveOf(req *Request, msg string) {
-	ps := ctrl.PkgSlaveOf{}
-	ps.ErrMsg = msg
-	pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &ps)
-	if err == nil {
-		srv.sendResp(false, req, pkg)
-	}
-}
-
-func (srv *Server) replyMigrate(req *Request, msg string) {
-	ps := ctrl.PkgMigrate{}
-	ps.ErrMsg = msg
-	pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &ps)
-	if err == nil {
-		srv.sendResp(false, req, pkg)
-	}
-}
-
-func sameAddress(masterAddr, slaveAddr string, req *Request) bool {
-	if masterAddr == slaveAddr {
-		return true
-	}
-
-	var mAddr = util.GetRealAddr(masterAddr, req.Cli.LocalAddr().String())
-	var sAddr = util.GetRealAddr(slaveAddr, req.Cli.LocalAddr().String())
-
-	return mAddr == sAddr || mAddr == req.Cli.LocalAddr().String()
-}
-
-func (srv *Server) slaveOf(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-
-	var p ctrl.PkgSlaveOf
-	var err = ctrl.Decode(req.Pkg, nil, &p)
-	if err == nil && !p.ClientReq {
-		srv.newNormalMaster(req, &p)
-		return
-	}
-
-	switch cliType {
-	case ClientTypeNormal:
-		if err != nil {
-			log.Printf("Failed to Decode pkg: %s\n", err)
-			srv.replySlaveOf(req, fmt.Sprintf("decode failed(%s)", err))
-			return
-		}
-
-		if !req.Cli.IsAuth(proto.AdminDbId) {
-			log.Printf("Not authorized!\n")
-			srv.replySlaveOf(req, "no priviledge")
-			return
-		}
-
-		if len(p.SlaveAddr) == 0 {
-			p.SlaveAddr = req.Cli.LocalAddr().String()
-		}
-		if sameAddress(p.MasterAddr, p.SlaveAddr, req) {
-			log.Printf("Master and slave addresses are the same!\n")
-			srv.replyMigrate(req, "master and slave addresses are the same")
-			return
-		}
-
-		err = srv.mc.SetMaster(p.MasterAddr, p.SlaveAddr)
-		if err != nil {
-			log.Printf("Failed to set config: %s\n", err)
-			srv.replySlaveOf(req, fmt.Sprintf("set config failed(%s)", err))
-			return
-		}
-
-		srv.rwMtx.Lock()
-		slv := srv.slv
-		srv.slv = nil
-		srv.rwMtx.Unlock()
-
-		if len(p.MasterAddr) > 0 {
-			if slv != nil {
-				slv.Close()
-			}
-			srv.bin.AsSlave()
-			srv.connectToMaster(srv.mc)
-		} else {
-			if slv != nil {
-				go slv.DelayClose()
-			}
-			srv.bin.AsMaster()
-		}
-
-		srv.replySlaveOf(req, "") // Success
-	case ClientTypeSlave:
-		fallthrough
-	case ClientTypeMaster:
-		if err != nil {
-			log.Printf("Failed to Decode pkg: %s\n", err)
-		} else {
-			log.Println("Invalid client type %d for SlaveOf command, close now!",
-				cliType)
-		}
-		req.Cli.Close()
-	}
-}
-
-func (srv *Server) migrate(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-
-	var p ctrl.PkgMigrate
-	var err = ctrl.Decode(req.Pkg, nil, &p)
-	if err == nil && !p.ClientReq {
-		srv.newMigMaster(req, &p)
-		return
-	}
-
-	switch cliType {
-	case ClientTypeNormal:
-		if err != nil {
-			log.Printf("Failed to Decode pkg: %s\n", err)
-			srv.replyMigrate(req, fmt.Sprintf("decode failed(%s)", err))
-			return
-		}
-
-		if !req.Cli.IsAuth(proto.AdminDbId) {
-			log.Printf("Not authorized!\n")
-			srv.replyMigrate(req, "no priviledge")
-			return
-		}
-
-		if len(p.SlaveAddr) == 0 {
-			p.SlaveAddr = req.Cli.LocalAddr().String()
-		}
-		if sameAddress(p.MasterAddr, p.SlaveAddr, req) {
-			log.Printf("Master and slave addresses are the same!\n")
-			srv.replyMigrate(req, "master and slave addresses are the same")
-			return
-		}
-
-		err = srv.mc.SetMigration(p.MasterAddr, p.SlaveAddr, p.SlotId)
-		if err != nil {
-			log.Printf("Failed to update migration config: %s\n", err)
-			srv.replyMigrate(req,
-				fmt.Sprintf("update migration config failed(%s)", err))
-			return
-		}
-
-		srv.rwMtx.Lock()
-		slv := srv.slv
-		srv.slv = nil
-		srv.rwMtx.Unlock()
-
-		if len(p.MasterAddr) > 0 {
-			if slv != nil {
-				slv.Close()
-			}
-			if srv.tbl.HasSlotData(p.SlotId) {
-				err = srv.mc.SetStatus(ctrl.SlaveNeedClear)
-				if err != nil {
-					log.Printf("Failed to set migration status: %s\n", err)
-					srv.replyMigrate(req,
-						fmt.Sprintf("failed to set migration status(%s)", err))
-					return
-				}
-			} else {
-				srv.connectToMaster(srv.mc)
-			}
-		} else {
-			if slv != nil {
-				go slv.DelayClose()
-			}
-			srv.bin.AsMaster()
-		}
-
-		srv.replyMigrate(req, "") // Success
-	case ClientTypeSlave:
-		fallthrough
-	case ClientTypeMaster:
-		if err != nil {
-			log.Printf("Failed to Decode pkg: %s\n", err)
-		} else {
-			log.Println("Invalid client type %d for Migrate command, close now!",
-				cliType)
-		}
-		req.Cli.Close()
-	}
-}
-
-func (srv *Server) connectToMaster(mc *config.MasterConfig) {
-	var slv = NewSlave(srv.reqChan, srv.bin, mc, srv.conf.Auth.AdminPwd)
-
-	srv.rwMtx.Lock()
-	srv.slv = slv
-	srv.rwMtx.Unlock()
-
-	go slv.GoConnectToMaster()
-}
-
-func (srv *Server) slaveStatus(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-
-	switch cliType {
-	case ClientTypeNormal:
-		var p ctrl.PkgSlaveStatus
-		var err = ctrl.Decode(req.Pkg, nil, &p)
-		p.ErrMsg = ""
-		if err != nil {
-			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
-		} else {
-			m := srv.mc.GetMaster()
-			if len(m.MasterAddr) > 0 {
-				if p.Migration {
-					if !m.Migration {
-						p.ErrMsg = fmt.Sprintf("check migration status on normal slave")
-					} else if m.SlotId != p.SlotId {
-						p.ErrMsg = fmt.Sprintf("slot id mismatch (%d, %d)",
-							m.SlotId, p.SlotId)
-					}
-				} else {
-					if m.Migration {
-						p.ErrMsg = fmt.Sprintf("check normal slave status on migration")
-					}
-				}
-			}
-			if len(p.ErrMsg) == 0 {
-				p.Status = m.Status
-			}
-		}
-
-		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
-		if err == nil {
-			srv.sendResp(false, req, pkg)
-		}
-	case ClientTypeSlave:
-		fallthrough
-	case ClientTypeMaster:
-		log.Println("Invalid client type %d for MigStatus command, close now!",
-			cliType)
-		req.Cli.Close()
-	}
-}
-
-func (srv *Server) deleteMigrationSlot(slotId uint16, m config.MasterInfo) error {
-	var match bool
-	if len(m.MasterAddr) > 0 && m.Migration && m.SlotId == slotId {
-		match = true
-	}
-
-	var err error
-	if match {
-		err = srv.mc.SetStatus(ctrl.SlaveClear)
-		if err != nil {
-			return err
-		}
-	}
-
-	err = srv.tbl.DeleteSlot(slotId)
-	if err != nil {
-		return err
-	}
-
-	if match {
-		// Set as NotSlave, need a new Migrate command
-		err = srv.mc.SetStatus(ctrl.NotSlave)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func (srv *Server) deleteSlot(req *Request) {
-	var cliType uint32 = ClientTypeNormal
-	if req.Cli != nil {
-		cliType = req.Cli.ClientType()
-	}
-
-	switch cliType {
-	case ClientTypeNormal:
-		var p ctrl.PkgDelSlot
-		var err = ctrl.Decode(req.Pkg, nil, &p)
-		p.ErrMsg = ""
-		if err != nil {
-			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
-		} else {
-			err = srv.deleteMigrationSlot(p.SlotId, srv.mc.GetMaster())
-			if err != nil {
-				p.ErrMsg = fmt.Sprintf("delete slot failed %s", err)
-			}
-		}
-
-		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
-		if err == nil {
-			srv.sendResp(false, req, pkg)
-		}
-	case ClientTypeSlave:
-		fallthrough
-	case ClientTypeMaster:
-		log.Println("Invalid client type %d for DelSlot command, close now!",
-			cliType)
-		req.Cli.Close()
-	}
-}
-
-func (srv *Server) processRead() {
-	for {
-		select {
-		case req := <-srv.reqChan.ReadReqChan:
-			if srv.IsClosed() {
-				continue
-			}
-			if !req.Cli.IsClosed() {
-				switch req.Cmd {
-				case proto.CmdAuth:
-					srv.auth(req)
-				case proto.CmdPing:
-					srv.ping(req)
-				case proto.CmdGet:
-					srv.get(req)
-				case proto.CmdMGet:
-					srv.mGet(req)
-				case proto.CmdScan:
-					srv.scan(req)
-				}
-			}
-		}
-	}
-}
-
-func (srv *Server) processWrite() {
-	for {
-		select {
-		case req := <-srv.reqChan.WriteReqChan:
-			if srv.IsClosed() {
-				continue
-			}
-			if !req.Cli.IsClosed() {
-				switch req.Cmd {
-				case proto.CmdSet:
-					srv.set(req)
-				case proto.CmdDel:
-					srv.del(req)
-				case proto.CmdIncr:
-					srv.incr(req)
-				case proto.CmdMSet:
-					srv.mSet(req)
-				case proto.CmdMDel:
-					srv.mDel(req)
-				case proto.CmdMIncr:
-					srv.mIncr(req)
-				}
-			}
-		}
-	}
-}
-
-func (srv *Server) processSync() {
-	for {
-		select {
-		case req := <-srv.reqChan.SyncReqChan:
-			if srv.IsClosed() {
-				continue
-			}
-			if !req.Cli.IsClosed() {
-				switch req.Cmd {
-				case proto.CmdSet:
-					srv.set(req)
-				case proto.CmdDel:
-					srv.del(req)
-				case proto.CmdIncr:
-					srv.incr(req)
-				case proto.CmdMSet:
-					srv.mSet(req)
-				case proto.CmdMDel:
-					srv.mDel(req)
-				case proto.CmdMIncr:
-					srv.mIncr(req)
-				case proto.CmdSync:
-					srv.sync(req)
-				case proto.CmdSyncSt:
-					srv.syncStatus(req)
-				}
-			}
-		}
-	}
-}
-
-func (srv *Server) processDump() {
-	for {
-		select {
-		case req := <-srv.reqChan.DumpReqChan:
-			if srv.IsClosed() {
-				continue
-			}
-			if !req.Cli.IsClosed() {
-				switch req.Cmd {
-				case proto.CmdDump:
-					srv.dump(req)
-				}
-			}
-		}
-	}
-}
-
-func (srv *Server) processCtrl() {
-	for {
-		select {
-		case req := <-srv.reqChan.CtrlReqChan:
-			if srv.IsClosed() {
-				continue
-			}
-			if !req.Cli.IsClosed() {
-				switch req.Cmd {
-				case proto.CmdSlaveOf:
-					srv.slaveOf(req)
-				case proto.CmdMigrate:
-					srv.migrate(req)
-				case proto.CmdSlaveSt:
-					srv.slaveStatus(req)
-				case proto.CmdDelSlot:
-					srv.deleteSlot(req)
-				}
-			}
-		}
-	}
-}
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/binlog"
+	"github.com/stevejiang/gotable/config"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/store"
+	"github.com/stevejiang/gotable/util"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// Default time the master waits for the slave in switchover
+	switchoverTimeout = time.Second * 10
+	// Default time to wait for the queued requests on shutdown
+	shutdownTimeout = time.Second * 30
+)
+
+type Server struct {
+	tbl     *store.Table
+	bin     *binlog.BinLog
+	setMtx  sync.Mutex     // serializes config changes
+	confMtx sync.Mutex     // protects conf
+	conf    *config.Config // Replaced by a new copy when changed
+	mc      *config.MasterConfig
+	sc      *config.SlotConfig
+	reqChan *RequestChan
+
+	syncBytes *util.RateLimiter // Limits bytes sent to slaves per second
+	syncKeys  *util.RateLimiter // Limits keys sent to slaves per second
+	vf        verifier
+	st        *serverStats
+	slow      *slowLog
+	cl        *clientList
+	replTLS   *tls.Config // TLS config of connecting to master, nil if disabled
+
+	readProcNum  int // Number of read goroutines
+	writeProcNum int // Number of write goroutines
+
+	// Atomic
+	closed   uint32
+	stopping uint32 // Shutting down
+	busy     int64  // Requests being processed by read/write/sync goroutines
+	switchOn uint32 // Switchover in progress
+
+	wg        *writeGate
+	rwMtx     sync.RWMutex // protects following
+	slv       *slave
+	readyTime time.Time
+	masters   map[string]*master // Normal slave address => master
+	link      net.Listener
+}
+
+func NewServer(conf *config.Config) *Server {
+	var tableDir = TableDirName(conf)
+	var binlogDir = BinLogDirName(conf)
+	var configDir = ConfigDirName(conf)
+
+	mc := config.NewMasterConfig(configDir)
+	if mc == nil {
+		return nil
+	}
+
+	err := clearSlaveOldData(conf, mc)
+	if err != nil {
+		return nil
+	}
+
+	srv := new(Server)
+	srv.conf = conf
+	srv.st = newServerStats()
+	srv.cl = newClientList()
+	srv.wg = newWriteGate()
+	srv.mc = mc
+	srv.sc = config.NewSlotConfig(configDir)
+	if srv.sc == nil {
+		return nil
+	}
+	srv.tbl = store.NewTable(tableDir, getMaxOpenFiles(),
+		conf.Db.WriteBufSize, conf.Db.CacheSize, conf.Db.Compression,
+		conf.Db.RateLimit*1024*1024)
+	if srv.tbl == nil {
+		return nil
+	}
+	srv.tbl.LoadPasswords()
+	srv.tbl.LoadUsers()
+
+	srv.slow, err = newSlowLog(conf.Slow.SlowerThan, conf.Slow.MaxLen,
+		conf.Slow.File)
+	if err != nil {
+		log.Printf("Open slow log file failed: %s\n", err)
+		return nil
+	}
+
+	srv.syncBytes = util.NewRateLimiter(conf.Repl.MaxMBps * 1024 * 1024)
+	srv.syncKeys = util.NewRateLimiter(conf.Repl.MaxKeys)
+
+	srv.bin = binlog.NewBinLog(binlogDir,
+		conf.Bin.MemSize*1024*1024, conf.Bin.KeepNum)
+	if srv.bin == nil {
+		return nil
+	}
+
+	srv.reqChan = new(RequestChan)
+	srv.reqChan.WriteReqChan = make(chan *Request, 1024)
+	srv.reqChan.ReadReqChan = make(chan *Request, 1024)
+	srv.reqChan.SyncReqChan = make(chan *Request, 64)
+	srv.reqChan.DumpReqChan = make(chan *Request, 16)
+	srv.reqChan.CtrlReqChan = make(chan *Request, 16)
+
+	var maxProcs = conf.Db.MaxCpuNum
+	if maxProcs <= 0 {
+		maxProcs = runtime.NumCPU()
+	}
+	runtime.GOMAXPROCS(maxProcs)
+
+	log.Printf("NumCPU %d, GOMAXPROCS %d\n", runtime.NumCPU(), maxProcs)
+
+	var totalProcNum = maxProcs * 2
+	var writeProcNum = totalProcNum / 4
+	var readProcNum = totalProcNum - writeProcNum
+	if writeProcNum < 2 {
+		writeProcNum = 2
+	}
+	for i := 0; i < readProcNum; i++ {
+		go srv.processRead()
+	}
+	for i := 0; i < writeProcNum; i++ {
+		go srv.processWrite()
+	}
+	srv.readProcNum = readProcNum
+	srv.writeProcNum = writeProcNum
+	go srv.processSync() // Use 1 goroutine to make sure data consistency
+	go srv.processDump()
+	go srv.processCtrl()
+
+	log.Printf("Goroutine distribution: read %d, write %d, %s\n",
+		readProcNum, writeProcNum, "sync 1, dump 1, ctrl 1")
+
+	return srv
+}
+
+func (srv *Server) Start() {
+	var conf = srv.getConf()
+	var authEnabled bool
+	if conf.Auth.AdminPwd != "" {
+		authEnabled = true
+		srv.tbl.SetPassword(proto.AdminDbId, conf.Auth.AdminPwd)
+		warnPlainAuth(conf)
+	}
+
+	srvTLS, err := newServerTLSConfig(conf)
+	if err != nil {
+		log.Fatalln("Load TLS config failed:", err)
+	}
+	srv.replTLS, err = newReplTLSConfig(conf)
+	if err != nil {
+		log.Fatalln("Load TLS config of replication failed:", err)
+	}
+
+	// Normal slave, reconnect to master
+	hasMaster, migration, _ := srv.mc.GetMasterSlot()
+	if hasMaster && !migration {
+		lastSeq, valid := srv.bin.GetMasterSeq()
+		if !valid {
+			srv.mc.SetStatus(ctrl.SlaveNeedClear)
+			// Any better solution?
+			log.Fatalf("Slave lastSeq %d is out of sync, please clear old data! "+
+				"(Restart may fix this issue)", lastSeq)
+		}
+
+		srv.bin.AsSlave()
+		srv.connectToMaster(srv.mc)
+	}
+
+	link, err := net.Listen(conf.Db.Network, conf.Db.Address)
+	if err != nil {
+		log.Fatalln("Listen failed:", err)
+	}
+	if srvTLS != nil {
+		link = tls.NewListener(link, srvTLS)
+		log.Printf("TLS enabled, client certificate: %s\n", conf.TLS.ClientCert)
+	}
+
+	srv.rwMtx.Lock()
+	srv.link = link
+	srv.rwMtx.Unlock()
+
+	log.Printf("GoTable %s started on %s://%s\n",
+		table.Version, conf.Db.Network, conf.Db.Address)
+
+	if conf.Db.IdleTimeout > 0 {
+		go srv.goCloseIdleClients(
+			time.Duration(conf.Db.IdleTimeout) * time.Second)
+	}
+
+	var maxClients = int64(conf.Db.MaxClients)
+	for {
+		if c, err := link.Accept(); err == nil {
+			//log.Printf("New connection %s\t%s\n", c.RemoteAddr(), c.LocalAddr())
+
+			if maxClients > 0 && atomic.LoadInt64(&srv.st.clients) >= maxClients {
+				log.Printf("Max clients %d reached, close new connection %s\n",
+					maxClients, c.RemoteAddr())
+				c.Close()
+				continue
+			}
+
+			cli := NewClient(c, authEnabled)
+			cli.stats = srv.st
+			srv.cl.add(cli)
+			atomic.AddInt64(&srv.st.clients, 1)
+			atomic.AddUint64(&srv.st.totalConns, 1)
+			if tc, ok := c.(*tls.Conn); ok {
+				go srv.goServeTLS(cli, tc)
+			} else {
+				go cli.GoRecvRequest(srv.reqChan, nil)
+				go cli.GoSendResponse()
+			}
+		} else if srv.isStopping() {
+			select {} // Wait for Shutdown to exit
+		}
+	}
+}
+
+// Stop server and exit, the same as Shutdown with the default timeout.
+func (srv *Server) Close() {
+	srv.Shutdown(shutdownTimeout)
+}
+
+// Shutdown stops accepting connections and requests, waits at most timeout
+// for the queued requests, flushes and closes the binlog and the table, and
+// then exits.
+func (srv *Server) Shutdown(timeout time.Duration) {
+	if !atomic.CompareAndSwapUint32(&srv.stopping, 0, 1) {
+		return // Already shutting down
+	}
+	log.Printf("Shutdown server, timeout %s\n", timeout)
+
+	srv.reqChan.Stop()
+	srv.rwMtx.Lock()
+	var link = srv.link
+	var slv = srv.slv
+	srv.rwMtx.Unlock()
+	if link != nil {
+		link.Close()
+	}
+
+	// Wait for the queued requests
+	var deadline = time.Now().Add(timeout)
+	for srv.pendingRequests() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := srv.pendingRequests(); n > 0 {
+		log.Printf("Shutdown timeout, %d requests not processed\n", n)
+	}
+
+	atomic.AddUint32(&srv.closed, 1)
+	for i := 0; atomic.LoadInt64(&srv.busy) > 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// Stop replication, and close clients after sending the responses
+	if slv != nil {
+		slv.Close()
+	}
+	var clients = srv.cl.getClients()
+	for i := 0; i < 100 && hasPendingResp(clients); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	for _, c := range clients {
+		c.Close()
+	}
+
+	// Flush data to file system
+	_, chanLen := srv.bin.GetLogSeqChanLen()
+	for i := 0; chanLen != 0 && i < 5000; i++ {
+		time.Sleep(time.Millisecond)
+		_, chanLen = srv.bin.GetLogSeqChanLen()
+	}
+
+	time.Sleep(time.Millisecond * 50)
+
+	srv.bin.Close()
+	srv.tbl.Close()
+	log.Println("Server shutdown")
+	os.Exit(0)
+}
+
+func (srv *Server) shutdown(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgShutdown
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = fmt.Sprintf("no priviledge")
+		} else if p.Timeout < 0 {
+			p.ErrMsg = fmt.Sprintf("invalid timeout %d", p.Timeout)
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+
+		if len(p.ErrMsg) == 0 {
+			var timeout = shutdownTimeout
+			if p.Timeout > 0 {
+				timeout = time.Duration(p.Timeout) * time.Second
+			}
+			log.Printf("Shutdown by client %s\n", req.Cli.RemoteAddr())
+			go srv.Shutdown(timeout)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Shutdown command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) isStopping() bool {
+	return atomic.LoadUint32(&srv.stopping) > 0
+}
+
+// Number of requests queued or being processed by read/write/sync goroutines.
+func (srv *Server) pendingRequests() int {
+	return len(srv.reqChan.ReadReqChan) + len(srv.reqChan.WriteReqChan) +
+		len(srv.reqChan.SyncReqChan) + int(atomic.LoadInt64(&srv.busy))
+}
+
+func hasPendingResp(clients []*Client) bool {
+	for _, c := range clients {
+		if !c.IsClosed() && len(c.respChan) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (srv *Server) IsClosed() bool {
+	return atomic.LoadUint32(&srv.closed) > 0
+}
+
+func TableDirName(conf *config.Config) string {
+	return fmt.Sprintf("%s/table", conf.Db.Data)
+}
+
+func BinLogDirName(conf *config.Config) string {
+	return fmt.Sprintf("%s/binlog", conf.Db.Data)
+}
+
+func ConfigDirName(conf *config.Config) string {
+	return fmt.Sprintf("%s/config", conf.Db.Data)
+}
+
+func BackupDirName(conf *config.Config) string {
+	return fmt.Sprintf("%s/backup", conf.Db.Data)
+}
+
+func clearSlaveOldData(conf *config.Config, mc *config.MasterConfig) error {
+	var err error
+	m := mc.GetMaster()
+	// Normal slave
+	if len(m.MasterAddr) > 0 && !m.Migration {
+		// Check whether need to clear old data
+		if m.Status != ctrl.SlaveNeedClear && m.Status != ctrl.SlaveClear {
+			return nil
+		}
+
+		err = mc.SetStatus(ctrl.SlaveClear)
+		if err != nil {
+			return err
+		}
+
+		// Move binlog & table to backup in case you need the old data
+		var backupDir = BackupDirName(conf)
+		err = os.RemoveAll(backupDir)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(backupDir, os.ModeDir|os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		var binlogDir = BinLogDirName(conf)
+		log.Println("Move directory binlog to backup")
+		err = os.Rename(binlogDir, backupDir+"/binlog")
+		if err != nil {
+			return err
+		}
+
+		var tableDir = TableDirName(conf)
+		log.Println("Move directory table to backup")
+		err = os.Rename(tableDir, backupDir+"/table")
+		if err != nil {
+			return err
+		}
+
+		err = mc.SetStatus(ctrl.SlaveInit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getMaxOpenFiles() int {
+	var rlim syscall.Rlimit
+	var err = syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim)
+	if err == nil && rlim.Cur < rlim.Max {
+		rlim.Cur = rlim.Max
+		syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlim)
+	}
+	var maxOpenFiles = 1024
+	if maxOpenFiles < int(rlim.Cur) {
+		maxOpenFiles = int(rlim.Cur)
+	}
+	return maxOpenFiles
+}
+
+func (srv *Server) sendResp(write bool, req *Request, pkg []byte) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		if write {
+			var logSeq = srv.bin.AddRequest(&binlog.Request{Pkg: req.Pkg})
+			if pkg != nil {
+				// Tell client the binlog seq of this write
+				pkg = proto.AppendLogSeq(pkg, logSeq)
+			}
+		}
+	case ClientTypeSlave:
+		if write {
+			srv.bin.AddRequest(&binlog.Request{MasterSeq: req.Seq, Pkg: req.Pkg})
+		}
+	}
+
+	if req.Cli != nil && pkg != nil {
+		req.Cli.AddResp(pkg)
+		if req.Cli.stats != nil {
+			var d = time.Since(req.recvTime)
+			req.Cli.stats.observe(req.Cmd, d)
+			srv.slow.check(req, d)
+		}
+	}
+}
+
+// Check whether the slave has synced the binlog seq required by the read
+// request. Returns false at once if the slave is still behind, so that the
+// read workers are never blocked by a lagging slave; the client retries.
+func (srv *Server) checkLogSeq(req *Request) bool {
+	if len(req.Pkg) <= proto.HeadSize ||
+		req.Pkg[proto.HeadSize]&proto.FlagLogSeq == 0 {
+		return true
+	}
+
+	hasMaster, migration, _ := srv.mc.GetMasterSlot()
+	if !hasMaster || migration {
+		return true // Master always has the latest data
+	}
+
+	var logSeq uint64
+	var err error
+	switch req.Cmd {
+	case proto.CmdGet:
+		var in proto.PkgOneOp
+		_, err = in.Decode(req.Pkg)
+		logSeq = in.LogSeq
+	case proto.CmdMGet:
+		var in proto.PkgMultiOp
+		_, err = in.Decode(req.Pkg)
+		logSeq = in.LogSeq
+	case proto.CmdScan:
+		var in proto.PkgScanReq
+		_, err = in.Decode(req.Pkg)
+		logSeq = in.LogSeq
+	}
+	if err != nil {
+		return true // Let the table report the decode error
+	}
+
+	masterSeq, _ := srv.bin.GetMasterSeq()
+	return masterSeq >= logSeq
+}
+
+func (srv *Server) replyOneOp(req *Request, errCode int8) {
+	var out proto.PkgOneOp
+	out.Cmd = req.Cmd
+	out.DbId = req.DbId
+	out.Seq = req.Seq
+	out.ErrCode = errCode
+	if out.ErrCode != 0 {
+		out.CtrlFlag |= proto.CtrlErrCode
+	}
+
+	var pkg = make([]byte, out.Length())
+	_, err := out.Encode(pkg)
+	if err != nil {
+		log.Fatalf("Encode failed: %s\n", err)
+	}
+
+	srv.sendResp(false, req, pkg)
+}
+
+func (srv *Server) replyMultiOp(req *Request, errCode int8) {
+	var out proto.PkgMultiOp
+	out.Cmd = req.Cmd
+	out.DbId = req.DbId
+	out.Seq = req.Seq
+	out.ErrCode = errCode
+
+	var pkg = make([]byte, out.Length())
+	_, err := out.Encode(pkg)
+	if err != nil {
+		log.Fatalf("Encode failed: %s\n", err)
+	}
+
+	srv.sendResp(false, req, pkg)
+}
+
+// Redirect the request by EcMoved/EcAsk if its slots are not served here.
+// Return true if the request is redirected. A multi-key request is redirected
+// only if all keys go to the same server, otherwise it fails by EcCrossSlot.
+// Request redirected by EcAsk from the old owner can write migrating slots.
+func (srv *Server) redirect(req *Request, wa *store.WriteAccess) bool {
+	if req.Cli == nil || req.Cli.ClientType() != ClientTypeNormal ||
+		len(req.Pkg) <= proto.HeadSize {
+		return false
+	}
+
+	var asking = req.Pkg[proto.HeadSize]&proto.FlagAsking != 0
+	if wa != nil && asking {
+		wa.SetAsking(true)
+	}
+
+	if !srv.sc.HasRedirect() {
+		return false
+	}
+
+	var multi bool
+	var slotIds []uint16
+	switch req.Cmd {
+	case proto.CmdGet:
+		fallthrough
+	case proto.CmdSet:
+		fallthrough
+	case proto.CmdDel:
+		fallthrough
+	case proto.CmdIncr:
+		var in proto.PkgOneOp
+		_, err := in.Decode(req.Pkg)
+		if err != nil {
+			return false
+		}
+		slotIds = append(slotIds, ctrl.GetSlotId(in.DbId, in.TableId, in.RowKey))
+	case proto.CmdMGet:
+		fallthrough
+	case proto.CmdMSet:
+		fallthrough
+	case proto.CmdMDel:
+		fallthrough
+	case proto.CmdMIncr:
+		var in proto.PkgMultiOp
+		_, err := in.Decode(req.Pkg)
+		if err != nil {
+			return false
+		}
+		for i := 0; i < len(in.Kvs); i++ {
+			slotIds = append(slotIds,
+				ctrl.GetSlotId(in.DbId, in.Kvs[i].TableId, in.Kvs[i].RowKey))
+		}
+		multi = true
+	case proto.CmdScan:
+		var in proto.PkgScanReq
+		_, err := in.Decode(req.Pkg)
+		if err != nil {
+			return false
+		}
+		slotIds = append(slotIds, ctrl.GetSlotId(in.DbId, in.TableId, in.RowKey))
+		multi = true
+	}
+
+	var errCode int8
+	var addr string
+	for i, slotId := range slotIds {
+		var ec int8
+		state, a := srv.sc.Redirect(slotId)
+		switch state {
+		case ctrl.SlotMoved:
+			ec = table.EcMoved
+		case ctrl.SlotMigrating:
+			ec = table.EcAsk
+		case ctrl.SlotNone:
+			// Importing slots in cluster mode are served for EcAsk
+			if !asking {
+				ec = table.EcMoved
+			}
+		}
+		if ec == 0 {
+			a = ""
+		}
+
+		if i == 0 {
+			errCode, addr = ec, a
+		} else if ec != errCode || a != addr {
+			// Keys are served by different servers, the request can be
+			// neither served here nor redirected as a whole
+			srv.replyMultiOp(req, table.EcCrossSlot)
+			return true
+		}
+	}
+
+	if errCode == 0 {
+		return false
+	}
+
+	// The new owner address is the value of the reply (first) KeyValue
+	var pkg []byte
+	var err error
+	if multi {
+		var out proto.PkgMultiOp
+		out.Cmd = req.Cmd
+		out.DbId = req.DbId
+		out.Seq = req.Seq
+		out.ErrCode = errCode
+		out.Kvs = make([]proto.KeyValue, 1)
+		out.Kvs[0].SetValue([]byte(addr))
+		pkg = make([]byte, out.Length())
+		_, err = out.Encode(pkg)
+	} else {
+		var out proto.PkgOneOp
+		out.Cmd = req.Cmd
+		out.DbId = req.DbId
+		out.Seq = req.Seq
+		out.SetErrCode(errCode)
+		out.SetValue([]byte(addr))
+		pkg = make([]byte, out.Length())
+		_, err = out.Encode(pkg)
+	}
+	if err != nil {
+		log.Fatalf("Encode failed: %s\n", err)
+	}
+
+	srv.sendResp(false, req, pkg)
+	return true
+}
+
+func (srv *Server) auth(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var conf = srv.getConf()
+		if conf.Auth.AdminPwd != "" && !conf.Auth.PlainAuth {
+			// Plain text password refused, use challenge-response auth
+			srv.replyOneOp(req, table.EcAuthFailed)
+			return
+		}
+		var pkg = srv.tbl.Auth(&req.PkgArgs, req.Cli)
+		srv.sendResp(false, req, pkg)
+	case ClientTypeSlave:
+		var in proto.PkgOneOp
+		_, err := in.Decode(req.Pkg)
+		if err != nil {
+			log.Printf("Decode failed for auth reply(%s), close slave!\n", err)
+		} else if in.ErrCode != 0 {
+			log.Printf("Auth failed (%d), close slave!\n", in.ErrCode)
+		}
+		if err != nil || in.ErrCode != 0 {
+			if req.Slv != nil {
+				req.Slv.Close()
+			} else {
+				req.Cli.Close()
+			}
+			return
+		}
+		// Slave auth succeed
+		if req.Slv != nil {
+			err = req.Slv.SendSlaveOfToMaster()
+			if err != nil {
+				log.Printf("SendSlaveOfToMaster failed(%s), close slave!\n", err)
+				req.Slv.Close()
+			}
+		}
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for AUTH cmd, close now!\n", cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) scram(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var pkg = srv.tbl.Scram(&req.PkgArgs, req.Cli)
+		srv.sendResp(false, req, pkg)
+	case ClientTypeSlave:
+		var in proto.PkgOneOp
+		var done bool
+		_, err := in.Decode(req.Pkg)
+		if err != nil {
+			log.Printf("Decode failed for scram reply(%s), close slave!\n", err)
+		} else if in.ErrCode != 0 {
+			log.Printf("Auth failed (%d), close slave!\n", in.ErrCode)
+		} else if req.Slv != nil {
+			done, err = req.Slv.OnScramReply(&in)
+			if err != nil {
+				log.Printf("Auth master failed(%s), close slave!\n", err)
+			}
+		}
+		if err != nil || in.ErrCode != 0 {
+			if req.Slv != nil {
+				req.Slv.Close()
+			} else {
+				req.Cli.Close()
+			}
+			return
+		}
+		// Slave auth succeed
+		if done {
+			err = req.Slv.SendSlaveOfToMaster()
+			if err != nil {
+				log.Printf("SendSlaveOfToMaster failed(%s), close slave!\n", err)
+				req.Slv.Close()
+			}
+		}
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for SCRAM cmd, close now!\n", cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) ping(req *Request) {
+	srv.sendResp(false, req, req.Pkg)
+}
+
+func (srv *Server) get(req *Request) {
+	if !srv.checkLogSeq(req) {
+		srv.replyOneOp(req, table.EcNotSynced)
+		return
+	}
+
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
+	if srv.redirect(req, wa) {
+		return
+	}
+
+	var pkg = srv.tbl.Get(&req.PkgArgs, req.Cli, wa)
+	srv.sendResp(false, req, pkg)
+}
+
+func (srv *Server) set(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
+	switch cliType {
+	case ClientTypeNormal:
+		if srv.redirect(req, wa) {
+			return
+		}
+		if !wa.Check() {
+			srv.replyOneOp(req, table.EcWriteSlave)
+			return
+		}
+		pkg, ok := srv.tbl.Set(&req.PkgArgs, req.Cli, wa)
+		srv.sendResp(ok, req, pkg)
+	case ClientTypeSlave:
+		pkg, ok := srv.tbl.Set(&req.PkgArgs, req.Cli, wa)
+		if ok {
+			srv.sendResp(ok, req, nil)
+		} else {
+			srv.sendResp(ok, req, pkg)
+		}
+	case ClientTypeMaster:
+		log.Printf("Slave SET failed: [%d, %d]\n", req.DbId, req.Seq)
+	}
+}
+
+func (srv *Server) del(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
+	switch cliType {
+	case ClientTypeNormal:
+		if srv.redirect(req, wa) {
+			return
+		}
+		if !wa.Check() {
+			srv.replyOneOp(req, table.EcWriteSlave)
+			return
+		}
+		pkg, ok := srv.tbl.Del(&req.PkgArgs, req.Cli, wa)
+		srv.sendResp(ok, req, pkg)
+	case ClientTypeSlave:
+		pkg, ok := srv.tbl.Del(&req.PkgArgs, req.Cli, wa)
+		if ok {
+			srv.sendResp(ok, req, nil)
+		} else {
+			srv.sendResp(ok, req, pkg)
+		}
+	case ClientTypeMaster:
+		log.Printf("Slave DEL failed: [%d, %d]\n", req.DbId, req.Seq)
+	}
+}
+
+func (srv *Server) incr(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
+	switch cliType {
+	case ClientTypeNormal:
+		if srv.redirect(req, wa) {
+			return
+		}
+		if !wa.Check() {
+			srv.replyOneOp(req, table.EcWriteSlave)
+			return
+		}
+		pkg, ok := srv.tbl.Incr(&req.PkgArgs, req.Cli, wa)
+		srv.sendResp(ok, req, pkg)
+	case ClientTypeSlave:
+		pkg, ok := srv.tbl.Incr(&req.PkgArgs, req.Cli, wa)
+		if ok {
+			srv.sendResp(ok, req, nil)
+		} else {
+			srv.sendResp(ok, req, pkg)
+		}
+	case ClientTypeMaster:
+		log.Printf("Slave INCR failed: [%d, %d]\n", req.DbId, req.Seq)
+	}
+}
+
+func (srv *Server) mGet(req *Request) {
+	if !srv.checkLogSeq(req) {
+		srv.replyMultiOp(req, table.EcNotSynced)
+		return
+	}
+
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
+	if srv.redirect(req, wa) {
+		return
+	}
+
+	var pkg = srv.tbl.MGet(&req.PkgArgs, req.Cli, wa)
+	srv.sendResp(false, req, pkg)
+}
+
+func (srv *Server) mSet(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
+	switch cliType {
+	case ClientTypeNormal:
+		if srv.redirect(req, wa) {
+			return
+		}
+		if !wa.Check() {
+			srv.replyMultiOp(req, table.EcWriteSlave)
+			return
+		}
+		pkg, ok := srv.tbl.MSet(&req.PkgArgs, req.Cli, wa)
+		srv.sendResp(ok, req, pkg)
+	case ClientTypeSlave:
+		pkg, ok := srv.tbl.MSet(&req.PkgArgs, req.Cli, wa)
+		if ok {
+			srv.sendResp(ok, req, nil)
+		} else {
+			srv.sendResp(ok, req, pkg)
+		}
+	case ClientTypeMaster:
+		log.Printf("Slave MSET failed: [%d, %d]\n", req.DbId, req.Seq)
+	}
+}
+
+func (srv *Server) mDel(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
+	switch cliType {
+	case ClientTypeNormal:
+		if srv.redirect(req, wa) {
+			return
+		}
+		if !wa.Check() {
+			srv.replyMultiOp(req, table.EcWriteSlave)
+			return
+		}
+		pkg, ok := srv.tbl.MDel(&req.PkgArgs, req.Cli, wa)
+		srv.sendResp(ok, req, pkg)
+	case ClientTypeSlave:
+		pkg, ok := srv.tbl.MDel(&req.PkgArgs, req.Cli, wa)
+		if ok {
+			srv.sendResp(ok, req, nil)
+		} else {
+			srv.sendResp(ok, req, pkg)
+		}
+	case ClientTypeMaster:
+		log.Printf("Slave MDEL failed: [%d, %d]\n", req.DbId, req.Seq)
+	}
+}
+
+func (srv *Server) mIncr(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	var wa = store.NewWriteAccess(ClientTypeSlave == cliType, srv.mc)
+	switch cliType {
+	case ClientTypeNormal:
+		if srv.redirect(req, wa) {
+			return
+		}
+		if !wa.Check() {
+			srv.replyMultiOp(req, table.EcWriteSlave)
+			return
+		}
+		pkg, ok := srv.tbl.MIncr(&req.PkgArgs, req.Cli, wa)
+		srv.sendResp(ok, req, pkg)
+	case ClientTypeSlave:
+		pkg, ok := srv.tbl.MIncr(&req.PkgArgs, req.Cli, wa)
+		if ok {
+			srv.sendResp(ok, req, nil)
+		} else {
+			srv.sendResp(ok, req, pkg)
+		}
+	case ClientTypeMaster:
+		log.Printf("Slave MINCR failed: [%d, %d]\n", req.DbId, req.Seq)
+	}
+}
+
+func (srv *Server) scan(req *Request) {
+	if !srv.checkLogSeq(req) {
+		srv.replyMultiOp(req, table.EcNotSynced)
+		return
+	}
+	if srv.redirect(req, nil) {
+		return
+	}
+
+	var pkg = srv.tbl.Scan(&req.PkgArgs, req.Cli)
+	srv.sendResp(false, req, pkg)
+}
+
+func (srv *Server) sync(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	switch cliType {
+	case ClientTypeSlave:
+		pkg, ok := srv.tbl.Sync(&req.PkgArgs)
+		if ok {
+			// Full sync data, binlog SYNC pkgs (like DB passwords) have seq
+			if req.Seq == 0 && len(req.Pkg) >= proto.HeadSize+4 {
+				var rows = binary.BigEndian.Uint16(req.Pkg[proto.HeadSize+2:])
+				srv.mc.AddCopied(int64(rows), int64(len(req.Pkg)))
+			}
+			srv.sendResp(ok, req, nil)
+		} else {
+			srv.sendResp(ok, req, pkg)
+		}
+	case ClientTypeNormal:
+		log.Printf("User cannot send SYNC command, close now!\n")
+		req.Cli.Close()
+	case ClientTypeMaster:
+		log.Printf("Slave SYNC failed: [%d, %d], close now!\n", req.DbId, req.Seq)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) syncStatus(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	switch cliType {
+	case ClientTypeSlave:
+		var in proto.PkgOneOp
+		_, err := in.Decode(req.Pkg)
+		if err != nil || len(in.RowKey) == 0 {
+			return
+		}
+
+		rowKey := string(in.RowKey)
+		switch rowKey {
+		case store.KeyFullSyncEnd:
+			srv.mc.SetStatus(ctrl.SlaveIncrSync)
+			log.Printf("Switch sync status to SlaveIncrSync\n")
+		case store.KeyIncrSyncEnd:
+			var st = srv.mc.Status()
+			srv.mc.SetStatus(ctrl.SlaveReady)
+
+			var now = time.Now()
+			srv.rwMtx.Lock()
+			var lastTime = srv.readyTime
+			srv.readyTime = now
+			srv.rwMtx.Unlock()
+
+			if st != ctrl.SlaveReady || now.Sub(lastTime).Seconds() > 120 {
+				log.Printf("Switch sync status to SlaveReady")
+			}
+		case store.KeyFullSyncSlot:
+			if len(in.Value) == 2 {
+				srv.mc.SetSyncSlot(binary.BigEndian.Uint16(in.Value))
+			}
+		case store.KeyFullSyncSize:
+			if len(in.Value) == 8 {
+				srv.mc.SetTotalBytes(int64(binary.BigEndian.Uint64(in.Value)))
+			}
+		case store.KeySyncSeq:
+			if len(in.Value) == 16 {
+				srv.mc.SetSyncSeq(binary.BigEndian.Uint64(in.Value),
+					binary.BigEndian.Uint64(in.Value[8:]))
+			}
+		case store.KeyRepairSlots:
+			var slots []ctrl.SlotRange
+			err = json.Unmarshal(in.Value, &slots)
+			if err == nil {
+				err = srv.tbl.DeleteSlots(slots, false)
+			}
+			if err != nil {
+				log.Printf("Delete slots for repair failed: %s\n", err)
+			} else {
+				log.Printf("Repair slots %s from master\n", ctrl.NewSlotSet(slots))
+			}
+		case store.KeyVerifySlots:
+			var p ctrl.PkgVerify
+			err = json.Unmarshal(in.Value, &p)
+			if err == nil {
+				err = srv.verifySnapshot(&p)
+			}
+			if err != nil {
+				log.Printf("Take verify snapshot failed: %s\n", err)
+			}
+		case store.KeySyncLogMissing:
+			srv.mc.SetStatus(ctrl.SlaveNeedClear)
+			lastSeq, _ := srv.bin.GetMasterSeq()
+			// Any better solution?
+			log.Fatalf("Slave lastSeq %d is out of sync, please clear old data! "+
+				"(Restart may fix this issue)", lastSeq)
+		}
+
+		if req.Seq > 0 {
+			in.RowKey = nil // Set it as an empty OP
+			req.Pkg = make([]byte, in.Length())
+			in.Encode(req.Pkg)
+			srv.sendResp(true, req, nil)
+		}
+	case ClientTypeNormal:
+		log.Printf("User cannot send SYNCST command\n")
+	case ClientTypeMaster:
+		log.Printf("Slave SYNCST failed: [%d, %d]\n", req.DbId, req.Seq)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) dump(req *Request) {
+	var pkg = srv.tbl.Dump(&req.PkgArgs, req.Cli)
+	srv.sendResp(false, req, pkg)
+}
+
+// Normal master
+func (srv *Server) newNormalMaster(req *Request, p *ctrl.PkgSlaveOf) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	switch cliType {
+	case ClientTypeNormal:
+		if !req.Cli.IsAuth(proto.AdminDbId) {
+			log.Printf("Not authorized!\n")
+			srv.replySlaveOf(req, "no priviledge")
+			return
+		}
+
+		filter, err := p.Filter.Matcher()
+		if err != nil {
+			log.Printf("Invalid slave filter: %s\n", err)
+			srv.replySlaveOf(req, fmt.Sprintf("invalid filter(%s)", err))
+			return
+		}
+
+		req.Cli.SetClientType(ClientTypeMaster) // switch client type
+
+		log.Printf("Receive a slave connection from %s (%s), lastSeq=%d\n",
+			p.SlaveAddr, req.Cli.c.RemoteAddr(), p.LastSeq)
+
+		ms := NewMaster(p.SlaveAddr, p.LastSeq, false, nil, filter,
+			req.Cli, srv.bin)
+		ms.syncBytes, ms.syncKeys = srv.syncBytes, srv.syncKeys
+		ms.wg = srv.wg
+		ms.logTime = p.Delay > 0
+		srv.addMaster(ms)
+		go ms.GoAsync(srv.tbl)
+	case ClientTypeSlave:
+		// Get response from master
+		log.Printf("Master failed(%s), close slave!\n", p.ErrMsg)
+		if req.Slv != nil {
+			req.Slv.Close()
+		} else {
+			req.Cli.Close()
+		}
+	case ClientTypeMaster:
+		log.Printf("Already master, cannot create master again, close now!\n")
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) addMaster(ms *master) {
+	srv.rwMtx.Lock()
+	defer srv.rwMtx.Unlock()
+	if srv.masters == nil {
+		srv.masters = make(map[string]*master)
+	}
+	for addr, m := range srv.masters {
+		if m.isClosed() {
+			delete(srv.masters, addr)
+		}
+	}
+	srv.masters[ms.slaveAddr] = ms
+}
+
+// Get the master syncing to the normal slave, nil if not found.
+func (srv *Server) getMaster(slaveAddr string) *master {
+	srv.rwMtx.RLock()
+	var ms = srv.masters[slaveAddr]
+	srv.rwMtx.RUnlock()
+	if ms == nil || ms.isClosed() {
+		return nil
+	}
+	return ms
+}
+
+// Migration master
+func (srv *Server) newMigMaster(req *Request, p *ctrl.PkgMigrate) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	switch cliType {
+	case ClientTypeNormal:
+		if !req.Cli.IsAuth(proto.AdminDbId) {
+			log.Printf("Not authorized!\n")
+			srv.replyMigrate(req, "no priviledge")
+			return
+		}
+
+		req.Cli.SetClientType(ClientTypeMaster) // switch client type
+
+		log.Printf("Receive a migration slave connection from %s(%s)\n",
+			req.Cli.c.RemoteAddr(), p.SlaveAddr)
+
+		ms := NewMaster(p.SlaveAddr, 0, true, ctrl.NewSlotSet(p.GetSlots()), nil,
+			req.Cli, srv.bin)
+		ms.sc = srv.sc
+		ms.syncBytes, ms.syncKeys = srv.syncBytes, srv.syncKeys
+		go ms.GoAsync(srv.tbl)
+	case ClientTypeSlave:
+		// Get response from master
+		log.Printf("Master failed(%s), close slave!\n", p.ErrMsg)
+		if req.Slv != nil {
+			req.Slv.Close()
+		} else {
+			req.Cli.Close()
+		}
+	case ClientTypeMaster:
+		log.Printf("Already master, cannot create master again, close now!\n")
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) replySlaveOf(req *Request, msg string) {
+	ps := ctrl.PkgSlaveOf{}
+	ps.ErrMsg = msg
+	pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &ps)
+	if err == nil {
+		srv.sendResp(false, req, pkg)
+	}
+}
+
+func (srv *Server) replyMigrate(req *Request, msg string) {
+	ps := ctrl.PkgMigrate{}
+	ps.ErrMsg = msg
+	pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &ps)
+	if err == nil {
+		srv.sendResp(false, req, pkg)
+	}
+}
+
+func sameAddress(masterAddr, slaveAddr string, req *Request) bool {
+	if masterAddr == slaveAddr {
+		return true
+	}
+
+	var mAddr = util.GetRealAddr(masterAddr, req.Cli.LocalAddr().String())
+	var sAddr = util.GetRealAddr(slaveAddr, req.Cli.LocalAddr().String())
+
+	return mAddr == sAddr || mAddr == req.Cli.LocalAddr().String()
+}
+
+func (srv *Server) slaveOf(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	var p ctrl.PkgSlaveOf
+	var err = ctrl.Decode(req.Pkg, nil, &p)
+	if err == nil && !p.ClientReq {
+		srv.newNormalMaster(req, &p)
+		return
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		if err != nil {
+			log.Printf("Failed to Decode pkg: %s\n", err)
+			srv.replySlaveOf(req, fmt.Sprintf("decode failed(%s)", err))
+			return
+		}
+
+		if !req.Cli.IsAuth(proto.AdminDbId) {
+			log.Printf("Not authorized!\n")
+			srv.replySlaveOf(req, "no priviledge")
+			return
+		}
+
+		if len(p.SlaveAddr) == 0 {
+			p.SlaveAddr = req.Cli.LocalAddr().String()
+		}
+		if sameAddress(p.MasterAddr, p.SlaveAddr, req) {
+			log.Printf("Master and slave addresses are the same!\n")
+			srv.replyMigrate(req, "master and slave addresses are the same")
+			return
+		}
+
+		_, err = p.Filter.Matcher()
+		if err != nil {
+			log.Printf("Invalid slave filter: %s\n", err)
+			srv.replySlaveOf(req, fmt.Sprintf("invalid filter(%s)", err))
+			return
+		}
+
+		if p.Delay < 0 {
+			srv.replySlaveOf(req, "invalid delay")
+			return
+		}
+
+		err = srv.mc.SetMaster(p.MasterAddr, p.SlaveAddr, &p.Filter, p.Delay)
+		if err != nil {
+			log.Printf("Failed to set config: %s\n", err)
+			srv.replySlaveOf(req, fmt.Sprintf("set config failed(%s)", err))
+			return
+		}
+
+		srv.rwMtx.Lock()
+		slv := srv.slv
+		srv.slv = nil
+		srv.rwMtx.Unlock()
+
+		if len(p.MasterAddr) > 0 {
+			if slv != nil {
+				slv.Close()
+			}
+			srv.bin.AsSlave()
+			srv.connectToMaster(srv.mc)
+		} else {
+			if slv != nil {
+				go slv.DelayClose()
+			}
+			srv.bin.AsMaster()
+		}
+
+		srv.replySlaveOf(req, "") // Success
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		if err != nil {
+			log.Printf("Failed to Decode pkg: %s\n", err)
+		} else {
+			log.Println("Invalid client type %d for SlaveOf command, close now!",
+				cliType)
+		}
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) migrate(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	var p ctrl.PkgMigrate
+	var err = ctrl.Decode(req.Pkg, nil, &p)
+	if err == nil && !p.ClientReq {
+		srv.newMigMaster(req, &p)
+		return
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		if err != nil {
+			log.Printf("Failed to Decode pkg: %s\n", err)
+			srv.replyMigrate(req, fmt.Sprintf("decode failed(%s)", err))
+			return
+		}
+
+		if !req.Cli.IsAuth(proto.AdminDbId) {
+			log.Printf("Not authorized!\n")
+			srv.replyMigrate(req, "no priviledge")
+			return
+		}
+
+		if len(p.SlaveAddr) == 0 {
+			p.SlaveAddr = req.Cli.LocalAddr().String()
+		}
+		if sameAddress(p.MasterAddr, p.SlaveAddr, req) {
+			log.Printf("Master and slave addresses are the same!\n")
+			srv.replyMigrate(req, "master and slave addresses are the same")
+			return
+		}
+
+		err = srv.mc.SetMigration(p.MasterAddr, p.SlaveAddr, p.GetSlots())
+		if err != nil {
+			log.Printf("Failed to update migration config: %s\n", err)
+			srv.replyMigrate(req,
+				fmt.Sprintf("update migration config failed(%s)", err))
+			return
+		}
+
+		srv.rwMtx.Lock()
+		slv := srv.slv
+		srv.slv = nil
+		srv.rwMtx.Unlock()
+
+		if len(p.MasterAddr) > 0 {
+			if slv != nil {
+				slv.Close()
+			}
+			if srv.hasSlotsData(p.GetSlots()) {
+				err = srv.mc.SetStatus(ctrl.SlaveNeedClear)
+				if err != nil {
+					log.Printf("Failed to set migration status: %s\n", err)
+					srv.replyMigrate(req,
+						fmt.Sprintf("failed to set migration status(%s)", err))
+					return
+				}
+			} else {
+				srv.connectToMaster(srv.mc)
+			}
+		} else {
+			if slv != nil {
+				go slv.DelayClose()
+			}
+			srv.bin.AsMaster()
+		}
+
+		srv.replyMigrate(req, "") // Success
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		if err != nil {
+			log.Printf("Failed to Decode pkg: %s\n", err)
+		} else {
+			log.Println("Invalid client type %d for Migrate command, close now!",
+				cliType)
+		}
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) hasSlotsData(slots []ctrl.SlotRange) bool {
+	for _, r := range ctrl.NewSlotSet(slots).Ranges() {
+		for slotId := uint32(r.Start); slotId <= uint32(r.End); slotId++ {
+			if srv.tbl.HasSlotData(uint16(slotId)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (srv *Server) connectToMaster(mc *config.MasterConfig) {
+	var slv = NewSlave(srv.reqChan, srv.bin, mc, srv.getConf().Auth.AdminPwd,
+		srv.replTLS)
+
+	srv.rwMtx.Lock()
+	srv.slv = slv
+	srv.rwMtx.Unlock()
+
+	go slv.GoConnectToMaster()
+}
+
+func (srv *Server) slaveStatus(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgSlaveStatus
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else {
+			m := srv.mc.GetMaster()
+			if len(m.MasterAddr) > 0 {
+				if p.Migration {
+					if !m.Migration {
+						p.ErrMsg = fmt.Sprintf("check migration status on normal slave")
+					} else if !ctrl.NewSlotSet(m.Slots).Has(p.SlotId) {
+						p.ErrMsg = fmt.Sprintf("slot id %d is not under migration",
+							p.SlotId)
+					}
+				} else {
+					if m.Migration {
+						p.ErrMsg = fmt.Sprintf("check normal slave status on migration")
+					}
+				}
+			}
+			if len(p.ErrMsg) == 0 {
+				p.Status = m.Status
+				if len(m.MasterAddr) > 0 && !m.Migration {
+					p.LastSeq, _ = srv.bin.GetMasterSeq()
+				}
+				if p.Migration {
+					p.SlotStatus = srv.mc.GetSlotStatus()
+				}
+				if len(m.MasterAddr) > 0 {
+					var prog = srv.mc.GetProgress()
+					if !prog.StartTime.IsZero() {
+						p.StartTime = prog.StartTime.Unix()
+					}
+					p.CopiedRows = prog.CopiedRows
+					p.CopiedBytes = prog.CopiedBytes
+					p.TotalBytes = prog.TotalBytes
+					if prog.MasterSeq > prog.AppliedSeq {
+						p.SeqLag = prog.MasterSeq - prog.AppliedSeq
+					}
+				}
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Println("Invalid client type %d for MigStatus command, close now!",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) deleteMigrationSlots(slots []ctrl.SlotRange, compact bool,
+	m config.MasterInfo) error {
+	var match bool
+	if len(m.MasterAddr) > 0 && m.Migration {
+		var ss = ctrl.NewSlotSet(m.Slots)
+		for _, r := range slots {
+			for i := uint32(r.Start); i <= uint32(r.End) && !match; i++ {
+				match = ss.Has(uint16(i))
+			}
+		}
+	}
+
+	var err error
+	if match {
+		err = srv.mc.SetStatus(ctrl.SlaveClear)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = srv.tbl.DeleteSlots(slots, compact)
+	if err != nil {
+		return err
+	}
+
+	if match {
+		// Set as NotSlave, need a new Migrate command
+		err = srv.mc.SetStatus(ctrl.NotSlave)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (srv *Server) deleteSlot(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgDelSlot
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		var slots = p.Slots
+		if len(slots) == 0 {
+			slots = []ctrl.SlotRange{{Start: p.SlotId, End: p.SlotId}}
+		}
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if err = ctrl.CheckSlotRanges(slots); err != nil {
+			p.ErrMsg = err.Error()
+		} else {
+			// Only slots already copied to the new owner are moved
+			var copied []uint16
+			for _, r := range slots {
+				for i := uint32(r.Start); i <= uint32(r.End); i++ {
+					if srv.sc.IsCopied(uint16(i)) {
+						copied = append(copied, uint16(i))
+					}
+				}
+			}
+
+			err = srv.deleteMigrationSlots(slots, p.Compact, srv.mc.GetMaster())
+			if err != nil {
+				p.ErrMsg = fmt.Sprintf("delete slot failed %s", err)
+			}
+			for i := 0; i < len(copied) && err == nil; i++ {
+				err = srv.sc.Moved(copied[i])
+				if err != nil {
+					p.ErrMsg = fmt.Sprintf("update slot config failed %s", err)
+				}
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Println("Invalid client type %d for DelSlot command, close now!",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) replySwitchover(req *Request, msg string) {
+	ps := ctrl.PkgSwitchover{}
+	ps.ErrMsg = msg
+	pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &ps)
+	if err == nil {
+		srv.sendResp(false, req, pkg)
+	}
+}
+
+func (srv *Server) switchover(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgSwitchover
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		if err != nil {
+			log.Printf("Failed to Decode pkg: %s\n", err)
+			srv.replySwitchover(req, fmt.Sprintf("decode failed(%s)", err))
+			return
+		}
+
+		if !req.Cli.IsAuth(proto.AdminDbId) {
+			log.Printf("Not authorized!\n")
+			srv.replySwitchover(req, "no priviledge")
+			return
+		}
+
+		hasMaster, _, _ := srv.mc.GetMasterSlot()
+		if hasMaster {
+			srv.replySwitchover(req, "server is not a master")
+			return
+		}
+
+		var masterAddr = req.Cli.LocalAddr().String()
+		if len(p.SlaveAddr) == 0 || sameAddress(p.SlaveAddr, masterAddr, req) {
+			srv.replySwitchover(req, "invalid slave address")
+			return
+		}
+
+		var timeout = time.Duration(p.Timeout) * time.Second
+		if timeout <= 0 {
+			timeout = switchoverTimeout
+		}
+
+		if !atomic.CompareAndSwapUint32(&srv.switchOn, 0, 1) {
+			srv.replySwitchover(req, "switchover in progress")
+			return
+		}
+
+		// Wait for the slave out of the ctrl goroutine
+		go func() {
+			defer atomic.StoreUint32(&srv.switchOn, 0)
+			err := srv.doSwitchover(p.SlaveAddr, masterAddr, timeout)
+			if err != nil {
+				log.Printf("Switchover to %s failed: %s\n", p.SlaveAddr, err)
+				srv.replySwitchover(req, fmt.Sprintf("switchover failed(%s)", err))
+				return
+			}
+
+			srv.replySwitchover(req, "") // Success
+		}()
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Switchover command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+// Stop write, wait for the slave to sync the last binlog seq, promote the
+// slave and become its slave from the same seq. No full sync is needed.
+func (srv *Server) doSwitchover(slaveAddr, masterAddr string,
+	timeout time.Duration) error {
+	cli, cc, err := srv.dialSlave(slaveAddr)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	// Pause write until the promotion is done, paused writes are rejected
+	// after it. No lock is held while waiting for the slave.
+	srv.wg.pause()
+	defer srv.wg.resume()
+
+	lastSeq, chanLen := srv.bin.GetLogSeqChanLen()
+	for chanLen != 0 {
+		time.Sleep(time.Millisecond)
+		lastSeq, chanLen = srv.bin.GetLogSeqChanLen()
+	}
+
+	log.Printf("Stop write for switchover to %s, lastSeq=%d\n", slaveAddr, lastSeq)
+
+	var deadline = time.Now().Add(timeout)
+	for {
+		st, err := cc.GetSlaveStatus(false, 0)
+		if err != nil {
+			return err
+		}
+		if st.Status == ctrl.NotSlave {
+			return fmt.Errorf("%s is not a slave", slaveAddr)
+		}
+		if st.LastSeq >= lastSeq {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("slave lastSeq %d is behind %d", st.LastSeq, lastSeq)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// Promote the slave
+	err = cc.SlaveOf("")
+	if err != nil {
+		return err
+	}
+
+	err = srv.mc.SetMaster(slaveAddr, masterAddr, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	srv.rwMtx.Lock()
+	slv := srv.slv
+	srv.slv = nil
+	srv.rwMtx.Unlock()
+	if slv != nil {
+		slv.Close()
+	}
+
+	// Binlog after lastSeq comes from the new master
+	srv.bin.AsSlave()
+	srv.connectToMaster(srv.mc)
+
+	log.Printf("Switchover finished, new master is %s\n", slaveAddr)
+	return nil
+}
+
+func (srv *Server) delay(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgDelay
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed(%s)", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else {
+			srv.rwMtx.RLock()
+			var slv = srv.slv
+			srv.rwMtx.RUnlock()
+
+			if !slv.IsDelayed() || slv.IsClosed() {
+				p.ErrMsg = "server is not a delayed slave"
+			} else if err = slv.DelayCtrl(&p); err != nil {
+				p.ErrMsg = err.Error()
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Delay command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) setSlot(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgSetSlot
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed(%s)", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else {
+			err = srv.sc.SetOwner(p.Slots, p.State, p.Addr)
+			if err != nil {
+				p.ErrMsg = err.Error()
+			} else {
+				log.Printf("Set slots %s state %d owner %q\n",
+					ctrl.NewSlotSet(p.Slots), p.State, p.Addr)
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for SetSlot command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) cluster(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgCluster
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed(%s)", err)
+		} else if p.Op != ctrl.ClusterSlots && p.Op != ctrl.ClusterSizes &&
+			!req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else {
+			switch p.Op {
+			case ctrl.ClusterSlots:
+			case ctrl.ClusterSizes:
+				p.Sizes = srv.tbl.GetSlotSizes()
+			case ctrl.ClusterSetSlots:
+				err = srv.sc.SetCluster(p.Slots)
+				if err == nil {
+					log.Printf("Cluster mode, serve slots %s\n",
+						ctrl.NewSlotSet(p.Slots))
+				}
+			case ctrl.ClusterReset:
+				err = srv.sc.Reset()
+				if err == nil {
+					log.Printf("Normal mode, serve all slots\n")
+				}
+			default:
+				err = fmt.Errorf("invalid cluster operation %d", p.Op)
+			}
+			if err != nil {
+				p.ErrMsg = err.Error()
+			}
+		}
+
+		p.Slots = nil
+		p.Cluster, p.Owners = srv.sc.GetOwners()
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Cluster command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) rateLimit(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgRateLimit
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed(%s)", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else if p.Set && (p.SyncMBps < 0 || p.SyncKeys < 0) {
+			p.ErrMsg = "invalid rate limit"
+		} else {
+			if p.Set {
+				srv.syncBytes.SetRate(p.SyncMBps * 1024 * 1024)
+				srv.syncKeys.SetRate(p.SyncKeys)
+				srv.updateConf(func(c *config.Config) {
+					c.Repl.MaxMBps = p.SyncMBps
+					c.Repl.MaxKeys = p.SyncKeys
+				})
+				log.Printf("Set sync rate limit to %dMB/s, %d keys/s\n",
+					p.SyncMBps, p.SyncKeys)
+			}
+			p.SyncMBps = srv.syncBytes.GetRate() / 1024 / 1024
+			p.SyncKeys = srv.syncKeys.GetRate()
+			p.DbMBps = srv.getConf().Db.RateLimit
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for RateLimit command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) stats(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgStats
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		var slots = p.Slots
+		if len(slots) == 0 {
+			slots = []ctrl.SlotRange{{Start: 0, End: ctrl.TotalSlotNum - 1}}
+		}
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed(%s)", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else if err = ctrl.CheckSlotRanges(slots); err != nil {
+			p.ErrMsg = err.Error()
+		} else {
+			switch p.Op {
+			case ctrl.StatsSlots:
+				p.SlotStats = srv.tbl.GetSlotStats(slots)
+			case ctrl.StatsTables:
+				p.TableStats = srv.tbl.GetTableStats(slots)
+			case ctrl.StatsCount:
+				if !srv.tbl.StartKeyCount() {
+					p.ErrMsg = "key counting is already running"
+				}
+			default:
+				p.ErrMsg = fmt.Sprintf("invalid stats operation %d", p.Op)
+			}
+
+			counting, countTime := srv.tbl.GetKeyCountStatus()
+			p.Counting = counting
+			if !countTime.IsZero() {
+				p.CountTime = countTime.Unix()
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Stats command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) processRead() {
+	for {
+		select {
+		case req := <-srv.reqChan.ReadReqChan:
+			atomic.AddInt64(&srv.busy, 1)
+			if !srv.IsClosed() && !req.Cli.IsClosed() {
+				switch req.Cmd {
+				case proto.CmdAuth:
+					srv.auth(req)
+				case proto.CmdScram:
+					srv.scram(req)
+				case proto.CmdPing:
+					srv.ping(req)
+				case proto.CmdGet:
+					srv.get(req)
+				case proto.CmdMGet:
+					srv.mGet(req)
+				case proto.CmdScan:
+					srv.scan(req)
+				}
+			}
+			atomic.AddInt64(&srv.busy, -1)
+		}
+	}
+}
+
+func (srv *Server) processWrite() {
+	for {
+		select {
+		case req := <-srv.reqChan.WriteReqChan:
+			atomic.AddInt64(&srv.busy, 1)
+			if !srv.IsClosed() && !req.Cli.IsClosed() {
+				srv.write(req)
+			}
+			atomic.AddInt64(&srv.busy, -1)
+		}
+	}
+}
+
+// Write of normal client. Write waits while it is paused, see writeGate.
+func (srv *Server) write(req *Request) {
+	srv.wg.begin()
+	defer srv.wg.end()
+
+	switch req.Cmd {
+	case proto.CmdSet:
+		srv.set(req)
+	case proto.CmdDel:
+		srv.del(req)
+	case proto.CmdIncr:
+		srv.incr(req)
+	case proto.CmdMSet:
+		srv.mSet(req)
+	case proto.CmdMDel:
+		srv.mDel(req)
+	case proto.CmdMIncr:
+		srv.mIncr(req)
+	}
+}
+
+func (srv *Server) processSync() {
+	for {
+		select {
+		case req := <-srv.reqChan.SyncReqChan:
+			atomic.AddInt64(&srv.busy, 1)
+			if !srv.IsClosed() && !req.Cli.IsClosed() {
+				if req.Seq > 0 {
+					srv.mc.SetSyncSeq(0, req.Seq)
+				}
+				srv.wg.begin()
+				switch req.Cmd {
+				case proto.CmdSet:
+					srv.set(req)
+				case proto.CmdDel:
+					srv.del(req)
+				case proto.CmdIncr:
+					srv.incr(req)
+				case proto.CmdMSet:
+					srv.mSet(req)
+				case proto.CmdMDel:
+					srv.mDel(req)
+				case proto.CmdMIncr:
+					srv.mIncr(req)
+				case proto.CmdSync:
+					srv.sync(req)
+				case proto.CmdSyncSt:
+					srv.syncStatus(req)
+				}
+				srv.wg.end()
+			}
+			atomic.AddInt64(&srv.busy, -1)
+		}
+	}
+}
+
+func (srv *Server) processDump() {
+	for {
+		select {
+		case req := <-srv.reqChan.DumpReqChan:
+			if srv.IsClosed() {
+				continue
+			}
+			if !req.Cli.IsClosed() {
+				switch req.Cmd {
+				case proto.CmdDump:
+					srv.dump(req)
+				}
+			}
+		}
+	}
+}
+
+func (srv *Server) processCtrl() {
+	for {
+		select {
+		case req := <-srv.reqChan.CtrlReqChan:
+			if srv.IsClosed() {
+				continue
+			}
+			if !req.Cli.IsClosed() {
+				switch req.Cmd {
+				case proto.CmdSlaveOf:
+					srv.slaveOf(req)
+				case proto.CmdMigrate:
+					srv.migrate(req)
+				case proto.CmdSlaveSt:
+					srv.slaveStatus(req)
+				case proto.CmdDelSlot:
+					srv.deleteSlot(req)
+				case proto.CmdSwitch:
+					srv.switchover(req)
+				case proto.CmdDelay:
+					srv.delay(req)
+				case proto.CmdSetSlot:
+					srv.setSlot(req)
+				case proto.CmdCluster:
+					srv.cluster(req)
+				case proto.CmdLimit:
+					srv.rateLimit(req)
+				case proto.CmdStats:
+					srv.stats(req)
+				case proto.CmdVerify:
+					srv.verify(req)
+				case proto.CmdInfo:
+					srv.info(req)
+				case proto.CmdSlowLog:
+					srv.slowLog(req)
+				case proto.CmdClient:
+					srv.client(req)
+				case proto.CmdConfig:
+					srv.config(req)
+				case proto.CmdShutdown:
+					srv.shutdown(req)
+				case proto.CmdPasswd:
+					srv.passwd(req)
+				case proto.CmdUser:
+					srv.user(req)
+				}
+			}
+		}
+	}
+}
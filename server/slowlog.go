@@ -0,0 +1,241 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxSlowKeyLen = 64 // Max rowKey length kept in slow log
+)
+
+// Ring buffer of the latest slow requests
+type slowLog struct {
+	// atomic
+	slowerThan int64 // Microseconds, 0 means disabled
+
+	mtx     sync.Mutex // protects following
+	entries []ctrl.SlowLogEntry
+	next    int // Position of the next entry
+	num     int // Number of entries kept
+	lastId  uint64
+	logger  *log.Logger // Append entries to file if not nil
+}
+
+func newSlowLog(slowerThan int64, maxLen int, fileName string) (*slowLog, error) {
+	if maxLen <= 0 {
+		maxLen = 128
+	}
+
+	var sl = new(slowLog)
+	sl.slowerThan = slowerThan
+	sl.entries = make([]ctrl.SlowLogEntry, maxLen)
+	if fileName != "" {
+		f, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		sl.logger = log.New(f, "", log.LstdFlags)
+	}
+	return sl, nil
+}
+
+func (sl *slowLog) setSlowerThan(slowerThan int64) {
+	atomic.StoreInt64(&sl.slowerThan, slowerThan)
+}
+
+func (sl *slowLog) getSlowerThan() int64 {
+	return atomic.LoadInt64(&sl.slowerThan)
+}
+
+// Record the request if it is slower than the threshold.
+func (sl *slowLog) check(req *Request, d time.Duration) {
+	var slowerThan = sl.getSlowerThan()
+	if slowerThan <= 0 || int64(d/time.Microsecond) < slowerThan {
+		return
+	}
+
+	var e = ctrl.SlowLogEntry{
+		Time:     req.recvTime.Unix(),
+		Duration: int64(d / time.Microsecond),
+		Cmd:      cmdName(req.Cmd),
+		DbId:     req.DbId,
+		Client:   req.Cli.RemoteAddr().String(),
+	}
+	e.TableId, e.RowKey, e.Keys = slowRequestKeys(req)
+
+	sl.mtx.Lock()
+	sl.lastId++
+	e.Id = sl.lastId
+	sl.entries[sl.next] = e
+	sl.next = (sl.next + 1) % len(sl.entries)
+	if sl.num < len(sl.entries) {
+		sl.num++
+	}
+	sl.mtx.Unlock()
+
+	if sl.logger != nil {
+		sl.logger.Printf("id=%d cmd=%s db=%d table=%d rowKey=%q keys=%d "+
+			"client=%s duration=%s\n", e.Id, e.Cmd, e.DbId, e.TableId,
+			e.RowKey, e.Keys, e.Client, d)
+	}
+}
+
+// Get at most num latest entries (all if num <= 0), the latest first.
+func (sl *slowLog) get(num int) ([]ctrl.SlowLogEntry, int) {
+	sl.mtx.Lock()
+	defer sl.mtx.Unlock()
+
+	if num <= 0 || num > sl.num {
+		num = sl.num
+	}
+	var res = make([]ctrl.SlowLogEntry, num)
+	for i := 0; i < num; i++ {
+		var pos = (sl.next - 1 - i + len(sl.entries)) % len(sl.entries)
+		res[i] = sl.entries[pos]
+	}
+	return res, sl.num
+}
+
+// Change the max number of entries kept, the latest entries are kept.
+func (sl *slowLog) setMaxLen(maxLen int) {
+	if maxLen <= 0 {
+		maxLen = 128
+	}
+
+	sl.mtx.Lock()
+	var num = sl.num
+	if num > maxLen {
+		num = maxLen
+	}
+	var entries = make([]ctrl.SlowLogEntry, maxLen)
+	for i := 0; i < num; i++ {
+		var pos = (sl.next - 1 - i + len(sl.entries)) % len(sl.entries)
+		entries[num-1-i] = sl.entries[pos]
+	}
+	sl.entries = entries
+	sl.num = num
+	sl.next = num % maxLen
+	sl.mtx.Unlock()
+}
+
+func (sl *slowLog) reset() {
+	sl.mtx.Lock()
+	for i := 0; i < len(sl.entries); i++ {
+		sl.entries[i] = ctrl.SlowLogEntry{}
+	}
+	sl.next = 0
+	sl.num = 0
+	sl.mtx.Unlock()
+}
+
+// Get tableId, truncated rowKey of the first key, and number of keys (or
+// scan size) of the request.
+func slowRequestKeys(req *Request) (uint8, []byte, int) {
+	var tableId uint8
+	var rowKey []byte
+	var keys int
+	switch req.Cmd {
+	case proto.CmdGet:
+		fallthrough
+	case proto.CmdSet:
+		fallthrough
+	case proto.CmdDel:
+		fallthrough
+	case proto.CmdIncr:
+		var p proto.PkgOneOp
+		if _, err := p.Decode(req.Pkg); err == nil {
+			tableId, rowKey, keys = p.TableId, p.RowKey, 1
+		}
+	case proto.CmdMGet:
+		fallthrough
+	case proto.CmdMSet:
+		fallthrough
+	case proto.CmdMDel:
+		fallthrough
+	case proto.CmdMIncr:
+		var p proto.PkgMultiOp
+		if _, err := p.Decode(req.Pkg); err == nil {
+			keys = len(p.Kvs)
+			if keys > 0 {
+				tableId, rowKey = p.Kvs[0].TableId, p.Kvs[0].RowKey
+			}
+		}
+	case proto.CmdScan:
+		var p proto.PkgScanReq
+		if _, err := p.Decode(req.Pkg); err == nil {
+			tableId, rowKey, keys = p.TableId, p.RowKey, int(p.Num)
+		}
+	case proto.CmdDump:
+		var p proto.PkgDumpReq
+		if _, err := p.Decode(req.Pkg); err == nil {
+			tableId, rowKey = p.TableId, p.RowKey
+		}
+	}
+
+	if len(rowKey) > maxSlowKeyLen {
+		rowKey = rowKey[:maxSlowKeyLen]
+	}
+	// Copy the key, the request pkg is not kept
+	return tableId, append([]byte(nil), rowKey...), keys
+}
+
+func (srv *Server) slowLog(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgSlowLog
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = fmt.Sprintf("no priviledge")
+		} else {
+			switch p.Op {
+			case ctrl.SlowLogGet:
+				p.Entries, p.Len = srv.slow.get(p.Num)
+			case ctrl.SlowLogReset:
+				srv.slow.reset()
+				p.Entries, p.Len = nil, 0
+			default:
+				p.ErrMsg = fmt.Sprintf("invalid slowlog op %d", p.Op)
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for SlowLog command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"github.com/stevejiang/gotable/config"
+	"github.com/stevejiang/gotable/util"
+	"log"
+	"time"
+)
+
+const (
+	tlsHandshakeTimeout = time.Second * 10
+)
+
+// Get the TLS config of the listener, or nil if TLS is disabled.
+func newServerTLSConfig(c *config.Config) (*tls.Config, error) {
+	if c.TLS.CertFile == "" {
+		return nil, nil
+	}
+
+	conf, err := util.NewTLSConfig(c.TLS.CertFile, c.TLS.KeyFile, c.TLS.CaFile)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.TLS.ClientCert {
+	case config.ClientCertVerify:
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	case config.ClientCertRequire:
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// Get the TLS config of connecting to master, or nil if TLS is disabled.
+func newReplTLSConfig(c *config.Config) (*tls.Config, error) {
+	if !c.TLS.Replication {
+		return nil, nil
+	}
+	return util.NewTLSConfig(c.TLS.CertFile, c.TLS.KeyFile, c.TLS.CaFile)
+}
+
+// Finish the TLS handshake of the new client, and authorize it as the named
+// user of the verified client certificate, before serving requests.
+func (srv *Server) goServeTLS(cli *Client, c *tls.Conn) {
+	c.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := c.Handshake(); err != nil {
+		log.Printf("TLS handshake with %s failed: %s\n", c.RemoteAddr(), err)
+		cli.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	var st = c.ConnectionState()
+	if len(st.VerifiedChains) > 0 {
+		var name = st.PeerCertificates[0].Subject.CommonName
+		if srv.tbl.AuthCertUser(name, cli) {
+			log.Printf("Client %s authorized as user %s by certificate\n",
+				c.RemoteAddr(), name)
+		}
+	}
+
+	go cli.GoRecvRequest(srv.reqChan, nil)
+	go cli.GoSendResponse()
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/store"
+	"log"
+)
+
+// Create or change the named user in the admin table.
+func (srv *Server) setUser(u *ctrl.UserInfo, password string) error {
+	if err := srv.checkAdminWrite(); err != nil {
+		return err
+	}
+
+	pkg, err := store.NewUserPkg(u, password)
+	if err != nil {
+		return err
+	}
+	if err = srv.writeAdminPkg(pkg); err != nil {
+		return err
+	}
+
+	log.Printf("User %s changed: role %s, DB %d, tables %v\n",
+		u.Name, u.Role, u.DbId, u.Tables)
+	return nil
+}
+
+// Remove the named user from the admin table. Connections already
+// authorized by the user are not affected.
+func (srv *Server) delUser(name string) error {
+	if err := srv.checkAdminWrite(); err != nil {
+		return err
+	}
+	if !srv.tbl.HasUser(name) {
+		return fmt.Errorf("no user %s", name)
+	}
+
+	pkg, err := store.NewDelUserPkg(name)
+	if err != nil {
+		return err
+	}
+	if err = srv.writeAdminPkg(pkg); err != nil {
+		return err
+	}
+
+	log.Printf("User %s removed\n", name)
+	return nil
+}
+
+func (srv *Server) user(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgUser
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = fmt.Sprintf("no priviledge")
+		} else {
+			switch p.Op {
+			case ctrl.UserSet:
+				err = srv.setUser(&p.User, p.Password)
+			case ctrl.UserDel:
+				err = srv.delUser(p.User.Name)
+			case ctrl.UserList:
+				p.Users = srv.tbl.GetUsers()
+			default:
+				err = fmt.Errorf("invalid user op %d", p.Op)
+			}
+			if err != nil {
+				p.ErrMsg = err.Error()
+			}
+		}
+		p.Password = ""
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for User command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
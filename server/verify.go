@@ -0,0 +1,327 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/store"
+	"log"
+	"sync"
+	"time"
+)
+
+// Slot checksums of the last verification
+type verifier struct {
+	mtx       sync.Mutex // protects following
+	running   bool
+	slaveAddr string // Master: the slave verified; Slave: empty
+	slots     []ctrl.SlotRange
+	seq       uint64 // 0: snapshot not taken yet
+	sums      []uint64
+	errMsg    string
+	deadline  time.Time // Master: the slave should take snapshot before it
+}
+
+// Mark the verification running before the snapshot is taken.
+func (vf *verifier) prepare(slaveAddr string, slots []ctrl.SlotRange,
+	timeout time.Duration) error {
+	vf.mtx.Lock()
+	defer vf.mtx.Unlock()
+	if vf.running {
+		return errors.New("verification is already running")
+	}
+
+	vf.running = true
+	vf.slaveAddr = slaveAddr
+	vf.slots = slots
+	vf.seq = 0
+	vf.sums = nil
+	vf.errMsg = ""
+	vf.deadline = time.Now().Add(timeout)
+	return nil
+}
+
+// Stop the verification which cannot take the snapshot.
+func (vf *verifier) fail(err error) {
+	vf.mtx.Lock()
+	vf.running = false
+	vf.errMsg = err.Error()
+	vf.mtx.Unlock()
+}
+
+// Start computing checksums from the snapshot iterator in the background,
+// skipping the tables not matching filter.
+func (vf *verifier) start(it *store.Iterator, seq uint64, filter *ctrl.SyncMatcher) {
+	vf.mtx.Lock()
+	vf.seq = seq
+	var slots = vf.slots
+	vf.mtx.Unlock()
+
+	go func() {
+		log.Printf("Start computing checksums of slots %s, seq=%d\n",
+			ctrl.NewSlotSet(slots), seq)
+		var sums = store.SlotChecksums(it, ctrl.NewSlotSet(slots), filter)
+		it.Destroy()
+
+		vf.mtx.Lock()
+		vf.running = false
+		vf.sums = sums
+		vf.mtx.Unlock()
+		log.Printf("Computing checksums finished, seq=%d\n", seq)
+	}()
+}
+
+// Whether the slave has not taken its snapshot in time.
+func (vf *verifier) expired() bool {
+	vf.mtx.Lock()
+	defer vf.mtx.Unlock()
+	return time.Now().After(vf.deadline)
+}
+
+func (vf *verifier) status(p *ctrl.PkgVerify) (string, error) {
+	vf.mtx.Lock()
+	defer vf.mtx.Unlock()
+	p.Running = vf.running
+	p.SlaveAddr = vf.slaveAddr
+	p.Slots = vf.slots
+	p.Seq = vf.seq
+	p.Sums = vf.sums
+	if len(vf.errMsg) > 0 {
+		return vf.slaveAddr, errors.New(vf.errMsg)
+	}
+	return vf.slaveAddr, nil
+}
+
+// Connect to the slave as admin, with TLS if replication TLS is enabled.
+func (srv *Server) dialSlave(slaveAddr string) (*table.Client, *table.CtrlContext, error) {
+	var cli *table.Client
+	var err error
+	if srv.replTLS != nil {
+		cli, err = table.DialTLS("tcp", slaveAddr, srv.replTLS)
+	} else {
+		cli, err = table.Dial("tcp", slaveAddr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ctx = cli.NewContext(proto.AdminDbId)
+	if pwd := srv.getConf().Auth.AdminPwd; len(pwd) > 0 {
+		err = ctx.Auth(pwd)
+		if err != nil {
+			cli.Close()
+			return nil, nil, err
+		}
+	}
+	return cli, (*table.CtrlContext)(ctx), nil
+}
+
+func (srv *Server) verify(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgVerify
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if len(p.Slots) == 0 {
+			p.Slots = []ctrl.SlotRange{{Start: 0, End: ctrl.TotalSlotNum - 1}}
+		}
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed(%s)", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else if err = ctrl.CheckSlotRanges(p.Slots); err != nil {
+			p.ErrMsg = err.Error()
+		} else {
+			switch p.Op {
+			case ctrl.VerifyStart:
+				err = srv.verifyStart(&p)
+			case ctrl.VerifyStatus:
+				err = srv.verifyStatus(&p)
+			case ctrl.VerifyRepair:
+				err = srv.verifyRepair(&p)
+			default:
+				err = fmt.Errorf("invalid verify operation %d", p.Op)
+			}
+			if err != nil {
+				p.ErrMsg = err.Error()
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlave:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Verify command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+// Ask the sync goroutine of the slave to take the master snapshot and tell
+// the slave to take its snapshot at the same seq. Write is only paused
+// while taking the master snapshot, the slave may take its snapshot later.
+func (srv *Server) verifyStart(p *ctrl.PkgVerify) error {
+	hasMaster, _, _ := srv.mc.GetMasterSlot()
+	if hasMaster {
+		return errors.New("server is not a master")
+	}
+	if len(p.SlaveAddr) == 0 {
+		return errors.New("invalid slave address")
+	}
+
+	var ms = srv.getMaster(p.SlaveAddr)
+	if ms == nil {
+		return fmt.Errorf("slave %s is not connected", p.SlaveAddr)
+	}
+
+	var timeout = time.Duration(p.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = switchoverTimeout
+	}
+
+	err := srv.vf.prepare(p.SlaveAddr, p.Slots, timeout)
+	if err != nil {
+		return err
+	}
+
+	err = ms.verify(&srv.vf, p.Slots)
+	if err != nil {
+		srv.vf.fail(err)
+		return err
+	}
+
+	log.Printf("Start verification of slots %s with %s\n",
+		ctrl.NewSlotSet(p.Slots), p.SlaveAddr)
+	p.Running = true
+	return nil
+}
+
+// Take the snapshot on the slave. It's called from the sync stream right
+// after the binlog of the master snapshot seq, which has been applied.
+func (srv *Server) verifySnapshot(p *ctrl.PkgVerify) error {
+	m := srv.mc.GetMaster()
+	if len(m.MasterAddr) == 0 || m.Migration {
+		return errors.New("server is not a slave")
+	}
+	filter, err := m.Filter.Matcher()
+	if err != nil {
+		return err
+	}
+
+	err = srv.vf.prepare("", p.Slots, 0)
+	if err != nil {
+		return err
+	}
+
+	srv.vf.start(srv.tbl.NewIterator(false), p.Seq, filter)
+	return nil
+}
+
+// Get the checksums. On the master the checksums are compared with the
+// slave's when both finished.
+func (srv *Server) verifyStatus(p *ctrl.PkgVerify) error {
+	slaveAddr, err := srv.vf.status(p)
+	if err != nil {
+		return err
+	}
+	if len(slaveAddr) == 0 || p.Running || p.Sums == nil {
+		return nil
+	}
+
+	mismatch, running, err := srv.verifyCompare(slaveAddr, p)
+	if err != nil {
+		return err
+	}
+	p.Running = running
+	p.Mismatch = mismatch
+	return nil
+}
+
+// Compare the master checksums with the slave's.
+func (srv *Server) verifyCompare(slaveAddr string,
+	p *ctrl.PkgVerify) ([]ctrl.SlotRange, bool, error) {
+	cli, cc, err := srv.dialSlave(slaveAddr)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cli.Close()
+
+	st, err := cc.Verify(ctrl.VerifyStatus, "", nil, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	if st.Seq < p.Seq && !srv.vf.expired() {
+		return nil, true, nil // Slave has not synced to the snapshot seq
+	}
+	if st.Seq != p.Seq {
+		return nil, false, fmt.Errorf("slave verified seq %d, not %d", st.Seq, p.Seq)
+	}
+	if st.Running || st.Sums == nil {
+		return nil, true, nil
+	}
+	if len(st.Sums) != len(p.Sums) {
+		return nil, false, errors.New("invalid slave checksums")
+	}
+
+	var mismatch []ctrl.SlotRange
+	var ss = ctrl.NewSlotSet(p.Slots)
+	for _, r := range ss.Ranges() {
+		for i := uint32(r.Start); i <= uint32(r.End); i++ {
+			if p.Sums[i] != st.Sums[i] {
+				mismatch = append(mismatch,
+					ctrl.SlotRange{Start: uint16(i), End: uint16(i)})
+			}
+		}
+	}
+	return ctrl.NewSlotSet(mismatch).Ranges(), false, nil
+}
+
+// Re-sync the mismatching slots of the last verification to the slave.
+func (srv *Server) verifyRepair(p *ctrl.PkgVerify) error {
+	err := srv.verifyStatus(p)
+	if err != nil {
+		return err
+	}
+	if len(p.SlaveAddr) == 0 || p.Sums == nil {
+		return errors.New("no verification result")
+	}
+	if p.Running {
+		return errors.New("verification is still running")
+	}
+	if len(p.Mismatch) == 0 {
+		return nil
+	}
+
+	var ms = srv.getMaster(p.SlaveAddr)
+	if ms == nil {
+		return fmt.Errorf("slave %s is not connected", p.SlaveAddr)
+	}
+
+	log.Printf("Repair slots %s of slave %s\n",
+		ctrl.NewSlotSet(p.Mismatch), p.SlaveAddr)
+	return ms.repair(p.Mismatch)
+}
@@ -23,12 +23,19 @@ type WriteAccess struct {
 	replication bool // Replication slave
 	hasMaster   bool
 	migration   bool
-	slotId      uint16
+	slots       *ctrl.SlotSet // Slots under migration
+	asking      bool          // Request redirected by EcAsk
 }
 
 func NewWriteAccess(replication bool, mc *config.MasterConfig) *WriteAccess {
-	hasMaster, migration, slotId := mc.GetMasterSlot()
-	return &WriteAccess{replication, hasMaster, migration, slotId}
+	hasMaster, migration, slots := mc.GetMasterSlot()
+	return &WriteAccess{replication, hasMaster, migration, slots, false}
+}
+
+// Accept writes to the slots under migration, as the old owner has
+// redirected the request here by EcAsk.
+func (m *WriteAccess) SetAsking(asking bool) {
+	m.asking = asking
 }
 
 // Do we have right to write this key?
@@ -42,7 +49,7 @@ func (m *WriteAccess) CheckKey(dbId, tableId uint8, rowKey []byte) bool {
 	}
 
 	if m.migration {
-		return m.slotId != ctrl.GetSlotId(dbId, tableId, rowKey)
+		return m.asking || !m.slots.Has(ctrl.GetSlotId(dbId, tableId, rowKey))
 	} else {
 		return false
 	}
@@ -59,7 +66,7 @@ func (m *WriteAccess) CheckSlot(slotId uint16) bool {
 	}
 
 	if m.migration {
-		return m.slotId != slotId
+		return m.asking || !m.slots.Has(slotId)
 	} else {
 		return false
 	}
@@ -12,4 +12,93 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//empty c++ file tells cgo use g++ as linker
+// This c++ file also tells cgo use g++ as linker
+
+#include <rocksdb/c.h>
+#include <rocksdb/cache.h>
+#include <rocksdb/db.h>
+#include <rocksdb/options.h>
+#include <rocksdb/rate_limiter.h>
+#include <rocksdb/write_batch.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+// The same as the definitions in rocksdb/db/c.cc of rocksdb-3.8, the
+// ROCKSDB_VER of build_deps.sh. The C API keeps these structs private, so
+// they must be checked against db/c.cc whenever RocksDB is upgraded, or the
+// wrappers below access the wrong memory.
+struct rocksdb_t { rocksdb::DB* rep; };
+struct rocksdb_cache_t { std::shared_ptr<rocksdb::Cache> rep; };
+struct rocksdb_options_t { rocksdb::Options rep; };
+struct rocksdb_writeoptions_t { rocksdb::WriteOptions rep; };
+
+static void saveError(char** errptr, const rocksdb::Status& s) {
+	if (*errptr != NULL) {
+		free(*errptr);
+	}
+	*errptr = strdup(s.ToString().c_str());
+}
+
+extern "C" {
+
+// Limit the IO rate of flush and compaction in bytes per second.
+void gotable_options_set_rate_limit(rocksdb_options_t* opt,
+	int64_t bytesPerSec) {
+	opt->rep.rate_limiter.reset(rocksdb::NewGenericRateLimiter(bytesPerSec));
+}
+
+// Memory size of entries in the block cache.
+uint64_t gotable_cache_get_usage(rocksdb_cache_t* cache) {
+	return cache->rep->GetUsage();
+}
+
+// Flush memtables and wait until the flush is done.
+void gotable_flush(rocksdb_t* db, char** errptr) {
+	rocksdb::FlushOptions opt;
+	opt.wait = true;
+	rocksdb::Status s = db->rep->Flush(opt);
+	if (!s.ok()) {
+		saveError(errptr, s);
+	}
+}
+
+// Delete at most maxNum keys in range [start, limit) by one write batch, and
+// return the number of deleted keys. The last deleted key is returned in
+// lastKey (malloc'ed), so that the caller continues after it.
+uint64_t gotable_delete_batch(rocksdb_t* db,
+	const rocksdb_writeoptions_t* wOpt,
+	const char* start, size_t startLen,
+	const char* limit, size_t limitLen, int maxNum,
+	char** lastKey, size_t* lastKeyLen, char** errptr) {
+	rocksdb::ReadOptions rOpt;
+	rOpt.fill_cache = false;
+	rocksdb::Slice limitKey(limit, limitLen);
+
+	rocksdb::WriteBatch batch;
+	rocksdb::Iterator* it = db->rep->NewIterator(rOpt);
+	for (it->Seek(rocksdb::Slice(start, startLen));
+		it->Valid() && it->key().compare(limitKey) < 0 &&
+		batch.Count() < maxNum; it->Next()) {
+		batch.Delete(it->key());
+		if (batch.Count() == maxNum) {
+			*lastKeyLen = it->key().size();
+			*lastKey = (char*)malloc(*lastKeyLen);
+			memcpy(*lastKey, it->key().data(), *lastKeyLen);
+		}
+	}
+
+	rocksdb::Status s = it->status();
+	delete it;
+	uint64_t count = batch.Count();
+	if (s.ok() && count > 0) {
+		s = db->rep->Write(wOpt->rep, &batch);
+	}
+	if (!s.ok()) {
+		saveError(errptr, s);
+		return 0;
+	}
+	return count;
+}
+
+}  // end extern "C"
@@ -16,6 +16,13 @@ package store
 
 // #include <rocksdb/c.h>
 // #include <stdlib.h>
+// #include <stdint.h>
+// void gotable_options_set_rate_limit(rocksdb_options_t* opt, int64_t bytesPerSec);
+// uint64_t gotable_cache_get_usage(rocksdb_cache_t* cache);
+// void gotable_flush(rocksdb_t* db, char** errptr);
+// uint64_t gotable_delete_batch(rocksdb_t* db, const rocksdb_writeoptions_t* wOpt,
+//	const char* start, size_t startLen, const char* limit, size_t limitLen,
+//	int maxNum, char** lastKey, size_t* lastKeyLen, char** errptr);
 import "C"
 
 import (
@@ -23,6 +30,10 @@ import (
 	"unsafe"
 )
 
+const (
+	iterDeleteBatchNum = 1000 // Keys deleted by one cgo call of IterDelete
+)
+
 type DB struct {
 	db    *C.rocksdb_t
 	opt   *C.rocksdb_options_t
@@ -75,13 +86,18 @@ func (db *DB) Close() {
 	}
 }
 
+// Open the DB. IO of flush and compaction is limited to rateLimit bytes
+// per second if rateLimit > 0.
 func (db *DB) Open(name string, createIfMissing bool, maxOpenFiles int,
-	writeBufSize int, cacheSize int64, compression int) error {
+	writeBufSize int, cacheSize int64, compression int, rateLimit int64) error {
 	db.opt = C.rocksdb_options_create()
 	C.rocksdb_options_set_create_if_missing(db.opt, boolToUchar(createIfMissing))
 	C.rocksdb_options_set_write_buffer_size(db.opt, C.size_t(writeBufSize))
 	C.rocksdb_options_set_max_open_files(db.opt, C.int(maxOpenFiles))
 	C.rocksdb_options_set_compression(db.opt, C.int(compression))
+	if rateLimit > 0 {
+		C.gotable_options_set_rate_limit(db.opt, C.int64_t(rateLimit))
+	}
 
 	var block_options = C.rocksdb_block_based_options_create()
 	if cacheSize > 0 {
@@ -291,6 +307,133 @@ func (iter *Iterator) Value() []byte {
 	return C.GoBytes(unsafe.Pointer(value), C.int(valueLen))
 }
 
+// GetApproximateSizes returns the approximate file system space used by
+// keys in each range [starts[i], limits[i]).
+func (db *DB) GetApproximateSizes(starts, limits [][]byte) []uint64 {
+	var num = len(starts)
+	if num == 0 || num != len(limits) {
+		return nil
+	}
+
+	var ptrSize = C.size_t(unsafe.Sizeof((*C.char)(nil)))
+	var lenSize = C.size_t(unsafe.Sizeof(C.size_t(0)))
+	var cStarts = (*[1 << 20]*C.char)(C.malloc(C.size_t(num) * ptrSize))
+	var cLimits = (*[1 << 20]*C.char)(C.malloc(C.size_t(num) * ptrSize))
+	var cStartLens = (*[1 << 20]C.size_t)(C.malloc(C.size_t(num) * lenSize))
+	var cLimitLens = (*[1 << 20]C.size_t)(C.malloc(C.size_t(num) * lenSize))
+	var cSizes = (*[1 << 20]C.uint64_t)(C.malloc(C.size_t(num) * 8))
+	defer C.free(unsafe.Pointer(cStarts))
+	defer C.free(unsafe.Pointer(cLimits))
+	defer C.free(unsafe.Pointer(cStartLens))
+	defer C.free(unsafe.Pointer(cLimitLens))
+	defer C.free(unsafe.Pointer(cSizes))
+
+	for i := 0; i < num; i++ {
+		cStarts[i] = C.CString(string(starts[i]))
+		cStartLens[i] = C.size_t(len(starts[i]))
+		cLimits[i] = C.CString(string(limits[i]))
+		cLimitLens[i] = C.size_t(len(limits[i]))
+	}
+
+	C.rocksdb_approximate_sizes(db.db, C.int(num), &cStarts[0], &cStartLens[0],
+		&cLimits[0], &cLimitLens[0], &cSizes[0])
+
+	var sizes = make([]uint64, num)
+	for i := 0; i < num; i++ {
+		sizes[i] = uint64(cSizes[i])
+		C.free(unsafe.Pointer(cStarts[i]))
+		C.free(unsafe.Pointer(cLimits[i]))
+	}
+
+	return sizes
+}
+
+// IterDelete iterates keys in range [start, limit) and deletes them by
+// write batches, and returns the number of deleted keys.
+// RocksDB 3.8 has no DeleteRange, so every deleted key leaves a tombstone
+// until the range is compacted by CompactRange. Keys are iterated in C++,
+// with one cgo call for every batch instead of every key.
+func (db *DB) IterDelete(start, limit []byte) (uint64, error) {
+	var count uint64
+	for {
+		n, lastKey, err := db.deleteBatch(start, limit, iterDeleteBatchNum)
+		count += n
+		if err != nil || n < iterDeleteBatchNum {
+			return count, err
+		}
+		start = append(lastKey, 0) // The next key after lastKey
+	}
+}
+
+// Delete at most maxNum keys in range [start, limit) by one write batch.
+// It returns the number of deleted keys, and the last deleted key if maxNum
+// keys are deleted.
+func (db *DB) deleteBatch(start, limit []byte, maxNum int) (uint64, []byte, error) {
+	var cStart = (*C.char)(unsafe.Pointer(&start[0]))
+	var cLimit = (*C.char)(unsafe.Pointer(&limit[0]))
+
+	var errStr, cLast *C.char
+	var lastLen C.size_t
+	var count = C.gotable_delete_batch(db.db, db.wOpt, cStart,
+		C.size_t(len(start)), cLimit, C.size_t(len(limit)), C.int(maxNum),
+		&cLast, &lastLen, &errStr)
+	if errStr != nil {
+		defer C.free(unsafe.Pointer(errStr))
+		return 0, nil, errors.New(C.GoString(errStr))
+	}
+
+	var lastKey []byte
+	if cLast != nil {
+		defer C.free(unsafe.Pointer(cLast))
+		lastKey = C.GoBytes(unsafe.Pointer(cLast), C.int(lastLen))
+	}
+	return uint64(count), lastKey, nil
+}
+
+// CompactRange compacts keys in range [start, limit), so that the deleted
+// keys are dropped from disk.
+func (db *DB) CompactRange(start, limit []byte) {
+	var cStart = (*C.char)(unsafe.Pointer(&start[0]))
+	var cLimit = (*C.char)(unsafe.Pointer(&limit[0]))
+
+	C.rocksdb_compact_range(db.db, cStart, C.size_t(len(start)),
+		cLimit, C.size_t(len(limit)))
+}
+
+// GetProperty returns the value of the RocksDB property, false if the
+// property is unknown.
+func (db *DB) GetProperty(name string) (string, bool) {
+	var cName = C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	var cValue = C.rocksdb_property_value(db.db, cName)
+	if cValue == nil {
+		return "", false
+	}
+	defer C.free(unsafe.Pointer(cValue))
+	return C.GoString(cValue), true
+}
+
+// Flush writes all memtables to SST files and waits until it's done, so that
+// no WAL recovery is needed when the DB is opened next time.
+func (db *DB) Flush() error {
+	var errStr *C.char
+	C.gotable_flush(db.db, &errStr)
+	if errStr != nil {
+		defer C.free(unsafe.Pointer(errStr))
+		return errors.New(C.GoString(errStr))
+	}
+	return nil
+}
+
+// GetCacheUsage returns the memory size of entries in the block cache.
+func (db *DB) GetCacheUsage() uint64 {
+	if db.cache == nil {
+		return 0
+	}
+	return uint64(C.gotable_cache_get_usage(db.cache))
+}
+
 func boolToUchar(b bool) C.uchar {
 	if b {
 		return 1
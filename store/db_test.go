@@ -15,9 +15,51 @@
 package store
 
 import (
+	"fmt"
 	"testing"
 )
 
 func TestDB(t *testing.T) {
 
 }
+
+func TestDBIterDelete(t *testing.T) {
+	var db = getTestTable().db
+
+	// More keys than a write batch
+	var num = iterDeleteBatchNum*2 + 10
+	for i := 0; i < num; i++ {
+		var key = []byte(fmt.Sprintf("iterdel-b%05d", i))
+		if err := db.Put(key, []byte("v"), nil); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	var before, after = []byte("iterdel-a"), []byte("iterdel-c")
+	db.Put(before, []byte("v"), nil)
+	db.Put(after, []byte("v"), nil)
+
+	count, err := db.IterDelete([]byte("iterdel-b"), after)
+	if err != nil {
+		t.Fatalf("IterDelete failed: %s", err)
+	}
+	if count != uint64(num) {
+		t.Fatalf("Deleted %d keys, expect %d", count, num)
+	}
+	db.CompactRange([]byte("iterdel-b"), after)
+
+	var rOpt = db.NewReadOptions(false)
+	defer rOpt.Destroy()
+	for _, key := range [][]byte{before, after} {
+		value, err := db.Get(rOpt, key)
+		if err != nil || string(value) != "v" {
+			t.Fatalf("Key %s out of range should not be deleted", key)
+		}
+	}
+	if value, _ := db.Get(rOpt, []byte("iterdel-b00000")); value != nil {
+		t.Fatalf("Key in range should be deleted")
+	}
+
+	if count, _ = db.IterDelete([]byte("iterdel-b"), after); count != 0 {
+		t.Fatalf("Deleted %d keys again", count)
+	}
+}
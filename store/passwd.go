@@ -0,0 +1,169 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/util"
+)
+
+// DB passwords are kept in the admin table (AdminDbId, 0) at row
+// KeyDbPassword. ColKey is the dbId, and value is the encoded SCRAM keys
+// (salted verifiers) of the password.
+// An empty value means the password was removed.
+
+func hashPassword(password string) ([]byte, error) {
+	keys, err := util.NewScramKeys(password)
+	if err != nil {
+		return nil, err
+	}
+	return keys.Encode(), nil
+}
+
+func checkPassword(hash []byte, password string) bool {
+	keys, err := util.DecodeScramKeys(hash)
+	if err != nil {
+		return false
+	}
+	return keys.VerifyPassword(password)
+}
+
+// NewPasswordPkg returns the SYNC pkg which stores the hashed password of
+// dbId in the admin table, or removes the password if it's empty.
+// The pkg is applied by Table.Sync, and replicated to slaves by binlog.
+func NewPasswordPkg(dbId uint8, password string) ([]byte, error) {
+	var hash []byte
+	if password != "" {
+		var err error
+		hash, err = hashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newAdminSyncPkg(KeyDbPassword, []byte{dbId}, hash)
+}
+
+// Get the SYNC pkg which sets a column of the admin table.
+func newAdminSyncPkg(rowKey string, colKey, value []byte) ([]byte, error) {
+	var kv proto.KeyValue
+	kv.TableId = 0
+	kv.RowKey = []byte(rowKey)
+	kv.ColKey = colKey
+	if len(value) > 0 {
+		kv.SetValue(value)
+	}
+
+	var p proto.PkgMultiOp
+	p.Cmd = proto.CmdSync
+	p.DbId = proto.AdminDbId
+	p.Kvs = []proto.KeyValue{kv}
+
+	var pkg = make([]byte, p.Length())
+	_, err := p.Encode(pkg)
+	if err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// Iterate the non-empty columns of rowKey in the admin table.
+func (tbl *Table) scanAdminRow(rowKey string, fn func(colKey, value []byte)) {
+	var rOpt = tbl.db.NewReadOptions(false)
+	rOpt.SetFillCache(false)
+	defer rOpt.Destroy()
+	var it = tbl.db.NewIterator(rOpt)
+	defer it.Destroy()
+
+	var row = []byte(rowKey)
+	for it.Seek(getRawKey(proto.AdminDbId, 0, proto.ColSpaceDefault,
+		row, nil)); it.Valid(); it.Next() {
+		_, dbId, tableId, colSpace, curRowKey, colKey := parseRawKey(it.Key())
+		if dbId != proto.AdminDbId || tableId != 0 ||
+			colSpace != proto.ColSpaceDefault || !bytes.Equal(curRowKey, row) {
+			break
+		}
+
+		value, _ := parseRawValue(it.Value())
+		if len(value) > 0 {
+			fn(colKey, value)
+		}
+	}
+}
+
+// Whether the sync kv of dbId sets a column of rowKey in the admin table.
+func isAdminKV(dbId uint8, kv *proto.KeyValue, rowKey string) bool {
+	return dbId == proto.AdminDbId && kv.TableId == 0 &&
+		kv.ColSpace == proto.ColSpaceDefault && string(kv.RowKey) == rowKey
+}
+
+// LoadPasswords reads the hashed DB passwords from the admin table.
+func (tbl *Table) LoadPasswords() {
+	var pwdHash = make(map[uint8][]byte)
+	tbl.scanAdminRow(KeyDbPassword, func(colKey, value []byte) {
+		if len(colKey) == 1 {
+			pwdHash[colKey[0]] = value
+		}
+	})
+
+	tbl.mtx.Lock()
+	tbl.pwdHash = pwdHash
+	tbl.mtx.Unlock()
+}
+
+// Update the DB passwords in memory after the sync kvs are written.
+func (tbl *Table) syncPasswords(dbId uint8, kvs []proto.KeyValue) {
+	if dbId != proto.AdminDbId {
+		return
+	}
+
+	tbl.mtx.Lock()
+	for i := 0; i < len(kvs); i++ {
+		if !isAdminKV(dbId, &kvs[i], KeyDbPassword) || len(kvs[i].ColKey) != 1 {
+			continue
+		}
+		if tbl.pwdHash == nil {
+			tbl.pwdHash = make(map[uint8][]byte)
+		}
+		if len(kvs[i].Value) > 0 {
+			tbl.pwdHash[kvs[i].ColKey[0]] = append([]byte(nil), kvs[i].Value...)
+		} else {
+			delete(tbl.pwdHash, kvs[i].ColKey[0])
+		}
+	}
+	tbl.mtx.Unlock()
+}
+
+// HasPassword returns whether the DB has a password set by command.
+func (tbl *Table) HasPassword(dbId uint8) bool {
+	tbl.mtx.Lock()
+	var ok = len(tbl.pwdHash[dbId]) > 0
+	tbl.mtx.Unlock()
+	return ok
+}
+
+// GetPasswordDbs returns the DBs having passwords set by command.
+func (tbl *Table) GetPasswordDbs() []int {
+	var dbs []int
+	tbl.mtx.Lock()
+	for i := 0; i < proto.AdminDbId; i++ {
+		if len(tbl.pwdHash[uint8(i)]) > 0 {
+			dbs = append(dbs, i)
+		}
+	}
+	tbl.mtx.Unlock()
+	return dbs
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"testing"
+)
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := hashPassword("secret")
+	if err != nil {
+		t.Fatalf("hashPassword failed: %s", err)
+	}
+	if !checkPassword(hash, "secret") {
+		t.Fatalf("Password should match")
+	}
+	if checkPassword(hash, "secret2") || checkPassword(nil, "") {
+		t.Fatalf("Password should not match")
+	}
+
+	hash2, _ := hashPassword("secret")
+	if string(hash) == string(hash2) {
+		t.Fatalf("Hashes of the same password should be salted")
+	}
+}
+
+func TestTablePassword(t *testing.T) {
+	var tbl = getTestTable()
+
+	var syncPassword = func(dbId uint8, password string) {
+		pkg, err := NewPasswordPkg(dbId, password)
+		if err != nil {
+			t.Fatalf("NewPasswordPkg failed: %s", err)
+		}
+		_, ok := tbl.Sync(&PkgArgs{proto.CmdSync, proto.AdminDbId, 0, pkg})
+		if !ok {
+			t.Fatalf("Sync password failed")
+		}
+	}
+
+	syncPassword(3, "pwd3")
+	syncPassword(4, "pwd4")
+	if !tbl.HasPassword(3) || !checkPassword(tbl.pwdHash[3], "pwd3") {
+		t.Fatalf("Password of DB 3 not set")
+	}
+
+	syncPassword(4, "")
+	if tbl.HasPassword(4) {
+		t.Fatalf("Password of DB 4 not removed")
+	}
+
+	// Reload from the admin table
+	tbl.mtx.Lock()
+	tbl.pwdHash = nil
+	tbl.mtx.Unlock()
+	tbl.LoadPasswords()
+
+	var dbs = tbl.GetPasswordDbs()
+	if len(dbs) != 1 || dbs[0] != 3 {
+		t.Fatalf("Invalid password DBs %v", dbs)
+	}
+	if !checkPassword(tbl.pwdHash[3], "pwd3") {
+		t.Fatalf("Password of DB 3 not loaded")
+	}
+
+	syncPassword(3, "")
+}
@@ -0,0 +1,156 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/util"
+)
+
+// Challenge-response auth (CmdScram) takes two round trips on PkgOneOp:
+//   1. Client sends DbId, ColKey=user name (empty for DB/admin password) and
+//      RowKey=client nonce.
+//      Server replies RowKey=client nonce+server nonce, Value=salt and
+//      Score=iter. A reply without Value means already authorized.
+//   2. Client sends RowKey=the combined nonce and Value=client proof.
+//      Server replies Value=server signature and DbId=the authorized DB.
+// Without a user name, the password of the selected DB is challenged if it
+// has one, otherwise the admin password.
+
+// State of the challenge-response auth in progress on a connection
+type ScramState struct {
+	name   string
+	dbId   uint8
+	nonce  []byte
+	keys   *util.ScramKeys // nil if the credential not exist
+	user   *userRecord     // nil for DB/admin password
+	authDB uint8           // DB authorized by password
+}
+
+func (tbl *Table) Scram(req *PkgArgs, au Authorize) []byte {
+	var in proto.PkgOneOp
+	_, err := in.Decode(req.Pkg)
+	if err != nil {
+		in.CtrlFlag &^= 0xFF // Clear all ctrl flags
+		in.SetErrCode(table.EcDecodeFail)
+		return replyHandle(&in)
+	}
+
+	in.ErrCode = 0
+	if in.CtrlFlag&proto.CtrlValue == 0 {
+		tbl.scramFirst(&in, au)
+	} else {
+		tbl.scramFinal(&in, au)
+	}
+
+	return replyHandle(&in)
+}
+
+func (tbl *Table) scramFirst(in *proto.PkgOneOp, au Authorize) {
+	au.SetScram(nil)
+	in.SetScore(0)
+	if len(in.RowKey) < util.ScramNonceLen {
+		in.SetErrCode(table.EcAuthFailed)
+		return
+	}
+
+	var st = new(ScramState)
+	st.name = string(in.ColKey)
+	st.dbId = in.DbId
+
+	tbl.mtx.Lock()
+	var noAuth = tbl.authKeys == nil
+	if st.name != "" {
+		if rec := tbl.users[st.name]; rec != nil {
+			st.keys, _ = util.DecodeScramKeys(rec.Hash)
+			st.user = rec
+		}
+	} else if !noAuth {
+		var hash = tbl.pwdHash[in.DbId]
+		if keys := tbl.authKeys[in.DbId]; keys != nil {
+			st.keys, st.authDB = keys, in.DbId
+		} else if len(hash) > 0 {
+			st.keys, _ = util.DecodeScramKeys(hash)
+			st.authDB = in.DbId
+		} else {
+			st.keys, st.authDB = tbl.authKeys[proto.AdminDbId], proto.AdminDbId
+		}
+	}
+	tbl.mtx.Unlock()
+
+	// Auth disabled, or already admin
+	if st.name == "" && (noAuth || au.IsAuth(proto.AdminDbId)) {
+		if noAuth {
+			in.DbId = proto.AdminDbId
+			au.SetAuth(proto.AdminDbId)
+			in.RowKey = []byte(util.ScramAuthDisabled)
+		} else {
+			in.RowKey = []byte(util.ScramAlreadyAdmin)
+		}
+		return
+	}
+
+	serverNonce, err := util.NewScramNonce()
+	if err != nil {
+		in.SetErrCode(table.EcAuthFailed)
+		return
+	}
+	st.nonce = append(append([]byte(nil), in.RowKey...), serverNonce...)
+
+	var salt []byte
+	var iter = util.ScramIter
+	if st.keys != nil {
+		salt, iter = st.keys.Salt, st.keys.Iter
+	} else {
+		// A fake salt, so that the failure is only known after the proof
+		salt, _ = util.NewScramNonce()
+	}
+
+	au.SetScram(st)
+	in.RowKey = st.nonce
+	in.SetValue(salt)
+	in.SetScore(int64(iter))
+}
+
+func (tbl *Table) scramFinal(in *proto.PkgOneOp, au Authorize) {
+	var st = au.GetScram()
+	au.SetScram(nil)
+
+	var proof = in.Value
+	in.SetValue(nil)
+	in.SetScore(0)
+	if st == nil || st.keys == nil || st.dbId != in.DbId ||
+		st.name != string(in.ColKey) || !bytes.Equal(st.nonce, in.RowKey) {
+		in.SetErrCode(table.EcAuthFailed)
+		return
+	}
+
+	var msg = util.ScramAuthMessage(st.name, st.dbId, st.nonce,
+		st.keys.Salt, st.keys.Iter)
+	if !st.keys.VerifyProof(msg, proof) {
+		in.SetErrCode(table.EcAuthFailed)
+		return
+	}
+
+	in.SetValue(st.keys.ServerSignature(msg))
+	if st.user != nil {
+		in.DbId = authorizeUser(st.user, au)
+	} else {
+		in.DbId = st.authDB
+		au.SetAuth(st.authDB)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/util"
+	"testing"
+)
+
+func TestTableScram(t *testing.T) {
+	var tbl = getTestTable()
+
+	var sync = func(pkg []byte, err error) {
+		if err != nil {
+			t.Fatalf("New admin pkg failed: %s", err)
+		}
+		_, ok := tbl.Sync(&PkgArgs{proto.CmdSync, proto.AdminDbId, 0, pkg})
+		if !ok {
+			t.Fatalf("Sync admin pkg failed")
+		}
+	}
+
+	var call = func(in *proto.PkgOneOp, au Authorize) proto.PkgOneOp {
+		var pkg = make([]byte, in.Length())
+		in.Encode(pkg)
+
+		var out proto.PkgOneOp
+		out.Decode(tbl.Scram(&PkgArgs{in.Cmd, in.DbId, in.Seq, pkg}, au))
+		return out
+	}
+
+	var scram = func(name string, dbId uint8, password string,
+		au Authorize) proto.PkgOneOp {
+		var in proto.PkgOneOp
+		in.Cmd = proto.CmdScram
+		in.DbId = dbId
+		in.ColKey = []byte(name)
+		in.RowKey, _ = util.NewScramNonce()
+
+		var out = call(&in, au)
+		if out.ErrCode != 0 || len(out.Value) == 0 {
+			return out
+		}
+
+		var msg = util.ScramAuthMessage(name, dbId, out.RowKey, out.Value,
+			int(out.Score))
+		proof, serverSig := util.ScramClientProof(password, out.Value,
+			int(out.Score), msg)
+		in.RowKey = out.RowKey
+		in.SetValue(proof)
+
+		out = call(&in, au)
+		if out.ErrCode == 0 && !bytes.Equal(out.Value, serverSig) {
+			t.Fatalf("Invalid server signature")
+		}
+		return out
+	}
+
+	tbl.SetPassword(proto.AdminDbId, "admin")
+	sync(NewPasswordPkg(6, "pwd6"))
+	sync(NewUserPkg(&ctrl.UserInfo{Name: "writer", Role: ctrl.RoleWrite,
+		DbId: 7}, "pwdw"))
+
+	var au permAuth
+	if out := scram("writer", 0, "bad", &au); out.ErrCode != table.EcAuthFailed {
+		t.Fatalf("Auth with bad password should fail")
+	}
+	if out := scram("nobody", 0, "pwdw", &au); out.ErrCode != table.EcAuthFailed {
+		t.Fatalf("Auth of unknown user should fail")
+	}
+	var out = scram("writer", 0, "pwdw", &au)
+	if out.ErrCode != 0 || out.DbId != 7 || !au.CanAccess(7, 1, true) {
+		t.Fatalf("User auth failed: %d, %d", out.ErrCode, out.DbId)
+	}
+
+	au = permAuth{}
+	if out = scram("", 6, "admin", &au); out.ErrCode != table.EcAuthFailed {
+		t.Fatalf("Admin password should not match DB having its own password")
+	}
+	if out = scram("", 6, "pwd6", &au); out.ErrCode != 0 || out.DbId != 6 {
+		t.Fatalf("DB password auth failed: %d, %d", out.ErrCode, out.DbId)
+	}
+	if out = scram("", 8, "admin", &au); out.ErrCode != 0 ||
+		out.DbId != proto.AdminDbId || !au.admin {
+		t.Fatalf("Admin password auth failed: %d, %d", out.ErrCode, out.DbId)
+	}
+	if out = scram("", 6, "any", &au); out.ErrCode != 0 || len(out.Value) != 0 ||
+		string(out.RowKey) != util.ScramAlreadyAdmin {
+		t.Fatalf("Admin should get no challenge: %d, %q", out.ErrCode, out.RowKey)
+	}
+
+	// The final step without challenge
+	var in proto.PkgOneOp
+	in.Cmd = proto.CmdScram
+	in.RowKey = []byte("nonce")
+	in.SetValue([]byte("proof"))
+	if out = call(&in, &permAuth{}); out.ErrCode != table.EcAuthFailed {
+		t.Fatalf("Proof without challenge should fail")
+	}
+
+	sync(NewPasswordPkg(6, ""))
+	sync(NewDelUserPkg("writer"))
+	tbl.mtx.Lock()
+	tbl.authPwd, tbl.authKeys = nil, nil
+	tbl.mtx.Unlock()
+
+	out = scram("", 6, "", &permAuth{})
+	if out.ErrCode != 0 || string(out.RowKey) != util.ScramAuthDisabled {
+		t.Fatalf("Auth disabled should be told: %d, %q", out.ErrCode, out.RowKey)
+	}
+}
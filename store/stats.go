@@ -0,0 +1,265 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/binary"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxSizeRanges = 4096 // Max ranges of one GetApproximateSizes call
+)
+
+// RocksDB statistics
+type DBStats struct {
+	MemTableSize      uint64 // Memory size of all memtables
+	ImmMemTables      uint64 // Number of immutable memtables not flushed yet
+	FlushPending      uint64 // 1 if a memtable flush is pending
+	CompactionPending uint64 // 1 if a compaction is pending
+	EstimateKeys      uint64 // Estimated number of keys
+	BackgroundErrors  uint64 // Accumulated number of background errors
+	SstSize           uint64 // Approximate size of all SST files
+	BlockCacheUsage   uint64 // Memory size of entries in the block cache
+}
+
+// GetDBStats returns the RocksDB statistics.
+func (tbl *Table) GetDBStats() DBStats {
+	var st DBStats
+	st.MemTableSize = tbl.getIntProperty("rocksdb.cur-size-all-mem-tables")
+	st.ImmMemTables = tbl.getIntProperty("rocksdb.num-immutable-mem-table")
+	st.FlushPending = tbl.getIntProperty("rocksdb.mem-table-flush-pending")
+	st.CompactionPending = tbl.getIntProperty("rocksdb.compaction-pending")
+	st.EstimateKeys = tbl.getIntProperty("rocksdb.estimate-num-keys")
+	st.BackgroundErrors = tbl.getIntProperty("rocksdb.background-errors")
+	st.SstSize = tbl.GetSlotsSize([]ctrl.SlotRange{{Start: 0,
+		End: ctrl.TotalSlotNum - 1}})
+	st.BlockCacheUsage = tbl.db.GetCacheUsage()
+	return st
+}
+
+func (tbl *Table) getIntProperty(name string) uint64 {
+	value, ok := tbl.db.GetProperty(name)
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+	return n
+}
+
+// Key counts of every table prefix (wSlotId+cDbId+cTableId), computed by
+// iterating all keys in the background.
+type keyCounter struct {
+	counting bool
+	doneTime time.Time         // Last counting finished time
+	counts   map[uint32]uint64 // Table prefix => key count
+}
+
+// Table prefix wSlotId+cDbId+cTableId as a number.
+func tablePrefix(slotId uint16, dbId, tableId uint8) uint32 {
+	return uint32(slotId)<<16 | uint32(dbId)<<8 | uint32(tableId)
+}
+
+func getRawPrefixKey(prefix uint32) []byte {
+	var rawKey = make([]byte, 4)
+	binary.BigEndian.PutUint32(rawKey, prefix)
+	return rawKey
+}
+
+// GetSlotStats returns the approximate data size of the slots with data,
+// and the key counts of the last counting (0 if never counted).
+func (tbl *Table) GetSlotStats(slots []ctrl.SlotRange) []ctrl.SlotStat {
+	var ss = ctrl.NewSlotSet(slots)
+	var ids []uint16
+	var starts, limits [][]byte
+	for _, r := range ss.Ranges() {
+		for i := uint32(r.Start); i <= uint32(r.End); i++ {
+			ids = append(ids, uint16(i))
+			starts = append(starts, getRawSlotKey(uint16(i), 0, 0))
+			limits = append(limits, getRawSlotKey(uint16(i+1), 0, 0))
+		}
+	}
+
+	var keys = make(map[uint16]uint64)
+	tbl.mtx.Lock()
+	for prefix, n := range tbl.kc.counts {
+		keys[uint16(prefix>>16)] += n
+	}
+	tbl.mtx.Unlock()
+
+	var stats []ctrl.SlotStat
+	var sizes = tbl.getApproximateSizes(starts, limits)
+	for i, slotId := range ids {
+		if sizes[i] == 0 && keys[slotId] == 0 {
+			continue
+		}
+		stats = append(stats, ctrl.SlotStat{SlotId: slotId,
+			Size: sizes[i], Keys: keys[slotId]})
+	}
+	return stats
+}
+
+// GetTableStats returns the approximate data size of every db/table in the
+// slots, and the key counts of the last counting (0 if never counted).
+// The reserved admin table is excluded.
+func (tbl *Table) GetTableStats(slots []ctrl.SlotRange) []ctrl.TableStat {
+	var prefixes = tbl.getTablePrefixes(ctrl.NewSlotSet(slots).Ranges())
+
+	var starts = make([][]byte, len(prefixes))
+	var limits = make([][]byte, len(prefixes))
+	for i, prefix := range prefixes {
+		starts[i] = getRawPrefixKey(prefix)
+		limits[i] = getRawPrefixKey(prefix + 1)
+	}
+	var sizes = tbl.getApproximateSizes(starts, limits)
+
+	var stats = make(map[uint16]*ctrl.TableStat)
+	tbl.mtx.Lock()
+	for i, prefix := range prefixes {
+		var id = uint16(prefix)
+		st, ok := stats[id]
+		if !ok {
+			st = &ctrl.TableStat{DbId: uint8(prefix >> 8), TableId: uint8(prefix)}
+			stats[id] = st
+		}
+		st.Size += sizes[i]
+		st.Keys += tbl.kc.counts[prefix]
+	}
+	tbl.mtx.Unlock()
+
+	var res = make([]ctrl.TableStat, 0, len(stats))
+	for _, st := range stats {
+		res = append(res, *st)
+	}
+	sort.Sort(tableStatSlice(res))
+	return res
+}
+
+// Find table prefixes with data in the slots, by seeking to the next prefix
+// after every key found.
+func (tbl *Table) getTablePrefixes(slots []ctrl.SlotRange) []uint32 {
+	var rOpt = tbl.db.NewReadOptions(false)
+	rOpt.SetFillCache(false)
+	defer rOpt.Destroy()
+	var it = tbl.db.NewIterator(rOpt)
+	defer it.Destroy()
+
+	var prefixes []uint32
+	for _, r := range slots {
+		var last = tablePrefix(r.End, 255, 255)
+		for it.Seek(getRawSlotKey(r.Start, 0, 0)); it.Valid(); {
+			slotId, dbId, tableId := parseRawKeySlotId(it.Key())
+			var prefix = tablePrefix(slotId, dbId, tableId)
+			if prefix > last {
+				break
+			}
+			if dbId != proto.AdminDbId || tableId != 0 {
+				prefixes = append(prefixes, prefix)
+			}
+			if prefix == last {
+				break
+			}
+			it.Seek(getRawPrefixKey(prefix + 1))
+		}
+	}
+	return prefixes
+}
+
+func (tbl *Table) getApproximateSizes(starts, limits [][]byte) []uint64 {
+	var sizes = make([]uint64, 0, len(starts))
+	for i := 0; i < len(starts); i += maxSizeRanges {
+		var end = i + maxSizeRanges
+		if end > len(starts) {
+			end = len(starts)
+		}
+		sizes = append(sizes,
+			tbl.db.GetApproximateSizes(starts[i:end], limits[i:end])...)
+	}
+	return sizes
+}
+
+// StartKeyCount starts counting keys of every slot and table in the
+// background. It returns false if the counting is already running.
+func (tbl *Table) StartKeyCount() bool {
+	tbl.mtx.Lock()
+	defer tbl.mtx.Unlock()
+	if tbl.kc.counting {
+		return false
+	}
+
+	tbl.kc.counting = true
+	go tbl.countKeys()
+	return true
+}
+
+// GetKeyCountStatus returns whether the key counting is running, and the
+// finished time of the last counting (zero if never counted).
+func (tbl *Table) GetKeyCountStatus() (bool, time.Time) {
+	tbl.mtx.Lock()
+	defer tbl.mtx.Unlock()
+	return tbl.kc.counting, tbl.kc.doneTime
+}
+
+func (tbl *Table) countKeys() {
+	log.Println("Start counting keys")
+	var startTime = time.Now()
+	var counts = make(map[uint32]uint64)
+	var total uint64
+
+	var it = tbl.NewIterator(false)
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		var rawKey = it.Key()
+		if len(rawKey) < 6 {
+			continue
+		}
+		slotId, dbId, tableId := parseRawKeySlotId(rawKey)
+		if dbId == proto.AdminDbId && tableId == 0 {
+			continue // Reserved admin table
+		}
+		var colTypePos = 5 + int(rawKey[4])
+		if colTypePos < len(rawKey) && rawKey[colTypePos] == proto.ColSpaceScore1 {
+			continue // Every zset key has a score index
+		}
+		counts[tablePrefix(slotId, dbId, tableId)]++
+		total++
+	}
+	it.Destroy()
+
+	tbl.mtx.Lock()
+	tbl.kc.counting = false
+	tbl.kc.doneTime = time.Now()
+	tbl.kc.counts = counts
+	tbl.mtx.Unlock()
+
+	log.Printf("Counting keys finished, %d keys, cost %s\n",
+		total, time.Since(startTime))
+}
+
+type tableStatSlice []ctrl.TableStat
+
+func (s tableStatSlice) Len() int      { return len(s) }
+func (s tableStatSlice) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s tableStatSlice) Less(i, j int) bool {
+	if s[i].DbId != s[j].DbId {
+		return s[i].DbId < s[j].DbId
+	}
+	return s[i].TableId < s[j].TableId
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"github.com/stevejiang/gotable/ctrl"
+	"testing"
+	"time"
+)
+
+func TestTableKeyCount(t *testing.T) {
+	var tbl = getTestTable()
+
+	var rowKey = []byte("key-count-row")
+	var slotId = ctrl.GetSlotId(5, 7, rowKey)
+	for _, col := range []string{"col1", "col2", "col3"} {
+		err := tbl.db.Put(getRawKey(5, 7, 0, rowKey, []byte(col)), []byte("v"), nil)
+		if err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+
+	if !tbl.StartKeyCount() {
+		t.Fatalf("StartKeyCount failed")
+	}
+	for i := 0; i < 100; i++ {
+		if counting, _ := tbl.GetKeyCountStatus(); !counting {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	counting, doneTime := tbl.GetKeyCountStatus()
+	if counting || doneTime.IsZero() {
+		t.Fatalf("Key counting not finished")
+	}
+
+	var slots = []ctrl.SlotRange{{Start: slotId, End: slotId}}
+	var ts = tbl.GetTableStats(slots)
+	if len(ts) != 1 || ts[0].DbId != 5 || ts[0].TableId != 7 || ts[0].Keys != 3 {
+		t.Fatalf("Invalid table stats %v", ts)
+	}
+
+	var ss = tbl.GetSlotStats(slots)
+	if len(ss) != 1 || ss[0].SlotId != slotId || ss[0].Keys != 3 {
+		t.Fatalf("Invalid slot stats %v", ss)
+	}
+}
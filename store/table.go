@@ -21,6 +21,7 @@ import (
 	"github.com/stevejiang/gotable/api/go/table"
 	"github.com/stevejiang/gotable/api/go/table/proto"
 	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/util"
 	"log"
 	"os"
 	"sync"
@@ -35,6 +36,13 @@ const (
 	KeyFullSyncEnd    = "full-sync-end"
 	KeyIncrSyncEnd    = "incr-sync-end"
 	KeySyncLogMissing = "sync-log-missing"
+	KeyFullSyncSlot   = "full-sync-slot" // Migration: the slot under full sync
+	KeyFullSyncSize   = "full-sync-size" // Estimated bytes of full sync
+	KeySyncSeq        = "sync-seq"       // Master seq and the last seq sent
+	KeyRepairSlots    = "repair-slots"   // Slots to delete before re-sync
+	KeyVerifySlots    = "verify-slots"   // Slots and seq of verify snapshot
+	KeyDbPassword     = "db-password"    // Hashed DB passwords, colKey is dbId
+	KeyUsers          = "users"          // Named users, colKey is user name
 )
 
 const (
@@ -59,6 +67,16 @@ type Authorize interface {
 
 	// If dbId is already authorized, SetAuth keeps this infomation
 	SetAuth(dbId uint8)
+
+	// Check whether the table can be read, or written if write is true
+	CanAccess(dbId, tableId uint8, write bool) bool
+
+	// Authorize dbId by a named user with the table permission
+	SetPerm(dbId uint8, perm *Perm)
+
+	// Keep the state of the challenge-response auth in progress
+	SetScram(st *ScramState)
+	GetScram() *ScramState
 }
 
 type Table struct {
@@ -66,12 +84,16 @@ type Table struct {
 	tl    *TableLock
 	rwMtx sync.RWMutex // stop write to NewIterator
 
-	mtx     sync.Mutex // protects following
-	authPwd []string
+	mtx      sync.Mutex // protects following
+	authPwd  []string
+	authKeys []*util.ScramKeys // SCRAM keys of authPwd
+	pwdHash  map[uint8][]byte  // Encoded SCRAM keys of DB passwords set by command
+	users    map[string]*userRecord
+	kc       keyCounter
 }
 
-func NewTable(tableDir string, maxOpenFiles int,
-	writeBufSize int, cacheSize int64, compression string) *Table {
+func NewTable(tableDir string, maxOpenFiles int, writeBufSize int,
+	cacheSize int64, compression string, rateLimit int64) *Table {
 	os.MkdirAll(tableDir, os.ModeDir|os.ModePerm)
 
 	var comp int = kNoCompression
@@ -96,20 +118,27 @@ func NewTable(tableDir string, maxOpenFiles int,
 	tbl.tl = NewTableLock()
 
 	tbl.db = NewDB()
-	err := tbl.db.Open(tableDir, true, maxOpenFiles, writeBufSize, cacheSize, comp)
+	err := tbl.db.Open(tableDir, true, maxOpenFiles, writeBufSize, cacheSize,
+		comp, rateLimit)
 	if err != nil {
 		log.Println("Open DB failed: ", err)
 		return nil
 	}
 
 	log.Printf("Open DB with maxOpenFiles %d, writeBufSize %dMB, cacheSize %dMB, "+
-		"compression(%s, %d)\n",
-		maxOpenFiles, writeBufSize/1048576, cacheSize/1048576, compression, comp)
+		"compression(%s, %d), rateLimit %dMB/s\n",
+		maxOpenFiles, writeBufSize/1048576, cacheSize/1048576, compression, comp,
+		rateLimit/1048576)
 
 	return tbl
 }
 
+// Close flushes memtables and closes the DB. Nothing should use the table
+// after it's closed.
 func (tbl *Table) Close() {
+	if err := tbl.db.Flush(); err != nil {
+		log.Printf("Flush table failed: %s\n", err)
+	}
 	tbl.db.Close()
 }
 
@@ -121,8 +150,17 @@ func (tbl *Table) SetPassword(dbId uint8, password string) {
 	tbl.mtx.Lock()
 	if tbl.authPwd == nil {
 		tbl.authPwd = make([]string, 256)
+		tbl.authKeys = make([]*util.ScramKeys, 256)
 	}
 	tbl.authPwd[dbId] = password
+	tbl.authKeys[dbId] = nil
+	if password != "" {
+		keys, err := util.NewScramKeys(password)
+		if err != nil {
+			log.Printf("Create SCRAM keys of DB %d failed: %s\n", dbId, err)
+		}
+		tbl.authKeys[dbId] = keys
+	}
 	tbl.mtx.Unlock()
 }
 
@@ -136,35 +174,43 @@ func (tbl *Table) Auth(req *PkgArgs, au Authorize) []byte {
 	}
 
 	in.ErrCode = 0
+	if len(in.ColKey) > 0 {
+		// Named user
+		tbl.authUser(&in, au)
+		return replyHandle(&in)
+	}
+
 	var authDB uint8
 
-	var already bool
+	// Already admin. A DB authorized by a named user may have limited
+	// access, so the password of the DB is always checked.
 	if au.IsAuth(proto.AdminDbId) {
-		authDB = proto.AdminDbId
-		already = true
-	} else if in.DbId != proto.AdminDbId && au.IsAuth(in.DbId) {
-		authDB = in.DbId
-		already = true
-	}
-	if already {
 		return replyHandle(&in)
 	}
 
 	password := string(in.RowKey)
 
 	tbl.mtx.Lock()
+	var noAuth = tbl.authPwd == nil
+	var adminPwd, dbPwd string
+	if !noAuth {
+		adminPwd, dbPwd = tbl.authPwd[proto.AdminDbId], tbl.authPwd[in.DbId]
+	}
+	var hash = tbl.pwdHash[in.DbId]
+	tbl.mtx.Unlock()
+
 	// Admin password
-	if tbl.authPwd == nil || tbl.authPwd[proto.AdminDbId] == password {
+	if noAuth || adminPwd == password {
 		authDB = proto.AdminDbId
 	} else {
-		// Selected DB password
-		if len(password) > 0 && tbl.authPwd[in.DbId] == password {
+		// Selected DB password, hash is checked without lock as it's slow
+		if len(password) > 0 && (dbPwd == password ||
+			checkPassword(hash, password)) {
 			authDB = in.DbId
 		} else {
 			in.SetErrCode(table.EcAuthFailed)
 		}
 	}
-	tbl.mtx.Unlock()
 
 	// Success
 	if in.ErrCode == 0 {
@@ -519,6 +565,8 @@ func (tbl *Table) Sync(req *PkgArgs) ([]byte, bool) {
 		wb.Destroy()
 
 		if err == nil {
+			tbl.syncPasswords(in.DbId, in.Kvs)
+			tbl.syncUsers(in.DbId, in.Kvs)
 			return nil, true
 		} else {
 			in.SetErrCode(table.EcWriteFail)
@@ -533,7 +581,7 @@ func (tbl *Table) Sync(req *PkgArgs) ([]byte, bool) {
 
 func (tbl *Table) Get(req *PkgArgs, au Authorize, wa *WriteAccess) []byte {
 	var in proto.PkgOneOp
-	if checkOneOp(&in, req, au) {
+	if checkOneOp(&in, req, au, false) {
 		zop := (in.PkgFlag&proto.FlagZop != 0)
 		err := tbl.getKV(nil, zop, in.DbId, &in.KeyValue, wa)
 		if err != nil {
@@ -546,7 +594,7 @@ func (tbl *Table) Get(req *PkgArgs, au Authorize, wa *WriteAccess) []byte {
 
 func (tbl *Table) MGet(req *PkgArgs, au Authorize, wa *WriteAccess) []byte {
 	var in proto.PkgMultiOp
-	if checkMultiOp(&in, req, au) {
+	if checkMultiOp(&in, req, au, false) {
 		var rOpt = tbl.db.NewReadOptions(true)
 		defer rOpt.Destroy()
 		zop := (in.PkgFlag&proto.FlagZop != 0)
@@ -564,7 +612,7 @@ func (tbl *Table) MGet(req *PkgArgs, au Authorize, wa *WriteAccess) []byte {
 
 func (tbl *Table) Set(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool) {
 	var in proto.PkgOneOp
-	if checkOneOp(&in, req, au) {
+	if checkOneOp(&in, req, au, true) {
 		zop := (in.PkgFlag&proto.FlagZop != 0)
 		tbl.rwMtx.RLock()
 		err := tbl.setKV(nil, zop, in.DbId, &in.KeyValue, wa)
@@ -580,7 +628,7 @@ func (tbl *Table) Set(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool
 
 func (tbl *Table) MSet(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool) {
 	var in proto.PkgMultiOp
-	if checkMultiOp(&in, req, au) {
+	if checkMultiOp(&in, req, au, true) {
 		var wb = tbl.db.NewWriteBatch()
 		defer wb.Destroy()
 		zop := (in.PkgFlag&proto.FlagZop != 0)
@@ -600,7 +648,7 @@ func (tbl *Table) MSet(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, boo
 
 func (tbl *Table) Del(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool) {
 	var in proto.PkgOneOp
-	if checkOneOp(&in, req, au) {
+	if checkOneOp(&in, req, au, true) {
 		zop := (in.PkgFlag&proto.FlagZop != 0)
 		tbl.rwMtx.RLock()
 		err := tbl.delKV(nil, zop, in.DbId, &in.KeyValue, wa)
@@ -616,7 +664,7 @@ func (tbl *Table) Del(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool
 
 func (tbl *Table) MDel(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool) {
 	var in proto.PkgMultiOp
-	if checkMultiOp(&in, req, au) {
+	if checkMultiOp(&in, req, au, true) {
 		var wb = tbl.db.NewWriteBatch()
 		defer wb.Destroy()
 		zop := (in.PkgFlag&proto.FlagZop != 0)
@@ -636,7 +684,7 @@ func (tbl *Table) MDel(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, boo
 
 func (tbl *Table) Incr(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool) {
 	var in proto.PkgOneOp
-	if checkOneOp(&in, req, au) {
+	if checkOneOp(&in, req, au, true) {
 		zop := (in.PkgFlag&proto.FlagZop != 0)
 		tbl.rwMtx.RLock()
 		err := tbl.incrKV(nil, zop, in.DbId, &in.KeyValue, wa)
@@ -652,7 +700,7 @@ func (tbl *Table) Incr(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, boo
 
 func (tbl *Table) MIncr(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool) {
 	var in proto.PkgMultiOp
-	if checkMultiOp(&in, req, au) {
+	if checkMultiOp(&in, req, au, true) {
 		var wb = tbl.db.NewWriteBatch()
 		defer wb.Destroy()
 		zop := (in.PkgFlag&proto.FlagZop != 0)
@@ -796,7 +844,7 @@ func (tbl *Table) Scan(req *PkgArgs, au Authorize) []byte {
 		return errorHandle(&out, table.EcInvDbId)
 	}
 
-	if !au.IsAuth(in.DbId) {
+	if !au.CanAccess(in.DbId, in.TableId, false) {
 		return errorHandle(&out, table.EcNoPrivilege)
 	}
 
@@ -919,11 +967,12 @@ func (tbl *Table) Dump(req *PkgArgs, au Authorize) []byte {
 		return errorHandle(&out, table.EcInvDbId)
 	}
 
-	if !au.IsAuth(in.DbId) {
+	var onlyOneTable = (out.PkgFlag&proto.FlagDumpTable != 0)
+	if !au.IsAuth(in.DbId) ||
+		(onlyOneTable && !au.CanAccess(in.DbId, in.TableId, false)) {
 		return errorHandle(&out, table.EcNoPrivilege)
 	}
 
-	var onlyOneTable = (out.PkgFlag&proto.FlagDumpTable != 0)
 	var rOpt = tbl.db.NewReadOptions(false)
 	rOpt.SetFillCache(false)
 	defer rOpt.Destroy()
@@ -994,6 +1043,16 @@ func (tbl *Table) Dump(req *PkgArgs, au Authorize) []byte {
 			}
 		}
 
+		if !onlyOneTable && !au.CanAccess(dbId, tableId, false) {
+			// Skip the table not readable by the user
+			if tableId < proto.MaxUint8 {
+				seekToSlot(it, slotId, dbId, tableId+1)
+			} else {
+				seekToSlot(it, slotId, dbId+1, 0)
+			}
+			continue
+		}
+
 		if colSpace == proto.ColSpaceScore2 {
 			it.Seek(getRawKey(dbId, tableId, colSpace+1, rowKey, nil))
 			continue // No need to dup dump
@@ -1048,47 +1107,57 @@ func (tbl *Table) Dump(req *PkgArgs, au Authorize) []byte {
 	return pkg
 }
 
-func (tbl *Table) DeleteSlot(slotId uint16) error {
-	var rOpt = tbl.db.NewReadOptions(false)
-	rOpt.SetFillCache(false)
-	defer rOpt.Destroy()
-
-	const maxDelNum = 1000000
-	var count, endTimes int
-	for {
-		var it = tbl.db.NewIterator(rOpt)
-		it.Seek(getRawSlotKey(slotId, 0, 0))
-		for count = 0; it.Valid() && count < maxDelNum; it.Next() {
-			curSlotId, dbId, tableId := parseRawKeySlotId(it.Key())
-			if curSlotId != slotId {
-				break
+// DeleteSlots deletes data of the slots, except the reserved admin table.
+// Keys of every slot are iterated and deleted by key range (wSlotId+cDbId+
+// cTableId prefix), and the slot ranges with deleted keys are compacted
+// afterwards to drop the tombstones from disk. All the slot ranges are
+// compacted if compact is true, including the tombstones of earlier deletes.
+func (tbl *Table) DeleteSlots(slots []ctrl.SlotRange, compact bool) error {
+	for _, r := range slots {
+		var deleted bool
+		for i := uint32(r.Start); i <= uint32(r.End); i++ {
+			count, err := tbl.deleteSlot(uint16(i))
+			if err != nil {
+				return err
 			}
-			if dbId == proto.AdminDbId && tableId == 0 {
-				continue // Reserved admin table
+			if count == 0 {
+				continue
 			}
-			err := tbl.db.Del(it.Key(), nil)
+			deleted = true
+
+			// Check again after deleting
+			count, err = tbl.deleteSlot(uint16(i))
 			if err != nil {
-				it.Destroy()
 				return err
 			}
-			count++
-		}
-		it.Destroy()
-
-		if count < maxDelNum {
-			endTimes++
-		}
-		if endTimes > 1 {
 			if count > 0 {
 				return errors.New("the slot deleting still has new data written")
 			}
-			return nil
+		}
+
+		if deleted || compact {
+			tbl.db.CompactRange(getRawSlotKey(r.Start, 0, 0),
+				getRawSlotKey(r.End+1, 0, 0))
 		}
 	}
 
 	return nil
 }
 
+// Delete all keys of the slot except the reserved admin table (AdminDbId, 0),
+// and return the number of deleted keys.
+func (tbl *Table) deleteSlot(slotId uint16) (uint64, error) {
+	count, err := tbl.db.IterDelete(getRawSlotKey(slotId, 0, 0),
+		getRawSlotKey(slotId, proto.AdminDbId, 0))
+	if err != nil {
+		return count, err
+	}
+
+	n, err := tbl.db.IterDelete(getRawSlotKey(slotId, proto.AdminDbId, 1),
+		getRawSlotKey(slotId+1, 0, 0))
+	return count + n, err
+}
+
 func (tbl *Table) HasSlotData(slotId uint16) bool {
 	var rOpt = tbl.db.NewReadOptions(false)
 	rOpt.SetFillCache(false)
@@ -1109,6 +1178,33 @@ func (tbl *Table) HasSlotData(slotId uint16) bool {
 	return false
 }
 
+// GetSlotsSize returns the approximate data size of the slot ranges.
+func (tbl *Table) GetSlotsSize(slots []ctrl.SlotRange) uint64 {
+	var starts, limits [][]byte
+	for _, r := range slots {
+		starts = append(starts, getRawSlotKey(r.Start, 0, 0))
+		limits = append(limits, getRawSlotKey(r.End+1, 0, 0))
+	}
+
+	var total uint64
+	for _, size := range tbl.db.GetApproximateSizes(starts, limits) {
+		total += size
+	}
+	return total
+}
+
+// GetSlotSizes returns the approximate data size of every slot.
+func (tbl *Table) GetSlotSizes() []uint64 {
+	var starts = make([][]byte, ctrl.TotalSlotNum)
+	var limits = make([][]byte, ctrl.TotalSlotNum)
+	for i := 0; i < ctrl.TotalSlotNum; i++ {
+		starts[i] = getRawSlotKey(uint16(i), 0, 0)
+		limits[i] = getRawSlotKey(uint16(i+1), 0, 0)
+	}
+
+	return tbl.db.GetApproximateSizes(starts, limits)
+}
+
 func (tbl *Table) NewIterator(fillCache bool) *Iterator {
 	if fillCache {
 		return tbl.db.NewIterator(nil)
@@ -1120,37 +1216,42 @@ func (tbl *Table) NewIterator(fillCache bool) *Iterator {
 	}
 }
 
+// Copy records from iterator to sync pkg.
+// In migration, only records of migSlots are copied, and a pkg never
+// contains records of more than 1 slot.
 func SeekAndCopySyncPkg(it *Iterator, p *proto.PkgMultiOp,
-	migration bool, migSlotId uint16) bool {
+	migration bool, migSlots *ctrl.SlotSet) bool {
 	p.PkgFlag &^= 0xFF
 	p.ErrCode = 0
 	p.Kvs = nil
 
 	var size = 0
+	var pkgSlotId uint16
 	for i := 0; i < 10 && size < 400 && it.Valid(); i++ {
 		var kv proto.KeyValue
 		dbId, slotId, ok := seekAndCopySyncKV(it, &kv)
 		if !ok {
 			return false
 		}
-		if migration && migSlotId != slotId {
-			if migSlotId < slotId {
+		for migration && !migSlots.Has(slotId) {
+			nextSlotId, found := migSlots.Next(slotId)
+			if !found {
+				return false
+			}
+			seekToSlot(it, nextSlotId, 0, 0)
+			if !it.Valid() {
+				return false
+			}
+			dbId, slotId, ok = seekAndCopySyncKV(it, &kv)
+			if !ok {
 				return false
-			} else if migSlotId > slotId {
-				seekToSlot(it, migSlotId, 0, 0)
-				if !it.Valid() {
-					return false
-				}
-				dbId, slotId, ok = seekAndCopySyncKV(it, &kv)
-				if !ok || migSlotId != slotId {
-					return false
-				}
 			}
 		}
 
 		if len(p.Kvs) == 0 {
 			p.DbId = dbId
-		} else if p.DbId != dbId {
+			pkgSlotId = slotId
+		} else if p.DbId != dbId || (migration && pkgSlotId != slotId) {
 			return true
 		}
 
@@ -1321,7 +1422,7 @@ func newScoreColKey(score int64, colKey []byte) []byte {
 	return col
 }
 
-func checkOneOp(in *proto.PkgOneOp, req *PkgArgs, au Authorize) bool {
+func checkOneOp(in *proto.PkgOneOp, req *PkgArgs, au Authorize, write bool) bool {
 	n, err := in.Decode(req.Pkg)
 	if err != nil || n != len(req.Pkg) {
 		in.ErrCode = table.EcDecodeFail
@@ -1329,7 +1430,7 @@ func checkOneOp(in *proto.PkgOneOp, req *PkgArgs, au Authorize) bool {
 	if in.ErrCode == 0 && in.DbId == proto.AdminDbId {
 		in.ErrCode = table.EcInvDbId
 	}
-	if in.ErrCode == 0 && !au.IsAuth(in.DbId) {
+	if in.ErrCode == 0 && !au.CanAccess(in.DbId, in.TableId, write) {
 		in.ErrCode = table.EcNoPrivilege
 	}
 
@@ -1341,7 +1442,7 @@ func checkOneOp(in *proto.PkgOneOp, req *PkgArgs, au Authorize) bool {
 	return in.ErrCode == 0
 }
 
-func checkMultiOp(in *proto.PkgMultiOp, req *PkgArgs, au Authorize) bool {
+func checkMultiOp(in *proto.PkgMultiOp, req *PkgArgs, au Authorize, write bool) bool {
 	n, err := in.Decode(req.Pkg)
 	if err != nil || n != len(req.Pkg) {
 		in.ErrCode = table.EcDecodeFail
@@ -1352,6 +1453,11 @@ func checkMultiOp(in *proto.PkgMultiOp, req *PkgArgs, au Authorize) bool {
 	if in.ErrCode == 0 && !au.IsAuth(in.DbId) {
 		in.ErrCode = table.EcNoPrivilege
 	}
+	for i := 0; in.ErrCode == 0 && i < len(in.Kvs); i++ {
+		if !au.CanAccess(in.DbId, in.Kvs[i].TableId, write) {
+			in.ErrCode = table.EcNoPrivilege
+		}
+	}
 
 	if in.ErrCode != 0 {
 		in.Kvs = nil
@@ -20,6 +20,7 @@ import (
 	"github.com/stevejiang/gotable/api/go/table"
 	"github.com/stevejiang/gotable/api/go/table/proto"
 	"github.com/stevejiang/gotable/config"
+	"github.com/stevejiang/gotable/ctrl"
 	"os"
 	"sync"
 	"testing"
@@ -40,11 +41,27 @@ func (ma MockAuth) SetAuth(dbId uint8) {
 
 }
 
+func (ma MockAuth) CanAccess(dbId, tableId uint8, write bool) bool {
+	return true
+}
+
+func (ma MockAuth) SetPerm(dbId uint8, perm *Perm) {
+
+}
+
+func (ma MockAuth) SetScram(st *ScramState) {
+
+}
+
+func (ma MockAuth) GetScram() *ScramState {
+	return nil
+}
+
 func getTestTable() *Table {
 	f := func() {
 		tblDir := "/tmp/test_gotable/table"
 		os.RemoveAll(tblDir)
-		testTbl = NewTable(tblDir, 1024, 1024*1024, 1024*1024, "snappy")
+		testTbl = NewTable(tblDir, 1024, 1024*1024, 1024*1024, "snappy", 0)
 	}
 
 	testTblOnce.Do(f)
@@ -957,3 +974,38 @@ func TestTableDump(t *testing.T) {
 		}
 	}
 }
+
+func TestTableDeleteSlots(t *testing.T) {
+	var tbl = getTestTable()
+
+	var rowKey = []byte("delete-slot-row")
+	var slotId = ctrl.GetSlotId(3, 9, rowKey)
+	var rawKey = getRawKey(3, 9, 0, rowKey, []byte("col"))
+	var adminKey = append(getRawSlotKey(slotId, proto.AdminDbId, 0), 'a')
+	if err := tbl.db.Put(rawKey, []byte("v"), nil); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err := tbl.db.Put(adminKey, []byte("v"), nil); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+
+	if !tbl.HasSlotData(slotId) {
+		t.Fatalf("Slot %d should have data", slotId)
+	}
+
+	var slots = []ctrl.SlotRange{{Start: slotId, End: slotId}}
+	if err := tbl.DeleteSlots(slots, true); err != nil {
+		t.Fatalf("DeleteSlots failed: %s", err)
+	}
+
+	if tbl.HasSlotData(slotId) {
+		t.Fatalf("Slot %d still has data", slotId)
+	}
+
+	var rOpt = tbl.db.NewReadOptions(false)
+	defer rOpt.Destroy()
+	value, err := tbl.db.Get(rOpt, adminKey)
+	if err != nil || string(value) != "v" {
+		t.Fatalf("Reserved admin table should not be deleted")
+	}
+}
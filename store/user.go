@@ -0,0 +1,283 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"log"
+	"sort"
+)
+
+// Named users are kept in the admin table (AdminDbId, 0) at row KeyUsers.
+// ColKey is the user name, and value is the JSON encoded userRecord.
+// An empty value means the user was removed.
+
+const (
+	maxUserNameLen = 64
+)
+
+// Access levels of a table
+const (
+	permNone = iota
+	permRead
+	permWrite
+)
+
+// Permission of a DB authorized by named users: the access level of every
+// table. A nil Perm means full access (authorized by password).
+type Perm [256]uint8
+
+// CanAccess returns whether the table can be read, or written if write is true.
+func (p *Perm) CanAccess(tableId uint8, write bool) bool {
+	if p == nil {
+		return true
+	}
+	if write {
+		return p[tableId] >= permWrite
+	}
+	return p[tableId] >= permRead
+}
+
+// Merge returns the permission granting the access of both p and o.
+func (p *Perm) Merge(o *Perm) *Perm {
+	if p == nil || o == nil {
+		return nil
+	}
+
+	var m = *p
+	for i := 0; i < len(m); i++ {
+		if o[i] > m[i] {
+			m[i] = o[i]
+		}
+	}
+	return &m
+}
+
+func newPerm(u *ctrl.UserInfo) *Perm {
+	var level uint8 = permRead
+	if u.Role == ctrl.RoleWrite {
+		level = permWrite
+	}
+
+	var p = new(Perm)
+	if len(u.Tables) == 0 {
+		for i := 0; i < len(p); i++ {
+			p[i] = level
+		}
+	} else {
+		for _, tableId := range u.Tables {
+			p[tableId] = level
+		}
+	}
+	return p
+}
+
+type userRecord struct {
+	ctrl.UserInfo
+	Hash []byte // Encoded SCRAM keys of the password
+}
+
+func checkUser(u *ctrl.UserInfo) error {
+	if len(u.Name) == 0 || len(u.Name) > maxUserNameLen {
+		return fmt.Errorf("user name length is out of range [1 ~ %d]",
+			maxUserNameLen)
+	}
+
+	switch u.Role {
+	case ctrl.RoleAdmin:
+		if u.DbId != 0 || len(u.Tables) > 0 {
+			return errors.New("admin role cannot be scoped to DB or tables")
+		}
+	case ctrl.RoleRead:
+		fallthrough
+	case ctrl.RoleWrite:
+		if u.DbId == proto.AdminDbId {
+			return fmt.Errorf("DB %d is reserved for admin", proto.AdminDbId)
+		}
+		for _, tableId := range u.Tables {
+			if tableId < 0 || tableId > proto.MaxUint8 {
+				return fmt.Errorf("tableId %d is out of range [0 ~ 255]", tableId)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid role %q", u.Role)
+	}
+
+	return nil
+}
+
+// NewUserPkg returns the SYNC pkg which stores the user with the hashed
+// password in the admin table.
+// The pkg is applied by Table.Sync, and replicated to slaves by binlog.
+func NewUserPkg(u *ctrl.UserInfo, password string) ([]byte, error) {
+	if err := checkUser(u); err != nil {
+		return nil, err
+	}
+	if password == "" {
+		return nil, errors.New("empty password")
+	}
+
+	var rec userRecord
+	rec.UserInfo = *u
+	rec.Tables = append([]int(nil), u.Tables...)
+	sort.Ints(rec.Tables)
+
+	var err error
+	rec.Hash, err = hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := json.Marshal(&rec)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAdminSyncPkg(KeyUsers, []byte(u.Name), value)
+}
+
+// NewDelUserPkg returns the SYNC pkg which removes the user.
+func NewDelUserPkg(name string) ([]byte, error) {
+	return newAdminSyncPkg(KeyUsers, []byte(name), nil)
+}
+
+func parseUserRecord(name, value []byte) *userRecord {
+	var rec = new(userRecord)
+	if err := json.Unmarshal(value, rec); err != nil {
+		log.Printf("Invalid user %q: %s\n", name, err)
+		return nil
+	}
+	if err := checkUser(&rec.UserInfo); err != nil {
+		log.Printf("Invalid user %q: %s\n", name, err)
+		return nil
+	}
+	return rec
+}
+
+// LoadUsers reads the named users from the admin table.
+func (tbl *Table) LoadUsers() {
+	var users = make(map[string]*userRecord)
+	tbl.scanAdminRow(KeyUsers, func(colKey, value []byte) {
+		if rec := parseUserRecord(colKey, value); rec != nil {
+			users[string(colKey)] = rec
+		}
+	})
+
+	tbl.mtx.Lock()
+	tbl.users = users
+	tbl.mtx.Unlock()
+}
+
+// Update the users in memory after the sync kvs are written.
+func (tbl *Table) syncUsers(dbId uint8, kvs []proto.KeyValue) {
+	if dbId != proto.AdminDbId {
+		return
+	}
+
+	tbl.mtx.Lock()
+	for i := 0; i < len(kvs); i++ {
+		if !isAdminKV(dbId, &kvs[i], KeyUsers) {
+			continue
+		}
+		if tbl.users == nil {
+			tbl.users = make(map[string]*userRecord)
+		}
+		var name = string(kvs[i].ColKey)
+		if len(kvs[i].Value) > 0 {
+			if rec := parseUserRecord(kvs[i].ColKey, kvs[i].Value); rec != nil {
+				tbl.users[name] = rec
+			}
+		} else {
+			delete(tbl.users, name)
+		}
+	}
+	tbl.mtx.Unlock()
+}
+
+// HasUser returns whether the named user exists.
+func (tbl *Table) HasUser(name string) bool {
+	tbl.mtx.Lock()
+	var _, ok = tbl.users[name]
+	tbl.mtx.Unlock()
+	return ok
+}
+
+// GetUsers returns all named users ordered by name.
+func (tbl *Table) GetUsers() []ctrl.UserInfo {
+	tbl.mtx.Lock()
+	var users = make([]ctrl.UserInfo, 0, len(tbl.users))
+	for _, rec := range tbl.users {
+		users = append(users, rec.UserInfo)
+	}
+	tbl.mtx.Unlock()
+
+	sort.Sort(userSlice(users))
+	return users
+}
+
+type userSlice []ctrl.UserInfo
+
+func (s userSlice) Len() int           { return len(s) }
+func (s userSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s userSlice) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// Authorize the named user, and reply the authorized DB in pkg.
+func (tbl *Table) authUser(in *proto.PkgOneOp, au Authorize) {
+	var name = string(in.ColKey)
+	var password = string(in.RowKey)
+
+	tbl.mtx.Lock()
+	var rec = tbl.users[name]
+	tbl.mtx.Unlock()
+
+	if rec == nil || !checkPassword(rec.Hash, password) {
+		in.SetErrCode(table.EcAuthFailed)
+		return
+	}
+
+	in.DbId = authorizeUser(rec, au)
+}
+
+// Authorize the DB of the user, and return the authorized DB.
+func authorizeUser(rec *userRecord, au Authorize) uint8 {
+	if rec.Role == ctrl.RoleAdmin {
+		au.SetAuth(proto.AdminDbId)
+		return proto.AdminDbId
+	}
+
+	au.SetPerm(rec.DbId, newPerm(&rec.UserInfo))
+	return rec.DbId
+}
+
+// AuthCertUser authorizes the client with a verified certificate as the
+// named user of the certificate common name. It returns false if the user
+// doesn't exist.
+func (tbl *Table) AuthCertUser(name string, au Authorize) bool {
+	tbl.mtx.Lock()
+	var rec = tbl.users[name]
+	tbl.mtx.Unlock()
+
+	if rec == nil {
+		return false
+	}
+
+	authorizeUser(rec, au)
+	return true
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"testing"
+)
+
+// Authorize keeping the permission of one DB
+type permAuth struct {
+	dbId  uint8
+	admin bool
+	auth  bool
+	perm  *Perm
+	scram *ScramState
+}
+
+func (pa *permAuth) IsAuth(dbId uint8) bool {
+	return pa.admin || (pa.auth && pa.dbId == dbId)
+}
+
+func (pa *permAuth) SetAuth(dbId uint8) {
+	if dbId == proto.AdminDbId {
+		pa.admin = true
+	}
+}
+
+func (pa *permAuth) CanAccess(dbId, tableId uint8, write bool) bool {
+	return pa.IsAuth(dbId) && (pa.admin || pa.perm.CanAccess(tableId, write))
+}
+
+func (pa *permAuth) SetPerm(dbId uint8, perm *Perm) {
+	pa.dbId, pa.auth, pa.perm = dbId, true, perm
+}
+
+func (pa *permAuth) SetScram(st *ScramState) {
+	pa.scram = st
+}
+
+func (pa *permAuth) GetScram() *ScramState {
+	return pa.scram
+}
+
+func TestPerm(t *testing.T) {
+	var ro = newPerm(&ctrl.UserInfo{Role: ctrl.RoleRead, Tables: []int{1, 2}})
+	if !ro.CanAccess(1, false) || ro.CanAccess(1, true) || ro.CanAccess(3, false) {
+		t.Fatalf("Invalid read-only permission")
+	}
+
+	var rw = newPerm(&ctrl.UserInfo{Role: ctrl.RoleWrite, Tables: []int{3}})
+	var m = ro.Merge(rw)
+	if !m.CanAccess(2, false) || m.CanAccess(2, true) || !m.CanAccess(3, true) {
+		t.Fatalf("Invalid merged permission")
+	}
+
+	var all = newPerm(&ctrl.UserInfo{Role: ctrl.RoleWrite})
+	if !all.CanAccess(255, true) || ro.Merge(nil) != nil {
+		t.Fatalf("Invalid full permission")
+	}
+}
+
+func TestTableUser(t *testing.T) {
+	var tbl = getTestTable()
+
+	var syncUser = func(u *ctrl.UserInfo, password string) {
+		var pkg []byte
+		var err error
+		if u.Role != "" {
+			pkg, err = NewUserPkg(u, password)
+		} else {
+			pkg, err = NewDelUserPkg(u.Name)
+		}
+		if err != nil {
+			t.Fatalf("New user pkg failed: %s", err)
+		}
+		_, ok := tbl.Sync(&PkgArgs{proto.CmdSync, proto.AdminDbId, 0, pkg})
+		if !ok {
+			t.Fatalf("Sync user failed")
+		}
+	}
+
+	var auth = func(name, password string, au Authorize) proto.PkgOneOp {
+		var in proto.PkgOneOp
+		in.Cmd = proto.CmdAuth
+		in.DbId = 0
+		in.RowKey = []byte(password)
+		in.ColKey = []byte(name)
+		var pkg = make([]byte, in.Length())
+		in.Encode(pkg)
+
+		var out proto.PkgOneOp
+		out.Decode(tbl.Auth(&PkgArgs{in.Cmd, in.DbId, in.Seq, pkg}, au))
+		return out
+	}
+
+	if _, err := NewUserPkg(&ctrl.UserInfo{Name: "u", Role: "root"}, "p"); err == nil {
+		t.Fatalf("Invalid role should fail")
+	}
+
+	syncUser(&ctrl.UserInfo{Name: "analytics", Role: ctrl.RoleRead, DbId: 2,
+		Tables: []int{5}}, "pwd")
+
+	var au permAuth
+	if out := auth("analytics", "bad", &au); out.ErrCode != table.EcAuthFailed {
+		t.Fatalf("Auth with bad password should fail")
+	}
+	var out = auth("analytics", "pwd", &au)
+	if out.ErrCode != 0 || out.DbId != 2 {
+		t.Fatalf("Auth failed: %d, %d", out.ErrCode, out.DbId)
+	}
+	if !au.CanAccess(2, 5, false) || au.CanAccess(2, 5, true) ||
+		au.CanAccess(2, 6, false) || au.CanAccess(3, 5, false) {
+		t.Fatalf("Invalid user permission")
+	}
+
+	var cu permAuth
+	if !tbl.AuthCertUser("analytics", &cu) || !cu.CanAccess(2, 5, false) ||
+		cu.CanAccess(2, 5, true) || tbl.AuthCertUser("nobody", &cu) {
+		t.Fatalf("Invalid certificate user")
+	}
+
+	// Reload from the admin table
+	tbl.LoadUsers()
+	var users = tbl.GetUsers()
+	if len(users) != 1 || users[0].Name != "analytics" ||
+		users[0].Role != ctrl.RoleRead || len(users[0].Tables) != 1 {
+		t.Fatalf("Invalid users %v", users)
+	}
+
+	syncUser(&ctrl.UserInfo{Name: "analytics"}, "")
+	if tbl.HasUser("analytics") {
+		t.Fatalf("User not removed")
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/binary"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"hash/fnv"
+)
+
+// SlotChecksums returns the checksum of raw keys and values of every slot,
+// iterated from the snapshot iterator it. Only the slots in ss are computed,
+// and slots without data have checksum 0. The reserved admin table is
+// excluded, as it's not deleted with the slot, and so are the tables not
+// matching filter (nil: no filter), as they are not synced to the slave.
+func SlotChecksums(it *Iterator, ss *ctrl.SlotSet, filter *ctrl.SyncMatcher) []uint64 {
+	var sums = make([]uint64, ctrl.TotalSlotNum)
+	var lenBuf = make([]byte, binary.MaxVarintLen64)
+	for _, r := range ss.Ranges() {
+		var h = fnv.New64a()
+		var curSlotId = r.Start
+		var hasData bool
+		for seekToSlot(it, r.Start, 0, 0); it.Valid(); it.Next() {
+			var rawKey = it.Key()
+			slotId, dbId, tableId := parseRawKeySlotId(rawKey)
+			if slotId > r.End {
+				break
+			}
+			if dbId == proto.AdminDbId && tableId == 0 {
+				continue // Reserved admin table
+			}
+			if !filter.Match(dbId, tableId) {
+				continue
+			}
+			if slotId != curSlotId {
+				if hasData {
+					sums[curSlotId] = h.Sum64()
+				}
+				h.Reset()
+				curSlotId = slotId
+				hasData = false
+			}
+
+			var value = it.Value()
+			h.Write(lenBuf[:binary.PutUvarint(lenBuf, uint64(len(rawKey)))])
+			h.Write(rawKey)
+			h.Write(lenBuf[:binary.PutUvarint(lenBuf, uint64(len(value)))])
+			h.Write(value)
+			hasData = true
+		}
+		if hasData {
+			sums[curSlotId] = h.Sum64()
+		}
+	}
+	return sums
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"github.com/stevejiang/gotable/ctrl"
+	"testing"
+)
+
+func TestSlotChecksums(t *testing.T) {
+	var tbl = getTestTable()
+
+	var rowKey = []byte("checksum-row")
+	var slotId = ctrl.GetSlotId(6, 1, rowKey)
+	var rawKey = getRawKey(6, 1, 0, rowKey, []byte("col"))
+	var ss = ctrl.NewSlotSet([]ctrl.SlotRange{{Start: slotId, End: slotId}})
+
+	var checksum = func() uint64 {
+		var it = tbl.NewIterator(false)
+		defer it.Destroy()
+		return SlotChecksums(it, ss, nil)[slotId]
+	}
+
+	if err := tbl.db.Put(rawKey, []byte("v1"), nil); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	var sum1 = checksum()
+	if sum1 == 0 || sum1 != checksum() {
+		t.Fatalf("Invalid checksum %d", sum1)
+	}
+
+	if err := tbl.db.Put(rawKey, []byte("v2"), nil); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if checksum() == sum1 {
+		t.Fatalf("Checksum should change with the value")
+	}
+
+	var it = tbl.NewIterator(false)
+	defer it.Destroy()
+	var f = ctrl.SyncFilter{Exclude: []string{"6.1"}}
+	m, err := f.Matcher()
+	if err != nil {
+		t.Fatalf("Matcher failed: %s", err)
+	}
+	if SlotChecksums(it, ss, m)[slotId] != 0 {
+		t.Fatalf("Filtered table should be skipped")
+	}
+
+	if err := tbl.DeleteSlots([]ctrl.SlotRange{{Start: slotId, End: slotId}},
+		false); err != nil {
+		t.Fatalf("DeleteSlots failed: %s", err)
+	}
+	if checksum() != 0 {
+		t.Fatalf("Checksum of empty slot should be 0")
+	}
+}
@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package main
+package util
 
 import (
 	"bytes"
@@ -67,9 +67,10 @@ func (h *Histogram) Clear() {
 }
 
 func (h *Histogram) Add(value float64) {
-	// Linear search is fast enough for our usage in db_bench
+	// Linear search is fast enough for our usage
 	var b = 0
-	for b < kNumBuckets-1 && kBucketLimit[b] <= value {
+	// The bucket limit is inclusive, like the Prometheus "le" bound
+	for b < kNumBuckets-1 && kBucketLimit[b] < value {
 		b++
 	}
 	h.buckets_[b] += 1.0
@@ -99,6 +100,26 @@ func (h *Histogram) Merge(other *Histogram) {
 	}
 }
 
+// Count returns the number of values added.
+func (h *Histogram) Count() float64 {
+	return h.num_
+}
+
+// Sum returns the sum of values added.
+func (h *Histogram) Sum() float64 {
+	return h.sum_
+}
+
+// CumulativeCount returns the number of values less than or equal to limit.
+// The result is exact only if limit is one of the bucket limits.
+func (h *Histogram) CumulativeCount(limit float64) float64 {
+	var sum float64
+	for b := 0; b < kNumBuckets && kBucketLimit[b] <= limit; b++ {
+		sum += h.buckets_[b]
+	}
+	return sum
+}
+
 func (h *Histogram) Median() float64 {
 	return h.Percentile(50.0)
 }
@@ -172,7 +193,7 @@ func (h *Histogram) ToString() string {
 			leftRes = kBucketLimit[b-1]
 		}
 
-		s.WriteString(fmt.Sprintf("[ %7.0f, %7.0f ) %7.0f %7.3f%% %7.3f%% ",
+		s.WriteString(fmt.Sprintf("( %7.0f, %7.0f ] %7.0f %7.3f%% %7.3f%% ",
 			leftRes,            // left
 			kBucketLimit[b],    // right
 			h.buckets_[b],      // count
@@ -0,0 +1,49 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	h.Clear()
+	for _, v := range []float64{0.5, 3, 99, 100, 250, 1500} {
+		h.Add(v)
+	}
+
+	if h.Count() != 6 {
+		t.Fatalf("count should be 6, but %.0f", h.Count())
+	}
+	if h.Sum() != 1952.5 {
+		t.Fatalf("sum should be 1952.5, but %f", h.Sum())
+	}
+
+	var cases = []struct {
+		limit float64
+		count float64
+	}{{1, 1}, {90, 2}, {100, 4}, {250, 5}, {1000, 5}, {2000, 6}, {1e9, 6}}
+	for _, c := range cases {
+		if n := h.CumulativeCount(c.limit); n != c.count {
+			t.Fatalf("values not greater than %.0f should be %.0f, but %.0f",
+				c.limit, c.count, n)
+		}
+	}
+
+	if m := h.Median(); m < 3 || m > 100 {
+		t.Fatalf("invalid median %f", m)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the rate of something (bytes, keys...) per second.
+// The rate can be changed at any time. It's safe to use in multiple goroutines.
+type RateLimiter struct {
+	mtx  sync.Mutex // protects following
+	rate int64      // Per second, 0 means unlimited
+	next time.Time  // Time when the consumed amount is paid off
+}
+
+// NewRateLimiter returns a new RateLimiter with rate per second.
+// Rate 0 means unlimited.
+func NewRateLimiter(rate int64) *RateLimiter {
+	rl := new(RateLimiter)
+	rl.rate = rate
+	return rl
+}
+
+func (rl *RateLimiter) SetRate(rate int64) {
+	rl.mtx.Lock()
+	rl.rate = rate
+	rl.mtx.Unlock()
+}
+
+func (rl *RateLimiter) GetRate() int64 {
+	rl.mtx.Lock()
+	var rate = rl.rate
+	rl.mtx.Unlock()
+	return rate
+}
+
+// Reserve consumes n and returns how long to wait before consuming more.
+func (rl *RateLimiter) Reserve(n int64) time.Duration {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	if rl.rate <= 0 || n <= 0 {
+		return 0
+	}
+
+	var now = time.Now()
+	if rl.next.Before(now) {
+		rl.next = now
+	}
+	rl.next = rl.next.Add(time.Duration(n * int64(time.Second) / rl.rate))
+
+	return rl.next.Sub(now)
+}
+
+// Wait consumes n and sleeps until it's paid off.
+func (rl *RateLimiter) Wait(n int64) {
+	if d := rl.Reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var rl = NewRateLimiter(0)
+	if d := rl.Reserve(1000000); d != 0 {
+		t.Fatalf("unlimited rate should not wait, but wait %s", d)
+	}
+
+	rl.SetRate(1000)
+	if rl.GetRate() != 1000 {
+		t.Fatalf("rate should be 1000, but %d", rl.GetRate())
+	}
+
+	var d = rl.Reserve(100)
+	if d < 90*time.Millisecond || d > 100*time.Millisecond {
+		t.Fatalf("100 of rate 1000 should wait 100ms, but %s", d)
+	}
+
+	d = rl.Reserve(100)
+	if d < 190*time.Millisecond || d > 200*time.Millisecond {
+		t.Fatalf("another 100 should wait 200ms, but %s", d)
+	}
+
+	rl.SetRate(0)
+	if d = rl.Reserve(100); d != 0 {
+		t.Fatalf("unlimited rate should not wait, but wait %s", d)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	var rl = NewRateLimiter(10000)
+	var start = time.Now()
+	for i := 0; i < 10; i++ {
+		rl.Wait(100)
+	}
+	var d = time.Since(start)
+	if d < 90*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("1000 of rate 10000 should take 100ms, but %s", d)
+	}
+}
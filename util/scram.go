@@ -0,0 +1,196 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// SCRAM-SHA-256 style challenge-response auth (RFC 5802), the password is
+// never sent to server, and server only keeps the salted verifiers:
+//   SaltedPassword  = PBKDF2-HMAC-SHA256(password, salt, iter)
+//   ClientKey       = HMAC(SaltedPassword, "Client Key")
+//   StoredKey       = SHA256(ClientKey)
+//   ServerKey       = HMAC(SaltedPassword, "Server Key")
+//   ClientProof     = ClientKey XOR HMAC(StoredKey, AuthMessage)
+//   ServerSignature = HMAC(ServerKey, AuthMessage)
+
+const (
+	ScramIter     = 4096
+	ScramMaxIter  = 1 << 20 // Clients refuse more iterations than this
+	ScramNonceLen = 16
+	scramSaltLen  = 16
+
+	// Nonce replied without challenge, clients fail on any other empty challenge
+	ScramAuthDisabled = "auth-disabled" // Server has no admin password
+	ScramAlreadyAdmin = "already-admin" // Connection already authorized as admin
+)
+
+var (
+	ErrScramKeys = errors.New("invalid scram keys")
+)
+
+type ScramKeys struct {
+	Salt      []byte
+	Iter      int
+	StoredKey []byte
+	ServerKey []byte
+}
+
+// NewScramKeys returns the verifiers of password with a random salt.
+func NewScramKeys(password string) (*ScramKeys, error) {
+	var salt = make([]byte, scramSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return GetScramKeys(password, salt, ScramIter), nil
+}
+
+// GetScramKeys returns the verifiers of password with the salt and iter.
+func GetScramKeys(password string, salt []byte, iter int) *ScramKeys {
+	var salted = pbkdf2Sha256([]byte(password), salt, iter)
+	var clientKey = hmacSha256(salted, []byte("Client Key"))
+	var storedKey = sha256.Sum256(clientKey)
+
+	var k = new(ScramKeys)
+	k.Salt = salt
+	k.Iter = iter
+	k.StoredKey = storedKey[:]
+	k.ServerKey = hmacSha256(salted, []byte("Server Key"))
+	return k
+}
+
+// Encode keys as: dwIter+cSaltLen+sSalt+sStoredKey+sServerKey
+func (k *ScramKeys) Encode() []byte {
+	var n = 5 + len(k.Salt)
+	var b = make([]byte, n, n+2*sha256.Size)
+	binary.BigEndian.PutUint32(b, uint32(k.Iter))
+	b[4] = uint8(len(k.Salt))
+	copy(b[5:], k.Salt)
+	b = append(b, k.StoredKey...)
+	return append(b, k.ServerKey...)
+}
+
+func DecodeScramKeys(b []byte) (*ScramKeys, error) {
+	if len(b) < 5 || len(b) != 5+int(b[4])+2*sha256.Size {
+		return nil, ErrScramKeys
+	}
+
+	var k = new(ScramKeys)
+	k.Iter = int(binary.BigEndian.Uint32(b))
+	var n = 5 + int(b[4])
+	k.Salt = b[5:n]
+	k.StoredKey = b[n : n+sha256.Size]
+	k.ServerKey = b[n+sha256.Size:]
+	return k, nil
+}
+
+// VerifyPassword checks the plain text password against the keys.
+func (k *ScramKeys) VerifyPassword(password string) bool {
+	var o = GetScramKeys(password, k.Salt, k.Iter)
+	return subtle.ConstantTimeCompare(o.StoredKey, k.StoredKey) == 1
+}
+
+// VerifyProof checks the client proof of the auth message.
+func (k *ScramKeys) VerifyProof(authMsg, proof []byte) bool {
+	if len(proof) != sha256.Size {
+		return false
+	}
+
+	var clientKey = hmacSha256(k.StoredKey, authMsg)
+	for i := 0; i < len(clientKey); i++ {
+		clientKey[i] ^= proof[i]
+	}
+	var storedKey = sha256.Sum256(clientKey)
+	return subtle.ConstantTimeCompare(storedKey[:], k.StoredKey) == 1
+}
+
+// ServerSignature proves to client that server knows the keys.
+func (k *ScramKeys) ServerSignature(authMsg []byte) []byte {
+	return hmacSha256(k.ServerKey, authMsg)
+}
+
+// ScramClientProof returns the client proof of the auth message, and the
+// server signature client expects.
+func ScramClientProof(password string, salt []byte, iter int,
+	authMsg []byte) (proof, serverSig []byte) {
+	var salted = pbkdf2Sha256([]byte(password), salt, iter)
+	var clientKey = hmacSha256(salted, []byte("Client Key"))
+	var storedKey = sha256.Sum256(clientKey)
+
+	proof = hmacSha256(storedKey[:], authMsg)
+	for i := 0; i < len(proof); i++ {
+		proof[i] ^= clientKey[i]
+	}
+
+	serverSig = hmacSha256(hmacSha256(salted, []byte("Server Key")), authMsg)
+	return
+}
+
+// ScramAuthMessage returns the message both sides sign:
+// cNameLen+sName+cDbId+cNonceLen+sNonce+dwIter+sSalt
+// The nonce is the client nonce followed by the server nonce.
+func ScramAuthMessage(name string, dbId uint8, nonce, salt []byte,
+	iter int) []byte {
+	var b = make([]byte, 0, 8+len(name)+len(nonce)+len(salt))
+	b = append(b, uint8(len(name)))
+	b = append(b, name...)
+	b = append(b, dbId, uint8(len(nonce)))
+	b = append(b, nonce...)
+	var iterBuf [4]byte
+	binary.BigEndian.PutUint32(iterBuf[:], uint32(iter))
+	b = append(b, iterBuf[:]...)
+	return append(b, salt...)
+}
+
+// NewScramNonce returns a random nonce.
+func NewScramNonce() ([]byte, error) {
+	var nonce = make([]byte, ScramNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+func hmacSha256(key, data []byte) []byte {
+	var h = hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// PBKDF2 (RFC 2898) with HMAC-SHA256, only the first block is needed.
+func pbkdf2Sha256(password, salt []byte, iter int) []byte {
+	var h = hmac.New(sha256.New, password)
+	h.Write(salt)
+	h.Write([]byte{0, 0, 0, 1})
+	var u = h.Sum(nil)
+
+	var t = append([]byte(nil), u...)
+	for i := 1; i < iter; i++ {
+		h.Reset()
+		h.Write(u)
+		u = h.Sum(u[:0])
+		for j := 0; j < len(t); j++ {
+			t[j] ^= u[j]
+		}
+	}
+	return t
+}
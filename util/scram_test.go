@@ -0,0 +1,72 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestPbkdf2Sha256(t *testing.T) {
+	var dk = hex.EncodeToString(pbkdf2Sha256([]byte("password"),
+		[]byte("salt"), 4096))
+	if dk != "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a" {
+		t.Fatalf("Invalid PBKDF2 result %s", dk)
+	}
+}
+
+func TestScramKeys(t *testing.T) {
+	keys, err := NewScramKeys("secret")
+	if err != nil {
+		t.Fatalf("NewScramKeys failed: %s", err)
+	}
+
+	k, err := DecodeScramKeys(keys.Encode())
+	if err != nil {
+		t.Fatalf("DecodeScramKeys failed: %s", err)
+	}
+	if k.Iter != ScramIter || !k.VerifyPassword("secret") ||
+		k.VerifyPassword("secret2") {
+		t.Fatalf("Invalid decoded keys")
+	}
+
+	if _, err = DecodeScramKeys(keys.Encode()[1:]); err == nil {
+		t.Fatalf("Decode bad keys should fail")
+	}
+}
+
+func TestScramProof(t *testing.T) {
+	keys, _ := NewScramKeys("secret")
+	var msg = ScramAuthMessage("user", 1, []byte("nonce"), keys.Salt, keys.Iter)
+
+	proof, serverSig := ScramClientProof("secret", keys.Salt, keys.Iter, msg)
+	if !keys.VerifyProof(msg, proof) {
+		t.Fatalf("Valid proof should pass")
+	}
+	if string(keys.ServerSignature(msg)) != string(serverSig) {
+		t.Fatalf("Server signature mismatch")
+	}
+
+	proof, _ = ScramClientProof("bad", keys.Salt, keys.Iter, msg)
+	if keys.VerifyProof(msg, proof) {
+		t.Fatalf("Proof of bad password should fail")
+	}
+
+	var msg2 = ScramAuthMessage("user", 2, []byte("nonce"), keys.Salt, keys.Iter)
+	proof, _ = ScramClientProof("secret", keys.Salt, keys.Iter, msg)
+	if keys.VerifyProof(msg2, proof) {
+		t.Fatalf("Proof of another message should fail")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// NewTLSConfig returns the TLS config with the certificate and key files,
+// and the CA file to verify peers (system CAs if empty). All files are in
+// PEM, and the certificate is optional for clients.
+func NewTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	var conf = new(tls.Config)
+	conf.MinVersion = tls.VersionTLS12
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		var pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", caFile)
+		}
+		conf.RootCAs = pool
+		conf.ClientCAs = pool
+	}
+
+	return conf, nil
+}
+
+// DialTimeout connects to the address with the timeout, and finishes the TLS
+// handshake within the timeout if config is not nil.
+func DialTimeout(network, address string, timeout time.Duration,
+	config *tls.Config) (net.Conn, error) {
+	if config != nil {
+		return tls.DialWithDialer(&net.Dialer{Timeout: timeout},
+			network, address, config)
+	}
+	return net.DialTimeout(network, address, timeout)
+}
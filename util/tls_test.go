@@ -0,0 +1,130 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write a self-signed certificate for 127.0.0.1 usable by both sides.
+func writeTestCert(t *testing.T, dir, cn string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %s", err)
+	}
+
+	var tmpl = x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %s", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %s", err)
+	}
+
+	certFile = filepath.Join(dir, cn+".crt")
+	keyFile = filepath.Join(dir, cn+".key")
+	ioutil.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotable-tls")
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCert(t, dir, "test")
+	srvConf, err := NewTLSConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatalf("NewTLSConfig failed: %s", err)
+	}
+	srvConf.ClientAuth = tls.RequireAndVerifyClientCert
+
+	cliConf, err := NewTLSConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatalf("NewTLSConfig failed: %s", err)
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", srvConf)
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	defer l.Close()
+
+	var peer = make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			peer <- ""
+			return
+		}
+		defer c.Close()
+		var tc = c.(*tls.Conn)
+		if err = tc.Handshake(); err != nil {
+			peer <- ""
+			return
+		}
+		peer <- tc.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}()
+
+	c, err := DialTimeout("tcp", l.Addr().String(), time.Second, cliConf)
+	if err != nil {
+		t.Fatalf("DialTimeout failed: %s", err)
+	}
+	defer c.Close()
+	if _, ok := c.(*tls.Conn); !ok {
+		t.Fatalf("DialTimeout should connect with TLS")
+	}
+	if cn := <-peer; cn != "test" {
+		t.Fatalf("Invalid client certificate name %q", cn)
+	}
+
+	if _, err = NewTLSConfig(certFile, "", ""); err == nil {
+		t.Fatalf("Certificate without key should fail")
+	}
+	if _, err = NewTLSConfig("", "", keyFile); err == nil {
+		t.Fatalf("CA file without certificate should fail")
+	}
+}